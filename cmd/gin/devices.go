@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/impact-dryer/gotattletale/internal/service"
+	"github.com/impact-dryer/gotattletale/pkg"
+)
+
+func runDevicesCommand(w io.Writer, deviceService service.DeviceService) error {
+	devices, err := deviceService.ListDevices()
+	if err != nil {
+		return err
+	}
+	printDevices(w, devices)
+	return nil
+}
+
+// printDevices prints the devices without their capture state, which only
+// the running server knows.
+func printDevices(w io.Writer, devices []pkg.DeviceStatus) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tMAC\tSTATE\tADDRESSES\tDESCRIPTION")
+	for _, d := range devices {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n",
+			d.Name, orDash(d.MAC), linkState(d), orDash(strings.Join(d.Addresses, ",")), orDash(d.Description))
+	}
+	tw.Flush()
+}
+
+func linkState(d pkg.DeviceStatus) string {
+	state := "down"
+	if d.Up {
+		state = "up"
+	}
+	if d.Up && d.Running {
+		state = "running"
+	}
+	if d.Loopback {
+		state += ",loopback"
+	}
+	return state
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"log"
+	"os"
+
 	"github.com/gin-gonic/gin"
 	"github.com/impact-dryer/gotattletale/internal/config"
 	"github.com/impact-dryer/gotattletale/internal/controller"
@@ -11,19 +14,126 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "devices" {
+		if err := runDevicesCommand(os.Stdout, service.NewDeviceService()); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "packets" {
+		appConfig := config.NewAppConfig()
+		db := pkg.NewSqlLiteDB(appConfig)
+		packetService := service.NewPacketService(pkg.NewSqlLitePacketRepository(db, appConfig), pkg.NewSqlLiteDNSRepository(db), pkg.NewSqlLiteHostRepository(db))
+		if err := runPacketsCommand(os.Stdout, os.Args[2:], packetService); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	fx.New(
 		fx.Provide(config.NewAppConfig),
+		fx.Provide(pkg.NewSqlLiteDB),
 		fx.Provide(pkg.NewSqlLitePacketRepository),
+		fx.Provide(pkg.NewSqlLiteCaptureSessionRepository),
 		fx.Provide(service.NewPacketService),
 		fx.Provide(controller.NewPacketController),
+		fx.Provide(service.NewPcapService),
+		fx.Provide(controller.NewPcapController),
+		fx.Provide(service.NewDeviceService),
+		fx.Provide(controller.NewDeviceController),
+		fx.Provide(service.NewCaptureService),
+		fx.Provide(controller.NewCaptureController),
+		fx.Provide(service.NewFilterService),
+		fx.Provide(controller.NewFilterController),
+		fx.Provide(pkg.NewPacketHub),
+		fx.Provide(service.NewStreamService),
+		fx.Provide(controller.NewStreamController),
+		fx.Provide(pkg.NewSqlLiteFlowRepository),
+		fx.Provide(service.NewFlowTable),
+		fx.Provide(service.NewFlowService),
+		fx.Provide(controller.NewFlowController),
+		fx.Provide(pkg.NewSqlLiteDNSRepository),
+		fx.Provide(pkg.NewDNSTracker),
+		fx.Provide(service.NewDNSService),
+		fx.Provide(controller.NewDNSController),
+		fx.Provide(pkg.NewSqlLiteHTTPRepository),
+		fx.Provide(service.NewHTTPService),
+		fx.Provide(controller.NewHTTPController),
+		fx.Provide(pkg.NewSqlLiteTLSRepository),
+		fx.Provide(service.NewTLSService),
+		fx.Provide(controller.NewTLSController),
+		fx.Provide(pkg.NewSqlLiteHostRepository),
+		fx.Provide(pkg.NewHostTracker),
+		fx.Provide(service.NewHostService),
+		fx.Provide(controller.NewHostController),
+		fx.Provide(pkg.NewSqlLiteAlertRepository),
+		fx.Provide(service.NewARPMonitor),
+		fx.Provide(service.NewAlertService),
+		fx.Provide(controller.NewAlertController),
+		fx.Provide(pkg.NewSqlLiteICMPRepository),
+		fx.Provide(pkg.NewPingTracker),
+		fx.Provide(service.NewICMPService),
+		fx.Provide(controller.NewICMPController),
+		fx.Provide(service.NewDefragmenter),
+		fx.Provide(service.NewDefragService),
+		fx.Provide(controller.NewDefragController),
 		fx.Invoke(service.SniffAndStorePackets),
-		fx.Invoke(pkg.CreateNewDeviceAndStartSniffing),
+		// The hooks stop in reverse, the flows flush their DNS messages
+		// before the DNS tracker is flushed.
+		fx.Invoke(service.TrackDNS),
+		fx.Invoke(service.TrackFlows),
+		fx.Invoke(service.TrackHosts),
+		fx.Invoke(service.TrackAlerts),
+		fx.Invoke(service.TrackPings),
+		fx.Invoke(service.StartConfiguredCaptures),
 		fx.Invoke(startGinServer),
 	).Run()
 }
 
-func startGinServer(packetController controller.PacketController) {
+func startGinServer(
+	packetController controller.PacketController,
+	pcapController controller.PcapController,
+	deviceController controller.DeviceController,
+	captureController controller.CaptureController,
+	filterController controller.FilterController,
+	streamController controller.StreamController,
+	flowController controller.FlowController,
+	dnsController controller.DNSController,
+	httpController controller.HTTPController,
+	tlsController controller.TLSController,
+	hostController controller.HostController,
+	alertController controller.AlertController,
+	icmpController controller.ICMPController,
+	defragController controller.DefragController,
+) {
 	router := gin.Default()
 	router.GET("/api/v1/packets", packetController.GetPackets)
+	router.GET("/api/v1/packets/stream", streamController.StreamPackets)
+	router.GET("/api/v1/packets/:id", packetController.GetPacket)
+	router.DELETE("/api/v1/packets/:id", packetController.DeletePacket)
+	router.PATCH("/api/v1/packets/:id", packetController.UpdatePacket)
+	router.GET("/api/v1/packets/:id/raw", packetController.GetPacketRaw)
+	router.GET("/api/v1/packets/:id/pcap", packetController.GetPacketPcap)
+	router.GET("/api/v1/flows", flowController.GetFlows)
+	router.GET("/api/v1/flows/:id/stream", flowController.GetFlowStream)
+	router.GET("/api/v1/dns", dnsController.GetDNSEvents)
+	router.GET("/api/v1/http", httpController.GetHTTPTransactions)
+	router.GET("/api/v1/tls_sessions", tlsController.GetTLSSessions)
+	router.GET("/api/v1/hosts", hostController.GetHosts)
+	router.GET("/api/v1/alerts", alertController.GetAlerts)
+	router.GET("/api/v1/alerts/:id", alertController.GetAlert)
+	router.GET("/api/v1/alerts/:id/pcap", alertController.GetAlertPcap)
+	router.GET("/api/v1/pings", icmpController.GetPings)
+	router.GET("/api/v1/icmp/unreachable", icmpController.GetUnreachable)
+	router.GET("/api/v1/defrag/stats", defragController.GetStats)
+	router.GET("/api/v1/pcaps", pcapController.ListPcapFiles)
+	router.GET("/api/v1/pcaps/:name", pcapController.DownloadPcapFile)
+	router.GET("/api/v1/devices", deviceController.ListDevices)
+	router.POST("/api/v1/captures", captureController.StartCapture)
+	router.GET("/api/v1/captures", captureController.ListCaptures)
+	router.GET("/api/v1/captures/:id", captureController.GetCapture)
+	router.DELETE("/api/v1/captures/:id", captureController.StopCapture)
+	router.PATCH("/api/v1/captures/:id", captureController.UpdateCapture)
+	router.POST("/api/v1/filters/validate", filterController.ValidateFilter)
 	router.Run(":8080")
 }
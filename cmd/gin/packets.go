@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/impact-dryer/gotattletale/internal/service"
+	"github.com/impact-dryer/gotattletale/pkg"
+)
+
+func runPacketsCommand(w io.Writer, args []string, packetService service.PacketService) error {
+	flags := flag.NewFlagSet("packets", flag.ContinueOnError)
+	flags.SetOutput(w)
+	query := flags.String("q", "", "display filter, for example 'tcp.port == 443 && !ip.addr == 10.0.0.0/8'")
+	limit := flags.Int("limit", 50, "number of packets to print")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	filter := pkg.PacketFilter{Limit: *limit}
+	if *query != "" {
+		parsed, err := pkg.ParseDisplayFilter(*query)
+		if err != nil {
+			return displayFilterError(*query, err)
+		}
+		filter.Query = parsed
+	}
+	page, err := packetService.GetPackets(filter)
+	if err != nil {
+		return err
+	}
+	printPackets(w, page.Packets)
+	return nil
+}
+
+// displayFilterError points at the error in the expression.
+func displayFilterError(query string, err error) error {
+	var displayErr *pkg.DisplayFilterError
+	if !errors.As(err, &displayErr) {
+		return err
+	}
+	return fmt.Errorf("%s\n%s\n%s^", displayErr.Message, query, strings.Repeat(" ", displayErr.Position-1))
+}
+
+func printPackets(w io.Writer, packets []pkg.SavedPacket) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tTIME\tDEVICE\tPROTOCOL\tSOURCE\tDESTINATION\tLENGTH")
+	for _, p := range packets {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%d\n",
+			p.ID, p.CreatedAt.Format(time.RFC3339Nano), p.DeviceID, p.Protocol,
+			endpoint(p.SourceIP, p.SourceMAC, p.SourcePort), endpoint(p.DestinationIP, p.DestinationMAC, p.DestinationPort), p.FrameLength)
+	}
+	tw.Flush()
+}
+
+func endpoint(ip, mac string, port *int) string {
+	address := ip
+	if address == "" {
+		address = mac
+	}
+	if port == nil {
+		return orDash(address)
+	}
+	if strings.Contains(address, ":") {
+		return fmt.Sprintf("[%s]:%d", address, *port)
+	}
+	return fmt.Sprintf("%s:%d", address, *port)
+}
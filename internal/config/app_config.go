@@ -3,14 +3,100 @@ package config
 import (
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
+const (
+	defaultSnapLen             = 65535
+	defaultPromiscuous         = true
+	defaultPcapOutput          = "output.pcap"
+	defaultPcapMaxFileSizeMB   = 100
+	defaultPcapRotateInterval  = time.Hour
+	defaultPcapMaxFiles        = 10
+	defaultRawPacketMaxBytes   = 0
+	defaultStreamBufferSize    = 256
+	defaultStreamHeartbeat     = 15 * time.Second
+	defaultFlowIdleTimeout     = time.Minute
+	defaultFlowActiveTimeout   = 30 * time.Minute
+	defaultFlowPayloadMaxBytes = 64 << 10
+	defaultFlowPayloadBudget   = 64 << 20
+	defaultHTTPBodyMaxBytes    = 0
+	defaultDefragMaxBytes      = 4 << 20
+	defaultDefragTimeout       = 30 * time.Second
+)
+
+// DeviceConfig holds the capture settings of a single interface.
+type DeviceConfig struct {
+	Name        string
+	Filter      string
+	SnapLen     int
+	Promiscuous bool
+}
+
 type AppConfig struct {
 	Port       string
 	DBName     string
 	DeviceName string
+
+	// Devices is DeviceName split on commas, each entry with its own
+	// DEVICE_<NAME>_FILTER, DEVICE_<NAME>_SNAPLEN and DEVICE_<NAME>_PROMISC
+	// overrides. The name "any" captures on every interface that is up.
+	Devices []DeviceConfig
+
+	// PcapOutput is the path template for rolling capture files. Rotated
+	// files are written next to it with a device and timestamp suffix.
+	// Set it to "none" to disable writing capture files.
+	PcapOutput         string
+	PcapMaxFileSizeMB  int
+	PcapRotateInterval time.Duration
+	PcapMaxFiles       int
+
+	// CaptureFile switches to offline mode: a pcap/pcapng file, a directory
+	// or a glob replayed instead of capturing on DeviceName.
+	CaptureFile string
+	// ReplaySpeed is 0 for as fast as possible, 1 for real time and any
+	// other positive value to scale the original timing.
+	ReplaySpeed float64
+
+	// RawPacketMaxBytes caps the frame bytes kept per stored packet, 0
+	// disables raw packet storage.
+	RawPacketMaxBytes int
+
+	// StreamBufferSize is the number of packets buffered per live stream
+	// client before packets are dropped for it.
+	StreamBufferSize int
+	// StreamHeartbeat is the interval of the heartbeat events of live
+	// streams.
+	StreamHeartbeat time.Duration
+
+	// FlowIdleTimeout ends a flow that saw no packet for that long.
+	// FlowActiveTimeout splits the longer flows into records of at most
+	// that length, 0 disables it.
+	FlowIdleTimeout   time.Duration
+	FlowActiveTimeout time.Duration
+	// FlowPayloadMaxBytes caps the reassembled TCP payload stored for each
+	// side of a flow, FlowPayloadBudget the payload held by all the flows
+	// in progress. 0 disables reassembly.
+	FlowPayloadMaxBytes int
+	FlowPayloadBudget   int
+	// HTTPBodyMaxBytes caps the bodies stored with the HTTP transactions
+	// read from the payloads, 0 stores none.
+	HTTPBodyMaxBytes int
+
+	// ARPAllowedMACs are the MACs, or MAC prefixes, whose ARP traffic
+	// raises no alert, such as the virtual MACs of VRRP (00:00:5e:00:01)
+	// or HSRP (00:00:0c:07:ac) failover.
+	ARPAllowedMACs []string
+
+	// DefragMaxBytes caps the fragments held while their IP datagram is
+	// reassembled, 0 disables reassembly. DefragTimeout releases the
+	// fragments of a datagram that is not complete after that long.
+	DefragMaxBytes int
+	DefragTimeout  time.Duration
 }
 
 func NewAppConfig() *AppConfig {
@@ -18,9 +104,130 @@ func NewAppConfig() *AppConfig {
 	if err != nil {
 		log.Fatal("Error loading .env file: ", err)
 	}
+	deviceName := os.Getenv("DEVICE_NAME")
 	return &AppConfig{
-		Port:       os.Getenv("PORT"),
-		DBName:     os.Getenv("DB_NAME"),
-		DeviceName: os.Getenv("DEVICE_NAME"),
+		Port:                os.Getenv("PORT"),
+		DBName:              os.Getenv("DB_NAME"),
+		DeviceName:          deviceName,
+		Devices:             parseDeviceConfigs(deviceName),
+		PcapOutput:          getEnvString("PCAP_OUTPUT", defaultPcapOutput),
+		PcapMaxFileSizeMB:   getEnvInt("PCAP_MAX_FILE_SIZE_MB", defaultPcapMaxFileSizeMB),
+		PcapRotateInterval:  getEnvDuration("PCAP_ROTATE_INTERVAL", defaultPcapRotateInterval),
+		PcapMaxFiles:        getEnvInt("PCAP_MAX_FILES", defaultPcapMaxFiles),
+		CaptureFile:         os.Getenv("CAPTURE_FILE"),
+		ReplaySpeed:         getEnvFloat("REPLAY_SPEED", 0),
+		RawPacketMaxBytes:   getEnvInt("RAW_PACKET_MAX_BYTES", defaultRawPacketMaxBytes),
+		StreamBufferSize:    getEnvInt("STREAM_BUFFER_SIZE", defaultStreamBufferSize),
+		StreamHeartbeat:     getEnvDuration("STREAM_HEARTBEAT", defaultStreamHeartbeat),
+		FlowIdleTimeout:     getEnvDuration("FLOW_IDLE_TIMEOUT", defaultFlowIdleTimeout),
+		FlowActiveTimeout:   getEnvDuration("FLOW_ACTIVE_TIMEOUT", defaultFlowActiveTimeout),
+		FlowPayloadMaxBytes: getEnvInt("FLOW_PAYLOAD_MAX_BYTES", defaultFlowPayloadMaxBytes),
+		FlowPayloadBudget:   getEnvInt("FLOW_PAYLOAD_BUDGET", defaultFlowPayloadBudget),
+		HTTPBodyMaxBytes:    getEnvInt("HTTP_BODY_MAX_BYTES", defaultHTTPBodyMaxBytes),
+		ARPAllowedMACs:      getEnvList("ARP_ALLOWED_MACS"),
+		DefragMaxBytes:      getEnvInt("DEFRAG_MAX_BYTES", defaultDefragMaxBytes),
+		DefragTimeout:       getEnvDuration("DEFRAG_TIMEOUT", defaultDefragTimeout),
+	}
+}
+
+func parseDeviceConfigs(deviceName string) []DeviceConfig {
+	filter := os.Getenv("CAPTURE_FILTER")
+	snapLen := getEnvInt("CAPTURE_SNAPLEN", defaultSnapLen)
+	promiscuous := getEnvBool("CAPTURE_PROMISC", defaultPromiscuous)
+
+	devices := make([]DeviceConfig, 0)
+	for _, name := range strings.Split(deviceName, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		prefix := "DEVICE_" + envKey(name) + "_"
+		devices = append(devices, DeviceConfig{
+			Name:        name,
+			Filter:      getEnvString(prefix+"FILTER", filter),
+			SnapLen:     getEnvInt(prefix+"SNAPLEN", snapLen),
+			Promiscuous: getEnvBool(prefix+"PROMISC", promiscuous),
+		})
+	}
+	return devices
+}
+
+// envKey turns an interface name such as "br-lan" into "BR_LAN".
+func envKey(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+func getEnvString(key, fallback string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+// getEnvList splits a comma separated variable, dropping the empty items.
+func getEnvList(key string) []string {
+	var items []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func getEnvInt(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("Invalid value for %s: %v", key, err)
+	}
+	return parsed
+}
+
+func getEnvBool(key string, fallback bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Fatalf("Invalid value for %s: %v", key, err)
+	}
+	return parsed
+}
+
+func getEnvFloat(key string, fallback float64) float64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil || parsed < 0 {
+		log.Fatalf("Invalid value for %s: %q", key, value)
+	}
+	return parsed
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("Invalid value for %s: %v", key, err)
 	}
+	return parsed
 }
@@ -250,3 +250,39 @@ DEVICE_NAME=eth0`
 		t.Errorf("expected modified Port '9999', got '%s'", config.Port)
 	}
 }
+
+func TestParseDeviceConfigs(t *testing.T) {
+	t.Setenv("CAPTURE_FILTER", "tcp or udp")
+	t.Setenv("CAPTURE_SNAPLEN", "1500")
+	t.Setenv("CAPTURE_PROMISC", "false")
+	t.Setenv("DEVICE_BR_LAN_FILTER", "arp")
+	t.Setenv("DEVICE_BR_LAN_PROMISC", "true")
+	t.Setenv("DEVICE_ETH0_SNAPLEN", "128")
+
+	devices := parseDeviceConfigs("eth0, br-lan,,any")
+
+	if len(devices) != 3 {
+		t.Fatalf("expected 3 devices, got %d", len(devices))
+	}
+	expected := []DeviceConfig{
+		{Name: "eth0", Filter: "tcp or udp", SnapLen: 128, Promiscuous: false},
+		{Name: "br-lan", Filter: "arp", SnapLen: 1500, Promiscuous: true},
+		{Name: "any", Filter: "tcp or udp", SnapLen: 1500, Promiscuous: false},
+	}
+	for i, want := range expected {
+		if devices[i] != want {
+			t.Errorf("device %d: expected %+v, got %+v", i, want, devices[i])
+		}
+	}
+}
+
+func TestParseDeviceConfigs_Defaults(t *testing.T) {
+	devices := parseDeviceConfigs("wlan0")
+
+	if len(devices) != 1 {
+		t.Fatalf("expected 1 device, got %d", len(devices))
+	}
+	if devices[0].SnapLen != 65535 || !devices[0].Promiscuous || devices[0].Filter != "" {
+		t.Errorf("unexpected defaults: %+v", devices[0])
+	}
+}
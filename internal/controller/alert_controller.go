@@ -0,0 +1,112 @@
+package controller
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	internal "github.com/impact-dryer/gotattletale/internal/service"
+	"github.com/impact-dryer/gotattletale/pkg"
+)
+
+type AlertController interface {
+	GetAlerts(c *gin.Context)
+	GetAlert(c *gin.Context)
+	GetAlertPcap(c *gin.Context)
+}
+
+type AlertControllerImpl struct {
+	Service internal.AlertService
+}
+
+func (controller *AlertControllerImpl) GetAlerts(c *gin.Context) {
+	filter, err := alertFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	alerts, err := controller.Service.GetAlerts(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, alerts)
+}
+
+func (controller *AlertControllerImpl) GetAlert(c *gin.Context) {
+	alert, ok := controller.alert(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, alert)
+}
+
+// GetAlertPcap serves the evidence packets of an alert as a pcap file.
+func (controller *AlertControllerImpl) GetAlertPcap(c *gin.Context) {
+	alert, ok := controller.alert(c)
+	if !ok {
+		return
+	}
+	var buf bytes.Buffer
+	if err := pkg.WriteAlertPcap(&buf, alert); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=alert-%d.pcap", alert.ID))
+	c.Data(http.StatusOK, "application/vnd.tcpdump.pcap", buf.Bytes())
+}
+
+func (controller *AlertControllerImpl) alert(c *gin.Context) (pkg.Alert, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid alert id"})
+		return pkg.Alert{}, false
+	}
+	alert, err := controller.Service.GetAlert(uint(id))
+	if errors.Is(err, pkg.ErrAlertNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return pkg.Alert{}, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return pkg.Alert{}, false
+	}
+	return alert, true
+}
+
+// alertFilter reads the alert listing query parameters. Types are case
+// insensitive.
+func alertFilter(c *gin.Context) (pkg.AlertFilter, error) {
+	filter := pkg.AlertFilter{Types: lowerList(c.Query("type")), Devices: queryList(c.Query("device"))}
+	var err error
+	if limit := c.Query("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil || filter.Limit < 0 {
+			return filter, fmt.Errorf("invalid limit %q", limit)
+		}
+	}
+	if filter.From, err = queryTime(c, "from"); err != nil {
+		return filter, err
+	}
+	if filter.To, err = queryTime(c, "to"); err != nil {
+		return filter, err
+	}
+	if filter.IPs, err = pkg.ParseIPNets(c.Query("ip")); err != nil {
+		return filter, err
+	}
+	for _, item := range queryList(c.Query("mac")) {
+		mac, err := net.ParseMAC(item)
+		if err != nil {
+			return filter, fmt.Errorf("invalid mac %q", item)
+		}
+		filter.MACs = append(filter.MACs, mac.String())
+	}
+	return filter, nil
+}
+
+func NewAlertController(service internal.AlertService) AlertController {
+	return &AlertControllerImpl{Service: service}
+}
@@ -0,0 +1,148 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	internal "github.com/impact-dryer/gotattletale/internal/service"
+	"github.com/impact-dryer/gotattletale/pkg"
+)
+
+type CaptureController interface {
+	StartCapture(c *gin.Context)
+	ListCaptures(c *gin.Context)
+	GetCapture(c *gin.Context)
+	StopCapture(c *gin.Context)
+	UpdateCapture(c *gin.Context)
+}
+
+type CaptureControllerImpl struct {
+	Service internal.CaptureService
+}
+
+type startCaptureRequest struct {
+	Device      string `json:"device" binding:"required"`
+	Filter      string `json:"filter"`
+	SnapLen     int    `json:"snaplen"`
+	Promiscuous *bool  `json:"promiscuous"`
+	Duration    string `json:"duration"`
+	MaxPackets  uint64 `json:"max_packets"`
+}
+
+func (controller *CaptureControllerImpl) StartCapture(c *gin.Context) {
+	var body startCaptureRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	request := internal.CaptureRequest{
+		Device:      body.Device,
+		Filter:      body.Filter,
+		SnapLen:     body.SnapLen,
+		Promiscuous: body.Promiscuous == nil || *body.Promiscuous,
+		MaxPackets:  body.MaxPackets,
+	}
+	if body.Duration != "" {
+		duration, err := time.ParseDuration(body.Duration)
+		if err != nil || duration < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid duration: " + body.Duration})
+			return
+		}
+		request.Duration = duration
+	}
+
+	session, err := controller.Service.StartCapture(request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, session)
+}
+
+func (controller *CaptureControllerImpl) ListCaptures(c *gin.Context) {
+	sessions, err := controller.Service.ListCaptures()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, sessions)
+}
+
+func (controller *CaptureControllerImpl) GetCapture(c *gin.Context) {
+	id, ok := captureID(c)
+	if !ok {
+		return
+	}
+	session, err := controller.Service.GetCapture(id)
+	if err != nil {
+		captureError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, session)
+}
+
+func (controller *CaptureControllerImpl) StopCapture(c *gin.Context) {
+	id, ok := captureID(c)
+	if !ok {
+		return
+	}
+	session, err := controller.Service.StopCapture(id)
+	if err != nil {
+		captureError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, session)
+}
+
+type updateCaptureRequest struct {
+	Filter *string `json:"filter" binding:"required"`
+}
+
+func (controller *CaptureControllerImpl) UpdateCapture(c *gin.Context) {
+	id, ok := captureID(c)
+	if !ok {
+		return
+	}
+	var body updateCaptureRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	session, err := controller.Service.UpdateCaptureFilter(id, *body.Filter)
+	if err != nil {
+		captureError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, session)
+}
+
+func captureID(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid capture id"})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+func captureError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, pkg.ErrCaptureSessionNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, internal.ErrCaptureNotRunning):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, pkg.ErrInvalidFilter), errors.Is(err, pkg.ErrFilterNotSupported):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
+
+func NewCaptureController(service internal.CaptureService) CaptureController {
+	return &CaptureControllerImpl{Service: service}
+}
@@ -0,0 +1,24 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	internal "github.com/impact-dryer/gotattletale/internal/service"
+)
+
+type DefragController interface {
+	GetStats(c *gin.Context)
+}
+
+type DefragControllerImpl struct {
+	Service internal.DefragService
+}
+
+func (controller *DefragControllerImpl) GetStats(c *gin.Context) {
+	c.JSON(http.StatusOK, controller.Service.GetStats())
+}
+
+func NewDefragController(service internal.DefragService) DefragController {
+	return &DefragControllerImpl{Service: service}
+}
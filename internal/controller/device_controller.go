@@ -0,0 +1,29 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	internal "github.com/impact-dryer/gotattletale/internal/service"
+)
+
+type DeviceController interface {
+	ListDevices(c *gin.Context)
+}
+
+type DeviceControllerImpl struct {
+	Service internal.DeviceService
+}
+
+func (controller *DeviceControllerImpl) ListDevices(c *gin.Context) {
+	devices, err := controller.Service.ListDevices()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, devices)
+}
+
+func NewDeviceController(service internal.DeviceService) DeviceController {
+	return &DeviceControllerImpl{Service: service}
+}
@@ -0,0 +1,81 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	internal "github.com/impact-dryer/gotattletale/internal/service"
+	"github.com/impact-dryer/gotattletale/pkg"
+)
+
+type DNSController interface {
+	GetDNSEvents(c *gin.Context)
+}
+
+type DNSControllerImpl struct {
+	Service internal.DNSService
+}
+
+func (controller *DNSControllerImpl) GetDNSEvents(c *gin.Context) {
+	filter, err := dnsFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	events, err := controller.Service.GetDNSEvents(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, events)
+}
+
+// dnsFilter reads the DNS log query parameters. Types and response codes
+// are comma separated and case insensitive.
+func dnsFilter(c *gin.Context) (pkg.DNSFilter, error) {
+	filter := pkg.DNSFilter{Name: c.Query("name")}
+	var err error
+	if limit := c.Query("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil || filter.Limit < 0 {
+			return filter, fmt.Errorf("invalid limit %q", limit)
+		}
+	}
+	if filter.From, err = queryTime(c, "from"); err != nil {
+		return filter, err
+	}
+	if filter.To, err = queryTime(c, "to"); err != nil {
+		return filter, err
+	}
+	if filter.ClientIPs, err = pkg.ParseIPNets(c.Query("client")); err != nil {
+		return filter, err
+	}
+	filter.Types = upperList(c.Query("type"))
+	filter.RCodes = upperList(c.Query("rcode"))
+	return filter, nil
+}
+
+// queryList splits a comma separated parameter, dropping the empty items.
+func queryList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func upperList(value string) []string {
+	items := queryList(value)
+	for i, item := range items {
+		items[i] = strings.ToUpper(item)
+	}
+	return items
+}
+
+func NewDNSController(service internal.DNSService) DNSController {
+	return &DNSControllerImpl{Service: service}
+}
@@ -0,0 +1,46 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/gopacket/layers"
+	internal "github.com/impact-dryer/gotattletale/internal/service"
+)
+
+type FilterController interface {
+	ValidateFilter(c *gin.Context)
+}
+
+type FilterControllerImpl struct {
+	Service internal.FilterService
+}
+
+type validateFilterRequest struct {
+	Expression string `json:"expression"`
+	// LinkType is the DLT number the expression is compiled for, Ethernet
+	// when omitted.
+	LinkType *int `json:"link_type"`
+	SnapLen  int  `json:"snaplen"`
+}
+
+func (controller *FilterControllerImpl) ValidateFilter(c *gin.Context) {
+	var body validateFilterRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	linkType := layers.LinkTypeEthernet
+	if body.LinkType != nil {
+		if *body.LinkType < 0 || *body.LinkType > 0xff {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid link_type"})
+			return
+		}
+		linkType = layers.LinkType(*body.LinkType)
+	}
+	c.JSON(http.StatusOK, controller.Service.ValidateFilter(body.Expression, linkType, body.SnapLen))
+}
+
+func NewFilterController(service internal.FilterService) FilterController {
+	return &FilterControllerImpl{Service: service}
+}
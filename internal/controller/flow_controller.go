@@ -0,0 +1,100 @@
+package controller
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	internal "github.com/impact-dryer/gotattletale/internal/service"
+	"github.com/impact-dryer/gotattletale/pkg"
+)
+
+type FlowController interface {
+	GetFlows(c *gin.Context)
+	GetFlowStream(c *gin.Context)
+}
+
+type FlowControllerImpl struct {
+	Service internal.FlowService
+}
+
+func (controller *FlowControllerImpl) GetFlows(c *gin.Context) {
+	filter, err := flowFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	flows, err := controller.Service.GetFlows(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, flows)
+}
+
+// flowFilter reads the flow listing query parameters, a subset of the
+// packet listing ones.
+func flowFilter(c *gin.Context) (pkg.FlowFilter, error) {
+	var filter pkg.FlowFilter
+	var err error
+	if limit := c.Query("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil || filter.Limit < 0 {
+			return filter, fmt.Errorf("invalid limit %q", limit)
+		}
+	}
+	if filter.From, err = queryTime(c, "from"); err != nil {
+		return filter, err
+	}
+	if filter.To, err = queryTime(c, "to"); err != nil {
+		return filter, err
+	}
+	if filter.IPs, err = pkg.ParseIPNets(c.Query("ip")); err != nil {
+		return filter, err
+	}
+	if filter.Ports, err = pkg.ParsePortRanges(c.Query("port")); err != nil {
+		return filter, err
+	}
+	if filter.Protocols, err = pkg.ParseProtocols(c.Query("protocol")); err != nil {
+		return filter, err
+	}
+	for _, device := range strings.Split(c.Query("device"), ",") {
+		if device = strings.TrimSpace(device); device != "" {
+			filter.Devices = append(filter.Devices, device)
+		}
+	}
+	filter.ConnectionIDs = lowerList(c.Query("cid"))
+	return filter, nil
+}
+
+// GetFlowStream returns the reassembled conversation of a TCP flow as
+// text, in the view given by ?format= (ascii by default, hex or raw).
+func (controller *FlowControllerImpl) GetFlowStream(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid flow id"})
+		return
+	}
+	flow, payload, err := controller.Service.GetFlowStream(uint(id))
+	if err != nil {
+		if errors.Is(err, pkg.ErrFlowNotFound) || errors.Is(err, pkg.ErrFlowPayloadNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	var buf bytes.Buffer
+	if err := pkg.WriteFlowStream(&buf, flow, payload, c.DefaultQuery("format", pkg.StreamFormatASCII)); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.Data(http.StatusOK, "text/plain; charset=utf-8", buf.Bytes())
+}
+
+func NewFlowController(service internal.FlowService) FlowController {
+	return &FlowControllerImpl{Service: service}
+}
@@ -0,0 +1,67 @@
+package controller
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	internal "github.com/impact-dryer/gotattletale/internal/service"
+	"github.com/impact-dryer/gotattletale/pkg"
+)
+
+type HostController interface {
+	GetHosts(c *gin.Context)
+}
+
+type HostControllerImpl struct {
+	Service internal.HostService
+}
+
+func (controller *HostControllerImpl) GetHosts(c *gin.Context) {
+	filter, err := hostFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	hosts, err := controller.Service.GetHosts(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, hosts)
+}
+
+// hostFilter reads the host inventory query parameters. MACs take any
+// notation net.ParseMAC does, hostnames are case insensitive.
+func hostFilter(c *gin.Context) (pkg.HostFilter, error) {
+	filter := pkg.HostFilter{Vendor: c.Query("vendor"), Hostnames: lowerList(c.Query("hostname"))}
+	var err error
+	if limit := c.Query("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil || filter.Limit < 0 {
+			return filter, fmt.Errorf("invalid limit %q", limit)
+		}
+	}
+	if filter.From, err = queryTime(c, "from"); err != nil {
+		return filter, err
+	}
+	if filter.To, err = queryTime(c, "to"); err != nil {
+		return filter, err
+	}
+	if filter.IPs, err = pkg.ParseIPNets(c.Query("ip")); err != nil {
+		return filter, err
+	}
+	for _, item := range queryList(c.Query("mac")) {
+		mac, err := net.ParseMAC(item)
+		if err != nil {
+			return filter, fmt.Errorf("invalid mac %q", item)
+		}
+		filter.MACs = append(filter.MACs, mac.String())
+	}
+	return filter, nil
+}
+
+func NewHostController(service internal.HostService) HostController {
+	return &HostControllerImpl{Service: service}
+}
@@ -0,0 +1,76 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	internal "github.com/impact-dryer/gotattletale/internal/service"
+	"github.com/impact-dryer/gotattletale/pkg"
+)
+
+type HTTPController interface {
+	GetHTTPTransactions(c *gin.Context)
+}
+
+type HTTPControllerImpl struct {
+	Service internal.HTTPService
+}
+
+func (controller *HTTPControllerImpl) GetHTTPTransactions(c *gin.Context) {
+	filter, err := httpFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	transactions, err := controller.Service.GetHTTPTransactions(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, transactions)
+}
+
+// httpFilter reads the HTTP transaction query parameters. Hosts, methods
+// and status codes are comma separated, hosts and methods case
+// insensitive.
+func httpFilter(c *gin.Context) (pkg.HTTPFilter, error) {
+	var filter pkg.HTTPFilter
+	var err error
+	if limit := c.Query("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil || filter.Limit < 0 {
+			return filter, fmt.Errorf("invalid limit %q", limit)
+		}
+	}
+	if filter.From, err = queryTime(c, "from"); err != nil {
+		return filter, err
+	}
+	if filter.To, err = queryTime(c, "to"); err != nil {
+		return filter, err
+	}
+	if flow := c.Query("flow"); flow != "" {
+		id, err := strconv.ParseUint(flow, 10, 64)
+		if err != nil {
+			return filter, fmt.Errorf("invalid flow %q", flow)
+		}
+		filter.FlowID = uint(id)
+	}
+	if filter.IPs, err = pkg.ParseIPNets(c.Query("ip")); err != nil {
+		return filter, err
+	}
+	filter.Hosts = lowerList(c.Query("host"))
+	filter.Methods = upperList(c.Query("method"))
+	for _, status := range queryList(c.Query("status")) {
+		code, err := strconv.Atoi(status)
+		if err != nil || code < 100 || code > 999 {
+			return filter, fmt.Errorf("invalid status %q", status)
+		}
+		filter.StatusCodes = append(filter.StatusCodes, code)
+	}
+	return filter, nil
+}
+
+func NewHTTPController(service internal.HTTPService) HTTPController {
+	return &HTTPControllerImpl{Service: service}
+}
@@ -0,0 +1,102 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	internal "github.com/impact-dryer/gotattletale/internal/service"
+	"github.com/impact-dryer/gotattletale/pkg"
+)
+
+type ICMPController interface {
+	GetPings(c *gin.Context)
+	GetUnreachable(c *gin.Context)
+}
+
+type ICMPControllerImpl struct {
+	Service internal.ICMPService
+}
+
+func (controller *ICMPControllerImpl) GetPings(c *gin.Context) {
+	filter, err := pingFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	pings, err := controller.Service.GetPings(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, pings)
+}
+
+func (controller *ICMPControllerImpl) GetUnreachable(c *gin.Context) {
+	filter, err := unreachableFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	sources, err := controller.Service.GetUnreachable(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, sources)
+}
+
+// pingFilter reads the ping listing query parameters.
+func pingFilter(c *gin.Context) (pkg.PingFilter, error) {
+	var filter pkg.PingFilter
+	var err error
+	if limit := c.Query("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil || filter.Limit < 0 {
+			return filter, fmt.Errorf("invalid limit %q", limit)
+		}
+	}
+	if filter.From, err = queryTime(c, "from"); err != nil {
+		return filter, err
+	}
+	if filter.To, err = queryTime(c, "to"); err != nil {
+		return filter, err
+	}
+	if filter.Sources, err = pkg.ParseIPNets(c.Query("src_ip")); err != nil {
+		return filter, err
+	}
+	if filter.Destinations, err = pkg.ParseIPNets(c.Query("dst_ip")); err != nil {
+		return filter, err
+	}
+	if lost := c.Query("lost"); lost != "" {
+		if filter.Lost, err = strconv.ParseBool(lost); err != nil {
+			return filter, fmt.Errorf("invalid lost %q", lost)
+		}
+	}
+	return filter, nil
+}
+
+// unreachableFilter reads the unreachable summary query parameters.
+func unreachableFilter(c *gin.Context) (pkg.UnreachableFilter, error) {
+	var filter pkg.UnreachableFilter
+	var err error
+	if limit := c.Query("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil || filter.Limit < 0 {
+			return filter, fmt.Errorf("invalid limit %q", limit)
+		}
+	}
+	if filter.From, err = queryTime(c, "from"); err != nil {
+		return filter, err
+	}
+	if filter.To, err = queryTime(c, "to"); err != nil {
+		return filter, err
+	}
+	if filter.Sources, err = pkg.ParseIPNets(c.Query("src_ip")); err != nil {
+		return filter, err
+	}
+	return filter, nil
+}
+
+func NewICMPController(service internal.ICMPService) ICMPController {
+	return &ICMPControllerImpl{Service: service}
+}
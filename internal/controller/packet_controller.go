@@ -1,14 +1,26 @@
 package controller
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	internal "github.com/impact-dryer/gotattletale/internal/service"
+	"github.com/impact-dryer/gotattletale/pkg"
 )
 
 type PacketController interface {
 	GetPackets(c *gin.Context)
+	GetPacket(c *gin.Context)
+	DeletePacket(c *gin.Context)
+	UpdatePacket(c *gin.Context)
+	GetPacketRaw(c *gin.Context)
+	GetPacketPcap(c *gin.Context)
 }
 
 type PacketControllerImpl struct {
@@ -16,20 +28,206 @@ type PacketControllerImpl struct {
 }
 
 func (controller *PacketControllerImpl) GetPackets(c *gin.Context) {
-	limit := c.GetInt("limit")
-	if limit <= 0 {
-		limit = 100
+	filter, err := packetFilter(c)
+	if err != nil {
+		packetFilterError(c, err)
+		return
+	}
+	page, err := controller.Service.GetPackets(filter)
+	if err != nil {
+		if errors.Is(err, pkg.ErrInvalidPacketFilter) {
+			packetFilterError(c, err)
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+// packetFilter reads the packet listing query parameters. Lists are comma
+// separated, times are RFC 3339.
+func packetFilter(c *gin.Context) (pkg.PacketFilter, error) {
+	filter := pkg.PacketFilter{
+		Sort:      c.Query("sort"),
+		Order:     c.Query("order"),
+		Direction: c.Query("direction"),
+		Tag:       c.Query("tag"),
+	}
+	var err error
+	if limit := c.Query("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil || filter.Limit < 0 {
+			return filter, fmt.Errorf("invalid limit %q", limit)
+		}
+	}
+	if filter.From, err = queryTime(c, "from"); err != nil {
+		return filter, err
+	}
+	if filter.To, err = queryTime(c, "to"); err != nil {
+		return filter, err
+	}
+	if filter.SourceIPs, err = pkg.ParseIPNets(c.Query("src_ip")); err != nil {
+		return filter, err
+	}
+	if filter.DestinationIPs, err = pkg.ParseIPNets(c.Query("dst_ip")); err != nil {
+		return filter, err
+	}
+	if filter.IPs, err = pkg.ParseIPNets(c.Query("ip")); err != nil {
+		return filter, err
+	}
+	if filter.SourcePorts, err = pkg.ParsePortRanges(c.Query("src_port")); err != nil {
+		return filter, err
+	}
+	if filter.DestinationPorts, err = pkg.ParsePortRanges(c.Query("dst_port")); err != nil {
+		return filter, err
+	}
+	if filter.Ports, err = pkg.ParsePortRanges(c.Query("port")); err != nil {
+		return filter, err
+	}
+	if filter.Protocols, err = pkg.ParseProtocols(c.Query("protocol")); err != nil {
+		return filter, err
+	}
+	if q := c.Query("q"); q != "" {
+		if filter.Query, err = pkg.ParseDisplayFilter(q); err != nil {
+			return filter, err
+		}
+	}
+	if cursor := c.Query("cursor"); cursor != "" {
+		if filter.Cursor, err = pkg.DecodePacketCursor(cursor); err != nil {
+			return filter, err
+		}
+	}
+	for _, device := range strings.Split(c.Query("device"), ",") {
+		if device = strings.TrimSpace(device); device != "" {
+			filter.Devices = append(filter.Devices, device)
+		}
+	}
+	return filter, nil
+}
+
+// packetFilterError answers 400, with the position of the error in the
+// display filter when it is one.
+func packetFilterError(c *gin.Context, err error) {
+	var displayErr *pkg.DisplayFilterError
+	if errors.As(err, &displayErr) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "position": displayErr.Position})
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+}
+
+func queryTime(c *gin.Context, key string) (time.Time, error) {
+	value := c.Query(key)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s %q, expected RFC 3339", key, value)
+	}
+	return t, nil
+}
+
+func (controller *PacketControllerImpl) GetPacket(c *gin.Context) {
+	id, ok := packetID(c)
+	if !ok {
+		return
+	}
+	packet, err := controller.Service.GetPacket(id)
+	if err != nil {
+		packetError(c, err)
+		return
 	}
+	c.JSON(http.StatusOK, packet)
+}
 
-	sort := c.GetString("sort")
-	if sort == "" {
-		sort = "created_at"
+func (controller *PacketControllerImpl) DeletePacket(c *gin.Context) {
+	id, ok := packetID(c)
+	if !ok {
+		return
 	}
-	packets, err := controller.Service.GetPackets(limit, sort)
+	if err := controller.Service.DeletePacket(id); err != nil {
+		packetError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+type updatePacketRequest struct {
+	Tags  *[]string `json:"tags"`
+	Notes *string   `json:"notes"`
+}
+
+func (controller *PacketControllerImpl) UpdatePacket(c *gin.Context) {
+	id, ok := packetID(c)
+	if !ok {
+		return
+	}
+	var body updatePacketRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	packet, err := controller.Service.UpdatePacket(id, pkg.PacketUpdate{Tags: body.Tags, Notes: body.Notes})
 	if err != nil {
+		packetError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, packet)
+}
+
+func (controller *PacketControllerImpl) GetPacketRaw(c *gin.Context) {
+	data, ok := controller.packetData(c)
+	if !ok {
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=packet-%d.bin", data.PacketID))
+	c.Header("X-Packet-Truncated", strconv.FormatBool(data.Truncated))
+	c.Data(http.StatusOK, "application/octet-stream", data.Data)
+}
+
+func (controller *PacketControllerImpl) GetPacketPcap(c *gin.Context) {
+	data, ok := controller.packetData(c)
+	if !ok {
+		return
+	}
+	var buf bytes.Buffer
+	if err := pkg.WritePacketPcap(&buf, data); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=packet-%d.pcap", data.PacketID))
+	c.Data(http.StatusOK, "application/vnd.tcpdump.pcap", buf.Bytes())
+}
+
+func (controller *PacketControllerImpl) packetData(c *gin.Context) (pkg.PacketData, bool) {
+	id, ok := packetID(c)
+	if !ok {
+		return pkg.PacketData{}, false
+	}
+	data, err := controller.Service.GetPacketData(id)
+	if err != nil {
+		packetError(c, err)
+		return pkg.PacketData{}, false
+	}
+	return data, true
+}
+
+func packetID(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid packet id"})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+func packetError(c *gin.Context, err error) {
+	if errors.Is(err, pkg.ErrPacketNotFound) || errors.Is(err, pkg.ErrPacketDataNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
 	}
-	c.JSON(http.StatusOK, packets)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 }
 
 func NewPacketController(service internal.PacketService) PacketController {
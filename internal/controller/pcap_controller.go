@@ -0,0 +1,46 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	internal "github.com/impact-dryer/gotattletale/internal/service"
+	"github.com/impact-dryer/gotattletale/pkg"
+)
+
+type PcapController interface {
+	ListPcapFiles(c *gin.Context)
+	DownloadPcapFile(c *gin.Context)
+}
+
+type PcapControllerImpl struct {
+	Service internal.PcapService
+}
+
+func (controller *PcapControllerImpl) ListPcapFiles(c *gin.Context) {
+	files, err := controller.Service.ListPcapFiles()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, files)
+}
+
+func (controller *PcapControllerImpl) DownloadPcapFile(c *gin.Context) {
+	name := c.Param("name")
+	path, err := controller.Service.GetPcapFilePath(name)
+	if errors.Is(err, pkg.ErrPcapFileNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.FileAttachment(path, name)
+}
+
+func NewPcapController(service internal.PcapService) PcapController {
+	return &PcapControllerImpl{Service: service}
+}
@@ -0,0 +1,80 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	internal "github.com/impact-dryer/gotattletale/internal/service"
+	"github.com/impact-dryer/gotattletale/pkg"
+	"golang.org/x/net/websocket"
+)
+
+type StreamController interface {
+	StreamPackets(c *gin.Context)
+}
+
+type StreamControllerImpl struct {
+	Service internal.StreamService
+}
+
+// StreamPackets streams the live packets matching the packet listing query
+// parameters, as Server-Sent Events or, when the client asks for an
+// upgrade, as WebSocket JSON messages.
+func (controller *StreamControllerImpl) StreamPackets(c *gin.Context) {
+	filter, err := packetFilter(c)
+	if err == nil {
+		err = filter.Validate()
+	}
+	if err != nil {
+		packetFilterError(c, err)
+		return
+	}
+	if strings.EqualFold(c.GetHeader("Upgrade"), "websocket") {
+		controller.streamWebSocket(c, filter)
+		return
+	}
+	controller.streamEvents(c, filter)
+}
+
+func (controller *StreamControllerImpl) streamEvents(c *gin.Context, filter pkg.PacketFilter) {
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.SSEvent(internal.StreamEventHeartbeat, internal.StreamEvent{Type: internal.StreamEventHeartbeat, Time: time.Now()})
+	c.Writer.Flush()
+	err := controller.Service.StreamPackets(c.Request.Context(), filter, func(event internal.StreamEvent) error {
+		c.SSEvent(event.Type, event)
+		c.Writer.Flush()
+		return c.Request.Context().Err()
+	})
+	if err != nil && !errors.Is(err, context.Canceled) {
+		c.Error(err)
+	}
+}
+
+func (controller *StreamControllerImpl) streamWebSocket(c *gin.Context, filter pkg.PacketFilter) {
+	// The API has no origin restrictions, so the handshake accepts any.
+	server := websocket.Server{Handler: func(conn *websocket.Conn) {
+		ctx, cancel := context.WithCancel(c.Request.Context())
+		defer cancel()
+		// Clients do not send anything, reading only notices the close.
+		go func() {
+			defer cancel()
+			var ignored []byte
+			for websocket.Message.Receive(conn, &ignored) == nil {
+			}
+		}()
+		controller.Service.StreamPackets(ctx, filter, func(event internal.StreamEvent) error {
+			return websocket.JSON.Send(conn, event)
+		})
+	}}
+	server.ServeHTTP(c.Writer, c.Request)
+}
+
+func NewStreamController(service internal.StreamService) StreamController {
+	return &StreamControllerImpl{Service: service}
+}
@@ -0,0 +1,79 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	internal "github.com/impact-dryer/gotattletale/internal/service"
+	"github.com/impact-dryer/gotattletale/pkg"
+)
+
+type TLSController interface {
+	GetTLSSessions(c *gin.Context)
+}
+
+type TLSControllerImpl struct {
+	Service internal.TLSService
+}
+
+func (controller *TLSControllerImpl) GetTLSSessions(c *gin.Context) {
+	filter, err := tlsFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	sessions, err := controller.Service.GetTLSSessions(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, sessions)
+}
+
+// tlsFilter reads the TLS session query parameters. Fingerprints and
+// versions are comma separated.
+func tlsFilter(c *gin.Context) (pkg.TLSFilter, error) {
+	filter := pkg.TLSFilter{ServerName: c.Query("sni")}
+	var err error
+	if limit := c.Query("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil || filter.Limit < 0 {
+			return filter, fmt.Errorf("invalid limit %q", limit)
+		}
+	}
+	if filter.From, err = queryTime(c, "from"); err != nil {
+		return filter, err
+	}
+	if filter.To, err = queryTime(c, "to"); err != nil {
+		return filter, err
+	}
+	if flow := c.Query("flow"); flow != "" {
+		id, err := strconv.ParseUint(flow, 10, 64)
+		if err != nil {
+			return filter, fmt.Errorf("invalid flow %q", flow)
+		}
+		filter.FlowID = uint(id)
+	}
+	if filter.IPs, err = pkg.ParseIPNets(c.Query("ip")); err != nil {
+		return filter, err
+	}
+	filter.JA3 = lowerList(c.Query("ja3"))
+	filter.JA3S = lowerList(c.Query("ja3s"))
+	filter.JA4 = lowerList(c.Query("ja4"))
+	filter.Versions = queryList(c.Query("version"))
+	return filter, nil
+}
+
+func lowerList(value string) []string {
+	items := queryList(value)
+	for i, item := range items {
+		items[i] = strings.ToLower(item)
+	}
+	return items
+}
+
+func NewTLSController(service internal.TLSService) TLSController {
+	return &TLSControllerImpl{Service: service}
+}
@@ -0,0 +1,59 @@
+package service
+
+import (
+	"log"
+	"time"
+
+	"github.com/impact-dryer/gotattletale/internal/config"
+	"github.com/impact-dryer/gotattletale/pkg"
+	"go.uber.org/fx"
+)
+
+const (
+	// alertSaveInterval is how often the raised alerts are stored.
+	alertSaveInterval = time.Second
+)
+
+type AlertService interface {
+	GetAlerts(filter pkg.AlertFilter) ([]pkg.Alert, error)
+	GetAlert(id uint) (pkg.Alert, error)
+}
+
+type AlertServiceImpl struct {
+	Storage pkg.AlertRepository
+}
+
+func (s AlertServiceImpl) GetAlerts(filter pkg.AlertFilter) ([]pkg.Alert, error) {
+	filter.Limit = clampLimit(filter.Limit)
+	return s.Storage.GetAlerts(filter)
+}
+
+func (s AlertServiceImpl) GetAlert(id uint) (pkg.Alert, error) {
+	return s.Storage.GetAlert(id)
+}
+
+func NewAlertService(storage pkg.AlertRepository) AlertService {
+	return &AlertServiceImpl{Storage: storage}
+}
+
+func NewARPMonitor(appConfig *config.AppConfig) (*pkg.ARPMonitor, error) {
+	return pkg.NewARPMonitor(appConfig.ARPAllowedMACs)
+}
+
+// TrackAlerts logs and stores the alerts raised by the ARP monitor and the
+// defragmenter.
+func TrackAlerts(arp *pkg.ARPMonitor, defragmenter *pkg.Defragmenter, repository pkg.AlertRepository, lifecycle fx.Lifecycle) {
+	save := func() {
+		saveAlerts(repository, append(arp.Take(), defragmenter.Take()...))
+	}
+	runPeriodically(lifecycle, alertSaveInterval, func(time.Time) { save() }, save)
+}
+
+func saveAlerts(repository pkg.AlertRepository, alerts []pkg.Alert) {
+	for _, alert := range alerts {
+		log.Printf("Alert %s on %s: %s", alert.Type, alert.DeviceID, alert.Message)
+	}
+	if err := repository.SaveAlerts(alerts); err != nil {
+		log.Println("Failed to store alerts:", err)
+	}
+}
@@ -0,0 +1,75 @@
+package service
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/impact-dryer/gotattletale/pkg"
+	"go.uber.org/fx/fxtest"
+)
+
+type MockAlertRepository struct {
+	saved  []pkg.Alert
+	filter pkg.AlertFilter
+}
+
+func (m *MockAlertRepository) SaveAlerts(alerts []pkg.Alert) error {
+	m.saved = append(m.saved, alerts...)
+	return nil
+}
+
+func (m *MockAlertRepository) GetAlerts(filter pkg.AlertFilter) ([]pkg.Alert, error) {
+	m.filter = filter
+	return []pkg.Alert{{ID: 1}}, nil
+}
+
+func (m *MockAlertRepository) GetAlert(id uint) (pkg.Alert, error) {
+	return pkg.Alert{ID: id}, nil
+}
+
+func newTestARPMonitor(t *testing.T) *pkg.ARPMonitor {
+	t.Helper()
+	monitor, err := pkg.NewARPMonitor(nil)
+	if err != nil {
+		t.Fatalf("NewARPMonitor failed: %v", err)
+	}
+	return monitor
+}
+
+// arpTestPacket returns an ARP reply in which mac claims ip.
+func arpTestPacket(t *testing.T, mac net.HardwareAddr, ip string, at time.Time) pkg.AppPacket {
+	t.Helper()
+	eth := &layers.Ethernet{SrcMAC: mac, DstMAC: layers.EthernetBroadcast, EthernetType: layers.EthernetTypeARP}
+	arp := &layers.ARP{
+		AddrType: layers.LinkTypeEthernet, Protocol: layers.EthernetTypeIPv4, HwAddressSize: 6, ProtAddressSize: 4, Operation: layers.ARPReply,
+		SourceHwAddress: mac, SourceProtAddress: net.ParseIP(ip).To4(), DstHwAddress: net.HardwareAddr{0x02, 0, 0, 0, 0, 0xff}, DstProtAddress: []byte{10, 0, 0, 254},
+	}
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, eth, arp); err != nil {
+		t.Fatalf("failed to serialize ARP: %v", err)
+	}
+	return pkg.AppPacket{Data: gopacket.NewPacket(buf.Bytes(), layers.LayerTypeEthernet, gopacket.Default), CreatedAt: at, DeviceID: "eth0", LinkType: layers.LinkTypeEthernet}
+}
+
+func TestTrackAlertsStoresARPAlerts(t *testing.T) {
+	repo := &MockAlertRepository{}
+	arp := newTestARPMonitor(t)
+	lifecycle := fxtest.NewLifecycle(t)
+	TrackAlerts(arp, pkg.NewDefragmenter(1<<20, time.Minute), repo, lifecycle)
+	lifecycle.RequireStart()
+
+	now := time.Now()
+	arp.Add(arpTestPacket(t, net.HardwareAddr{0x02, 0, 0, 0, 0, 1}, "10.0.0.1", now))
+	arp.Add(arpTestPacket(t, net.HardwareAddr{0x02, 0, 0, 0, 0, 2}, "10.0.0.1", now.Add(time.Second)))
+	if err := lifecycle.Stop(context.Background()); err != nil {
+		t.Fatalf("failed to stop: %v", err)
+	}
+
+	if len(repo.saved) != 1 || repo.saved[0].Type != pkg.AlertARPDuplicateIP || repo.saved[0].PreviousMAC != "02:00:00:00:00:01" {
+		t.Errorf("expected the duplicate address alert stored on stop, got %+v", repo.saved)
+	}
+}
@@ -0,0 +1,258 @@
+package service
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/impact-dryer/gotattletale/internal/config"
+	"github.com/impact-dryer/gotattletale/pkg"
+)
+
+const (
+	maxListedCaptures  = 100
+	captureStopTimeout = 10 * time.Second
+)
+
+var (
+	ErrCaptureDeviceRequired = errors.New("capture device is required")
+	ErrCaptureNotRunning     = errors.New("capture session is not running")
+)
+
+type CaptureRequest struct {
+	Device      string
+	Filter      string
+	SnapLen     int
+	Promiscuous bool
+	Duration    time.Duration
+	MaxPackets  uint64
+}
+
+type CaptureService interface {
+	StartCapture(request CaptureRequest) (pkg.CaptureSession, error)
+	ListCaptures() ([]pkg.CaptureSession, error)
+	GetCapture(id uint) (pkg.CaptureSession, error)
+	StopCapture(id uint) (pkg.CaptureSession, error)
+	UpdateCaptureFilter(id uint, filter string) (pkg.CaptureSession, error)
+}
+
+// CaptureHandle is the part of pkg.Capture the service relies on.
+type CaptureHandle interface {
+	Run()
+	Stop()
+	Stats() pkg.CaptureStats
+	SetFilter(filter string) error
+}
+
+type CaptureServiceImpl struct {
+	Storage pkg.CaptureSessionRepository
+	Open    func(pkg.Device, pkg.CaptureLimits) (CaptureHandle, error)
+
+	mu      sync.Mutex
+	running map[uint]*runningCapture
+}
+
+type runningCapture struct {
+	capture CaptureHandle
+	session pkg.CaptureSession
+	// finished is closed once the final record has been stored.
+	finished chan struct{}
+}
+
+func (s *CaptureServiceImpl) StartCapture(request CaptureRequest) (pkg.CaptureSession, error) {
+	if request.Device == "" {
+		return pkg.CaptureSession{}, ErrCaptureDeviceRequired
+	}
+	if request.SnapLen <= 0 {
+		request.SnapLen = pkg.SNAPSHOTLENGTH
+	}
+	device := pkg.Device{
+		Name: request.Device,
+		Options: pkg.CaptureOptions{
+			Filter:      request.Filter,
+			SnapLen:     int32(request.SnapLen),
+			Promiscuous: request.Promiscuous,
+		},
+	}
+	capture, err := s.Open(device, pkg.CaptureLimits{Duration: request.Duration, MaxPackets: request.MaxPackets})
+	if err != nil {
+		return pkg.CaptureSession{}, err
+	}
+
+	session := pkg.CaptureSession{
+		Device:      request.Device,
+		Filter:      request.Filter,
+		SnapLen:     request.SnapLen,
+		Promiscuous: request.Promiscuous,
+		Duration:    int64(request.Duration / time.Second),
+		MaxPackets:  request.MaxPackets,
+		Status:      pkg.CaptureStatusRunning,
+		StartedAt:   time.Now(),
+	}
+	if err := s.Storage.SaveCaptureSession(&session); err != nil {
+		capture.Stop()
+		capture.Run()
+		return pkg.CaptureSession{}, err
+	}
+
+	running := &runningCapture{capture: capture, session: session, finished: make(chan struct{})}
+	s.mu.Lock()
+	s.running[session.ID] = running
+	s.mu.Unlock()
+
+	go func() {
+		capture.Run()
+		s.finish(running)
+	}()
+	return session, nil
+}
+
+func (s *CaptureServiceImpl) finish(running *runningCapture) {
+	defer close(running.finished)
+	s.mu.Lock()
+	session := running.session
+	s.mu.Unlock()
+	stats := running.capture.Stats()
+	stoppedAt := time.Now()
+	session.Status = pkg.CaptureStatusStopped
+	session.Packets = stats.Packets
+	session.Bytes = stats.Bytes
+	session.StoppedAt = &stoppedAt
+	if err := s.Storage.UpdateCaptureSession(&session); err != nil {
+		log.Println("Failed to update capture session:", err)
+	}
+
+	s.mu.Lock()
+	delete(s.running, session.ID)
+	s.mu.Unlock()
+}
+
+func (s *CaptureServiceImpl) ListCaptures() ([]pkg.CaptureSession, error) {
+	sessions, err := s.Storage.GetCaptureSessions(maxListedCaptures)
+	if err != nil {
+		return nil, err
+	}
+	for i := range sessions {
+		s.withLiveStats(&sessions[i])
+	}
+	return sessions, nil
+}
+
+func (s *CaptureServiceImpl) GetCapture(id uint) (pkg.CaptureSession, error) {
+	session, err := s.Storage.GetCaptureSession(id)
+	if err != nil {
+		return pkg.CaptureSession{}, err
+	}
+	s.withLiveStats(&session)
+	return session, nil
+}
+
+// StopCapture stops a running capture and waits for its cleanups before
+// returning the final record. Stopping a finished capture returns it as is.
+func (s *CaptureServiceImpl) StopCapture(id uint) (pkg.CaptureSession, error) {
+	s.mu.Lock()
+	running, ok := s.running[id]
+	s.mu.Unlock()
+	if ok {
+		running.capture.Stop()
+		select {
+		case <-running.finished:
+		case <-time.After(captureStopTimeout):
+			log.Printf("Capture session %d did not stop within %s", id, captureStopTimeout)
+		}
+	}
+	return s.GetCapture(id)
+}
+
+// UpdateCaptureFilter swaps the BPF filter of a running capture without
+// restarting it.
+func (s *CaptureServiceImpl) UpdateCaptureFilter(id uint, filter string) (pkg.CaptureSession, error) {
+	s.mu.Lock()
+	running, ok := s.running[id]
+	s.mu.Unlock()
+	if !ok {
+		if _, err := s.Storage.GetCaptureSession(id); err != nil {
+			return pkg.CaptureSession{}, err
+		}
+		return pkg.CaptureSession{}, ErrCaptureNotRunning
+	}
+	if err := running.capture.SetFilter(filter); err != nil {
+		return pkg.CaptureSession{}, err
+	}
+
+	s.mu.Lock()
+	running.session.Filter = filter
+	session := running.session
+	s.mu.Unlock()
+	if err := s.Storage.UpdateCaptureSession(&session); err != nil {
+		return pkg.CaptureSession{}, err
+	}
+	s.withLiveStats(&session)
+	return session, nil
+}
+
+func (s *CaptureServiceImpl) withLiveStats(session *pkg.CaptureSession) {
+	s.mu.Lock()
+	running, ok := s.running[session.ID]
+	s.mu.Unlock()
+	if !ok {
+		return
+	}
+	stats := running.capture.Stats()
+	session.Packets = stats.Packets
+	session.Bytes = stats.Bytes
+}
+
+func NewCaptureService(storage pkg.CaptureSessionRepository) CaptureService {
+	if err := storage.InterruptRunningCaptureSessions(time.Now()); err != nil {
+		log.Println("Failed to close stale capture sessions:", err)
+	}
+	return &CaptureServiceImpl{
+		Storage: storage,
+		Open:    openCapture,
+		running: make(map[uint]*runningCapture),
+	}
+}
+
+func openCapture(device pkg.Device, limits pkg.CaptureLimits) (CaptureHandle, error) {
+	capture, err := pkg.OpenCapture(device, limits)
+	if err != nil {
+		return nil, err
+	}
+	return capture, nil
+}
+
+// StartConfiguredCaptures starts a capture session for every device of
+// appconfig, or replays the configured capture files instead.
+func StartConfiguredCaptures(captureService CaptureService, appconfig *config.AppConfig) {
+	pkg.ConfigureCapture(appconfig)
+	if appconfig.CaptureFile != "" {
+		go func() {
+			if err := pkg.ReplayCaptureFiles(appconfig.CaptureFile); err != nil {
+				log.Println("Failed to replay capture files:", err)
+			}
+		}()
+		return
+	}
+	if len(appconfig.Devices) == 0 {
+		log.Println("No capture device configured, start one through the API")
+		return
+	}
+
+	devices, err := pkg.NewDevices(appconfig.Devices)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, device := range devices.List() {
+		_, err := captureService.StartCapture(CaptureRequest{
+			Device:      device.Name,
+			Filter:      device.Options.Filter,
+			SnapLen:     int(device.Options.SnapLen),
+			Promiscuous: device.Options.Promiscuous,
+		})
+		if err != nil {
+			log.Printf("Capture on %s failed: %v", device.Name, err)
+		}
+	}
+}
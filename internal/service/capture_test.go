@@ -0,0 +1,218 @@
+package service
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/impact-dryer/gotattletale/pkg"
+)
+
+type MockCaptureSessionRepository struct {
+	mu          sync.Mutex
+	sessions    map[uint]pkg.CaptureSession
+	nextID      uint
+	interrupted bool
+	saveErr     error
+}
+
+func newMockCaptureSessionRepository() *MockCaptureSessionRepository {
+	return &MockCaptureSessionRepository{sessions: make(map[uint]pkg.CaptureSession)}
+}
+
+func (m *MockCaptureSessionRepository) SaveCaptureSession(session *pkg.CaptureSession) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.saveErr != nil {
+		return m.saveErr
+	}
+	m.nextID++
+	session.ID = m.nextID
+	m.sessions[session.ID] = *session
+	return nil
+}
+
+func (m *MockCaptureSessionRepository) UpdateCaptureSession(session *pkg.CaptureSession) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sessions[session.ID] = *session
+	return nil
+}
+
+func (m *MockCaptureSessionRepository) GetCaptureSession(id uint) (pkg.CaptureSession, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	session, ok := m.sessions[id]
+	if !ok {
+		return pkg.CaptureSession{}, pkg.ErrCaptureSessionNotFound
+	}
+	return session, nil
+}
+
+func (m *MockCaptureSessionRepository) GetCaptureSessions(limit int) ([]pkg.CaptureSession, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sessions := make([]pkg.CaptureSession, 0, len(m.sessions))
+	for id := m.nextID; id > 0 && len(sessions) < limit; id-- {
+		if session, ok := m.sessions[id]; ok {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions, nil
+}
+
+func (m *MockCaptureSessionRepository) InterruptRunningCaptureSessions(stoppedAt time.Time) error {
+	m.interrupted = true
+	return nil
+}
+
+// fakeCaptureHandle runs until stopped and reports fixed counters.
+type fakeCaptureHandle struct {
+	device   pkg.Device
+	limits   pkg.CaptureLimits
+	stop     chan struct{}
+	stopOnce sync.Once
+	filter   string
+}
+
+func (f *fakeCaptureHandle) Run() {
+	<-f.stop
+}
+
+func (f *fakeCaptureHandle) Stop() {
+	f.stopOnce.Do(func() { close(f.stop) })
+}
+
+func (f *fakeCaptureHandle) Stats() pkg.CaptureStats {
+	return pkg.CaptureStats{Packets: 7, Bytes: 700}
+}
+
+func (f *fakeCaptureHandle) SetFilter(filter string) error {
+	if filter == "bogus" {
+		return pkg.ErrInvalidFilter
+	}
+	f.filter = filter
+	return nil
+}
+
+func newTestCaptureService(repo pkg.CaptureSessionRepository) (*CaptureServiceImpl, *[]*fakeCaptureHandle) {
+	handles := &[]*fakeCaptureHandle{}
+	service := NewCaptureService(repo).(*CaptureServiceImpl)
+	service.Open = func(device pkg.Device, limits pkg.CaptureLimits) (CaptureHandle, error) {
+		if device.Name == "missing" {
+			return nil, errors.New("no such device")
+		}
+		handle := &fakeCaptureHandle{device: device, limits: limits, stop: make(chan struct{})}
+		*handles = append(*handles, handle)
+		return handle, nil
+	}
+	return service, handles
+}
+
+func TestCaptureService_StartListAndStop(t *testing.T) {
+	repo := newMockCaptureSessionRepository()
+	service, handles := newTestCaptureService(repo)
+
+	if !repo.interrupted {
+		t.Error("expected stale sessions to be interrupted on startup")
+	}
+
+	session, err := service.StartCapture(CaptureRequest{
+		Device:      "eth0",
+		Filter:      "udp",
+		Promiscuous: true,
+		Duration:    time.Minute,
+		MaxPackets:  10,
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if session.ID == 0 || session.Status != pkg.CaptureStatusRunning || session.Duration != 60 {
+		t.Fatalf("unexpected session: %+v", session)
+	}
+
+	handle := (*handles)[0]
+	if handle.device.Options.Filter != "udp" || handle.device.Options.SnapLen != pkg.SNAPSHOTLENGTH {
+		t.Errorf("unexpected capture options: %+v", handle.device.Options)
+	}
+	if handle.limits.MaxPackets != 10 || handle.limits.Duration != time.Minute {
+		t.Errorf("unexpected capture limits: %+v", handle.limits)
+	}
+
+	sessions, err := service.ListCaptures()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(sessions) != 1 || sessions[0].Packets != 7 {
+		t.Fatalf("expected running session with live counters, got %+v", sessions)
+	}
+
+	stopped, err := service.StopCapture(session.ID)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if stopped.Status != pkg.CaptureStatusStopped || stopped.StoppedAt == nil || stopped.Bytes != 700 {
+		t.Fatalf("unexpected stopped session: %+v", stopped)
+	}
+}
+
+func TestCaptureService_StartCaptureErrors(t *testing.T) {
+	repo := newMockCaptureSessionRepository()
+	service, _ := newTestCaptureService(repo)
+
+	if _, err := service.StartCapture(CaptureRequest{}); !errors.Is(err, ErrCaptureDeviceRequired) {
+		t.Errorf("expected ErrCaptureDeviceRequired, got %v", err)
+	}
+	if _, err := service.StartCapture(CaptureRequest{Device: "missing"}); err == nil {
+		t.Error("expected error for missing device")
+	}
+
+	repo.saveErr = errors.New("disk full")
+	if _, err := service.StartCapture(CaptureRequest{Device: "eth0"}); err == nil {
+		t.Error("expected error when the session cannot be stored")
+	}
+}
+
+func TestCaptureService_StopUnknownCapture(t *testing.T) {
+	service, _ := newTestCaptureService(newMockCaptureSessionRepository())
+
+	if _, err := service.StopCapture(42); !errors.Is(err, pkg.ErrCaptureSessionNotFound) {
+		t.Errorf("expected ErrCaptureSessionNotFound, got %v", err)
+	}
+}
+
+func TestCaptureService_UpdateCaptureFilter(t *testing.T) {
+	repo := newMockCaptureSessionRepository()
+	service, handles := newTestCaptureService(repo)
+
+	session, err := service.StartCapture(CaptureRequest{Device: "eth0", Filter: "tcp"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	updated, err := service.UpdateCaptureFilter(session.ID, "udp or arp")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if updated.Filter != "udp or arp" || (*handles)[0].filter != "udp or arp" {
+		t.Fatalf("expected filter to be swapped, got %+v", updated)
+	}
+	if _, err := service.UpdateCaptureFilter(session.ID, "bogus"); !errors.Is(err, pkg.ErrInvalidFilter) {
+		t.Errorf("expected ErrInvalidFilter, got %v", err)
+	}
+
+	stopped, err := service.StopCapture(session.ID)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if stopped.Filter != "udp or arp" {
+		t.Errorf("expected final record to keep the new filter, got %q", stopped.Filter)
+	}
+	if _, err := service.UpdateCaptureFilter(session.ID, "icmp"); !errors.Is(err, ErrCaptureNotRunning) {
+		t.Errorf("expected ErrCaptureNotRunning, got %v", err)
+	}
+	if _, err := service.UpdateCaptureFilter(42, "icmp"); !errors.Is(err, pkg.ErrCaptureSessionNotFound) {
+		t.Errorf("expected ErrCaptureSessionNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,26 @@
+package service
+
+import (
+	"github.com/impact-dryer/gotattletale/internal/config"
+	"github.com/impact-dryer/gotattletale/pkg"
+)
+
+type DefragService interface {
+	GetStats() pkg.DefragStats
+}
+
+type DefragServiceImpl struct {
+	Defragmenter *pkg.Defragmenter
+}
+
+func (s DefragServiceImpl) GetStats() pkg.DefragStats {
+	return s.Defragmenter.Stats()
+}
+
+func NewDefragService(defragmenter *pkg.Defragmenter) DefragService {
+	return &DefragServiceImpl{Defragmenter: defragmenter}
+}
+
+func NewDefragmenter(appConfig *config.AppConfig) *pkg.Defragmenter {
+	return pkg.NewDefragmenter(appConfig.DefragMaxBytes, appConfig.DefragTimeout)
+}
@@ -0,0 +1,99 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/impact-dryer/gotattletale/pkg"
+	"go.uber.org/fx/fxtest"
+)
+
+// fragmentTestPacket returns the fragment of an IPv4 datagram to port 53
+// holding data at offset.
+func fragmentTestPacket(t *testing.T, data []byte, offset int, more bool) pkg.AppPacket {
+	t.Helper()
+	ip := &layers.IPv4{Version: 4, IHL: 5, TTL: 64, Id: 9, Protocol: layers.IPProtocolUDP, SrcIP: []byte{10, 0, 0, 1}, DstIP: []byte{10, 0, 0, 2}, FragOffset: uint16(offset / 8)}
+	if more {
+		ip.Flags = layers.IPv4MoreFragments
+	}
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}, ip, gopacket.Payload(data)); err != nil {
+		t.Fatalf("failed to serialize fragment: %v", err)
+	}
+	return pkg.AppPacket{Data: gopacket.NewPacket(buf.Bytes(), layers.LayerTypeIPv4, gopacket.Default), CreatedAt: time.Now(), DeviceID: "eth0"}
+}
+
+func udpTestSegment(t *testing.T) []byte {
+	t.Helper()
+	udp := &layers.UDP{SrcPort: 40000, DstPort: 53}
+	udp.SetNetworkLayerForChecksum(&layers.IPv4{SrcIP: []byte{10, 0, 0, 1}, DstIP: []byte{10, 0, 0, 2}})
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}, udp, gopacket.Payload(make([]byte, 40))); err != nil {
+		t.Fatalf("failed to serialize segment: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestDefragService_GetStats(t *testing.T) {
+	defragmenter := pkg.NewDefragmenter(1<<20, time.Minute)
+	defragmenter.Add(fragmentTestPacket(t, udpTestSegment(t)[:24], 0, true))
+	stats := NewDefragService(defragmenter).GetStats()
+	if stats.Fragments != 1 || stats.Pending != 1 {
+		t.Errorf("expected one pending fragment, got %+v", stats)
+	}
+}
+
+func TestSniffAndStorePackets_StoresReassembledDatagrams(t *testing.T) {
+	originalQueue := pkg.PacketsToCaptureQueue
+	defer func() { pkg.PacketsToCaptureQueue = originalQueue }()
+	pkg.PacketsToCaptureQueue = pkg.PacketQueue{
+		ItemsChan: make(chan pkg.AppPacket, 200),
+	}
+	mockRepo := &TestMockPacketRepository{
+		savedPackets: make([][]pkg.AppPacket, 0),
+		saveCalled:   make(chan struct{}, 1),
+	}
+	SniffAndStorePackets(mockRepo, pkg.NewPacketHub(), pkg.NewDefragmenter(1<<20, time.Minute), pkg.NewFlowTable(time.Minute, 0, 0, 0), pkg.NewDNSTracker(), pkg.NewHostTracker(), newTestARPMonitor(t), pkg.NewPingTracker())
+
+	segment := udpTestSegment(t)
+	pkg.PacketsToCaptureQueue.ItemsChan <- fragmentTestPacket(t, segment[:24], 0, true)
+	pkg.PacketsToCaptureQueue.ItemsChan <- fragmentTestPacket(t, segment[24:], 24, false)
+	for i := 0; i < 100; i++ {
+		pkg.PacketsToCaptureQueue.ItemsChan <- pkg.AppPacket{DeviceID: "eth0", CreatedAt: time.Now()}
+	}
+
+	select {
+	case <-mockRepo.saveCalled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for SavePackets to be called")
+	}
+	batch := mockRepo.GetSavedPackets()[0]
+	if len(batch) != 101 {
+		t.Fatalf("expected the fragments stored as one packet, got %d packets", len(batch))
+	}
+	if udp, ok := batch[0].Data.Layer(layers.LayerTypeUDP).(*layers.UDP); !ok || udp.DstPort != 53 {
+		t.Errorf("expected the reassembled UDP datagram first, got %v", batch[0].Data)
+	}
+}
+
+func TestTrackAlertsStoresFragmentAlerts(t *testing.T) {
+	repo := &MockAlertRepository{}
+	defragmenter := pkg.NewDefragmenter(1<<20, time.Minute)
+	lifecycle := fxtest.NewLifecycle(t)
+	TrackAlerts(newTestARPMonitor(t), defragmenter, repo, lifecycle)
+	lifecycle.RequireStart()
+
+	segment := udpTestSegment(t)
+	defragmenter.Add(fragmentTestPacket(t, segment[:24], 0, true))
+	defragmenter.Add(fragmentTestPacket(t, segment[16:], 16, false))
+	if err := lifecycle.Stop(context.Background()); err != nil {
+		t.Fatalf("failed to stop: %v", err)
+	}
+
+	if len(repo.saved) != 1 || repo.saved[0].Type != pkg.AlertIPFragmentOverlap || repo.saved[0].IP != "10.0.0.1" {
+		t.Errorf("expected the overlap alert stored on stop, got %+v", repo.saved)
+	}
+}
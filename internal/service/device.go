@@ -0,0 +1,27 @@
+package service
+
+import "github.com/impact-dryer/gotattletale/pkg"
+
+type DeviceService interface {
+	ListDevices() ([]pkg.DeviceStatus, error)
+}
+
+type DeviceServiceImpl struct {
+	Discover func() ([]pkg.Device, error)
+}
+
+func (s DeviceServiceImpl) ListDevices() ([]pkg.DeviceStatus, error) {
+	devices, err := s.Discover()
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]pkg.DeviceStatus, len(devices))
+	for i, device := range devices {
+		statuses[i] = device.Status()
+	}
+	return statuses, nil
+}
+
+func NewDeviceService() DeviceService {
+	return &DeviceServiceImpl{Discover: pkg.DiscoverDevices}
+}
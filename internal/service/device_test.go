@@ -0,0 +1,52 @@
+package service
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/impact-dryer/gotattletale/pkg"
+)
+
+func TestDeviceService_ListDevices(t *testing.T) {
+	mac, _ := net.ParseMAC("00:11:22:33:44:55")
+	service := &DeviceServiceImpl{Discover: func() ([]pkg.Device, error) {
+		return []pkg.Device{
+			{
+				Name:        "eth0",
+				Description: "Ethernet",
+				MAC:         mac,
+				Addresses: []pkg.Addrs{
+					{IP: net.ParseIP("192.168.1.10").To4(), Netmask: net.CIDRMask(24, 32)},
+					{IP: net.ParseIP("fe80::1")},
+				},
+				Up: true,
+			},
+		}, nil
+	}}
+
+	devices, err := service.ListDevices()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(devices) != 1 {
+		t.Fatalf("expected 1 device, got %d", len(devices))
+	}
+	got := devices[0]
+	if got.Name != "eth0" || got.MAC != "00:11:22:33:44:55" || !got.Up || got.Capturing {
+		t.Errorf("unexpected device status: %+v", got)
+	}
+	if len(got.Addresses) != 2 || got.Addresses[0] != "192.168.1.10/24" || got.Addresses[1] != "fe80::1" {
+		t.Errorf("unexpected addresses: %v", got.Addresses)
+	}
+}
+
+func TestDeviceService_ListDevicesError(t *testing.T) {
+	service := &DeviceServiceImpl{Discover: func() ([]pkg.Device, error) {
+		return nil, errors.New("permission denied")
+	}}
+
+	if _, err := service.ListDevices(); err == nil {
+		t.Fatal("expected error, got nil")
+	}
+}
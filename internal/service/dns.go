@@ -0,0 +1,48 @@
+package service
+
+import (
+	"log"
+	"time"
+
+	"github.com/impact-dryer/gotattletale/pkg"
+	"go.uber.org/fx"
+)
+
+const (
+	// dnsExpireInterval is how often the answered and timed out queries
+	// are stored.
+	dnsExpireInterval = time.Second
+)
+
+type DNSService interface {
+	GetDNSEvents(filter pkg.DNSFilter) ([]pkg.DNSEvent, error)
+}
+
+type DNSServiceImpl struct {
+	Storage pkg.DNSRepository
+}
+
+func (s DNSServiceImpl) GetDNSEvents(filter pkg.DNSFilter) ([]pkg.DNSEvent, error) {
+	filter.Limit = clampLimit(filter.Limit)
+	return s.Storage.GetDNSEvents(filter)
+}
+
+func NewDNSService(storage pkg.DNSRepository) DNSService {
+	return &DNSServiceImpl{Storage: storage}
+}
+
+// TrackDNS stores the DNS events of the tracker as queries get answered or
+// time out.
+func TrackDNS(tracker *pkg.DNSTracker, repository pkg.DNSRepository, lifecycle fx.Lifecycle) {
+	runPeriodically(lifecycle, dnsExpireInterval, func(now time.Time) {
+		saveDNSEvents(repository, tracker.Expire(now))
+	}, func() {
+		saveDNSEvents(repository, tracker.Flush())
+	})
+}
+
+func saveDNSEvents(repository pkg.DNSRepository, events []pkg.DNSEvent) {
+	if err := repository.SaveDNSEvents(events); err != nil {
+		log.Println("Failed to store DNS events:", err)
+	}
+}
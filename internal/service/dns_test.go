@@ -0,0 +1,95 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/impact-dryer/gotattletale/internal/config"
+	"github.com/impact-dryer/gotattletale/pkg"
+	"go.uber.org/fx/fxtest"
+)
+
+type MockDNSRepository struct {
+	mu        sync.Mutex
+	saved     []pkg.DNSEvent
+	filter    pkg.DNSFilter
+	hostnames map[string]string
+}
+
+func (m *MockDNSRepository) SaveDNSEvents(events []pkg.DNSEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.saved = append(m.saved, events...)
+	return nil
+}
+
+func (m *MockDNSRepository) GetDNSEvents(filter pkg.DNSFilter) ([]pkg.DNSEvent, error) {
+	m.filter = filter
+	return []pkg.DNSEvent{{ID: 1}}, nil
+}
+
+func (m *MockDNSRepository) ResolveHostnames(ips []string) (map[string]string, error) {
+	return m.hostnames, nil
+}
+
+func TestTrackDNSStoresQueriesOnStop(t *testing.T) {
+	repo := &MockDNSRepository{}
+	tracker := pkg.NewDNSTracker()
+	lifecycle := fxtest.NewLifecycle(t)
+	TrackDNS(tracker, repo, lifecycle)
+	lifecycle.RequireStart()
+
+	ip := &layers.IPv4{Version: 4, IHL: 5, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: []byte{10, 0, 0, 1}, DstIP: []byte{10, 0, 0, 53}}
+	udp := &layers.UDP{SrcPort: 40000, DstPort: 53}
+	udp.SetNetworkLayerForChecksum(ip)
+	dns := &layers.DNS{ID: 7, RD: true, Questions: []layers.DNSQuestion{{Name: []byte("example.com"), Type: layers.DNSTypeA, Class: layers.DNSClassIN}}}
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}, ip, udp, dns); err != nil {
+		t.Fatalf("failed to serialize query: %v", err)
+	}
+	tracker.Add(pkg.AppPacket{Data: gopacket.NewPacket(buf.Bytes(), layers.LayerTypeIPv4, gopacket.Default), CreatedAt: time.Now()})
+	if err := lifecycle.Stop(context.Background()); err != nil {
+		t.Fatalf("failed to stop: %v", err)
+	}
+
+	if len(repo.saved) != 1 || repo.saved[0].Name != "example.com" || repo.saved[0].RespondedAt != nil {
+		t.Errorf("expected the unanswered query stored on stop, got %+v", repo.saved)
+	}
+}
+
+func TestTrackFlowsStoresTCPQueriesOnStop(t *testing.T) {
+	dnsRepo := &MockDNSRepository{}
+	tracker := pkg.NewDNSTracker()
+	table := pkg.NewFlowTable(time.Hour, 0, 1024, 1<<20)
+	lifecycle := fxtest.NewLifecycle(t)
+	// In the order of the application, for the flows to stop first.
+	TrackDNS(tracker, dnsRepo, lifecycle)
+	TrackFlows(table, tracker, &MockFlowRepository{}, &config.AppConfig{}, lifecycle)
+	lifecycle.RequireStart()
+
+	dns := &layers.DNS{ID: 7, RD: true, Questions: []layers.DNSQuestion{{Name: []byte("example.com"), Type: layers.DNSTypeA, Class: layers.DNSClassIN}}}
+	message := gopacket.NewSerializeBuffer()
+	if err := dns.SerializeTo(message, gopacket.SerializeOptions{FixLengths: true}); err != nil {
+		t.Fatalf("failed to serialize query: %v", err)
+	}
+	ip := &layers.IPv4{Version: 4, IHL: 5, TTL: 64, Protocol: layers.IPProtocolTCP, SrcIP: []byte{10, 0, 0, 1}, DstIP: []byte{10, 0, 0, 53}}
+	tcp := &layers.TCP{SrcPort: 40000, DstPort: 53, Seq: 100, ACK: true, PSH: true, Window: 1024}
+	tcp.SetNetworkLayerForChecksum(ip)
+	payload := append([]byte{0, byte(len(message.Bytes()))}, message.Bytes()...)
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}, ip, tcp, gopacket.Payload(payload)); err != nil {
+		t.Fatalf("failed to serialize segment: %v", err)
+	}
+	table.Add(pkg.AppPacket{Data: gopacket.NewPacket(buf.Bytes(), layers.LayerTypeIPv4, gopacket.Default), CreatedAt: time.Now(), DeviceID: "eth0"})
+	if err := lifecycle.Stop(context.Background()); err != nil {
+		t.Fatalf("failed to stop: %v", err)
+	}
+
+	if len(dnsRepo.saved) != 1 || dnsRepo.saved[0].Name != "example.com" || dnsRepo.saved[0].ServerPort != 53 {
+		t.Errorf("expected the query over TCP stored on stop, got %+v", dnsRepo.saved)
+	}
+}
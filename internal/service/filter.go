@@ -0,0 +1,43 @@
+package service
+
+import (
+	"github.com/google/gopacket/layers"
+	"github.com/impact-dryer/gotattletale/pkg"
+)
+
+// FilterValidation is the outcome of compiling a BPF expression.
+type FilterValidation struct {
+	Expression   string               `json:"expression"`
+	LinkType     string               `json:"link_type"`
+	Valid        bool                 `json:"valid"`
+	Error        string               `json:"error,omitempty"`
+	Instructions []pkg.BPFInstruction `json:"instructions"`
+}
+
+type FilterService interface {
+	ValidateFilter(expression string, linkType layers.LinkType, snaplen int) FilterValidation
+}
+
+type FilterServiceImpl struct {
+	Compile func(expression string, linkType layers.LinkType, snaplen int) ([]pkg.BPFInstruction, error)
+}
+
+func (s FilterServiceImpl) ValidateFilter(expression string, linkType layers.LinkType, snaplen int) FilterValidation {
+	validation := FilterValidation{
+		Expression:   expression,
+		LinkType:     linkType.String(),
+		Instructions: []pkg.BPFInstruction{},
+	}
+	instructions, err := s.Compile(expression, linkType, snaplen)
+	if err != nil {
+		validation.Error = err.Error()
+		return validation
+	}
+	validation.Valid = true
+	validation.Instructions = instructions
+	return validation
+}
+
+func NewFilterService() FilterService {
+	return &FilterServiceImpl{Compile: pkg.CompileFilter}
+}
@@ -0,0 +1,31 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/gopacket/layers"
+	"github.com/impact-dryer/gotattletale/pkg"
+)
+
+func TestFilterService_ValidateFilter(t *testing.T) {
+	service := &FilterServiceImpl{Compile: func(expression string, linkType layers.LinkType, snaplen int) ([]pkg.BPFInstruction, error) {
+		if expression == "tcp port" {
+			return nil, errors.New("syntax error")
+		}
+		return []pkg.BPFInstruction{{Code: 0x6, K: uint32(snaplen)}}, nil
+	}}
+
+	valid := service.ValidateFilter("udp", layers.LinkTypeEthernet, 96)
+	if !valid.Valid || valid.Error != "" || len(valid.Instructions) != 1 || valid.Instructions[0].K != 96 {
+		t.Fatalf("unexpected validation: %+v", valid)
+	}
+	if valid.LinkType != "Ethernet" {
+		t.Errorf("expected link type Ethernet, got %s", valid.LinkType)
+	}
+
+	invalid := service.ValidateFilter("tcp port", layers.LinkTypeEthernet, 0)
+	if invalid.Valid || invalid.Error != "syntax error" || invalid.Instructions == nil {
+		t.Fatalf("unexpected validation: %+v", invalid)
+	}
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"log"
+	"time"
+
+	"github.com/impact-dryer/gotattletale/internal/config"
+	"github.com/impact-dryer/gotattletale/pkg"
+	"go.uber.org/fx"
+)
+
+const (
+	// flowExpireInterval is how often the flow table is checked for
+	// timeouts.
+	flowExpireInterval = time.Second
+)
+
+type FlowService interface {
+	GetFlows(filter pkg.FlowFilter) ([]pkg.Flow, error)
+	// GetFlowStream returns a flow with its reassembled TCP payload.
+	GetFlowStream(id uint) (pkg.Flow, pkg.FlowPayload, error)
+}
+
+type FlowServiceImpl struct {
+	Storage pkg.FlowRepository
+}
+
+func (s FlowServiceImpl) GetFlows(filter pkg.FlowFilter) ([]pkg.Flow, error) {
+	filter.Limit = clampLimit(filter.Limit)
+	return s.Storage.GetFlows(filter)
+}
+
+func (s FlowServiceImpl) GetFlowStream(id uint) (pkg.Flow, pkg.FlowPayload, error) {
+	flow, err := s.Storage.GetFlow(id)
+	if err != nil {
+		return pkg.Flow{}, pkg.FlowPayload{}, err
+	}
+	payload, err := s.Storage.GetFlowPayload(id)
+	if err != nil {
+		return pkg.Flow{}, pkg.FlowPayload{}, err
+	}
+	return flow, payload, nil
+}
+
+func NewFlowService(storage pkg.FlowRepository) FlowService {
+	return &FlowServiceImpl{Storage: storage}
+}
+
+func NewFlowTable(appConfig *config.AppConfig) *pkg.FlowTable {
+	return pkg.NewFlowTable(appConfig.FlowIdleTimeout, appConfig.FlowActiveTimeout, appConfig.FlowPayloadMaxBytes, appConfig.FlowPayloadBudget)
+}
+
+// TrackFlows stores the flows of the table as they expire, and the ones
+// still in progress when the application stops, with the HTTP transactions
+// and the TLS handshakes of their payloads. The DNS messages of their
+// payloads go to the DNS tracker, which must stop after the flows.
+func TrackFlows(table *pkg.FlowTable, dns *pkg.DNSTracker, repository pkg.FlowRepository, appConfig *config.AppConfig, lifecycle fx.Lifecycle) {
+	save := func(flows []pkg.Flow) {
+		for _, flow := range flows {
+			dns.AddFlow(flow)
+		}
+		saveFlows(repository, flows, appConfig.HTTPBodyMaxBytes)
+	}
+	runPeriodically(lifecycle, flowExpireInterval, func(now time.Time) {
+		save(table.Expire(now))
+	}, func() {
+		save(table.Flush())
+	})
+}
+
+func saveFlows(repository pkg.FlowRepository, flows []pkg.Flow, httpBodyMaxBytes int) {
+	addHTTPTransactions(flows, httpBodyMaxBytes)
+	addTLSSessions(flows)
+	if err := repository.SaveFlows(flows); err != nil {
+		log.Println("Failed to store flows:", err)
+	}
+}
@@ -0,0 +1,90 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/impact-dryer/gotattletale/internal/config"
+	"github.com/impact-dryer/gotattletale/pkg"
+	"go.uber.org/fx/fxtest"
+)
+
+type MockFlowRepository struct {
+	mu     sync.Mutex
+	saved  []pkg.Flow
+	filter pkg.FlowFilter
+}
+
+func (m *MockFlowRepository) SaveFlows(flows []pkg.Flow) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.saved = append(m.saved, flows...)
+	return nil
+}
+
+func (m *MockFlowRepository) GetFlows(filter pkg.FlowFilter) ([]pkg.Flow, error) {
+	m.filter = filter
+	return []pkg.Flow{{ID: 1}}, nil
+}
+
+func (m *MockFlowRepository) GetFlow(id uint) (pkg.Flow, error) {
+	if id != 1 {
+		return pkg.Flow{}, pkg.ErrFlowNotFound
+	}
+	return pkg.Flow{ID: 1}, nil
+}
+
+func (m *MockFlowRepository) GetFlowPayload(flowID uint) (pkg.FlowPayload, error) {
+	return pkg.FlowPayload{FlowID: flowID, SourceData: []byte("GET /")}, nil
+}
+
+func (m *MockFlowRepository) Saved() []pkg.Flow {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.saved
+}
+
+func TestTrackFlowsStoresFlowsOnStop(t *testing.T) {
+	repo := &MockFlowRepository{}
+	table := pkg.NewFlowTable(time.Hour, 0, 0, 0)
+	lifecycle := fxtest.NewLifecycle(t)
+	TrackFlows(table, pkg.NewDNSTracker(), repo, &config.AppConfig{}, lifecycle)
+	lifecycle.RequireStart()
+
+	table.Add(streamTestPacket(53))
+	table.Add(streamTestPacket(123))
+	if err := lifecycle.Stop(context.Background()); err != nil {
+		t.Fatalf("failed to stop: %v", err)
+	}
+
+	saved := repo.Saved()
+	if len(saved) != 2 {
+		t.Fatalf("expected 2 flows stored on stop, got %d", len(saved))
+	}
+	for _, flow := range saved {
+		if flow.EndReason != pkg.FlowEndShutdown {
+			t.Errorf("expected shutdown reason, got %s", flow.EndReason)
+		}
+	}
+	if table.Len() != 0 {
+		t.Errorf("expected empty table, got %d flows", table.Len())
+	}
+}
+
+func TestFlowService_GetFlowStream(t *testing.T) {
+	service := NewFlowService(&MockFlowRepository{})
+
+	flow, payload, err := service.GetFlowStream(1)
+	if err != nil {
+		t.Fatalf("GetFlowStream returned error: %v", err)
+	}
+	if flow.ID != 1 || string(payload.SourceData) != "GET /" {
+		t.Errorf("unexpected flow %+v and payload %+v", flow, payload)
+	}
+	if _, _, err := service.GetFlowStream(2); !errors.Is(err, pkg.ErrFlowNotFound) {
+		t.Errorf("expected ErrFlowNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,42 @@
+package service
+
+import (
+	"log"
+	"time"
+
+	"github.com/impact-dryer/gotattletale/pkg"
+	"go.uber.org/fx"
+)
+
+const (
+	// hostSaveInterval is how often the hosts seen are merged into the
+	// inventory.
+	hostSaveInterval = time.Second
+)
+
+type HostService interface {
+	GetHosts(filter pkg.HostFilter) ([]pkg.Host, error)
+}
+
+type HostServiceImpl struct {
+	Storage pkg.HostRepository
+}
+
+func (s HostServiceImpl) GetHosts(filter pkg.HostFilter) ([]pkg.Host, error) {
+	filter.Limit = clampLimit(filter.Limit)
+	return s.Storage.GetHosts(filter)
+}
+
+func NewHostService(storage pkg.HostRepository) HostService {
+	return &HostServiceImpl{Storage: storage}
+}
+
+// TrackHosts merges the hosts seen by the tracker into the inventory.
+func TrackHosts(tracker *pkg.HostTracker, repository pkg.HostRepository, lifecycle fx.Lifecycle) {
+	save := func() {
+		if err := repository.SaveHosts(tracker.Take()); err != nil {
+			log.Println("Failed to store hosts:", err)
+		}
+	}
+	runPeriodically(lifecycle, hostSaveInterval, func(time.Time) { save() }, save)
+}
@@ -0,0 +1,54 @@
+package service
+
+import (
+	"context"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/impact-dryer/gotattletale/pkg"
+	"go.uber.org/fx/fxtest"
+)
+
+type MockHostRepository struct {
+	saved  []pkg.Host
+	filter pkg.HostFilter
+	byMAC  map[string]pkg.Host
+}
+
+func (m *MockHostRepository) SaveHosts(hosts []pkg.Host) error {
+	m.saved = append(m.saved, hosts...)
+	return nil
+}
+
+func (m *MockHostRepository) GetHosts(filter pkg.HostFilter) ([]pkg.Host, error) {
+	m.filter = filter
+	return []pkg.Host{{ID: 1}}, nil
+}
+
+func (m *MockHostRepository) GetHostsByMAC(macs []string) (map[string]pkg.Host, error) {
+	return m.byMAC, nil
+}
+
+func TestTrackHostsStoresHostsOnStop(t *testing.T) {
+	repo := &MockHostRepository{}
+	tracker := pkg.NewHostTracker()
+	lifecycle := fxtest.NewLifecycle(t)
+	TrackHosts(tracker, repo, lifecycle)
+	lifecycle.RequireStart()
+
+	tracker.Add(arpTestPacket(t, net.HardwareAddr{0x02, 0, 0, 0, 0, 1}, "10.0.0.1", time.Now()))
+	if err := lifecycle.Stop(context.Background()); err != nil {
+		t.Fatalf("failed to stop: %v", err)
+	}
+
+	// The reply tells about its sender and its target.
+	if len(repo.saved) != 2 {
+		t.Fatalf("expected 2 hosts stored on stop, got %+v", repo.saved)
+	}
+	i := slices.IndexFunc(repo.saved, func(host pkg.Host) bool { return host.MAC == "02:00:00:00:00:01" })
+	if i < 0 || len(repo.saved[i].Addresses) != 1 || repo.saved[i].Addresses[0].IP != "10.0.0.1" {
+		t.Errorf("expected the sender stored with its address, got %+v", repo.saved)
+	}
+}
@@ -0,0 +1,32 @@
+package service
+
+import (
+	"github.com/impact-dryer/gotattletale/pkg"
+)
+
+type HTTPService interface {
+	GetHTTPTransactions(filter pkg.HTTPFilter) ([]pkg.HTTPTransaction, error)
+}
+
+type HTTPServiceImpl struct {
+	Storage pkg.HTTPRepository
+}
+
+func (s HTTPServiceImpl) GetHTTPTransactions(filter pkg.HTTPFilter) ([]pkg.HTTPTransaction, error) {
+	filter.Limit = clampLimit(filter.Limit)
+	return s.Storage.GetHTTPTransactions(filter)
+}
+
+func NewHTTPService(storage pkg.HTTPRepository) HTTPService {
+	return &HTTPServiceImpl{Storage: storage}
+}
+
+// addHTTPTransactions reads the HTTP of the TCP payloads, to be stored
+// along with the flows.
+func addHTTPTransactions(flows []pkg.Flow, bodyMaxBytes int) {
+	for i := range flows {
+		if flows[i].Protocol == pkg.ProtocolTCP && flows[i].Payload != nil {
+			flows[i].HTTPTransactions = pkg.ParseHTTPTransactions(flows[i], *flows[i].Payload, bodyMaxBytes)
+		}
+	}
+}
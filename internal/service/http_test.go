@@ -0,0 +1,36 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/impact-dryer/gotattletale/pkg"
+)
+
+type MockHTTPRepository struct {
+	filter pkg.HTTPFilter
+}
+
+func (m *MockHTTPRepository) GetHTTPTransactions(filter pkg.HTTPFilter) ([]pkg.HTTPTransaction, error) {
+	m.filter = filter
+	return []pkg.HTTPTransaction{{ID: 1}}, nil
+}
+
+func TestSaveFlowsAddsHTTPTransactions(t *testing.T) {
+	repo := &MockFlowRepository{}
+	payload := &pkg.FlowPayload{
+		SourceData:      []byte("GET / HTTP/1.1\r\nHost: a\r\n\r\n"),
+		DestinationData: []byte("HTTP/1.1 204 No Content\r\n\r\n"),
+	}
+	saveFlows(repo, []pkg.Flow{
+		{Protocol: pkg.ProtocolTCP, Payload: payload},
+		{Protocol: pkg.ProtocolTCP},
+	}, 0)
+
+	saved := repo.Saved()
+	if len(saved) != 2 || len(saved[0].HTTPTransactions) != 1 || len(saved[1].HTTPTransactions) != 0 {
+		t.Fatalf("expected the transaction on the first flow, got %+v", saved)
+	}
+	if code := saved[0].HTTPTransactions[0].StatusCode; code == nil || *code != 204 {
+		t.Errorf("unexpected transaction %+v", saved[0].HTTPTransactions[0])
+	}
+}
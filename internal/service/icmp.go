@@ -0,0 +1,54 @@
+package service
+
+import (
+	"log"
+	"time"
+
+	"github.com/impact-dryer/gotattletale/pkg"
+	"go.uber.org/fx"
+)
+
+const (
+	// pingExpireInterval is how often the answered and lost pings are
+	// stored.
+	pingExpireInterval = time.Second
+)
+
+type ICMPService interface {
+	GetPings(filter pkg.PingFilter) ([]pkg.Ping, error)
+	GetUnreachable(filter pkg.UnreachableFilter) ([]pkg.UnreachableSource, error)
+}
+
+type ICMPServiceImpl struct {
+	Storage pkg.ICMPRepository
+}
+
+func (s ICMPServiceImpl) GetPings(filter pkg.PingFilter) ([]pkg.Ping, error) {
+	filter.Limit = clampLimit(filter.Limit)
+	return s.Storage.GetPings(filter)
+}
+
+func (s ICMPServiceImpl) GetUnreachable(filter pkg.UnreachableFilter) ([]pkg.UnreachableSource, error) {
+	filter.Limit = clampLimit(filter.Limit)
+	return s.Storage.GetUnreachable(filter)
+}
+
+func NewICMPService(storage pkg.ICMPRepository) ICMPService {
+	return &ICMPServiceImpl{Storage: storage}
+}
+
+// TrackPings stores the pings of the tracker as requests get replies or
+// time out.
+func TrackPings(tracker *pkg.PingTracker, repository pkg.ICMPRepository, lifecycle fx.Lifecycle) {
+	runPeriodically(lifecycle, pingExpireInterval, func(now time.Time) {
+		savePings(repository, tracker.Expire(now))
+	}, func() {
+		savePings(repository, tracker.Flush())
+	})
+}
+
+func savePings(repository pkg.ICMPRepository, pings []pkg.Ping) {
+	if err := repository.SavePings(pings); err != nil {
+		log.Println("Failed to store pings:", err)
+	}
+}
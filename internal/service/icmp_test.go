@@ -0,0 +1,65 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/impact-dryer/gotattletale/pkg"
+	"go.uber.org/fx/fxtest"
+)
+
+type MockICMPRepository struct {
+	mu          sync.Mutex
+	saved       []pkg.Ping
+	pingFilter  pkg.PingFilter
+	unreachable pkg.UnreachableFilter
+}
+
+func (m *MockICMPRepository) SavePings(pings []pkg.Ping) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.saved = append(m.saved, pings...)
+	return nil
+}
+
+func (m *MockICMPRepository) Saved() []pkg.Ping {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.saved
+}
+
+func (m *MockICMPRepository) GetPings(filter pkg.PingFilter) ([]pkg.Ping, error) {
+	m.pingFilter = filter
+	return []pkg.Ping{{ID: 1}}, nil
+}
+
+func (m *MockICMPRepository) GetUnreachable(filter pkg.UnreachableFilter) ([]pkg.UnreachableSource, error) {
+	m.unreachable = filter
+	return []pkg.UnreachableSource{{SourceIP: "10.0.0.5"}}, nil
+}
+
+func TestTrackPingsStoresRequestsOnStop(t *testing.T) {
+	repo := &MockICMPRepository{}
+	tracker := pkg.NewPingTracker()
+	lifecycle := fxtest.NewLifecycle(t)
+	TrackPings(tracker, repo, lifecycle)
+	lifecycle.RequireStart()
+
+	ip := &layers.IPv4{Version: 4, IHL: 5, TTL: 64, Protocol: layers.IPProtocolICMPv4, SrcIP: []byte{10, 0, 0, 1}, DstIP: []byte{10, 0, 0, 2}}
+	icmp := &layers.ICMPv4{TypeCode: layers.CreateICMPv4TypeCode(layers.ICMPv4TypeEchoRequest, 0), Id: 1, Seq: 1}
+	buf := gopacket.NewSerializeBuffer()
+	gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}, ip, icmp)
+	tracker.Add(pkg.AppPacket{Data: gopacket.NewPacket(buf.Bytes(), layers.LayerTypeIPv4, gopacket.Default), CreatedAt: time.Now()})
+	if err := lifecycle.Stop(context.Background()); err != nil {
+		t.Fatalf("failed to stop: %v", err)
+	}
+
+	saved := repo.Saved()
+	if len(saved) != 1 || saved[0].DestinationIP != "10.0.0.2" || saved[0].RepliedAt != nil {
+		t.Errorf("expected the waiting request stored on stop, got %+v", saved)
+	}
+}
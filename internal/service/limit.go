@@ -0,0 +1,16 @@
+package service
+
+const (
+	defaultLimit = 100
+	// maxLimit caps the page size whatever the client asks for.
+	maxLimit = 1000
+)
+
+// clampLimit returns the page size to ask the repositories for: the
+// default when the client asked for none, and never more than maxLimit.
+func clampLimit(limit int) int {
+	if limit <= 0 {
+		return defaultLimit
+	}
+	return min(limit, maxLimit)
+}
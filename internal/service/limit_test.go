@@ -0,0 +1,18 @@
+package service
+
+import "testing"
+
+func TestClampLimit(t *testing.T) {
+	tests := []struct{ limit, want int }{
+		{-1, defaultLimit},
+		{0, defaultLimit},
+		{10, 10},
+		{maxLimit, maxLimit},
+		{maxLimit + 1, maxLimit},
+	}
+	for _, tt := range tests {
+		if got := clampLimit(tt.limit); got != tt.want {
+			t.Errorf("clampLimit(%d): expected %d, got %d", tt.limit, tt.want, got)
+		}
+	}
+}
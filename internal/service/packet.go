@@ -1,19 +1,163 @@
 package service
 
-import "github.com/impact-dryer/gotattletale/pkg"
+import (
+	"log"
+
+	"github.com/impact-dryer/gotattletale/pkg"
+)
 
 type PacketService interface {
-	GetPackets(limit int, sort string) ([]pkg.SavedPacket, error)
+	GetPackets(filter pkg.PacketFilter) (PacketPage, error)
+	GetPacket(id uint) (pkg.SavedPacket, error)
+	DeletePacket(id uint) error
+	UpdatePacket(id uint, update pkg.PacketUpdate) (pkg.SavedPacket, error)
+	GetPacketData(id uint) (pkg.PacketData, error)
 }
 
 type PacketServiceImpl struct {
 	Storage pkg.PacketRepository
+	// Hostnames names the endpoints of the listed packets, nil leaves
+	// them unnamed.
+	Hostnames pkg.DNSRepository
+	// Hosts links the listed packets to the host inventory by MAC, nil
+	// leaves them unlinked.
+	Hosts pkg.HostRepository
+}
+
+// PacketPage is one page of the packet listing. Next and Prev are the
+// cursors of the neighbouring pages, empty when there is none or when the
+// listing is not sorted by created_at.
+type PacketPage struct {
+	Packets    []pkg.SavedPacket `json:"packets"`
+	Limit      int               `json:"limit"`
+	Next       string            `json:"next,omitempty"`
+	Prev       string            `json:"prev,omitempty"`
+	Total      int64             `json:"total"`
+	TotalExact bool              `json:"total_exact"`
+}
+
+func (s PacketServiceImpl) GetPackets(filter pkg.PacketFilter) (PacketPage, error) {
+	limit := clampLimit(filter.Limit)
+
+	// One packet more than the page tells whether another page follows.
+	filter.Limit = limit + 1
+	packets, err := s.Storage.GetPackets(filter)
+	if err != nil {
+		return PacketPage{}, err
+	}
+	backward := filter.Cursor != nil && filter.Cursor.Backward
+	more := len(packets) > limit
+	if more && backward {
+		packets = packets[1:]
+	} else if more {
+		packets = packets[:limit]
+	}
+
+	s.setHostnames(packets)
+	s.setHosts(packets)
+	page := PacketPage{Packets: packets, Limit: limit}
+	if filter.Pageable() {
+		setPageCursors(&page, filter.Cursor, more)
+	}
+
+	count, err := s.Storage.CountPackets(filter)
+	if err != nil {
+		return PacketPage{}, err
+	}
+	page.Total = count.Count
+	page.TotalExact = count.Exact
+	return page, nil
+}
+
+// setPageCursors links the page to its neighbours. The page after a
+// forward cursor has packets before it, the page before a backward cursor
+// packets after it.
+func setPageCursors(page *PacketPage, cursor *pkg.PacketCursor, more bool) {
+	backward := cursor != nil && cursor.Backward
+	if len(page.Packets) == 0 {
+		if cursor != nil && backward {
+			page.Next = cursor.Reverse().Encode()
+		} else if cursor != nil {
+			page.Prev = cursor.Reverse().Encode()
+		}
+		return
+	}
+	first := page.Packets[0]
+	last := page.Packets[len(page.Packets)-1]
+	if more || backward {
+		page.Next = pkg.NewPacketCursor(last, false).Encode()
+	}
+	if (more && backward) || (cursor != nil && !backward) {
+		page.Prev = pkg.NewPacketCursor(first, true).Encode()
+	}
+}
+
+// setHostnames names the endpoints from the passive DNS map. The listing
+// does not fail for it.
+func (s PacketServiceImpl) setHostnames(packets []pkg.SavedPacket) {
+	if s.Hostnames == nil || len(packets) == 0 {
+		return
+	}
+	ips := make([]string, 0, 2*len(packets))
+	for _, packet := range packets {
+		if packet.SourceIP != "" {
+			ips = append(ips, packet.SourceIP, packet.DestinationIP)
+		}
+	}
+	names, err := s.Hostnames.ResolveHostnames(ips)
+	if err != nil {
+		log.Println("Failed to resolve packet hostnames:", err)
+		return
+	}
+	for i := range packets {
+		packets[i].SourceHostname = names[packets[i].SourceIP]
+		packets[i].DestinationHostname = names[packets[i].DestinationIP]
+	}
+}
+
+// setHosts links the packets to the hosts of their MACs. The listing does
+// not fail for it.
+func (s PacketServiceImpl) setHosts(packets []pkg.SavedPacket) {
+	if s.Hosts == nil || len(packets) == 0 {
+		return
+	}
+	macs := make([]string, 0, 2*len(packets))
+	for _, packet := range packets {
+		if packet.SourceMAC != "" {
+			macs = append(macs, packet.SourceMAC, packet.DestinationMAC)
+		}
+	}
+	hosts, err := s.Hosts.GetHostsByMAC(macs)
+	if err != nil {
+		log.Println("Failed to link packets to hosts:", err)
+		return
+	}
+	for i := range packets {
+		if host, ok := hosts[packets[i].SourceMAC]; ok {
+			packets[i].SourceHostID = &host.ID
+		}
+		if host, ok := hosts[packets[i].DestinationMAC]; ok {
+			packets[i].DestinationHostID = &host.ID
+		}
+	}
+}
+
+func (s PacketServiceImpl) GetPacket(id uint) (pkg.SavedPacket, error) {
+	return s.Storage.GetPacket(id)
+}
+
+func (s PacketServiceImpl) DeletePacket(id uint) error {
+	return s.Storage.DeletePacket(id)
+}
+
+func (s PacketServiceImpl) UpdatePacket(id uint, update pkg.PacketUpdate) (pkg.SavedPacket, error) {
+	return s.Storage.UpdatePacket(id, update)
 }
 
-func (s PacketServiceImpl) GetPackets(limit int, sort string) ([]pkg.SavedPacket, error) {
-	return s.Storage.GetPackets(limit, sort)
+func (s PacketServiceImpl) GetPacketData(id uint) (pkg.PacketData, error) {
+	return s.Storage.GetPacketData(id)
 }
 
-func NewPacketService(storage pkg.PacketRepository) PacketService {
-	return &PacketServiceImpl{Storage: storage}
+func NewPacketService(storage pkg.PacketRepository, hostnames pkg.DNSRepository, hosts pkg.HostRepository) PacketService {
+	return &PacketServiceImpl{Storage: storage, Hostnames: hostnames, Hosts: hosts}
 }
@@ -10,14 +10,16 @@ import (
 
 // MockPacketRepository is a mock implementation of PacketRepository
 type MockPacketRepository struct {
-	packets         []pkg.SavedPacket
-	getPacketsErr   error
-	savePacketErr   error
-	savePacketsErr  error
-	calledWithLimit int
-	calledWithSort  string
-	savedPacket     pkg.AppPacket
-	savedPackets    []pkg.AppPacket
+	packets          []pkg.SavedPacket
+	getPacketsErr    error
+	savePacketErr    error
+	savePacketsErr   error
+	calledWithLimit  int
+	calledWithSort   string
+	calledWithCursor *pkg.PacketCursor
+	savedPacket      pkg.AppPacket
+	savedPackets     []pkg.AppPacket
+	packetData       map[uint]pkg.PacketData
 }
 
 func (m *MockPacketRepository) SavePacket(packet pkg.AppPacket) error {
@@ -30,22 +32,62 @@ func (m *MockPacketRepository) SavePackets(packets []pkg.AppPacket) error {
 	return m.savePacketsErr
 }
 
-func (m *MockPacketRepository) GetPackets(limit int, sort string) ([]pkg.SavedPacket, error) {
-	m.calledWithLimit = limit
-	m.calledWithSort = sort
+func (m *MockPacketRepository) GetPackets(filter pkg.PacketFilter) ([]pkg.SavedPacket, error) {
+	m.calledWithLimit = filter.Limit
+	m.calledWithSort = filter.Sort
+	m.calledWithCursor = filter.Cursor
 	return m.packets, m.getPacketsErr
 }
 
-func (m *MockPacketRepository) GetPacket(packetID string) (pkg.SavedPacket, error) {
-	return pkg.SavedPacket{}, nil
+func (m *MockPacketRepository) CountPackets(filter pkg.PacketFilter) (pkg.PacketCount, error) {
+	return pkg.PacketCount{Count: int64(len(m.packets)), Exact: true}, nil
 }
 
-func (m *MockPacketRepository) DeletePacket(packetID string) error {
-	return nil
+func (m *MockPacketRepository) GetPacket(packetID uint) (pkg.SavedPacket, error) {
+	for _, packet := range m.packets {
+		if packet.ID == packetID {
+			return packet, nil
+		}
+	}
+	return pkg.SavedPacket{}, pkg.ErrPacketNotFound
+}
+
+func (m *MockPacketRepository) DeletePacket(packetID uint) error {
+	for i, packet := range m.packets {
+		if packet.ID == packetID {
+			m.packets = append(m.packets[:i], m.packets[i+1:]...)
+			return nil
+		}
+	}
+	return pkg.ErrPacketNotFound
+}
+
+func (m *MockPacketRepository) UpdatePacket(packetID uint, update pkg.PacketUpdate) (pkg.SavedPacket, error) {
+	for i, packet := range m.packets {
+		if packet.ID != packetID {
+			continue
+		}
+		if update.Tags != nil {
+			m.packets[i].Tags = *update.Tags
+		}
+		if update.Notes != nil {
+			m.packets[i].Notes = *update.Notes
+		}
+		return m.packets[i], nil
+	}
+	return pkg.SavedPacket{}, pkg.ErrPacketNotFound
+}
+
+func (m *MockPacketRepository) GetPacketData(packetID uint) (pkg.PacketData, error) {
+	data, ok := m.packetData[packetID]
+	if !ok {
+		return pkg.PacketData{}, pkg.ErrPacketNotFound
+	}
+	return data, nil
 }
 
-func (m *MockPacketRepository) UpdatePacket(packet pkg.AppPacket) error {
-	return nil
+func intPtr(v int) *int {
+	return &v
 }
 
 func TestPacketService_GetPackets_Success(t *testing.T) {
@@ -56,8 +98,8 @@ func TestPacketService_GetPackets_Success(t *testing.T) {
 			ID:              1,
 			SourceIP:        "192.168.1.1",
 			DestinationIP:   "192.168.1.2",
-			SourcePort:      8080,
-			DestinationPort: 443,
+			SourcePort:      intPtr(8080),
+			DestinationPort: intPtr(443),
 			Protocol:        "TCP",
 			CreatedAt:       now,
 			UpdatedAt:       now,
@@ -67,8 +109,8 @@ func TestPacketService_GetPackets_Success(t *testing.T) {
 			ID:              2,
 			SourceIP:        "10.0.0.1",
 			DestinationIP:   "10.0.0.2",
-			SourcePort:      3000,
-			DestinationPort: 80,
+			SourcePort:      intPtr(3000),
+			DestinationPort: intPtr(80),
 			Protocol:        "UDP",
 			CreatedAt:       now,
 			UpdatedAt:       now,
@@ -81,22 +123,31 @@ func TestPacketService_GetPackets_Success(t *testing.T) {
 		getPacketsErr: nil,
 	}
 
-	service := NewPacketService(mockRepo)
+	service := NewPacketService(mockRepo, nil, nil)
 
 	// Act
-	packets, err := service.GetPackets(50, "source_ip")
+	page, err := service.GetPackets(pkg.PacketFilter{Limit: 50, Sort: "source_ip"})
 
 	// Assert
 	if err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
 
-	if len(packets) != len(expectedPackets) {
-		t.Errorf("expected %d packets, got %d", len(expectedPackets), len(packets))
+	if len(page.Packets) != len(expectedPackets) {
+		t.Errorf("expected %d packets, got %d", len(expectedPackets), len(page.Packets))
 	}
 
-	if mockRepo.calledWithLimit != 50 {
-		t.Errorf("expected limit 50, got %d", mockRepo.calledWithLimit)
+	// One packet more than the page is asked to tell whether more follow.
+	if mockRepo.calledWithLimit != 51 {
+		t.Errorf("expected limit 51, got %d", mockRepo.calledWithLimit)
+	}
+
+	if page.Limit != 50 || page.Total != 2 || !page.TotalExact {
+		t.Errorf("unexpected page %+v", page)
+	}
+
+	if page.Next != "" || page.Prev != "" {
+		t.Errorf("expected no cursors when sorting by source_ip, got %+v", page)
 	}
 
 	if mockRepo.calledWithSort != "source_ip" {
@@ -112,10 +163,10 @@ func TestPacketService_GetPackets_RepositoryError(t *testing.T) {
 		getPacketsErr: expectedError,
 	}
 
-	service := NewPacketService(mockRepo)
+	service := NewPacketService(mockRepo, nil, nil)
 
 	// Act
-	packets, err := service.GetPackets(100, "created_at")
+	page, err := service.GetPackets(pkg.PacketFilter{Limit: 100, Sort: "created_at"})
 
 	// Assert
 	if err == nil {
@@ -126,8 +177,8 @@ func TestPacketService_GetPackets_RepositoryError(t *testing.T) {
 		t.Errorf("expected error '%s', got '%s'", expectedError.Error(), err.Error())
 	}
 
-	if packets != nil {
-		t.Errorf("expected nil packets on error, got %v", packets)
+	if page.Packets != nil {
+		t.Errorf("expected nil packets on error, got %v", page.Packets)
 	}
 }
 
@@ -138,18 +189,22 @@ func TestPacketService_GetPackets_EmptyResult(t *testing.T) {
 		getPacketsErr: nil,
 	}
 
-	service := NewPacketService(mockRepo)
+	service := NewPacketService(mockRepo, nil, nil)
 
 	// Act
-	packets, err := service.GetPackets(100, "")
+	page, err := service.GetPackets(pkg.PacketFilter{Limit: 100})
 
 	// Assert
 	if err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
 
-	if len(packets) != 0 {
-		t.Errorf("expected 0 packets, got %d", len(packets))
+	if len(page.Packets) != 0 {
+		t.Errorf("expected 0 packets, got %d", len(page.Packets))
+	}
+
+	if page.Next != "" || page.Prev != "" {
+		t.Errorf("expected no cursors on a single empty page, got %+v", page)
 	}
 }
 
@@ -160,10 +215,10 @@ func TestPacketService_GetPackets_DefaultSort(t *testing.T) {
 		getPacketsErr: nil,
 	}
 
-	service := NewPacketService(mockRepo)
+	service := NewPacketService(mockRepo, nil, nil)
 
 	// Act
-	_, err := service.GetPackets(10, "")
+	_, err := service.GetPackets(pkg.PacketFilter{Limit: 10})
 
 	// Assert
 	if err != nil {
@@ -182,7 +237,7 @@ func TestNewPacketService(t *testing.T) {
 	mockRepo := &MockPacketRepository{}
 
 	// Act
-	service := NewPacketService(mockRepo)
+	service := NewPacketService(mockRepo, nil, nil)
 
 	// Assert
 	if service == nil {
@@ -225,16 +280,23 @@ func TestPacketServiceImpl_GetPackets_PassesParametersCorrectly(t *testing.T) {
 			name:          "zero limit",
 			limit:         0,
 			sort:          "source_port",
-			expectedLimit: 0,
+			expectedLimit: defaultLimit,
 			expectedSort:  "source_port",
 		},
 		{
 			name:          "negative limit",
 			limit:         -1,
 			sort:          "protocol",
-			expectedLimit: -1,
+			expectedLimit: defaultLimit,
 			expectedSort:  "protocol",
 		},
+		{
+			name:          "limit above the maximum",
+			limit:         maxLimit + 1,
+			sort:          "created_at",
+			expectedLimit: maxLimit,
+			expectedSort:  "created_at",
+		},
 	}
 
 	for _, tc := range testCases {
@@ -245,10 +307,14 @@ func TestPacketServiceImpl_GetPackets_PassesParametersCorrectly(t *testing.T) {
 
 			service := &PacketServiceImpl{Storage: mockRepo}
 
-			_, _ = service.GetPackets(tc.limit, tc.sort)
+			page, _ := service.GetPackets(pkg.PacketFilter{Limit: tc.limit, Sort: tc.sort})
+
+			if page.Limit != tc.expectedLimit {
+				t.Errorf("expected limit %d, got %d", tc.expectedLimit, page.Limit)
+			}
 
-			if mockRepo.calledWithLimit != tc.expectedLimit {
-				t.Errorf("expected limit %d, got %d", tc.expectedLimit, mockRepo.calledWithLimit)
+			if mockRepo.calledWithLimit != tc.expectedLimit+1 {
+				t.Errorf("expected the repository to be asked for %d packets, got %d", tc.expectedLimit+1, mockRepo.calledWithLimit)
 			}
 
 			if mockRepo.calledWithSort != tc.expectedSort {
@@ -257,3 +323,148 @@ func TestPacketServiceImpl_GetPackets_PassesParametersCorrectly(t *testing.T) {
 		})
 	}
 }
+
+func TestPacketService_GetPacketsCursors(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	packets := make([]pkg.SavedPacket, 3)
+	for i := range packets {
+		packets[i] = pkg.SavedPacket{ID: uint(3 - i), CreatedAt: start.Add(-time.Duration(i) * time.Second)}
+	}
+	at := func(i int, backward bool) *pkg.PacketCursor {
+		return pkg.NewPacketCursor(packets[i], backward)
+	}
+
+	testCases := []struct {
+		name     string
+		cursor   *pkg.PacketCursor
+		returned []pkg.SavedPacket
+		expected []uint
+		next     *pkg.PacketCursor
+		prev     *pkg.PacketCursor
+	}{
+		{"first page", nil, packets, []uint{3, 2}, at(1, false), nil},
+		{"last page", at(0, false), packets[1:], []uint{2, 1}, nil, at(1, true)},
+		{"backward with more", at(2, true), packets, []uint{2, 1}, at(2, false), at(1, true)},
+		{"backward to the start", at(1, true), packets[:1], []uint{3}, at(0, false), nil},
+		{"empty after cursor", at(2, false), nil, nil, nil, at(2, true)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := &MockPacketRepository{packets: tc.returned}
+			service := NewPacketService(mockRepo, nil, nil)
+
+			page, err := service.GetPackets(pkg.PacketFilter{Limit: 2, Cursor: tc.cursor})
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if mockRepo.calledWithCursor != tc.cursor {
+				t.Errorf("expected the cursor to be passed to the repository")
+			}
+			if len(page.Packets) != len(tc.expected) {
+				t.Fatalf("expected %d packets, got %+v", len(tc.expected), page.Packets)
+			}
+			for i, id := range tc.expected {
+				if page.Packets[i].ID != id {
+					t.Errorf("packet %d: expected id %d, got %d", i, id, page.Packets[i].ID)
+				}
+			}
+			if expected := encodeCursor(tc.next); page.Next != expected {
+				t.Errorf("expected next %q, got %q", expected, page.Next)
+			}
+			if expected := encodeCursor(tc.prev); page.Prev != expected {
+				t.Errorf("expected prev %q, got %q", expected, page.Prev)
+			}
+		})
+	}
+}
+
+func encodeCursor(cursor *pkg.PacketCursor) string {
+	if cursor == nil {
+		return ""
+	}
+	return cursor.Encode()
+}
+
+func TestPacketService_GetPacketData(t *testing.T) {
+	mockRepo := &MockPacketRepository{
+		packetData: map[uint]pkg.PacketData{7: {PacketID: 7, Data: []byte{0xde, 0xad}}},
+	}
+	service := NewPacketService(mockRepo, nil, nil)
+
+	data, err := service.GetPacketData(7)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if data.PacketID != 7 || len(data.Data) != 2 {
+		t.Errorf("unexpected packet data: %+v", data)
+	}
+	if _, err := service.GetPacketData(8); !errors.Is(err, pkg.ErrPacketNotFound) {
+		t.Errorf("expected ErrPacketNotFound, got %v", err)
+	}
+}
+
+func TestPacketService_GetUpdateAndDeletePacket(t *testing.T) {
+	mockRepo := &MockPacketRepository{
+		packets: []pkg.SavedPacket{{ID: 1, Protocol: "TCP"}, {ID: 2, Protocol: "ARP"}},
+	}
+	service := NewPacketService(mockRepo, nil, nil)
+
+	packet, err := service.GetPacket(2)
+	if err != nil || packet.Protocol != "ARP" {
+		t.Fatalf("expected packet 2, got %+v (%v)", packet, err)
+	}
+
+	tags := []string{"suspicious"}
+	notes := "beaconing every 60s"
+	updated, err := service.UpdatePacket(1, pkg.PacketUpdate{Tags: &tags, Notes: &notes})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(updated.Tags) != 1 || updated.Notes != notes {
+		t.Errorf("unexpected annotations: %+v", updated)
+	}
+
+	if err := service.DeletePacket(1); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := service.GetPacket(1); !errors.Is(err, pkg.ErrPacketNotFound) {
+		t.Errorf("expected ErrPacketNotFound after delete, got %v", err)
+	}
+	if err := service.DeletePacket(1); !errors.Is(err, pkg.ErrPacketNotFound) {
+		t.Errorf("expected ErrPacketNotFound, got %v", err)
+	}
+}
+
+func TestPacketService_GetPacketsHostnames(t *testing.T) {
+	mockRepo := &MockPacketRepository{
+		packets: []pkg.SavedPacket{{ID: 1, SourceIP: "10.0.0.5", DestinationIP: "93.184.216.34"}, {ID: 2, Protocol: "ARP"}},
+	}
+	hostnames := &MockDNSRepository{hostnames: map[string]string{"93.184.216.34": "www.example.com"}}
+	service := NewPacketService(mockRepo, hostnames, nil)
+
+	page, err := service.GetPackets(pkg.PacketFilter{Limit: 10})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if page.Packets[0].DestinationHostname != "www.example.com" || page.Packets[0].SourceHostname != "" {
+		t.Errorf("unexpected hostnames: %+v", page.Packets[0])
+	}
+}
+
+func TestPacketService_GetPacketsHosts(t *testing.T) {
+	mockRepo := &MockPacketRepository{
+		packets: []pkg.SavedPacket{{ID: 1, SourceMAC: "00:50:56:01:02:03", DestinationMAC: "ff:ff:ff:ff:ff:ff", Protocol: "ARP"}},
+	}
+	hosts := &MockHostRepository{byMAC: map[string]pkg.Host{"00:50:56:01:02:03": {ID: 7, MAC: "00:50:56:01:02:03"}}}
+	service := NewPacketService(mockRepo, nil, hosts)
+
+	page, err := service.GetPackets(pkg.PacketFilter{Limit: 10})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	packet := page.Packets[0]
+	if packet.SourceHostID == nil || *packet.SourceHostID != 7 || packet.DestinationHostID != nil {
+		t.Errorf("unexpected host links: %v %v", packet.SourceHostID, packet.DestinationHostID)
+	}
+}
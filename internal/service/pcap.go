@@ -0,0 +1,33 @@
+package service
+
+import (
+	"github.com/impact-dryer/gotattletale/internal/config"
+	"github.com/impact-dryer/gotattletale/pkg"
+)
+
+type PcapService interface {
+	ListPcapFiles() ([]pkg.PcapFile, error)
+	GetPcapFilePath(name string) (string, error)
+}
+
+type PcapServiceImpl struct {
+	OutputPath string
+}
+
+func (s PcapServiceImpl) ListPcapFiles() ([]pkg.PcapFile, error) {
+	if s.OutputPath == "" || s.OutputPath == "none" {
+		return []pkg.PcapFile{}, nil
+	}
+	return pkg.ListPcapFiles(s.OutputPath)
+}
+
+func (s PcapServiceImpl) GetPcapFilePath(name string) (string, error) {
+	if s.OutputPath == "" || s.OutputPath == "none" {
+		return "", pkg.ErrPcapFileNotFound
+	}
+	return pkg.PcapFilePath(s.OutputPath, name)
+}
+
+func NewPcapService(appconfig *config.AppConfig) PcapService {
+	return &PcapServiceImpl{OutputPath: appconfig.PcapOutput}
+}
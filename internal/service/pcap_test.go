@@ -0,0 +1,53 @@
+package service
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/impact-dryer/gotattletale/internal/config"
+	"github.com/impact-dryer/gotattletale/pkg"
+)
+
+func TestPcapService_ListPcapFiles(t *testing.T) {
+	dir := t.TempDir()
+	name := "capture-eth0-20240301T120000.000000.pcap"
+	if err := os.WriteFile(filepath.Join(dir, name), []byte("data"), 0644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+
+	service := NewPcapService(&config.AppConfig{PcapOutput: filepath.Join(dir, "capture.pcap")})
+
+	files, err := service.ListPcapFiles()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(files) != 1 || files[0].Name != name || files[0].Size != 4 {
+		t.Fatalf("unexpected files: %+v", files)
+	}
+
+	path, err := service.GetPcapFilePath(name)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if path != filepath.Join(dir, name) {
+		t.Errorf("unexpected path %s", path)
+	}
+}
+
+func TestPcapService_Disabled(t *testing.T) {
+	service := NewPcapService(&config.AppConfig{PcapOutput: "none"})
+
+	files, err := service.ListPcapFiles()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(files) != 0 {
+		t.Errorf("expected no files, got %d", len(files))
+	}
+
+	if _, err := service.GetPcapFilePath("output-eth0.pcap"); !errors.Is(err, pkg.ErrPcapFileNotFound) {
+		t.Errorf("expected ErrPcapFileNotFound, got %v", err)
+	}
+}
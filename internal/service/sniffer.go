@@ -1,19 +1,36 @@
 package service
 
 import (
+	"context"
+	"errors"
 	"log"
+	"time"
 
 	"github.com/impact-dryer/gotattletale/pkg"
+	"go.uber.org/fx"
 )
 
-func SniffAndStorePackets(repository pkg.PacketRepository) {
+func SniffAndStorePackets(repository pkg.PacketRepository, hub *pkg.PacketHub, defragmenter *pkg.Defragmenter, flows *pkg.FlowTable, dns *pkg.DNSTracker, hosts *pkg.HostTracker, arp *pkg.ARPMonitor, pings *pkg.PingTracker) {
+	queue := pkg.PacketsToCaptureQueue.ItemsChan
 	go func() {
 		packetCache := make([]pkg.AppPacket, 0)
-		for v, ok := <-pkg.PacketsToCaptureQueue.ItemsChan; ok; v, ok = <-pkg.PacketsToCaptureQueue.ItemsChan {
-			packetCache = append(packetCache, v)
+		for v, ok := <-queue; ok; v, ok = <-queue {
+			// Fragments are held until their datagram is reassembled.
+			for _, v := range defragmenter.Add(v) {
+				hub.Publish(v)
+				flows.Add(v)
+				dns.Add(v)
+				hosts.Add(v)
+				arp.Add(v)
+				pings.Add(v)
+				packetCache = append(packetCache, v)
+			}
 			if len(packetCache) > 100 {
 				err := repository.SavePackets(packetCache)
-				if err != nil {
+				var packetErrors *pkg.PacketErrors
+				if errors.As(err, &packetErrors) {
+					log.Println("Skipped packets:", packetErrors)
+				} else if err != nil {
 					log.Fatal(err)
 					panic(err)
 				}
@@ -22,3 +39,34 @@ func SniffAndStorePackets(repository pkg.PacketRepository) {
 		}
 	}()
 }
+
+// runPeriodically calls tick at every interval while the application runs,
+// then stop once it stops.
+func runPeriodically(lifecycle fx.Lifecycle, interval time.Duration, tick func(now time.Time), stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	lifecycle.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			go func() {
+				defer close(stopped)
+				ticker := time.NewTicker(interval)
+				defer ticker.Stop()
+				for {
+					select {
+					case <-done:
+						return
+					case now := <-ticker.C:
+						tick(now)
+					}
+				}
+			}()
+			return nil
+		},
+		OnStop: func(context.Context) error {
+			close(done)
+			<-stopped
+			stop()
+			return nil
+		},
+	})
+}
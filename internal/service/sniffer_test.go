@@ -39,20 +39,28 @@ func (m *TestMockPacketRepository) SavePackets(packets []pkg.AppPacket) error {
 	return m.savePacketsErr
 }
 
-func (m *TestMockPacketRepository) GetPackets(limit int, sort string) ([]pkg.SavedPacket, error) {
+func (m *TestMockPacketRepository) GetPackets(filter pkg.PacketFilter) ([]pkg.SavedPacket, error) {
 	return nil, nil
 }
 
-func (m *TestMockPacketRepository) GetPacket(packetID string) (pkg.SavedPacket, error) {
+func (m *TestMockPacketRepository) CountPackets(filter pkg.PacketFilter) (pkg.PacketCount, error) {
+	return pkg.PacketCount{}, nil
+}
+
+func (m *TestMockPacketRepository) GetPacket(packetID uint) (pkg.SavedPacket, error) {
 	return pkg.SavedPacket{}, nil
 }
 
-func (m *TestMockPacketRepository) DeletePacket(packetID string) error {
+func (m *TestMockPacketRepository) DeletePacket(packetID uint) error {
 	return nil
 }
 
-func (m *TestMockPacketRepository) UpdatePacket(packet pkg.AppPacket) error {
-	return nil
+func (m *TestMockPacketRepository) UpdatePacket(packetID uint, update pkg.PacketUpdate) (pkg.SavedPacket, error) {
+	return pkg.SavedPacket{}, nil
+}
+
+func (m *TestMockPacketRepository) GetPacketData(packetID uint) (pkg.PacketData, error) {
+	return pkg.PacketData{}, nil
 }
 
 func (m *TestMockPacketRepository) GetSavedPackets() [][]pkg.AppPacket {
@@ -78,7 +86,7 @@ func TestSniffAndStorePackets_BatchesSaveWhenCacheExceeds100(t *testing.T) {
 	}
 
 	// Start the sniff and store service
-	SniffAndStorePackets(mockRepo)
+	SniffAndStorePackets(mockRepo, pkg.NewPacketHub(), pkg.NewDefragmenter(1<<20, time.Minute), pkg.NewFlowTable(time.Minute, 0, 0, 0), pkg.NewDNSTracker(), pkg.NewHostTracker(), newTestARPMonitor(t), pkg.NewPingTracker())
 
 	// Send more than 100 packets to trigger a save
 	for i := 0; i < 101; i++ {
@@ -126,7 +134,7 @@ func TestSniffAndStorePackets_ProcessesMultipleBatches(t *testing.T) {
 	}
 
 	// Start the sniff and store service
-	SniffAndStorePackets(mockRepo)
+	SniffAndStorePackets(mockRepo, pkg.NewPacketHub(), pkg.NewDefragmenter(1<<20, time.Minute), pkg.NewFlowTable(time.Minute, 0, 0, 0), pkg.NewDNSTracker(), pkg.NewHostTracker(), newTestARPMonitor(t), pkg.NewPingTracker())
 
 	// Send enough packets for two batches (101 + 101 = 202 packets)
 	for i := 0; i < 202; i++ {
@@ -173,7 +181,7 @@ func TestSniffAndStorePackets_DoesNotSaveUntilThreshold(t *testing.T) {
 	}
 
 	// Start the sniff and store service
-	SniffAndStorePackets(mockRepo)
+	SniffAndStorePackets(mockRepo, pkg.NewPacketHub(), pkg.NewDefragmenter(1<<20, time.Minute), pkg.NewFlowTable(time.Minute, 0, 0, 0), pkg.NewDNSTracker(), pkg.NewHostTracker(), newTestARPMonitor(t), pkg.NewPingTracker())
 
 	// Send fewer than 101 packets
 	for i := 0; i < 50; i++ {
@@ -215,7 +223,7 @@ func TestSniffAndStorePackets_PreservesPacketData(t *testing.T) {
 	}
 
 	// Start the sniff and store service
-	SniffAndStorePackets(mockRepo)
+	SniffAndStorePackets(mockRepo, pkg.NewPacketHub(), pkg.NewDefragmenter(1<<20, time.Minute), pkg.NewFlowTable(time.Minute, 0, 0, 0), pkg.NewDNSTracker(), pkg.NewHostTracker(), newTestARPMonitor(t), pkg.NewPingTracker())
 
 	// Send packets with specific device IDs
 	expectedDeviceIDs := make([]string, 101)
@@ -267,7 +275,7 @@ func TestSniffAndStorePackets_StartsGoroutine(t *testing.T) {
 	// The function should return immediately (non-blocking)
 	done := make(chan struct{})
 	go func() {
-		SniffAndStorePackets(mockRepo)
+		SniffAndStorePackets(mockRepo, pkg.NewPacketHub(), pkg.NewDefragmenter(1<<20, time.Minute), pkg.NewFlowTable(time.Minute, 0, 0, 0), pkg.NewDNSTracker(), pkg.NewHostTracker(), newTestARPMonitor(t), pkg.NewPingTracker())
 		close(done)
 	}()
 
@@ -278,3 +286,36 @@ func TestSniffAndStorePackets_StartsGoroutine(t *testing.T) {
 		t.Fatal("SniffAndStorePackets should return immediately (it starts a goroutine)")
 	}
 }
+
+func TestSniffAndStorePackets_KeepsGoingAfterPacketErrors(t *testing.T) {
+	originalQueue := pkg.PacketsToCaptureQueue
+	defer func() { pkg.PacketsToCaptureQueue = originalQueue }()
+
+	pkg.PacketsToCaptureQueue = pkg.PacketQueue{
+		ItemsChan: make(chan pkg.AppPacket, 300),
+	}
+
+	mockRepo := &TestMockPacketRepository{
+		savedPackets: make([][]pkg.AppPacket, 0),
+		saveCalled:   make(chan struct{}, 10),
+		savePacketsErr: &pkg.PacketErrors{
+			Total:  101,
+			Failed: []pkg.PacketError{{Index: 3, Err: pkg.ErrPacketWithoutData}},
+		},
+	}
+
+	SniffAndStorePackets(mockRepo, pkg.NewPacketHub(), pkg.NewDefragmenter(1<<20, time.Minute), pkg.NewFlowTable(time.Minute, 0, 0, 0), pkg.NewDNSTracker(), pkg.NewHostTracker(), newTestARPMonitor(t), pkg.NewPingTracker())
+
+	for i := 0; i < 202; i++ {
+		pkg.PacketsToCaptureQueue.ItemsChan <- pkg.AppPacket{DeviceID: "test-device"}
+	}
+
+	timeout := time.After(3 * time.Second)
+	for saveCount := 0; saveCount < 2; saveCount++ {
+		select {
+		case <-mockRepo.saveCalled:
+		case <-timeout:
+			t.Fatalf("timeout waiting for SavePackets, only got %d calls", saveCount)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/impact-dryer/gotattletale/internal/config"
+	"github.com/impact-dryer/gotattletale/pkg"
+)
+
+// Stream event types.
+const (
+	StreamEventPacket    = "packet"
+	StreamEventDropped   = "dropped"
+	StreamEventHeartbeat = "heartbeat"
+)
+
+// StreamEvent is one event of a live packet stream. Dropped counts the
+// packets lost since the previous dropped event because the client was
+// too slow.
+type StreamEvent struct {
+	Type    string           `json:"type"`
+	Time    time.Time        `json:"time"`
+	Packet  *pkg.SavedPacket `json:"packet,omitempty"`
+	Dropped uint64           `json:"dropped,omitempty"`
+}
+
+type StreamService interface {
+	// StreamPackets sends the live packets matching filter until ctx is
+	// done or send fails.
+	StreamPackets(ctx context.Context, filter pkg.PacketFilter, send func(StreamEvent) error) error
+}
+
+type StreamServiceImpl struct {
+	Hub       *pkg.PacketHub
+	Buffer    int
+	Heartbeat time.Duration
+}
+
+func (s StreamServiceImpl) StreamPackets(ctx context.Context, filter pkg.PacketFilter, send func(StreamEvent) error) error {
+	if err := filter.Validate(); err != nil {
+		return err
+	}
+	subscription := s.Hub.Subscribe(filter, s.Buffer)
+	defer s.Hub.Unsubscribe(subscription)
+
+	heartbeat := time.NewTicker(s.Heartbeat)
+	defer heartbeat.Stop()
+
+	// reportDropped sends a dropped event before anything else, so the
+	// client learns about the gap where it happened.
+	reportDropped := func() error {
+		if dropped := subscription.TakeDropped(); dropped > 0 {
+			return send(StreamEvent{Type: StreamEventDropped, Time: time.Now(), Dropped: dropped})
+		}
+		return nil
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case packet := <-subscription.Packets:
+			if err := reportDropped(); err != nil {
+				return err
+			}
+			if err := send(StreamEvent{Type: StreamEventPacket, Time: packet.CreatedAt, Packet: &packet}); err != nil {
+				return err
+			}
+		case now := <-heartbeat.C:
+			if err := reportDropped(); err != nil {
+				return err
+			}
+			if err := send(StreamEvent{Type: StreamEventHeartbeat, Time: now}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func NewStreamService(hub *pkg.PacketHub, appConfig *config.AppConfig) StreamService {
+	heartbeat := appConfig.StreamHeartbeat
+	if heartbeat <= 0 {
+		heartbeat = 15 * time.Second
+	}
+	return &StreamServiceImpl{Hub: hub, Buffer: appConfig.StreamBufferSize, Heartbeat: heartbeat}
+}
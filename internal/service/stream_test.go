@@ -0,0 +1,102 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/impact-dryer/gotattletale/pkg"
+)
+
+func streamTestPacket(dstPort int) pkg.AppPacket {
+	ip := &layers.IPv4{Version: 4, SrcIP: []byte{10, 0, 0, 1}, DstIP: []byte{10, 0, 0, 2}, Protocol: layers.IPProtocolUDP}
+	udp := &layers.UDP{SrcPort: 5000, DstPort: layers.UDPPort(dstPort)}
+	udp.SetNetworkLayerForChecksum(ip)
+	buf := gopacket.NewSerializeBuffer()
+	gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}, ip, udp)
+	return pkg.AppPacket{Data: gopacket.NewPacket(buf.Bytes(), layers.LayerTypeIPv4, gopacket.Default), CreatedAt: time.Now()}
+}
+
+func TestStreamService_StreamPackets(t *testing.T) {
+	hub := pkg.NewPacketHub()
+	service := &StreamServiceImpl{Hub: hub, Buffer: 2, Heartbeat: time.Hour}
+	query, _ := pkg.ParseDisplayFilter("udp.dstport == 53")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := make(chan StreamEvent, 10)
+	done := make(chan error)
+	go func() {
+		done <- service.StreamPackets(ctx, pkg.PacketFilter{Query: query}, func(event StreamEvent) error {
+			events <- event
+			return nil
+		})
+	}()
+
+	// Wait for the subscription before publishing.
+	for deadline := time.Now().Add(time.Second); ; {
+		hub.Publish(streamTestPacket(53))
+		select {
+		case event := <-events:
+			if event.Type != StreamEventPacket || *event.Packet.DestinationPort != 53 {
+				t.Fatalf("unexpected event %+v", event)
+			}
+		case <-time.After(10 * time.Millisecond):
+			if time.Now().After(deadline) {
+				t.Fatal("no packet streamed")
+			}
+			continue
+		}
+		break
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("expected nil after cancel, got %v", err)
+	}
+}
+
+func TestStreamService_ReportsDroppedPackets(t *testing.T) {
+	hub := pkg.NewPacketHub()
+	service := &StreamServiceImpl{Hub: hub, Buffer: 1, Heartbeat: 20 * time.Millisecond}
+
+	var received []StreamEvent
+	stop := errors.New("stop")
+	err := service.StreamPackets(context.Background(), pkg.PacketFilter{}, func(event StreamEvent) error {
+		received = append(received, event)
+		switch len(received) {
+		case 1:
+			// The first heartbeat arrives with nobody publishing yet:
+			// publish more than the buffer holds.
+			for i := 0; i < 3; i++ {
+				hub.Publish(streamTestPacket(80))
+			}
+		case 3:
+			return stop
+		}
+		return nil
+	})
+
+	if !errors.Is(err, stop) {
+		t.Fatalf("expected the send error, got %v", err)
+	}
+	if received[0].Type != StreamEventHeartbeat {
+		t.Errorf("expected a heartbeat first, got %+v", received[0])
+	}
+	if received[1].Type != StreamEventDropped || received[1].Dropped != 2 {
+		t.Errorf("expected 2 dropped packets reported, got %+v", received[1])
+	}
+	if received[2].Type != StreamEventPacket {
+		t.Errorf("expected the buffered packet after the drop report, got %+v", received[2])
+	}
+}
+
+func TestStreamService_RejectsInvalidFilter(t *testing.T) {
+	service := &StreamServiceImpl{Hub: pkg.NewPacketHub(), Buffer: 1, Heartbeat: time.Hour}
+	err := service.StreamPackets(context.Background(), pkg.PacketFilter{Direction: "up"}, func(StreamEvent) error { return nil })
+	if !errors.Is(err, pkg.ErrInvalidPacketFilter) {
+		t.Fatalf("expected ErrInvalidPacketFilter, got %v", err)
+	}
+}
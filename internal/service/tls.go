@@ -0,0 +1,32 @@
+package service
+
+import (
+	"github.com/impact-dryer/gotattletale/pkg"
+)
+
+type TLSService interface {
+	GetTLSSessions(filter pkg.TLSFilter) ([]pkg.TLSSession, error)
+}
+
+type TLSServiceImpl struct {
+	Storage pkg.TLSRepository
+}
+
+func (s TLSServiceImpl) GetTLSSessions(filter pkg.TLSFilter) ([]pkg.TLSSession, error) {
+	filter.Limit = clampLimit(filter.Limit)
+	return s.Storage.GetTLSSessions(filter)
+}
+
+func NewTLSService(storage pkg.TLSRepository) TLSService {
+	return &TLSServiceImpl{Storage: storage}
+}
+
+// addTLSSessions reads the TLS handshakes of the TCP payloads, to be
+// stored along with the flows.
+func addTLSSessions(flows []pkg.Flow) {
+	for i := range flows {
+		if flows[i].Protocol == pkg.ProtocolTCP && flows[i].Payload != nil {
+			flows[i].TLS = pkg.ParseTLSSession(flows[i], *flows[i].Payload)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package service
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+
+	"github.com/impact-dryer/gotattletale/pkg"
+)
+
+type MockTLSRepository struct {
+	filter pkg.TLSFilter
+}
+
+func (m *MockTLSRepository) GetTLSSessions(filter pkg.TLSFilter) ([]pkg.TLSSession, error) {
+	m.filter = filter
+	return []pkg.TLSSession{{ID: 1}}, nil
+}
+
+// clientHelloTestPayload returns the ClientHello of the crypto/tls client
+// for www.example.com as the payload of a flow from the client.
+func clientHelloTestPayload(t *testing.T) *pkg.FlowPayload {
+	t.Helper()
+	clientEnd, serverEnd := net.Pipe()
+	defer clientEnd.Close()
+	defer serverEnd.Close()
+	go tls.Client(clientEnd, &tls.Config{ServerName: "www.example.com", InsecureSkipVerify: true}).Handshake()
+	hello := make([]byte, 4096)
+	n, err := serverEnd.Read(hello)
+	if err != nil {
+		t.Fatalf("failed to read the ClientHello: %v", err)
+	}
+	return &pkg.FlowPayload{SourceData: hello[:n], Segments: []pkg.StreamSegment{{FromSource: true, Length: n}}}
+}
+
+func TestSaveFlowsAddsTLSSessions(t *testing.T) {
+	repo := &MockFlowRepository{}
+	hello := clientHelloTestPayload(t)
+	saveFlows(repo, []pkg.Flow{
+		{Protocol: pkg.ProtocolTCP, SourceIP: "10.0.0.1", DestinationIP: "10.0.0.2", Payload: hello},
+		{Protocol: pkg.ProtocolTCP, Payload: &pkg.FlowPayload{SourceData: []byte("GET / HTTP/1.1\r\nHost: a\r\n\r\n")}},
+		{Protocol: pkg.ProtocolTCP},
+		{Protocol: pkg.ProtocolUDP, Payload: hello},
+	}, 0)
+
+	saved := repo.Saved()
+	if len(saved) != 4 {
+		t.Fatalf("expected 4 flows, got %+v", saved)
+	}
+	if session := saved[0].TLS; session == nil || session.ServerName != "www.example.com" || session.ClientIP != "10.0.0.1" {
+		t.Errorf("expected the session on the first flow, got %+v", session)
+	}
+	for _, flow := range saved[1:] {
+		if flow.TLS != nil {
+			t.Errorf("expected no session on %+v", flow)
+		}
+	}
+}
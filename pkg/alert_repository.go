@@ -0,0 +1,206 @@
+package pkg
+
+import (
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"gorm.io/gorm"
+)
+
+var ErrAlertNotFound = errors.New("alert not found")
+
+// Alert types.
+const (
+	AlertARPBindingChanged  = "arp_binding_changed"
+	AlertARPDuplicateIP     = "arp_duplicate_ip"
+	AlertARPGratuitousFlood = "arp_gratuitous_flood"
+	AlertIPFragmentOverlap  = "ip_fragment_overlap"
+	AlertIPTinyFragment     = "ip_tiny_fragment"
+)
+
+// Alert is something suspicious seen on the wire, with the packets that
+// show it. IP and MAC are the address and the hardware address the alert
+// is about, PreviousMAC the one the address was bound to before.
+type Alert struct {
+	ID          uint          `gorm:"primaryKey" json:"id"`
+	Time        time.Time     `gorm:"not null;index" json:"time"`
+	Type        string        `gorm:"not null;index" json:"type"`
+	DeviceID    string        `gorm:"not null" json:"device"`
+	IP          string        `gorm:"column:alert_ip;index" json:"ip,omitempty"`
+	IPKey       string        `gorm:"column:alert_ip_key;index" json:"-"`
+	MAC         string        `gorm:"index" json:"mac,omitempty"`
+	PreviousMAC string        `json:"previous_mac,omitempty"`
+	Message     string        `gorm:"not null" json:"message"`
+	Evidence    []AlertPacket `gorm:"serializer:json" json:"evidence"`
+}
+
+// AlertPacket is a frame given as evidence of an alert, with the fields
+// of its ARP message when it carries one.
+type AlertPacket struct {
+	Time      time.Time `json:"time"`
+	LinkType  int       `json:"link_type"`
+	Length    int       `json:"length"`
+	Data      []byte    `json:"data"`
+	Operation string    `json:"operation,omitempty"`
+	SenderMAC string    `json:"sender_mac,omitempty"`
+	SenderIP  string    `json:"sender_ip,omitempty"`
+	TargetMAC string    `json:"target_mac,omitempty"`
+	TargetIP  string    `json:"target_ip,omitempty"`
+}
+
+// AlertFilter selects the alerts returned by GetAlerts, newest first. Zero
+// fields do not filter, MACs are lower case.
+type AlertFilter struct {
+	Limit   int
+	From    time.Time
+	To      time.Time
+	Types   []string
+	IPs     []*net.IPNet
+	MACs    []string
+	Devices []string
+}
+
+type AlertRepository interface {
+	SaveAlerts(alerts []Alert) error
+	GetAlerts(filter AlertFilter) ([]Alert, error)
+	GetAlert(id uint) (Alert, error)
+}
+
+type SqlLiteAlertRepository struct {
+	db *gorm.DB
+}
+
+func (r *SqlLiteAlertRepository) SaveAlerts(alerts []Alert) error {
+	if len(alerts) == 0 {
+		return nil
+	}
+	for i := range alerts {
+		alerts[i].IPKey = ipKey(alerts[i].IP)
+	}
+	return r.db.CreateInBatches(alerts, 100).Error
+}
+
+func (r *SqlLiteAlertRepository) GetAlerts(filter AlertFilter) ([]Alert, error) {
+	alerts := make([]Alert, 0)
+	query := r.db.Model(&Alert{})
+	query = timeRange(query, "time", filter.From, filter.To)
+	if len(filter.Types) > 0 {
+		query = query.Where("type IN ?", filter.Types)
+	}
+	if len(filter.IPs) > 0 {
+		query = query.Where(ipCondition(query, filter.IPs, "alert"))
+	}
+	if len(filter.MACs) > 0 {
+		query = query.Where("mac IN ? OR previous_mac IN ?", filter.MACs, filter.MACs)
+	}
+	if len(filter.Devices) > 0 {
+		query = query.Where("device_id IN ?", filter.Devices)
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+	result := query.Order("time desc").Order("id desc").Find(&alerts)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return alerts, nil
+}
+
+func (r *SqlLiteAlertRepository) GetAlert(id uint) (Alert, error) {
+	var alert Alert
+	result := r.db.First(&alert, id)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return Alert{}, ErrAlertNotFound
+	}
+	if result.Error != nil {
+		return Alert{}, result.Error
+	}
+	return alert, nil
+}
+
+// raisedAlerts holds the alerts raised until they are taken, without
+// raising the same one again within the interval.
+type raisedAlerts struct {
+	interval time.Duration
+	raised   map[string]time.Time
+	alerts   []Alert
+}
+
+func newRaisedAlerts(interval time.Duration) raisedAlerts {
+	return raisedAlerts{interval: interval, raised: make(map[string]time.Time)}
+}
+
+// raise adds the alert unless the same one was raised recently, and
+// forgets the alerts that are old enough to be raised again.
+func (r *raisedAlerts) raise(key string, alert Alert) {
+	for k, at := range r.raised {
+		if alert.Time.Sub(at) >= r.interval {
+			delete(r.raised, k)
+		}
+	}
+	if _, ok := r.raised[key]; ok {
+		return
+	}
+	r.raised[key] = alert.Time
+	r.alerts = append(r.alerts, alert)
+}
+
+// take returns the alerts raised since the last call.
+func (r *raisedAlerts) take() []Alert {
+	alerts := r.alerts
+	r.alerts = nil
+	return alerts
+}
+
+// newAlertPacket copies the frame of a packet as evidence.
+func newAlertPacket(packet AppPacket) AlertPacket {
+	data := packet.Data.Data()
+	evidence := AlertPacket{
+		Time:     packet.CreatedAt,
+		LinkType: int(packet.LinkType),
+		Length:   max(packet.Data.Metadata().Length, len(data)),
+		Data:     append([]byte(nil), data...),
+	}
+	if arp, ok := packet.Data.Layer(layers.LayerTypeARP).(*layers.ARP); ok {
+		evidence.Operation = arpOperationName(arp.Operation)
+		evidence.SenderMAC = net.HardwareAddr(arp.SourceHwAddress).String()
+		evidence.SenderIP = net.IP(arp.SourceProtAddress).String()
+		evidence.TargetMAC = net.HardwareAddr(arp.DstHwAddress).String()
+		evidence.TargetIP = net.IP(arp.DstProtAddress).String()
+	}
+	return evidence
+}
+
+// WriteAlertPcap writes a pcap file holding the evidence of an alert. The
+// frames of different link types are written with the type of the first.
+func WriteAlertPcap(w io.Writer, alert Alert) error {
+	writer := newPcapWriter(w)
+	linkType := layers.LinkTypeEthernet
+	if len(alert.Evidence) > 0 {
+		linkType = layers.LinkType(alert.Evidence[0].LinkType)
+	}
+	if err := writer.WriteFileHeader(SNAPSHOTLENGTH, linkType); err != nil {
+		return err
+	}
+	for _, packet := range alert.Evidence {
+		err := writer.WritePacket(gopacket.CaptureInfo{
+			Timestamp:     packet.Time,
+			CaptureLength: len(packet.Data),
+			Length:        max(packet.Length, len(packet.Data)),
+		}, packet.Data)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func NewSqlLiteAlertRepository(db *gorm.DB) AlertRepository {
+	db.AutoMigrate(&Alert{})
+
+	return &SqlLiteAlertRepository{db: db}
+}
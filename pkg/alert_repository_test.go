@@ -0,0 +1,75 @@
+package pkg
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupAlertTestDB(t *testing.T) AlertRepository {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open test database: %v", err)
+	}
+	return NewSqlLiteAlertRepository(db)
+}
+
+func TestAlertRepositoryGetAlerts(t *testing.T) {
+	repo := setupAlertTestDB(t)
+	start := time.Now().Add(-time.Hour)
+	alerts := []Alert{
+		{Time: start, Type: AlertARPBindingChanged, DeviceID: "eth0", IP: "10.0.0.1", MAC: "00:50:56:00:00:02", PreviousMAC: "00:50:56:00:00:01", Message: "moved",
+			Evidence: []AlertPacket{{Time: start, Length: 42, Data: []byte{1, 2, 3}, Operation: "reply", SenderMAC: "00:50:56:00:00:02", SenderIP: "10.0.0.1"}}},
+		{Time: start.Add(10 * time.Minute), Type: AlertARPDuplicateIP, DeviceID: "eth0", IP: "192.168.1.7", MAC: "00:50:56:00:00:03", PreviousMAC: "00:50:56:00:00:04", Message: "duplicate"},
+		{Time: start.Add(20 * time.Minute), Type: AlertARPGratuitousFlood, DeviceID: "eth1", MAC: "00:50:56:00:00:01", Message: "flood"},
+	}
+	if err := repo.SaveAlerts(alerts); err != nil {
+		t.Fatalf("SaveAlerts failed: %v", err)
+	}
+	_, network, _ := net.ParseCIDR("192.168.0.0/16")
+
+	tests := []struct {
+		name   string
+		filter AlertFilter
+		want   []string
+	}{
+		{"all, newest first", AlertFilter{}, []string{"flood", "duplicate", "moved"}},
+		{"limit", AlertFilter{Limit: 1}, []string{"flood"}},
+		{"type", AlertFilter{Types: []string{AlertARPDuplicateIP}}, []string{"duplicate"}},
+		{"ip in network", AlertFilter{IPs: []*net.IPNet{network}}, []string{"duplicate"}},
+		{"mac or previous mac", AlertFilter{MACs: []string{"00:50:56:00:00:01"}}, []string{"flood", "moved"}},
+		{"device", AlertFilter{Devices: []string{"eth1"}}, []string{"flood"}},
+		{"time range", AlertFilter{From: start.Add(5 * time.Minute), To: start.Add(15 * time.Minute)}, []string{"duplicate"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := repo.GetAlerts(tt.filter)
+			if err != nil {
+				t.Fatalf("GetAlerts failed: %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("expected %v, got %+v", tt.want, got)
+			}
+			for i, alert := range got {
+				if alert.Message != tt.want[i] {
+					t.Errorf("expected %v, got %s at %d", tt.want, alert.Message, i)
+				}
+			}
+		})
+	}
+
+	alert, err := repo.GetAlert(alerts[0].ID)
+	if err != nil {
+		t.Fatalf("GetAlert failed: %v", err)
+	}
+	if len(alert.Evidence) != 1 || alert.Evidence[0].SenderIP != "10.0.0.1" || len(alert.Evidence[0].Data) != 3 {
+		t.Errorf("expected the evidence stored with the alert, got %+v", alert.Evidence)
+	}
+	if _, err := repo.GetAlert(1000); !errors.Is(err, ErrAlertNotFound) {
+		t.Errorf("expected ErrAlertNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,243 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+const (
+	// arpConflictWindow is how recently the previous MAC of an address
+	// must have claimed it for a new claim to be a duplicate rather than a
+	// move.
+	arpConflictWindow = 30 * time.Second
+	// arpFloodWindow and arpFloodThreshold: that many gratuitous ARPs from
+	// one MAC within the window are a flood.
+	arpFloodWindow    = 10 * time.Second
+	arpFloodThreshold = 20
+	// arpAlertInterval is how long the same alert is not raised again.
+	arpAlertInterval = time.Minute
+	// arpMaxEvidence caps the packets kept as evidence of a flood.
+	arpMaxEvidence = 5
+	// arpBindingTTL is how long a binding nobody claims again is kept.
+	arpBindingTTL = time.Hour
+	// arpExpireInterval is how often, in packet time, the bindings and
+	// floods are checked for expiry.
+	arpExpireInterval = time.Second
+)
+
+// ARPMonitor watches the IPv4 to MAC bindings announced over ARP on each
+// device and VLAN. It raises an alert when an address moves to another
+// MAC, when two MACs claim it at the same time and when a MAC floods
+// gratuitous ARPs. The allowed MACs, such as the virtual MACs of VRRP or
+// HSRP failover, raise none. Bindings not claimed again within
+// arpBindingTTL are forgotten, and so are the floods once their window is
+// over.
+type ARPMonitor struct {
+	mu         sync.Mutex
+	allowed    [][]byte
+	bindings   map[arpBindingKey]*arpClaim
+	floods     map[arpFloodKey]*arpFlood
+	nextExpire time.Time
+	alerts     raisedAlerts
+}
+
+type arpBindingKey struct {
+	device string
+	vlan   int
+	ip     string
+}
+
+type arpFloodKey struct {
+	device string
+	vlan   int
+	mac    string
+}
+
+// arpClaim is the last packet in which a MAC claimed an address.
+type arpClaim struct {
+	mac      string
+	at       time.Time
+	evidence AlertPacket
+}
+
+type arpFlood struct {
+	start    time.Time
+	count    int
+	evidence []AlertPacket
+}
+
+// NewARPMonitor returns a monitor ignoring the MACs that start with one
+// of the allowed prefixes, from one byte to a whole MAC.
+func NewARPMonitor(allowed []string) (*ARPMonitor, error) {
+	prefixes := make([][]byte, 0, len(allowed))
+	for _, prefix := range allowed {
+		parsed, err := parseMACPrefix(prefix)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, parsed)
+	}
+	return &ARPMonitor{
+		allowed:  prefixes,
+		bindings: make(map[arpBindingKey]*arpClaim),
+		floods:   make(map[arpFloodKey]*arpFlood),
+		alerts:   newRaisedAlerts(arpAlertInterval),
+	}, nil
+}
+
+// parseMACPrefix reads a MAC or the first bytes of one, in hex separated
+// by colons or dashes.
+func parseMACPrefix(prefix string) ([]byte, error) {
+	parts := strings.FieldsFunc(strings.TrimSpace(prefix), func(r rune) bool { return r == ':' || r == '-' })
+	if len(parts) == 0 || len(parts) > 6 {
+		return nil, fmt.Errorf("invalid MAC prefix %q", prefix)
+	}
+	parsed := make([]byte, 0, len(parts))
+	for _, part := range parts {
+		b, err := hex.DecodeString(part)
+		if err != nil || len(b) != 1 {
+			return nil, fmt.Errorf("invalid MAC prefix %q", prefix)
+		}
+		parsed = append(parsed, b[0])
+	}
+	return parsed, nil
+}
+
+// Add checks the ARP message of the packet, if it carries one.
+func (m *ARPMonitor) Add(packet AppPacket) {
+	if packet.Data == nil {
+		return
+	}
+	arp, ok := packet.Data.Layer(layers.LayerTypeARP).(*layers.ARP)
+	if !ok || arp.AddrType != layers.LinkTypeEthernet || arp.Protocol != layers.EthernetTypeIPv4 || arp.HwAddressSize != 6 || arp.ProtAddressSize != 4 {
+		return
+	}
+	sender := net.HardwareAddr(arp.SourceHwAddress)
+	senderIP := net.IP(arp.SourceProtAddress)
+	// Probes claim no address.
+	if !usableMAC(sender) || senderIP.IsUnspecified() || m.isAllowed(sender) {
+		return
+	}
+	vlan := 0
+	if dot1q, ok := packet.Data.Layer(layers.LayerTypeDot1Q).(*layers.Dot1Q); ok {
+		vlan = int(dot1q.VLANIdentifier)
+	}
+	claim := &arpClaim{mac: sender.String(), at: packet.CreatedAt, evidence: newAlertPacket(packet)}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expire(packet.CreatedAt)
+	m.addClaim(arpBindingKey{packet.DeviceID, vlan, senderIP.String()}, claim)
+	if gratuitousARP(arp) {
+		m.addGratuitous(arpFloodKey{packet.DeviceID, vlan, claim.mac}, claim)
+	}
+}
+
+// Take returns the alerts raised since the last call.
+func (m *ARPMonitor) Take() []Alert {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.alerts.take()
+}
+
+func (m *ARPMonitor) isAllowed(mac net.HardwareAddr) bool {
+	return slices.ContainsFunc(m.allowed, func(prefix []byte) bool { return bytes.HasPrefix(mac, prefix) })
+}
+
+// addClaim binds the address to the MAC that claimed it last. A previous
+// MAC still claiming it within the conflict window makes a duplicate.
+func (m *ARPMonitor) addClaim(key arpBindingKey, claim *arpClaim) {
+	previous, ok := m.bindings[key]
+	m.bindings[key] = claim
+	if !ok || previous.mac == claim.mac {
+		return
+	}
+	alert := Alert{
+		Time:        claim.at,
+		Type:        AlertARPBindingChanged,
+		DeviceID:    key.device,
+		IP:          key.ip,
+		MAC:         claim.mac,
+		PreviousMAC: previous.mac,
+		Message:     fmt.Sprintf("%s moved from %s to %s", key.ip, previous.mac, claim.mac),
+		Evidence:    []AlertPacket{previous.evidence, claim.evidence},
+	}
+	if claim.at.Sub(previous.at) < arpConflictWindow {
+		alert.Type = AlertARPDuplicateIP
+		alert.Message = fmt.Sprintf("%s claimed by both %s and %s", key.ip, previous.mac, claim.mac)
+	}
+	if key.vlan != 0 {
+		alert.Message += fmt.Sprintf(" on VLAN %d", key.vlan)
+	}
+	m.alerts.raise(fmt.Sprintf("%s|%s|%d|%s", alert.Type, key.device, key.vlan, key.ip), alert)
+}
+
+func (m *ARPMonitor) addGratuitous(key arpFloodKey, claim *arpClaim) {
+	flood, ok := m.floods[key]
+	if !ok || claim.at.Sub(flood.start) >= arpFloodWindow {
+		flood = &arpFlood{start: claim.at}
+		m.floods[key] = flood
+	}
+	flood.count++
+	if len(flood.evidence) < arpMaxEvidence {
+		flood.evidence = append(flood.evidence, claim.evidence)
+	}
+	if flood.count != arpFloodThreshold {
+		return
+	}
+	m.alerts.raise(fmt.Sprintf("%s|%s|%d|%s", AlertARPGratuitousFlood, key.device, key.vlan, key.mac), Alert{
+		Time:     claim.at,
+		Type:     AlertARPGratuitousFlood,
+		DeviceID: key.device,
+		MAC:      key.mac,
+		Message:  fmt.Sprintf("%s sent %d gratuitous ARPs in %s", key.mac, flood.count, claim.at.Sub(flood.start).Round(time.Millisecond)),
+		Evidence: flood.evidence,
+	})
+}
+
+// expire forgets the bindings older than the TTL and the floods whose
+// window is over.
+func (m *ARPMonitor) expire(now time.Time) {
+	if now.Before(m.nextExpire) {
+		return
+	}
+	m.nextExpire = now.Add(arpExpireInterval)
+	for key, claim := range m.bindings {
+		if now.Sub(claim.at) >= arpBindingTTL {
+			delete(m.bindings, key)
+		}
+	}
+	for key, flood := range m.floods {
+		if now.Sub(flood.start) >= arpFloodWindow {
+			delete(m.floods, key)
+		}
+	}
+}
+
+// gratuitousARP tells whether a message announces the address of its
+// sender unasked: a request for itself or a reply to no one.
+func gratuitousARP(arp *layers.ARP) bool {
+	if bytes.Equal(arp.SourceProtAddress, arp.DstProtAddress) {
+		return true
+	}
+	target := net.HardwareAddr(arp.DstHwAddress)
+	return arp.Operation == layers.ARPReply && !usableMAC(target)
+}
+
+func arpOperationName(operation uint16) string {
+	switch operation {
+	case layers.ARPRequest:
+		return "request"
+	case layers.ARPReply:
+		return "reply"
+	}
+	return fmt.Sprintf("op%d", operation)
+}
@@ -0,0 +1,176 @@
+package pkg
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+func newTestARPMonitor(t *testing.T, allowed ...string) *ARPMonitor {
+	t.Helper()
+	monitor, err := NewARPMonitor(allowed)
+	if err != nil {
+		t.Fatalf("NewARPMonitor failed: %v", err)
+	}
+	return monitor
+}
+
+func TestARPMonitorBindingChanges(t *testing.T) {
+	gateway := net.HardwareAddr{0x00, 0x00, 0x0c, 0x11, 0x22, 0x33}
+	attacker := net.HardwareAddr{0x00, 0x50, 0x56, 0x66, 0x66, 0x66}
+	replacement := net.HardwareAddr{0x00, 0x1b, 0x21, 0x44, 0x44, 0x44}
+	zero := net.HardwareAddr{0, 0, 0, 0, 0, 0}
+
+	tests := []struct {
+		name    string
+		second  net.HardwareAddr
+		after   time.Duration
+		allowed []string
+		want    string
+	}{
+		{"other MAC while the first is active", attacker, time.Second, nil, AlertARPDuplicateIP},
+		{"other MAC once the first is gone", replacement, arpConflictWindow, nil, AlertARPBindingChanged},
+		{"same MAC", gateway, time.Second, nil, ""},
+		{"allowed failover MAC", attacker, time.Second, []string{"00-50-56"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			monitor := newTestARPMonitor(t, tt.allowed...)
+			start := time.Now()
+			monitor.Add(arpTestPacket(t, layers.ARPReply, gateway, "10.0.0.1", testSrcMAC, "10.0.0.5", start))
+			// A probe does not claim the address.
+			monitor.Add(arpTestPacket(t, layers.ARPRequest, testDstMAC, "0.0.0.0", zero, "10.0.0.1", start))
+			monitor.Add(arpTestPacket(t, layers.ARPReply, tt.second, "10.0.0.1", testSrcMAC, "10.0.0.5", start.Add(tt.after)))
+
+			alerts := monitor.Take()
+			if tt.want == "" {
+				if len(alerts) != 0 {
+					t.Errorf("expected no alert, got %+v", alerts)
+				}
+				return
+			}
+			if len(alerts) != 1 {
+				t.Fatalf("expected 1 alert, got %+v", alerts)
+			}
+			alert := alerts[0]
+			if alert.Type != tt.want || alert.IP != "10.0.0.1" || alert.MAC != tt.second.String() || alert.PreviousMAC != gateway.String() || alert.DeviceID != "eth0" {
+				t.Errorf("unexpected alert %+v", alert)
+			}
+			if !alert.Time.Equal(start.Add(tt.after)) {
+				t.Errorf("expected the alert at the new claim, got %v", alert.Time)
+			}
+			if len(alert.Evidence) != 2 || alert.Evidence[0].SenderMAC != gateway.String() || alert.Evidence[1].SenderMAC != tt.second.String() {
+				t.Fatalf("expected the old and new claims as evidence, got %+v", alert.Evidence)
+			}
+			if alert.Evidence[1].Operation != "reply" || alert.Evidence[1].SenderIP != "10.0.0.1" || len(alert.Evidence[1].Data) == 0 {
+				t.Errorf("unexpected evidence %+v", alert.Evidence[1])
+			}
+		})
+	}
+}
+
+func TestARPMonitorRaisesAlertsOnce(t *testing.T) {
+	monitor := newTestARPMonitor(t)
+	start := time.Now()
+	// Two MACs fighting over an address raise one alert per interval.
+	for i := range 10 {
+		mac := testSrcMAC
+		if i%2 == 1 {
+			mac = testDstMAC
+		}
+		monitor.Add(arpTestPacket(t, layers.ARPReply, mac, "10.0.0.1", layers.EthernetBroadcast, "10.0.0.5", start.Add(time.Duration(i)*time.Second)))
+	}
+	if alerts := monitor.Take(); len(alerts) != 1 {
+		t.Fatalf("expected 1 alert, got %+v", alerts)
+	}
+	monitor.Add(arpTestPacket(t, layers.ARPReply, testDstMAC, "10.0.0.1", layers.EthernetBroadcast, "10.0.0.5", start.Add(arpAlertInterval+time.Second)))
+	monitor.Add(arpTestPacket(t, layers.ARPReply, testSrcMAC, "10.0.0.1", layers.EthernetBroadcast, "10.0.0.5", start.Add(arpAlertInterval+2*time.Second)))
+	if alerts := monitor.Take(); len(alerts) != 1 || alerts[0].Type != AlertARPDuplicateIP {
+		t.Errorf("expected the alert raised again after the interval, got %+v", alerts)
+	}
+}
+
+func TestARPMonitorGratuitousFlood(t *testing.T) {
+	monitor := newTestARPMonitor(t)
+	start := time.Now()
+	for i := range 2 * arpFloodThreshold {
+		monitor.Add(arpTestPacket(t, layers.ARPRequest, testSrcMAC, "10.0.0.9", net.HardwareAddr{0, 0, 0, 0, 0, 0}, "10.0.0.9", start.Add(time.Duration(i)*10*time.Millisecond)))
+	}
+	// Slow announcements are no flood.
+	for i := range arpFloodThreshold {
+		monitor.Add(arpTestPacket(t, layers.ARPReply, testDstMAC, "10.0.0.8", layers.EthernetBroadcast, "10.0.0.8", start.Add(time.Duration(i)*time.Second)))
+	}
+
+	alerts := monitor.Take()
+	if len(alerts) != 1 {
+		t.Fatalf("expected 1 flood alert, got %+v", alerts)
+	}
+	alert := alerts[0]
+	if alert.Type != AlertARPGratuitousFlood || alert.MAC != testSrcMAC.String() || alert.IP != "" {
+		t.Errorf("unexpected alert %+v", alert)
+	}
+	if len(alert.Evidence) != arpMaxEvidence {
+		t.Errorf("expected %d evidence packets, got %d", arpMaxEvidence, len(alert.Evidence))
+	}
+}
+
+func TestARPMonitorExpires(t *testing.T) {
+	monitor := newTestARPMonitor(t)
+	start := time.Now()
+	monitor.Add(arpTestPacket(t, layers.ARPReply, testSrcMAC, "10.0.0.1", testDstMAC, "10.0.0.5", start))
+	monitor.Add(arpTestPacket(t, layers.ARPRequest, testDstMAC, "10.0.0.9", net.HardwareAddr{0, 0, 0, 0, 0, 0}, "10.0.0.9", start))
+	if len(monitor.bindings) != 2 || len(monitor.floods) != 1 {
+		t.Fatalf("expected 2 bindings and 1 flood, got %d and %d", len(monitor.bindings), len(monitor.floods))
+	}
+
+	// The flood is over with its window, the bindings last for the TTL.
+	monitor.Add(arpTestPacket(t, layers.ARPReply, testDstMAC, "10.0.0.2", testSrcMAC, "10.0.0.5", start.Add(arpFloodWindow)))
+	if len(monitor.bindings) != 3 || len(monitor.floods) != 0 {
+		t.Fatalf("expected 3 bindings and no flood, got %d and %d", len(monitor.bindings), len(monitor.floods))
+	}
+	// Only the binding claimed since is left.
+	monitor.Add(arpTestPacket(t, layers.ARPReply, testDstMAC, "10.0.0.2", testSrcMAC, "10.0.0.5", start.Add(arpBindingTTL)))
+	if len(monitor.bindings) != 1 {
+		t.Errorf("expected 1 binding left, got %d", len(monitor.bindings))
+	}
+	// A forgotten binding raises no alert when another MAC claims it.
+	monitor.Add(arpTestPacket(t, layers.ARPReply, testDstMAC, "10.0.0.1", testSrcMAC, "10.0.0.5", start.Add(arpBindingTTL+time.Second)))
+	if alerts := monitor.Take(); len(alerts) != 0 {
+		t.Errorf("expected no alert, got %+v", alerts)
+	}
+}
+
+func TestNewARPMonitorRejectsInvalidPrefixes(t *testing.T) {
+	for _, prefix := range []string{"", "zz:00", "00:00:5e:00:01:02:03", "0000.5e00.0101"} {
+		if _, err := NewARPMonitor([]string{prefix}); err == nil {
+			t.Errorf("expected an error for %q", prefix)
+		}
+	}
+}
+
+func TestWriteAlertPcap(t *testing.T) {
+	monitor := newTestARPMonitor(t)
+	start := time.Now()
+	monitor.Add(arpTestPacket(t, layers.ARPReply, testSrcMAC, "10.0.0.1", testDstMAC, "10.0.0.5", start))
+	monitor.Add(arpTestPacket(t, layers.ARPReply, testDstMAC, "10.0.0.1", testSrcMAC, "10.0.0.5", start.Add(time.Second)))
+	alerts := monitor.Take()
+	if len(alerts) != 1 {
+		t.Fatalf("expected 1 alert, got %+v", alerts)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteAlertPcap(&buf, alerts[0]); err != nil {
+		t.Fatalf("WriteAlertPcap failed: %v", err)
+	}
+	frames := 0
+	for data := buf.Bytes()[24:]; len(data) >= 16; frames++ {
+		length := int(data[8]) | int(data[9])<<8 | int(data[10])<<16 | int(data[11])<<24
+		data = data[16+length:]
+	}
+	if frames != 2 {
+		t.Errorf("expected 2 frames in the pcap, got %d", frames)
+	}
+}
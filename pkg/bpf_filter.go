@@ -0,0 +1,46 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+)
+
+var (
+	ErrInvalidFilter      = errors.New("invalid capture filter")
+	ErrFilterNotSupported = errors.New("capture does not support changing its filter")
+)
+
+var compileBPFFilter = pcap.CompileBPFFilter
+
+// BPFInstruction is one compiled BPF instruction as returned by the API.
+type BPFInstruction struct {
+	Code uint16 `json:"code"`
+	Jt   uint8  `json:"jt"`
+	Jf   uint8  `json:"jf"`
+	K    uint32 `json:"k"`
+}
+
+// CompileFilter compiles a BPF expression for the given link type without
+// opening a device. A zero snaplen falls back to SNAPSHOTLENGTH.
+func CompileFilter(expression string, linkType layers.LinkType, snaplen int) ([]BPFInstruction, error) {
+	if snaplen <= 0 {
+		snaplen = SNAPSHOTLENGTH
+	}
+	compiled, err := compileBPFFilter(linkType, snaplen, expression)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidFilter, err)
+	}
+	instructions := make([]BPFInstruction, len(compiled))
+	for i, instruction := range compiled {
+		instructions[i] = BPFInstruction{
+			Code: instruction.Code,
+			Jt:   instruction.Jt,
+			Jf:   instruction.Jf,
+			K:    instruction.K,
+		}
+	}
+	return instructions, nil
+}
@@ -0,0 +1,46 @@
+package pkg
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+)
+
+func TestCompileFilter(t *testing.T) {
+	original := compileBPFFilter
+	defer func() { compileBPFFilter = original }()
+
+	var gotLinkType layers.LinkType
+	var gotSnaplen int
+	compileBPFFilter = func(linkType layers.LinkType, snaplen int, expr string) ([]pcap.BPFInstruction, error) {
+		gotLinkType = linkType
+		gotSnaplen = snaplen
+		return []pcap.BPFInstruction{{Code: 0x28, K: 12}, {Code: 0x6, K: 65535}}, nil
+	}
+
+	instructions, err := CompileFilter("ip", layers.LinkTypeEthernet, 0)
+	if err != nil {
+		t.Fatalf("CompileFilter returned error: %v", err)
+	}
+	if gotLinkType != layers.LinkTypeEthernet || gotSnaplen != SNAPSHOTLENGTH {
+		t.Fatalf("unexpected compile arguments: %v %d", gotLinkType, gotSnaplen)
+	}
+	if len(instructions) != 2 || instructions[0].Code != 0x28 || instructions[0].K != 12 {
+		t.Fatalf("unexpected instructions: %+v", instructions)
+	}
+}
+
+func TestCompileFilterInvalid(t *testing.T) {
+	original := compileBPFFilter
+	defer func() { compileBPFFilter = original }()
+
+	compileBPFFilter = func(layers.LinkType, int, string) ([]pcap.BPFInstruction, error) {
+		return nil, errors.New("syntax error")
+	}
+
+	if _, err := CompileFilter("tcp port", layers.LinkTypeEthernet, 96); !errors.Is(err, ErrInvalidFilter) {
+		t.Fatalf("expected ErrInvalidFilter, got %v", err)
+	}
+}
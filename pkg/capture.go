@@ -0,0 +1,148 @@
+package pkg
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CaptureLimits stop a capture on their own. Zero values mean no limit.
+type CaptureLimits struct {
+	Duration   time.Duration
+	MaxPackets uint64
+}
+
+type CaptureStats struct {
+	Packets uint64
+	Bytes   uint64
+}
+
+// Capture is an opened packet stream on one device that can be stopped.
+type Capture struct {
+	Device Device
+	Limits CaptureLimits
+
+	stream   packetStream
+	packets  atomic.Uint64
+	bytes    atomic.Uint64
+	stopOnce sync.Once
+	stopped  chan struct{}
+	done     chan struct{}
+	filterMu sync.Mutex
+}
+
+// OpenCapture opens the device with the current packet stream factory. The
+// capture only starts pushing packets once Run is called.
+func OpenCapture(device Device, limits CaptureLimits) (*Capture, error) {
+	return openCapture(device, limits, packetStreamFactory)
+}
+
+func openCapture(device Device, limits CaptureLimits, factory func(*Device) (packetStream, error)) (*Capture, error) {
+	c := &Capture{
+		Device:  device,
+		Limits:  limits,
+		stopped: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	stream, err := factory(&c.Device)
+	if err != nil {
+		return nil, err
+	}
+	c.stream = stream
+	c.Device.linkType = stream.linkType
+	if c.Device.MAC == nil {
+		if iface, err := interfaceByName(c.Device.Name); err == nil {
+			c.Device.MAC = iface.HardwareAddr
+		}
+	}
+	return c, nil
+}
+
+// Run pushes packets into PacketsToCaptureQueue until the stream ends, a
+// limit is reached or Stop is called, then runs the stream cleanups.
+func (c *Capture) Run() {
+	defer close(c.done)
+	if c.stream.cleanup != nil {
+		defer c.stream.cleanup()
+	}
+	defer markCapturing(c.Device.Name)()
+
+	if c.Limits.Duration > 0 {
+		timer := time.AfterFunc(c.Limits.Duration, c.Stop)
+		defer timer.Stop()
+	}
+
+	for {
+		select {
+		case packet, ok := <-c.stream.packets:
+			if !ok {
+				return
+			}
+			c.Device.pushPacket(packet)
+			c.packets.Add(1)
+			c.bytes.Add(uint64(len(packet.Data())))
+			if c.Limits.MaxPackets > 0 && c.packets.Load() >= c.Limits.MaxPackets {
+				c.Stop()
+				c.drain()
+				return
+			}
+		case <-c.stopped:
+			c.drain()
+			return
+		}
+	}
+}
+
+// drain discards what is left in a stopped stream so the goroutines feeding
+// it can exit. Streams without a stop function are abandoned instead.
+func (c *Capture) drain() {
+	if c.stream.stop == nil {
+		return
+	}
+	for range c.stream.packets {
+	}
+}
+
+// Stop asks the capture to end. It returns immediately; use Done to wait
+// for the cleanups to finish.
+func (c *Capture) Stop() {
+	c.stopOnce.Do(func() {
+		close(c.stopped)
+		if c.stream.stop != nil {
+			c.stream.stop()
+		}
+	})
+}
+
+// SetFilter replaces the BPF filter of the running capture without
+// reopening the device. An empty filter captures every packet.
+func (c *Capture) SetFilter(filter string) error {
+	if c.stream.setFilter == nil {
+		return ErrFilterNotSupported
+	}
+	c.filterMu.Lock()
+	defer c.filterMu.Unlock()
+	if err := c.stream.setFilter(filter); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidFilter, err)
+	}
+	c.Device.Options.Filter = filter
+	return nil
+}
+
+func (c *Capture) Filter() string {
+	c.filterMu.Lock()
+	defer c.filterMu.Unlock()
+	return c.Device.Options.Filter
+}
+
+func (c *Capture) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *Capture) Stats() CaptureStats {
+	return CaptureStats{
+		Packets: c.packets.Load(),
+		Bytes:   c.bytes.Load(),
+	}
+}
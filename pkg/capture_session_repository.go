@@ -0,0 +1,86 @@
+package pkg
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	CaptureStatusRunning     = "running"
+	CaptureStatusStopped     = "stopped"
+	CaptureStatusInterrupted = "interrupted"
+)
+
+var ErrCaptureSessionNotFound = errors.New("capture session not found")
+
+type CaptureSession struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	Device      string     `gorm:"not null" json:"device"`
+	Filter      string     `json:"filter"`
+	SnapLen     int        `json:"snaplen"`
+	Promiscuous bool       `json:"promiscuous"`
+	Duration    int64      `json:"duration_seconds"`
+	MaxPackets  uint64     `json:"max_packets"`
+	Status      string     `gorm:"not null;index" json:"status"`
+	Packets     uint64     `json:"packets"`
+	Bytes       uint64     `json:"bytes"`
+	StartedAt   time.Time  `gorm:"not null" json:"started_at"`
+	StoppedAt   *time.Time `json:"stopped_at"`
+}
+
+type CaptureSessionRepository interface {
+	SaveCaptureSession(session *CaptureSession) error
+	UpdateCaptureSession(session *CaptureSession) error
+	GetCaptureSession(id uint) (CaptureSession, error)
+	GetCaptureSessions(limit int) ([]CaptureSession, error)
+	// InterruptRunningCaptureSessions closes the records left running by a
+	// previous process.
+	InterruptRunningCaptureSessions(stoppedAt time.Time) error
+}
+
+type SqlLiteCaptureSessionRepository struct {
+	db *gorm.DB
+}
+
+func (r *SqlLiteCaptureSessionRepository) SaveCaptureSession(session *CaptureSession) error {
+	return r.db.Create(session).Error
+}
+
+func (r *SqlLiteCaptureSessionRepository) UpdateCaptureSession(session *CaptureSession) error {
+	return r.db.Save(session).Error
+}
+
+func (r *SqlLiteCaptureSessionRepository) GetCaptureSession(id uint) (CaptureSession, error) {
+	var session CaptureSession
+	result := r.db.First(&session, id)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return CaptureSession{}, ErrCaptureSessionNotFound
+	}
+	if result.Error != nil {
+		return CaptureSession{}, result.Error
+	}
+	return session, nil
+}
+
+func (r *SqlLiteCaptureSessionRepository) GetCaptureSessions(limit int) ([]CaptureSession, error) {
+	sessions := make([]CaptureSession, 0)
+	result := r.db.Order("started_at desc").Order("id desc").Limit(limit).Find(&sessions)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return sessions, nil
+}
+
+func (r *SqlLiteCaptureSessionRepository) InterruptRunningCaptureSessions(stoppedAt time.Time) error {
+	return r.db.Model(&CaptureSession{}).
+		Where("status = ?", CaptureStatusRunning).
+		Updates(map[string]any{"status": CaptureStatusInterrupted, "stopped_at": stoppedAt}).Error
+}
+
+func NewSqlLiteCaptureSessionRepository(db *gorm.DB) CaptureSessionRepository {
+	db.AutoMigrate(&CaptureSession{})
+
+	return &SqlLiteCaptureSessionRepository{db: db}
+}
@@ -0,0 +1,79 @@
+package pkg
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupCaptureSessionTestDB(t *testing.T) CaptureSessionRepository {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open test database: %v", err)
+	}
+	return NewSqlLiteCaptureSessionRepository(db)
+}
+
+func TestCaptureSessionRepositoryRoundTrip(t *testing.T) {
+	repo := setupCaptureSessionTestDB(t)
+
+	now := time.Now()
+	session := CaptureSession{Device: "eth0", Filter: "tcp", Status: CaptureStatusRunning, StartedAt: now}
+	if err := repo.SaveCaptureSession(&session); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	if session.ID == 0 {
+		t.Fatal("expected session ID to be assigned")
+	}
+
+	stoppedAt := now.Add(time.Minute)
+	session.Status = CaptureStatusStopped
+	session.Packets = 42
+	session.StoppedAt = &stoppedAt
+	if err := repo.UpdateCaptureSession(&session); err != nil {
+		t.Fatalf("failed to update session: %v", err)
+	}
+
+	got, err := repo.GetCaptureSession(session.ID)
+	if err != nil {
+		t.Fatalf("failed to get session: %v", err)
+	}
+	if got.Status != CaptureStatusStopped || got.Packets != 42 || got.StoppedAt == nil {
+		t.Fatalf("unexpected session: %+v", got)
+	}
+
+	if _, err := repo.GetCaptureSession(999); !errors.Is(err, ErrCaptureSessionNotFound) {
+		t.Fatalf("expected ErrCaptureSessionNotFound, got %v", err)
+	}
+}
+
+func TestCaptureSessionRepositoryInterruptsRunningSessions(t *testing.T) {
+	repo := setupCaptureSessionTestDB(t)
+
+	now := time.Now()
+	running := CaptureSession{Device: "eth0", Status: CaptureStatusRunning, StartedAt: now.Add(-time.Hour)}
+	stopped := CaptureSession{Device: "eth1", Status: CaptureStatusStopped, StartedAt: now}
+	repo.SaveCaptureSession(&running)
+	repo.SaveCaptureSession(&stopped)
+
+	if err := repo.InterruptRunningCaptureSessions(now); err != nil {
+		t.Fatalf("failed to interrupt sessions: %v", err)
+	}
+
+	sessions, err := repo.GetCaptureSessions(10)
+	if err != nil {
+		t.Fatalf("failed to list sessions: %v", err)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("expected 2 sessions, got %d", len(sessions))
+	}
+	if sessions[0].Device != "eth1" || sessions[0].Status != CaptureStatusStopped {
+		t.Fatalf("expected most recent session first and untouched, got %+v", sessions[0])
+	}
+	if sessions[1].Status != CaptureStatusInterrupted || sessions[1].StoppedAt == nil {
+		t.Fatalf("expected running session to be interrupted, got %+v", sessions[1])
+	}
+}
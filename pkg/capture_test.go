@@ -0,0 +1,185 @@
+package pkg
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+)
+
+// endlessStream produces packets until its stop function is called.
+func endlessStream(t *testing.T) (packetStream, *bool) {
+	packet := mustBuildPacket(t, "10.1.1.1", "10.1.1.2", 6000, 22)
+	packets := make(chan gopacket.Packet)
+	stop := make(chan struct{})
+	var once sync.Once
+	cleaned := false
+	go func() {
+		defer close(packets)
+		for {
+			select {
+			case packets <- packet:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return packetStream{
+		packets: packets,
+		stop:    func() { once.Do(func() { close(stop) }) },
+		cleanup: func() { cleaned = true },
+	}, &cleaned
+}
+
+func TestCaptureStopsAfterMaxPackets(t *testing.T) {
+	originalQueue := PacketsToCaptureQueue
+	defer func() { PacketsToCaptureQueue = originalQueue }()
+	PacketsToCaptureQueue = PacketQueue{ItemsChan: make(chan AppPacket, 10)}
+
+	stream, cleaned := endlessStream(t)
+	capture, err := openCapture(Device{Name: "eth0"}, CaptureLimits{MaxPackets: 3}, func(*Device) (packetStream, error) {
+		return stream, nil
+	})
+	if err != nil {
+		t.Fatalf("openCapture returned error: %v", err)
+	}
+
+	capture.Run()
+
+	if got := len(PacketsToCaptureQueue.ItemsChan); got != 3 {
+		t.Fatalf("expected 3 packets queued, got %d", got)
+	}
+	stats := capture.Stats()
+	if stats.Packets != 3 || stats.Bytes == 0 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	if !*cleaned {
+		t.Fatal("expected cleanup to run")
+	}
+	if IsCapturing("eth0") {
+		t.Fatal("expected device not to be capturing anymore")
+	}
+}
+
+func TestCaptureStopsAfterDuration(t *testing.T) {
+	originalQueue := PacketsToCaptureQueue
+	defer func() { PacketsToCaptureQueue = originalQueue }()
+	queue := make(chan AppPacket, 1)
+	PacketsToCaptureQueue = PacketQueue{ItemsChan: queue}
+	go func() {
+		for range queue {
+		}
+	}()
+	defer close(queue)
+
+	stream, cleaned := endlessStream(t)
+	capture, err := openCapture(Device{Name: "eth0"}, CaptureLimits{Duration: 20 * time.Millisecond}, func(*Device) (packetStream, error) {
+		return stream, nil
+	})
+	if err != nil {
+		t.Fatalf("openCapture returned error: %v", err)
+	}
+
+	go capture.Run()
+	select {
+	case <-capture.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("capture did not stop after its duration")
+	}
+	if !*cleaned {
+		t.Fatal("expected cleanup to run")
+	}
+}
+
+func TestCaptureStop(t *testing.T) {
+	originalQueue := PacketsToCaptureQueue
+	defer func() { PacketsToCaptureQueue = originalQueue }()
+	queue := make(chan AppPacket)
+	PacketsToCaptureQueue = PacketQueue{ItemsChan: queue}
+
+	stream, cleaned := endlessStream(t)
+	capture, err := openCapture(Device{Name: "eth0"}, CaptureLimits{}, func(*Device) (packetStream, error) {
+		return stream, nil
+	})
+	if err != nil {
+		t.Fatalf("openCapture returned error: %v", err)
+	}
+
+	go capture.Run()
+	<-queue
+	if !IsCapturing("eth0") {
+		t.Fatal("expected device to be capturing")
+	}
+
+	capture.Stop()
+	capture.Stop()
+	go func() {
+		for range queue {
+		}
+	}()
+	select {
+	case <-capture.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("capture did not stop")
+	}
+	close(queue)
+	if !*cleaned {
+		t.Fatal("expected cleanup to run")
+	}
+}
+
+func TestOpenCaptureFactoryError(t *testing.T) {
+	_, err := openCapture(Device{Name: "eth0"}, CaptureLimits{}, func(*Device) (packetStream, error) {
+		return packetStream{}, errors.New("no such device")
+	})
+	if err == nil {
+		t.Fatal("expected error from factory")
+	}
+}
+
+func TestCaptureSetFilter(t *testing.T) {
+	var applied []string
+	stream := packetStream{
+		packets: make(chan gopacket.Packet),
+		setFilter: func(filter string) error {
+			if filter == "bogus" {
+				return errors.New("syntax error")
+			}
+			applied = append(applied, filter)
+			return nil
+		},
+	}
+	capture, err := openCapture(Device{Name: "eth0", Options: CaptureOptions{Filter: "tcp"}}, CaptureLimits{}, func(*Device) (packetStream, error) {
+		return stream, nil
+	})
+	if err != nil {
+		t.Fatalf("openCapture returned error: %v", err)
+	}
+
+	if err := capture.SetFilter("udp"); err != nil {
+		t.Fatalf("SetFilter returned error: %v", err)
+	}
+	if err := capture.SetFilter("bogus"); !errors.Is(err, ErrInvalidFilter) {
+		t.Fatalf("expected ErrInvalidFilter, got %v", err)
+	}
+	if len(applied) != 1 || applied[0] != "udp" {
+		t.Fatalf("unexpected applied filters: %v", applied)
+	}
+	if capture.Filter() != "udp" {
+		t.Fatalf("expected filter udp to be kept, got %s", capture.Filter())
+	}
+}
+
+func TestCaptureSetFilterNotSupported(t *testing.T) {
+	capture, err := openCapture(Device{Name: "trace.pcap"}, CaptureLimits{}, func(*Device) (packetStream, error) {
+		return packetStream{packets: make(chan gopacket.Packet)}, nil
+	})
+	if err != nil {
+		t.Fatalf("openCapture returned error: %v", err)
+	}
+	if err := capture.SetFilter("udp"); !errors.Is(err, ErrFilterNotSupported) {
+		t.Fatalf("expected ErrFilterNotSupported, got %v", err)
+	}
+}
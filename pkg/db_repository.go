@@ -1,11 +1,15 @@
 package pkg
 
 import (
+	"errors"
+	"fmt"
 	"log"
-	"strconv"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
 	"github.com/impact-dryer/gotattletale/internal/config"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
@@ -17,31 +21,143 @@ type AppPacket struct {
 	CreatedAt time.Time
 	UpdatedAt time.Time
 	DeviceID  string
+	LinkType  layers.LinkType
+	// Direction is DirectionIn or DirectionOut when the capture can tell,
+	// empty otherwise.
+	Direction string
 }
 
+// SavedPacket is the stored view of a packet. Fields of layers the packet
+// does not have are left empty, ports are nil when there is no transport
+// layer.
 type SavedPacket struct {
-	ID              uint      `gorm:"primaryKey"`
-	SourceIP        string    `gorm:"not null"`
-	DestinationIP   string    `gorm:"not null"`
-	SourcePort      int       `gorm:"not null"`
-	DestinationPort int       `gorm:"not null"`
-	Protocol        string    `gorm:"not null"`
+	ID              uint   `gorm:"primaryKey"`
+	SourceIP        string `gorm:"not null"`
+	DestinationIP   string `gorm:"not null"`
+	SourcePort      *int
+	DestinationPort *int
+	Protocol        string `gorm:"not null;index"`
+	DecodeError     *string
 	CreatedAt       time.Time `gorm:"not null"`
 	UpdatedAt       time.Time `gorm:"not null"`
 	DeviceID        string    `gorm:"not null"`
+	Direction       string    `gorm:"index"`
+
+	// SourceIPKey and DestinationIPKey are the addresses in a sortable form
+	// used to match networks, see ipKey.
+	SourceIPKey      string `gorm:"index" json:"-"`
+	DestinationIPKey string `gorm:"index" json:"-"`
+
+	// Link layer. EtherType is the type of the payload, after the VLAN tags.
+	SourceMAC      string `gorm:"index"`
+	DestinationMAC string `gorm:"index"`
+	EtherType      *int
+	VLANID         *int `gorm:"index"`
+	InnerVLANID    *int
+
+	// Outermost IP header. TTL holds the hop limit for IPv6, IPID and the
+	// fragment fields come from the fragment header when there is one.
+	IPVersion      *int
+	TTL            *int
+	DSCP           *int
+	ECN            *int
+	IPID           *int
+	DontFragment   *bool
+	MoreFragments  *bool
+	FragmentOffset *int
+	TotalLength    *int
+
+	CapturedLength int `gorm:"not null;default:0"`
+	FrameLength    int `gorm:"not null;default:0"`
+
+	// TCP header, nil for other transports. The options are only set when
+	// the segment carries them.
+	TCPFlagSYN       *bool
+	TCPFlagACK       *bool
+	TCPFlagFIN       *bool
+	TCPFlagRST       *bool `gorm:"index"`
+	TCPFlagPSH       *bool
+	TCPFlagURG       *bool
+	TCPFlagECE       *bool
+	TCPFlagCWR       *bool
+	TCPSeq           *int64
+	TCPAck           *int64
+	TCPWindow        *int `gorm:"index"`
+	TCPMSS           *int `gorm:"column:tcp_mss"`
+	TCPWindowScale   *int
+	TCPSACKPermitted *bool `gorm:"column:tcp_sack_permitted"`
+	TCPTimestamp     *int64
+	TCPTimestampEcho *int64
+
+	// ICMP and ICMPv6 header. The echo fields are set on echo requests and
+	// replies. The original fields are read from the header an error such
+	// as an unreachable or a time exceeded quotes, the packet it rejects.
+	ICMPType                    *int   `gorm:"column:icmp_type;index"`
+	ICMPCode                    *int   `gorm:"column:icmp_code"`
+	ICMPEchoID                  *int   `gorm:"column:icmp_echo_id"`
+	ICMPEchoSeq                 *int   `gorm:"column:icmp_echo_seq"`
+	ICMPOriginalProtocol        string `gorm:"column:icmp_original_protocol"`
+	ICMPOriginalSourceIP        string `gorm:"column:icmp_original_source_ip"`
+	ICMPOriginalSourceIPKey     string `gorm:"column:icmp_original_source_ip_key;index" json:"-"`
+	ICMPOriginalDestinationIP   string `gorm:"column:icmp_original_destination_ip"`
+	ICMPOriginalSourcePort      *int   `gorm:"column:icmp_original_source_port"`
+	ICMPOriginalDestinationPort *int   `gorm:"column:icmp_original_destination_port"`
+
+	// Analyst annotations, set through UpdatePacket.
+	Tags  []string `gorm:"serializer:json"`
+	Notes string
+
+	// Names of the endpoints from the passive DNS map, filled in when
+	// listing packets.
+	SourceHostname      string `gorm:"-"`
+	DestinationHostname string `gorm:"-"`
+
+	// Hosts of the inventory owning the MACs of the packet, filled in when
+	// listing packets.
+	SourceHostID      *uint `gorm:"-"`
+	DestinationHostID *uint `gorm:"-"`
+}
+
+// PacketUpdate is a partial update of the annotations of a packet. Nil
+// fields are left unchanged, Tags replaces the whole list.
+type PacketUpdate struct {
+	Tags  *[]string
+	Notes *string
+}
+
+// PacketError is a packet of a batch that could not be stored.
+type PacketError struct {
+	Index int
+	Err   error
+}
+
+// PacketErrors is returned by SavePackets when some packets of the batch
+// were skipped. The other packets are stored.
+type PacketErrors struct {
+	Total  int
+	Failed []PacketError
+}
+
+func (e *PacketErrors) Error() string {
+	return fmt.Sprintf("%d of %d packets not stored, first error: %v", len(e.Failed), e.Total, e.Failed[0].Err)
 }
 
 type PacketRepository interface {
 	SavePacket(packet AppPacket) error
 	SavePackets(packets []AppPacket) error
-	GetPackets(limit int, sort string) ([]SavedPacket, error)
-	GetPacket(packetID string) (SavedPacket, error)
-	DeletePacket(packetID string) error
-	UpdatePacket(packet AppPacket) error
+	GetPackets(filter PacketFilter) ([]SavedPacket, error)
+	CountPackets(filter PacketFilter) (PacketCount, error)
+	GetPacket(packetID uint) (SavedPacket, error)
+	DeletePacket(packetID uint) error
+	UpdatePacket(packetID uint, update PacketUpdate) (SavedPacket, error)
+	GetPacketData(packetID uint) (PacketData, error)
 }
 
 type SqlLitePacketRepository struct {
 	db *gorm.DB
+	// rawMaxBytes caps the frame bytes stored per packet, 0 disables
+	// raw packet storage.
+	rawMaxBytes int
 }
 
 func (r *SqlLitePacketRepository) SavePacket(packet AppPacket) error {
@@ -49,82 +165,232 @@ func (r *SqlLitePacketRepository) SavePacket(packet AppPacket) error {
 	if err != nil {
 		return err
 	}
-	r.db.Create(savedPacket)
-	return nil
+	return r.create([]*SavedPacket{savedPacket}, []AppPacket{packet})
 }
 
 func mapPacketToSavedPacket(packet AppPacket) (*SavedPacket, error) {
-	sourceIP := packet.Data.NetworkLayer().NetworkFlow().Src().String()
-	destinationIP := packet.Data.NetworkLayer().NetworkFlow().Dst().String()
-	sourcePort := packet.Data.TransportLayer().TransportFlow().Src().String()
-	destinationPort := packet.Data.TransportLayer().TransportFlow().Dst().String()
-	protocol := packet.Data.TransportLayer().LayerType().String()
-	sourcePortInt, err := strconv.Atoi(sourcePort)
-	if err != nil {
-		return nil, err
+	savedPacket := &SavedPacket{
+		CreatedAt: packet.CreatedAt,
+		UpdatedAt: packet.UpdatedAt,
+		DeviceID:  packet.DeviceID,
+		Direction: packet.Direction,
 	}
-	destinationPortInt, err := strconv.Atoi(destinationPort)
-	if err != nil {
+	if err := decodePacket(packet.Data, savedPacket); err != nil {
 		return nil, err
 	}
-	savedPacket := &SavedPacket{
-		SourceIP:        sourceIP,
-		DestinationIP:   destinationIP,
-		SourcePort:      sourcePortInt,
-		DestinationPort: destinationPortInt,
-		Protocol:        protocol,
-		CreatedAt:       packet.CreatedAt,
-		UpdatedAt:       packet.UpdatedAt,
-		DeviceID:        packet.DeviceID,
-	}
+	savedPacket.SourceIPKey = ipKey(savedPacket.SourceIP)
+	savedPacket.DestinationIPKey = ipKey(savedPacket.DestinationIP)
+	savedPacket.ICMPOriginalSourceIPKey = ipKey(savedPacket.ICMPOriginalSourceIP)
 	return savedPacket, nil
 }
 
+// SavePackets stores every packet of the batch it can map. Packets that
+// cannot be mapped are reported in a *PacketErrors without failing the rest.
 func (r *SqlLitePacketRepository) SavePackets(packets []AppPacket) error {
-	mapedPackets := make([]*SavedPacket, len(packets))
+	mapedPackets := make([]*SavedPacket, 0, len(packets))
+	mapedFrom := make([]AppPacket, 0, len(packets))
+	var packetErrors *PacketErrors
 	for i, packet := range packets {
-		packet, err := mapPacketToSavedPacket(packet)
+		mapped, err := mapPacketToSavedPacket(packet)
 		if err != nil {
+			if packetErrors == nil {
+				packetErrors = &PacketErrors{Total: len(packets)}
+			}
+			packetErrors.Failed = append(packetErrors.Failed, PacketError{Index: i, Err: err})
+			continue
+		}
+		mapedPackets = append(mapedPackets, mapped)
+		mapedFrom = append(mapedFrom, packet)
+	}
+	if len(mapedPackets) > 0 {
+		if err := r.create(mapedPackets, mapedFrom); err != nil {
 			return err
 		}
-		mapedPackets[i] = packet
 	}
-	r.db.Create(mapedPackets)
+	if packetErrors != nil {
+		return packetErrors
+	}
 	return nil
 }
 
-func (r *SqlLitePacketRepository) GetPackets(limit int, sort string) ([]SavedPacket, error) {
-	packets := make([]SavedPacket, 100)
-	if sort == "" {
-		sort = "created_at"
+// create stores the mapped packets and, when enabled, the raw bytes of the
+// packets they were mapped from.
+func (r *SqlLitePacketRepository) create(mapped []*SavedPacket, from []AppPacket) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(mapped).Error; err != nil {
+			return err
+		}
+		if r.rawMaxBytes <= 0 {
+			return nil
+		}
+		raw := make([]*PacketData, len(mapped))
+		for i, saved := range mapped {
+			raw[i] = newPacketData(from[i], r.rawMaxBytes)
+			raw[i].PacketID = saved.ID
+		}
+		return tx.Create(raw).Error
+	})
+}
+
+func (r *SqlLitePacketRepository) GetPackets(filter PacketFilter) ([]SavedPacket, error) {
+	if err := filter.Validate(); err != nil {
+		return nil, err
+	}
+	packets := make([]SavedPacket, 0)
+	query := filter.page(filter.apply(r.db.Model(&SavedPacket{})))
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
 	}
-	result := r.db.Order(sort + " desc").Limit(limit).Find(&packets)
+	result := query.Find(&packets)
 	if result.Error != nil {
 		return nil, result.Error
 	}
+	if filter.Cursor != nil && filter.Cursor.Backward {
+		slices.Reverse(packets)
+	}
 	return packets, nil
 }
 
-func (r *SqlLitePacketRepository) GetPacket(packetID string) (SavedPacket, error) {
-	return SavedPacket{}, nil
+// CountPackets counts the packets matching filter, ignoring its cursor and
+// limit. Past packetCountLimit the count is estimated from the ID range for
+// the whole table and is the limit itself otherwise.
+func (r *SqlLitePacketRepository) CountPackets(filter PacketFilter) (PacketCount, error) {
+	if err := filter.Validate(); err != nil {
+		return PacketCount{}, err
+	}
+	var count int64
+	matched := filter.apply(r.db.Model(&SavedPacket{})).Select("id").Limit(packetCountLimit + 1)
+	if err := r.db.Table("(?) AS matched", matched).Count(&count).Error; err != nil {
+		return PacketCount{}, err
+	}
+	if count <= packetCountLimit {
+		return PacketCount{Count: count, Exact: true}, nil
+	}
+	if filter.filtered() {
+		return PacketCount{Count: packetCountLimit}, nil
+	}
+	var estimate int64
+	err := r.db.Model(&SavedPacket{}).Select("COALESCE(MAX(id) - MIN(id) + 1, 0)").Scan(&estimate).Error
+	if err != nil {
+		return PacketCount{}, err
+	}
+	return PacketCount{Count: max(estimate, count)}, nil
 }
 
-func (r *SqlLitePacketRepository) DeletePacket(packetID string) error {
-	return nil
+func (r *SqlLitePacketRepository) GetPacket(packetID uint) (SavedPacket, error) {
+	var packet SavedPacket
+	result := r.db.First(&packet, packetID)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return SavedPacket{}, ErrPacketNotFound
+	}
+	if result.Error != nil {
+		return SavedPacket{}, result.Error
+	}
+	return packet, nil
 }
 
-func (r *SqlLitePacketRepository) UpdatePacket(packet AppPacket) error {
-	return nil
+// DeletePacket removes the packet and its raw bytes.
+func (r *SqlLitePacketRepository) DeletePacket(packetID uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Delete(&SavedPacket{}, packetID)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrPacketNotFound
+		}
+		return tx.Delete(&PacketData{}, packetID).Error
+	})
+}
+
+func (r *SqlLitePacketRepository) UpdatePacket(packetID uint, update PacketUpdate) (SavedPacket, error) {
+	packet, err := r.GetPacket(packetID)
+	if err != nil {
+		return SavedPacket{}, err
+	}
+	if update.Tags != nil {
+		packet.Tags = normalizeTags(*update.Tags)
+	}
+	if update.Notes != nil {
+		packet.Notes = *update.Notes
+	}
+	result := r.db.Model(&packet).Select("Tags", "Notes").Updates(&packet)
+	if result.Error != nil {
+		return SavedPacket{}, result.Error
+	}
+	return packet, nil
+}
+
+// normalizeTags trims the tags and drops empty and repeated ones, keeping
+// the order they were given in.
+func normalizeTags(tags []string) []string {
+	normalized := make([]string, 0, len(tags))
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	return normalized
+}
+
+func (r *SqlLitePacketRepository) GetPacketData(packetID uint) (PacketData, error) {
+	var data PacketData
+	result := r.db.First(&data, packetID)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		var count int64
+		if err := r.db.Model(&SavedPacket{}).Where("id = ?", packetID).Count(&count).Error; err != nil {
+			return PacketData{}, err
+		}
+		if count == 0 {
+			return PacketData{}, ErrPacketNotFound
+		}
+		return PacketData{}, ErrPacketDataNotFound
+	}
+	if result.Error != nil {
+		return PacketData{}, result.Error
+	}
+	return data, nil
 }
 
-func NewSqlLitePacketRepository(appconfig *config.AppConfig) PacketRepository {
+// backfillIPKeys sets the address keys of packets stored before they
+// existed.
+func backfillIPKeys(db *gorm.DB) error {
+	var packets []SavedPacket
+	return db.Select("id", "source_ip", "destination_ip").
+		Where("source_ip <> '' AND (source_ip_key IS NULL OR source_ip_key = '')").
+		FindInBatches(&packets, 1000, func(tx *gorm.DB, batch int) error {
+			for _, packet := range packets {
+				err := db.Model(&SavedPacket{ID: packet.ID}).UpdateColumns(map[string]any{
+					"source_ip_key":      ipKey(packet.SourceIP),
+					"destination_ip_key": ipKey(packet.DestinationIP),
+				}).Error
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}).Error
+}
+
+func NewSqlLiteDB(appconfig *config.AppConfig) *gorm.DB {
 	db, err := gorm.Open(sqlite.Open(appconfig.DBName), &gorm.Config{})
 	if err != nil {
 		log.Fatal(err)
 		return nil
 	}
+	return db
+}
+
+func NewSqlLitePacketRepository(db *gorm.DB, appconfig *config.AppConfig) PacketRepository {
 	// Migrate the schema
-	db.AutoMigrate(&SavedPacket{})
+	db.AutoMigrate(&SavedPacket{}, &PacketData{})
+	if err := backfillIPKeys(db); err != nil {
+		log.Println("Failed to index packet addresses:", err)
+	}
 
-	return &SqlLitePacketRepository{db: db}
+	return &SqlLitePacketRepository{db: db, rawMaxBytes: appconfig.RawPacketMaxBytes}
 }
@@ -1,6 +1,7 @@
 package pkg
 
 import (
+	"errors"
 	"testing"
 	"time"
 
@@ -15,10 +16,14 @@ func setupTestDB(t *testing.T) *SqlLitePacketRepository {
 	if err != nil {
 		t.Fatalf("failed to open test database: %v", err)
 	}
-	db.AutoMigrate(&SavedPacket{})
+	db.AutoMigrate(&SavedPacket{}, &PacketData{})
 	return &SqlLitePacketRepository{db: db}
 }
 
+func intPtr(v int) *int {
+	return &v
+}
+
 func createTestPacket(srcIP, dstIP string, srcPort, dstPort int) gopacket.Packet {
 	// Create a mock TCP/IP packet
 	ipLayer := &layers.IPv4{
@@ -72,11 +77,11 @@ func TestSavePacket(t *testing.T) {
 	if savedPacket.DeviceID != "eth0" {
 		t.Errorf("expected DeviceID 'eth0', got '%s'", savedPacket.DeviceID)
 	}
-	if savedPacket.SourcePort != 8080 {
-		t.Errorf("expected SourcePort 8080, got %d", savedPacket.SourcePort)
+	if savedPacket.SourcePort == nil || *savedPacket.SourcePort != 8080 {
+		t.Errorf("expected SourcePort 8080, got %v", savedPacket.SourcePort)
 	}
-	if savedPacket.DestinationPort != 443 {
-		t.Errorf("expected DestinationPort 443, got %d", savedPacket.DestinationPort)
+	if savedPacket.DestinationPort == nil || *savedPacket.DestinationPort != 443 {
+		t.Errorf("expected DestinationPort 443, got %v", savedPacket.DestinationPort)
 	}
 	if savedPacket.Protocol != "TCP" {
 		t.Errorf("expected Protocol 'TCP', got '%s'", savedPacket.Protocol)
@@ -116,6 +121,31 @@ func TestSavePackets(t *testing.T) {
 	}
 }
 
+func TestSavePacketsSkipsPacketsThatCannotBeMapped(t *testing.T) {
+	repo := setupTestDB(t)
+
+	packets := []AppPacket{
+		{Data: createTestPacket("192.168.1.1", "192.168.1.2", 8080, 443), DeviceID: "eth0"},
+		{DeviceID: "eth0"},
+		{Data: createTestPacket("10.0.0.1", "10.0.0.2", 9000, 80), DeviceID: "eth0"},
+	}
+
+	err := repo.SavePackets(packets)
+	var packetErrors *PacketErrors
+	if !errors.As(err, &packetErrors) {
+		t.Fatalf("expected PacketErrors, got %v", err)
+	}
+	if packetErrors.Total != 3 || len(packetErrors.Failed) != 1 || packetErrors.Failed[0].Index != 1 {
+		t.Fatalf("unexpected packet errors: %+v", packetErrors)
+	}
+
+	var count int64
+	repo.db.Model(&SavedPacket{}).Count(&count)
+	if count != 2 {
+		t.Errorf("expected 2 packets saved, got %d", count)
+	}
+}
+
 func TestGetPackets(t *testing.T) {
 	repo := setupTestDB(t)
 
@@ -125,8 +155,8 @@ func TestGetPackets(t *testing.T) {
 		{
 			SourceIP:        "192.168.1.1",
 			DestinationIP:   "192.168.1.2",
-			SourcePort:      8080,
-			DestinationPort: 443,
+			SourcePort:      intPtr(8080),
+			DestinationPort: intPtr(443),
 			Protocol:        "TCP",
 			CreatedAt:       now.Add(-time.Hour),
 			UpdatedAt:       now,
@@ -135,8 +165,8 @@ func TestGetPackets(t *testing.T) {
 		{
 			SourceIP:        "10.0.0.1",
 			DestinationIP:   "10.0.0.2",
-			SourcePort:      9000,
-			DestinationPort: 80,
+			SourcePort:      intPtr(9000),
+			DestinationPort: intPtr(80),
 			Protocol:        "TCP",
 			CreatedAt:       now,
 			UpdatedAt:       now,
@@ -146,7 +176,7 @@ func TestGetPackets(t *testing.T) {
 	repo.db.Create(&testPackets)
 
 	// Test GetPackets with default sort
-	packets, err := repo.GetPackets(10, "")
+	packets, err := repo.GetPackets(PacketFilter{Limit: 10})
 	if err != nil {
 		t.Fatalf("failed to get packets: %v", err)
 	}
@@ -170,8 +200,8 @@ func TestGetPacketsWithLimit(t *testing.T) {
 		packet := SavedPacket{
 			SourceIP:        "192.168.1.1",
 			DestinationIP:   "192.168.1.2",
-			SourcePort:      8080 + i,
-			DestinationPort: 443,
+			SourcePort:      intPtr(8080 + i),
+			DestinationPort: intPtr(443),
 			Protocol:        "TCP",
 			CreatedAt:       now.Add(time.Duration(i) * time.Minute),
 			UpdatedAt:       now,
@@ -181,7 +211,7 @@ func TestGetPacketsWithLimit(t *testing.T) {
 	}
 
 	// Test with limit of 3
-	packets, err := repo.GetPackets(3, "")
+	packets, err := repo.GetPackets(PacketFilter{Limit: 3})
 	if err != nil {
 		t.Fatalf("failed to get packets: %v", err)
 	}
@@ -200,8 +230,8 @@ func TestGetPacketsWithCustomSort(t *testing.T) {
 		{
 			SourceIP:        "192.168.1.1",
 			DestinationIP:   "192.168.1.2",
-			SourcePort:      1000,
-			DestinationPort: 443,
+			SourcePort:      intPtr(1000),
+			DestinationPort: intPtr(443),
 			Protocol:        "TCP",
 			CreatedAt:       now,
 			UpdatedAt:       now,
@@ -210,8 +240,8 @@ func TestGetPacketsWithCustomSort(t *testing.T) {
 		{
 			SourceIP:        "10.0.0.1",
 			DestinationIP:   "10.0.0.2",
-			SourcePort:      9000,
-			DestinationPort: 80,
+			SourcePort:      intPtr(9000),
+			DestinationPort: intPtr(80),
 			Protocol:        "TCP",
 			CreatedAt:       now,
 			UpdatedAt:       now,
@@ -221,50 +251,97 @@ func TestGetPacketsWithCustomSort(t *testing.T) {
 	repo.db.Create(&testPackets)
 
 	// Test GetPackets with source_port sort
-	packets, err := repo.GetPackets(10, "source_port")
+	packets, err := repo.GetPackets(PacketFilter{Limit: 10, Sort: "source_port"})
 	if err != nil {
 		t.Fatalf("failed to get packets: %v", err)
 	}
 
 	// Should be sorted desc by source_port
-	if packets[0].SourcePort != 9000 {
-		t.Errorf("expected first packet SourcePort 9000, got %d", packets[0].SourcePort)
+	if *packets[0].SourcePort != 9000 {
+		t.Errorf("expected first packet SourcePort 9000, got %d", *packets[0].SourcePort)
 	}
 }
 
 func TestGetPacket(t *testing.T) {
 	repo := setupTestDB(t)
 
-	// GetPacket is not implemented, just verify it returns empty
-	packet, err := repo.GetPacket("any-id")
+	if err := repo.SavePacket(AppPacket{Data: createTestPacket("192.168.1.1", "192.168.1.2", 8080, 443), DeviceID: "eth0"}); err != nil {
+		t.Fatalf("failed to save packet: %v", err)
+	}
+	var saved SavedPacket
+	repo.db.First(&saved)
+
+	packet, err := repo.GetPacket(saved.ID)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
+	if packet.ID != saved.ID || packet.DeviceID != "eth0" {
+		t.Errorf("unexpected packet: %+v", packet)
+	}
 
-	// Should return empty SavedPacket (not implemented)
-	if packet.ID != 0 {
-		t.Errorf("expected empty packet, got ID %d", packet.ID)
+	if _, err := repo.GetPacket(saved.ID + 1); !errors.Is(err, ErrPacketNotFound) {
+		t.Errorf("expected ErrPacketNotFound, got %v", err)
 	}
 }
 
 func TestDeletePacket(t *testing.T) {
 	repo := setupTestDB(t)
+	repo.rawMaxBytes = 1500
 
-	// DeletePacket is not implemented, just verify no error
-	err := repo.DeletePacket("any-id")
-	if err != nil {
+	if err := repo.SavePacket(AppPacket{Data: createTestPacket("192.168.1.1", "192.168.1.2", 8080, 443), DeviceID: "eth0"}); err != nil {
+		t.Fatalf("failed to save packet: %v", err)
+	}
+	var saved SavedPacket
+	repo.db.First(&saved)
+
+	if err := repo.DeletePacket(saved.ID); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
+	var count int64
+	repo.db.Model(&PacketData{}).Count(&count)
+	if count != 0 {
+		t.Errorf("expected raw data to be deleted with the packet, %d left", count)
+	}
+	if err := repo.DeletePacket(saved.ID); !errors.Is(err, ErrPacketNotFound) {
+		t.Errorf("expected ErrPacketNotFound, got %v", err)
+	}
 }
 
 func TestUpdatePacket(t *testing.T) {
 	repo := setupTestDB(t)
 
-	// UpdatePacket is not implemented, just verify no error
-	err := repo.UpdatePacket(AppPacket{})
+	if err := repo.SavePacket(AppPacket{Data: createTestPacket("192.168.1.1", "192.168.1.2", 8080, 443), DeviceID: "eth0"}); err != nil {
+		t.Fatalf("failed to save packet: %v", err)
+	}
+	var saved SavedPacket
+	repo.db.First(&saved)
+
+	tags := []string{" c2 ", "beacon", "c2", ""}
+	notes := "periodic callback"
+	updated, err := repo.UpdatePacket(saved.ID, PacketUpdate{Tags: &tags, Notes: &notes})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
+	if len(updated.Tags) != 2 || updated.Tags[0] != "c2" || updated.Tags[1] != "beacon" {
+		t.Errorf("expected normalized tags [c2 beacon], got %v", updated.Tags)
+	}
+
+	// Only the notes change, the tags are kept.
+	notes = "false positive"
+	if _, err := repo.UpdatePacket(saved.ID, PacketUpdate{Notes: &notes}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	stored, err := repo.GetPacket(saved.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stored.Notes != "false positive" || len(stored.Tags) != 2 || stored.SourceIP != "192.168.1.1" {
+		t.Errorf("unexpected stored packet: %+v", stored)
+	}
+
+	if _, err := repo.UpdatePacket(saved.ID+1, PacketUpdate{Notes: &notes}); !errors.Is(err, ErrPacketNotFound) {
+		t.Errorf("expected ErrPacketNotFound, got %v", err)
+	}
 }
 
 func TestMapPacketToSavedPacket(t *testing.T) {
@@ -282,11 +359,11 @@ func TestMapPacketToSavedPacket(t *testing.T) {
 		t.Fatalf("failed to map packet: %v", err)
 	}
 
-	if savedPacket.SourcePort != 8080 {
-		t.Errorf("expected SourcePort 8080, got %d", savedPacket.SourcePort)
+	if savedPacket.SourcePort == nil || *savedPacket.SourcePort != 8080 {
+		t.Errorf("expected SourcePort 8080, got %v", savedPacket.SourcePort)
 	}
-	if savedPacket.DestinationPort != 443 {
-		t.Errorf("expected DestinationPort 443, got %d", savedPacket.DestinationPort)
+	if savedPacket.DestinationPort == nil || *savedPacket.DestinationPort != 443 {
+		t.Errorf("expected DestinationPort 443, got %v", savedPacket.DestinationPort)
 	}
 	if savedPacket.Protocol != "TCP" {
 		t.Errorf("expected Protocol 'TCP', got '%s'", savedPacket.Protocol)
@@ -298,3 +375,93 @@ func TestMapPacketToSavedPacket(t *testing.T) {
 		t.Errorf("expected CreatedAt %v, got %v", now, savedPacket.CreatedAt)
 	}
 }
+
+func TestGetPacketsSortedByHeaderField(t *testing.T) {
+	repo := setupTestDB(t)
+
+	now := time.Now()
+	ttl := 64
+	for _, packet := range []SavedPacket{
+		{SourceIP: "10.0.0.1", DestinationIP: "10.0.0.2", Protocol: "TCP", TTL: &ttl, VLANID: intPtr(10), SourceMAC: "00:11:22:33:44:55", CreatedAt: now, UpdatedAt: now, DeviceID: "eth0"},
+		{SourceIP: "10.0.0.3", DestinationIP: "10.0.0.4", Protocol: "TCP", TTL: intPtr(128), CreatedAt: now, UpdatedAt: now, DeviceID: "eth0"},
+		{Protocol: "ARP", CreatedAt: now, UpdatedAt: now, DeviceID: "eth0"},
+	} {
+		repo.db.Create(&packet)
+	}
+
+	packets, err := repo.GetPackets(PacketFilter{Limit: 10, Sort: "ttl"})
+	if err != nil {
+		t.Fatalf("failed to get packets: %v", err)
+	}
+	if len(packets) != 3 || *packets[0].TTL != 128 || *packets[1].TTL != 64 || packets[2].TTL != nil {
+		t.Fatalf("expected packets sorted by ttl desc with missing values last, got %+v", packets)
+	}
+
+	var tagged []SavedPacket
+	repo.db.Where("vlan_id = ? AND source_mac = ?", 10, "00:11:22:33:44:55").Find(&tagged)
+	if len(tagged) != 1 {
+		t.Errorf("expected 1 packet on VLAN 10, got %d", len(tagged))
+	}
+}
+
+func TestSavePacketsStoresRawData(t *testing.T) {
+	repo := setupTestDB(t)
+	repo.rawMaxBytes = 30
+
+	packet := createTestPacket("192.168.1.1", "192.168.1.2", 8080, 443)
+	now := time.Now()
+	err := repo.SavePackets([]AppPacket{
+		{Data: packet, CreatedAt: now, UpdatedAt: now, DeviceID: "eth0", LinkType: layers.LinkTypeRaw},
+	})
+	if err != nil {
+		t.Fatalf("failed to save packets: %v", err)
+	}
+
+	var saved SavedPacket
+	repo.db.First(&saved)
+	data, err := repo.GetPacketData(saved.ID)
+	if err != nil {
+		t.Fatalf("failed to get packet data: %v", err)
+	}
+	if len(data.Data) != 30 || !data.Truncated || data.CaptureLength != len(packet.Data()) {
+		t.Errorf("expected data cut to 30 of %d bytes, got %d (truncated %v)", len(packet.Data()), len(data.Data), data.Truncated)
+	}
+	if data.LinkType != int(layers.LinkTypeRaw) || !data.Timestamp.Equal(now) {
+		t.Errorf("unexpected capture info: %+v", data)
+	}
+}
+
+func TestGetPacketDataNotFound(t *testing.T) {
+	repo := setupTestDB(t)
+
+	if _, err := repo.GetPacketData(42); !errors.Is(err, ErrPacketNotFound) {
+		t.Errorf("expected ErrPacketNotFound, got %v", err)
+	}
+
+	// Raw storage is disabled, the packet exists without its bytes.
+	if err := repo.SavePacket(AppPacket{Data: createTestPacket("192.168.1.1", "192.168.1.2", 1, 2), DeviceID: "eth0"}); err != nil {
+		t.Fatalf("failed to save packet: %v", err)
+	}
+	var saved SavedPacket
+	repo.db.First(&saved)
+	if _, err := repo.GetPacketData(saved.ID); !errors.Is(err, ErrPacketDataNotFound) {
+		t.Errorf("expected ErrPacketDataNotFound, got %v", err)
+	}
+}
+
+func TestBackfillIPKeys(t *testing.T) {
+	repo := setupTestDB(t)
+	now := time.Now()
+	repo.db.Create(&SavedPacket{SourceIP: "10.0.0.1", DestinationIP: "10.0.0.2", Protocol: "TCP", CreatedAt: now, UpdatedAt: now, DeviceID: "eth0"})
+	repo.db.Create(&SavedPacket{Protocol: "Ethernet", CreatedAt: now, UpdatedAt: now, DeviceID: "eth0"})
+
+	if err := backfillIPKeys(repo.db); err != nil {
+		t.Fatalf("backfillIPKeys returned error: %v", err)
+	}
+
+	var saved SavedPacket
+	repo.db.First(&saved, 1)
+	if saved.SourceIPKey != ipKey("10.0.0.1") || saved.DestinationIPKey != ipKey("10.0.0.2") {
+		t.Errorf("expected keys to be set, got %q %q", saved.SourceIPKey, saved.DestinationIPKey)
+	}
+}
@@ -0,0 +1,479 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/ip4defrag"
+	"github.com/google/gopacket/layers"
+)
+
+var (
+	errFragmentPastEnd = errors.New("fragment past the end of the datagram")
+	errDatagramTooLong = errors.New("datagram longer than its length field allows")
+)
+
+const (
+	// defragExpireInterval is how often, in packet time, the datagrams
+	// are checked for the reassembly timeout.
+	defragExpireInterval = time.Second
+	// fragmentAlertInterval is how long the same fragment alert is not
+	// raised again.
+	fragmentAlertInterval = time.Minute
+	// fragmentMaxEvidence caps the fragments kept as evidence of an alert.
+	fragmentMaxEvidence = 5
+)
+
+// DefragStats counts the IP fragments seen by a Defragmenter. Pending and
+// PendingBytes are the datagrams waiting for fragments and the size of
+// the frames held for them.
+type DefragStats struct {
+	Fragments    uint64 `json:"fragments"`
+	Reassembled  uint64 `json:"reassembled"`
+	TimedOut     uint64 `json:"timed_out"`
+	Evicted      uint64 `json:"evicted"`
+	Overlapping  uint64 `json:"overlapping"`
+	Tiny         uint64 `json:"tiny"`
+	Invalid      uint64 `json:"invalid"`
+	Pending      int    `json:"pending"`
+	PendingBytes int    `json:"pending_bytes"`
+}
+
+// Defragmenter reassembles the fragmented IPv4 and IPv6 datagrams of each
+// device so that the rest of the pipeline sees their transport layer. The
+// fragments are held until the datagram is complete, then replaced by one
+// packet with the link header of the last fragment and the whole
+// datagram. Datagrams that do not complete within the timeout, or that
+// must make room under the memory limit, release their fragments as they
+// were captured. Overlapping fragments abandon the datagram and, like the
+// tiny fragments that split the transport header, raise an alert.
+type Defragmenter struct {
+	mu         sync.Mutex
+	maxBytes   int
+	timeout    time.Duration
+	datagrams  map[fragmentKey]*fragmentedDatagram
+	nextExpire time.Time
+	stats      DefragStats
+	alerts     raisedAlerts
+}
+
+type fragmentKey struct {
+	device   string
+	src      string
+	dst      string
+	protocol layers.IPProtocol
+	id       uint32
+}
+
+// fragmentedDatagram is a datagram waiting for its fragments. It is
+// reassembled by hand rather than by ip4defrag, which keeps its fragments
+// per address pair without a way to drop one datagram and rejects the
+// final fragments shorter than 8 bytes.
+type fragmentedDatagram struct {
+	start     time.Time
+	packets   []AppPacket
+	bytes     int
+	fragments []fragmentData
+	// header is the IP header of the first fragment, for IPv6 up to the
+	// fragment header, and size the length of the data once the last
+	// fragment is seen.
+	header []byte
+	size   int
+}
+
+type fragmentData struct {
+	offset int
+	data   []byte
+}
+
+// ipFragment is a fragment read from a packet. link is the length of the
+// frame before the IP header, ipv6Header the length of the IPv6 headers
+// before the fragment header and ipv6NextHeader the offset of the next
+// header field pointing at it.
+type ipFragment struct {
+	key            fragmentKey
+	offset         int
+	data           []byte
+	last           bool
+	link           int
+	ipv4           *layers.IPv4
+	ipv6Header     int
+	ipv6NextHeader int
+}
+
+// NewDefragmenter returns a defragmenter holding at most maxBytes of
+// fragments for at most timeout per datagram. A maxBytes of 0 disables
+// reassembly.
+func NewDefragmenter(maxBytes int, timeout time.Duration) *Defragmenter {
+	return &Defragmenter{
+		maxBytes:  maxBytes,
+		timeout:   timeout,
+		datagrams: make(map[fragmentKey]*fragmentedDatagram),
+		alerts:    newRaisedAlerts(fragmentAlertInterval),
+	}
+}
+
+// Add returns the packets to pass down the pipeline in place of the
+// packet: the packet itself when it is no fragment, nothing while its
+// datagram waits for more fragments and the reassembled datagram once it
+// is complete, after the fragments released by the timeout.
+func (d *Defragmenter) Add(packet AppPacket) []AppPacket {
+	if d.maxBytes <= 0 || packet.Data == nil {
+		return []AppPacket{packet}
+	}
+	fragment, ok := readFragment(packet.Data)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	released := d.expire(packet.CreatedAt)
+	if !ok {
+		return append(released, packet)
+	}
+	fragment.key.device = packet.DeviceID
+	d.stats.Fragments++
+	return append(released, d.addFragment(packet, fragment)...)
+}
+
+// Take returns the alerts raised since the last call.
+func (d *Defragmenter) Take() []Alert {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.alerts.take()
+}
+
+// Stats returns the counters of the defragmenter.
+func (d *Defragmenter) Stats() DefragStats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	stats := d.stats
+	stats.Pending = len(d.datagrams)
+	for _, datagram := range d.datagrams {
+		stats.PendingBytes += datagram.bytes
+	}
+	return stats
+}
+
+func (d *Defragmenter) addFragment(packet AppPacket, fragment ipFragment) []AppPacket {
+	d.checkTiny(packet, fragment)
+	if fragment.offset+len(fragment.data) > ip4defrag.IPv4MaximumSize {
+		d.stats.Invalid++
+		return append(d.drop(fragment.key), packet)
+	}
+	if datagram, ok := d.datagrams[fragment.key]; ok {
+		duplicate, overlap := datagram.covers(fragment)
+		if overlap {
+			d.stats.Overlapping++
+			d.raise(AlertIPFragmentOverlap, fragment.key, packet.CreatedAt,
+				fmt.Sprintf("%s sent overlapping fragments of datagram %d to %s", fragment.key.src, fragment.key.id, fragment.key.dst),
+				append(datagram.packets, packet))
+			return append(d.drop(fragment.key), packet)
+		}
+		if duplicate {
+			return []AppPacket{packet}
+		}
+	}
+
+	size := len(packet.Data.Data())
+	if size > d.maxBytes {
+		d.stats.Evicted++
+		return append(d.drop(fragment.key), packet)
+	}
+	released := d.makeRoom(size)
+	datagram, ok := d.datagrams[fragment.key]
+	if !ok {
+		datagram = &fragmentedDatagram{start: packet.CreatedAt}
+		d.datagrams[fragment.key] = datagram
+	}
+	datagram.packets = append(datagram.packets, packet)
+	datagram.bytes += size
+	datagram.fragments = append(datagram.fragments, fragmentData{fragment.offset, fragment.data})
+
+	rebuilt, err := datagram.reassemble(packet, fragment)
+	if err != nil {
+		d.stats.Invalid++
+		return append(released, d.drop(fragment.key)...)
+	}
+	if rebuilt == nil {
+		return released
+	}
+	delete(d.datagrams, fragment.key)
+	d.stats.Reassembled++
+	return append(released, *rebuilt)
+}
+
+// checkTiny raises an alert for a fragment that is not the last one and
+// carries less than the minimum fragment size, or less than the transport
+// header when it is the first.
+func (d *Defragmenter) checkTiny(packet AppPacket, fragment ipFragment) {
+	if fragment.last {
+		return
+	}
+	minimum := ip4defrag.IPv4MinimumFragmentSize
+	if fragment.offset == 0 {
+		minimum = max(minimum, transportHeaderSize(fragment.key.protocol))
+	}
+	if len(fragment.data) >= minimum {
+		return
+	}
+	d.stats.Tiny++
+	d.raise(AlertIPTinyFragment, fragment.key, packet.CreatedAt,
+		fmt.Sprintf("%s sent a %d byte fragment at offset %d of datagram %d to %s",
+			fragment.key.src, len(fragment.data), fragment.offset, fragment.key.id, fragment.key.dst),
+		[]AppPacket{packet})
+}
+
+func (d *Defragmenter) raise(typ string, key fragmentKey, at time.Time, message string, packets []AppPacket) {
+	evidence := make([]AlertPacket, 0, min(len(packets), fragmentMaxEvidence))
+	for _, packet := range packets[max(len(packets)-fragmentMaxEvidence, 0):] {
+		evidence = append(evidence, newAlertPacket(packet))
+	}
+	d.alerts.raise(fmt.Sprintf("%s|%s|%s", typ, key.device, key.src), Alert{
+		Time:     at,
+		Type:     typ,
+		DeviceID: key.device,
+		IP:       key.src,
+		Message:  message,
+		Evidence: evidence,
+	})
+}
+
+// expire releases the fragments of the datagrams older than the timeout.
+func (d *Defragmenter) expire(now time.Time) []AppPacket {
+	if now.Before(d.nextExpire) {
+		return nil
+	}
+	d.nextExpire = now.Add(defragExpireInterval)
+	var released []AppPacket
+	for key, datagram := range d.datagrams {
+		if now.Sub(datagram.start) >= d.timeout {
+			d.stats.TimedOut++
+			released = append(released, d.drop(key)...)
+		}
+	}
+	return released
+}
+
+// makeRoom releases the fragments of the oldest datagrams until size more
+// bytes fit under the memory limit.
+func (d *Defragmenter) makeRoom(size int) []AppPacket {
+	var released []AppPacket
+	total := 0
+	for _, datagram := range d.datagrams {
+		total += datagram.bytes
+	}
+	for total+size > d.maxBytes && len(d.datagrams) > 0 {
+		var oldest fragmentKey
+		var oldestStart time.Time
+		for key, datagram := range d.datagrams {
+			if oldestStart.IsZero() || datagram.start.Before(oldestStart) {
+				oldest, oldestStart = key, datagram.start
+			}
+		}
+		total -= d.datagrams[oldest].bytes
+		d.stats.Evicted++
+		released = append(released, d.drop(oldest)...)
+	}
+	return released
+}
+
+// drop forgets a datagram and returns the fragments it held.
+func (d *Defragmenter) drop(key fragmentKey) []AppPacket {
+	datagram, ok := d.datagrams[key]
+	if !ok {
+		return nil
+	}
+	delete(d.datagrams, key)
+	return datagram.packets
+}
+
+// covers tells whether the fragment repeats one already received, or
+// overlaps the data of others.
+func (g *fragmentedDatagram) covers(fragment ipFragment) (duplicate bool, overlap bool) {
+	end := fragment.offset + len(fragment.data)
+	for _, received := range g.fragments {
+		receivedEnd := received.offset + len(received.data)
+		if fragment.offset >= receivedEnd || received.offset >= end {
+			continue
+		}
+		if fragment.offset == received.offset && bytes.Equal(fragment.data, received.data) {
+			return true, false
+		}
+		return false, true
+	}
+	return false, false
+}
+
+// reassemble returns the whole datagram once the fragment completes it.
+func (g *fragmentedDatagram) reassemble(packet AppPacket, fragment ipFragment) (*AppPacket, error) {
+	ip, err := g.assemble(packet, fragment)
+	if ip == nil {
+		return nil, err
+	}
+
+	data := packet.Data.Data()
+	frame := append(append(make([]byte, 0, fragment.link+len(ip)), data[:fragment.link]...), ip...)
+	rebuilt := gopacket.NewPacket(frame, packet.Data.Layers()[0].LayerType(), gopacket.Default)
+	rebuilt.Metadata().CaptureInfo = gopacket.CaptureInfo{
+		Timestamp:      packet.CreatedAt,
+		CaptureLength:  len(frame),
+		Length:         len(frame),
+		InterfaceIndex: packet.Data.Metadata().InterfaceIndex,
+	}
+	reassembled := packet
+	reassembled.Data = rebuilt
+	reassembled.UpdatedAt = time.Now()
+	return &reassembled, nil
+}
+
+// assemble returns the IP datagram once its fragments cover it from the
+// start to the end of the last one: the header of the first fragment,
+// without the fragment header for IPv6, followed by the data of all
+// fragments. Data past the end of the last fragment is an error.
+func (g *fragmentedDatagram) assemble(packet AppPacket, fragment ipFragment) ([]byte, error) {
+	if fragment.offset == 0 && fragment.ipv4 != nil {
+		g.header = append([]byte(nil), fragment.ipv4.Contents...)
+	} else if fragment.offset == 0 {
+		start := fragment.link
+		g.header = append([]byte(nil), packet.Data.Data()[start:start+fragment.ipv6Header]...)
+		nextHeader := packet.Data.Data()[start+fragment.ipv6Header]
+		g.header[fragment.ipv6NextHeader] = nextHeader
+	}
+	if fragment.last {
+		end := fragment.offset + len(fragment.data)
+		if g.size != 0 && g.size != end {
+			return nil, errFragmentPastEnd
+		}
+		g.size = end
+	}
+	if g.size == 0 {
+		return nil, nil
+	}
+	slices.SortFunc(g.fragments, func(a, b fragmentData) int { return a.offset - b.offset })
+	received := 0
+	for _, f := range g.fragments {
+		if f.offset+len(f.data) > g.size {
+			return nil, errFragmentPastEnd
+		}
+		if f.offset == received {
+			received += len(f.data)
+		}
+	}
+	if g.header == nil || received != g.size {
+		return nil, nil
+	}
+	// The IPv4 length counts the header, the IPv6 one only what follows
+	// the fixed 40 bytes.
+	length := len(g.header) + g.size
+	if fragment.ipv4 == nil {
+		length -= 40
+	}
+	if length > 0xffff {
+		return nil, errDatagramTooLong
+	}
+	ip := append(make([]byte, 0, len(g.header)+g.size), g.header...)
+	for _, f := range g.fragments {
+		ip = append(ip, f.data...)
+	}
+	if fragment.ipv4 != nil {
+		// Neither more fragments nor an offset, and the checksum of the
+		// new header.
+		binary.BigEndian.PutUint16(ip[2:4], uint16(length))
+		binary.BigEndian.PutUint16(ip[6:8], 0)
+		binary.BigEndian.PutUint16(ip[10:12], 0)
+		binary.BigEndian.PutUint16(ip[10:12], ipv4HeaderChecksum(ip[:len(g.header)]))
+	} else {
+		binary.BigEndian.PutUint16(ip[4:6], uint16(length))
+	}
+	return ip, nil
+}
+
+func ipv4HeaderChecksum(header []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(header); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(header[i:]))
+	}
+	for sum > 0xffff {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+// readFragment reads the fragment carried by the first IP header of the
+// packet. Fragments cut by the snapshot length are not reassembled; the
+// truncated flag of gopacket is not used as it is set for every IPv6
+// packet with a hop-by-hop header.
+func readFragment(packet gopacket.Packet) (ipFragment, bool) {
+	network := packet.NetworkLayer()
+	if network == nil {
+		return ipFragment{}, false
+	}
+	packetLayers := packet.Layers()
+	fragment := ipFragment{}
+	i := 0
+	for ; i < len(packetLayers) && packetLayers[i] != network; i++ {
+		fragment.link += len(packetLayers[i].LayerContents())
+	}
+	switch ip := network.(type) {
+	case *layers.IPv4:
+		if ip.Flags&layers.IPv4MoreFragments == 0 && ip.FragOffset == 0 || ip.Flags&layers.IPv4DontFragment != 0 {
+			return ipFragment{}, false
+		}
+		if len(ip.Payload) != int(ip.Length)-int(ip.IHL)*4 {
+			return ipFragment{}, false
+		}
+		fragment.key = fragmentKey{src: ip.SrcIP.String(), dst: ip.DstIP.String(), protocol: ip.Protocol, id: uint32(ip.Id)}
+		fragment.offset = int(ip.FragOffset) * 8
+		fragment.data = ip.Payload
+		fragment.last = ip.Flags&layers.IPv4MoreFragments == 0
+		fragment.ipv4 = ip
+		return fragment, true
+	case *layers.IPv6:
+		if ip.Length == 0 || len(packet.Data()) < fragment.link+40+int(ip.Length) {
+			return ipFragment{}, false
+		}
+		// The next header field of the fixed header, then of each
+		// extension header, until the fragment header.
+		fragment.ipv6NextHeader = 6
+		for _, layer := range packetLayers[i:] {
+			switch layer.LayerType() {
+			case layers.LayerTypeIPv6, layers.LayerTypeIPv6HopByHop, layers.LayerTypeIPv6Routing, layers.LayerTypeIPv6Destination:
+				if layer != network {
+					fragment.ipv6NextHeader = fragment.ipv6Header
+				}
+				fragment.ipv6Header += len(layer.LayerContents())
+			case layers.LayerTypeIPv6Fragment:
+				header := layer.(*layers.IPv6Fragment)
+				fragment.key = fragmentKey{src: ip.SrcIP.String(), dst: ip.DstIP.String(), protocol: header.NextHeader, id: header.Identification}
+				fragment.offset = int(header.FragmentOffset) * 8
+				fragment.data = header.Payload
+				fragment.last = !header.MoreFragments
+				if fragment.offset == 0 && fragment.last {
+					return ipFragment{}, false
+				}
+				return fragment, true
+			default:
+				return ipFragment{}, false
+			}
+		}
+	}
+	return ipFragment{}, false
+}
+
+// transportHeaderSize is the length of the fixed header of a transport
+// protocol, which the first fragment of a datagram must hold.
+func transportHeaderSize(protocol layers.IPProtocol) int {
+	switch protocol {
+	case layers.IPProtocolTCP:
+		return 20
+	case layers.IPProtocolSCTP:
+		return 12
+	case layers.IPProtocolUDP, layers.IPProtocolUDPLite, layers.IPProtocolICMPv4, layers.IPProtocolICMPv6:
+		return 8
+	}
+	return 0
+}
@@ -0,0 +1,357 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+var fragmentTestEthernet = func(typ layers.EthernetType) *layers.Ethernet {
+	return &layers.Ethernet{SrcMAC: net.HardwareAddr{0, 1, 2, 3, 4, 5}, DstMAC: net.HardwareAddr{0, 1, 2, 3, 4, 6}, EthernetType: typ}
+}
+
+// udpTestDatagram returns a UDP header and payload of the given length.
+func udpTestDatagram(t *testing.T, network gopacket.NetworkLayer, length int) []byte {
+	t.Helper()
+	udp := &layers.UDP{SrcPort: 5000, DstPort: 6000}
+	udp.SetNetworkLayerForChecksum(network)
+	payload := make([]byte, length-8)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}, udp, gopacket.Payload(payload)); err != nil {
+		t.Fatalf("failed to serialize datagram: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// ipv4TestFragment wraps data at offset of a datagram with id in an
+// Ethernet frame.
+func ipv4TestFragment(t *testing.T, protocol layers.IPProtocol, id uint16, data []byte, offset int, more bool, at time.Time) AppPacket {
+	t.Helper()
+	ip := &layers.IPv4{Version: 4, IHL: 5, TTL: 64, Id: id, Protocol: protocol, SrcIP: net.IP{10, 0, 0, 5}, DstIP: net.IP{10, 0, 0, 9}, FragOffset: uint16(offset / 8)}
+	if more {
+		ip.Flags = layers.IPv4MoreFragments
+	}
+	packet := serializeTestFrame(t, layers.LayerTypeEthernet, fragmentTestEthernet(layers.EthernetTypeIPv4), ip, gopacket.Payload(data))
+	return AppPacket{Data: packet, CreatedAt: at, UpdatedAt: at, DeviceID: "eth0", LinkType: layers.LinkTypeEthernet}
+}
+
+var ipv6TestSource, ipv6TestDestination = net.ParseIP("2001:db8::5"), net.ParseIP("2001:db8::9")
+
+// ipv6TestFragment wraps data at offset of a UDP datagram in an IPv6
+// packet with a hop-by-hop header before the fragment header.
+func ipv6TestFragment(t *testing.T, data []byte, offset int, more bool, at time.Time) AppPacket {
+	t.Helper()
+	ip := &layers.IPv6{Version: 6, NextHeader: layers.IPProtocolIPv6HopByHop, HopLimit: 64, SrcIP: ipv6TestSource, DstIP: ipv6TestDestination}
+	hopByHop := &layers.IPv6HopByHop{}
+	hopByHop.NextHeader = layers.IPProtocolIPv6Fragment
+	hopByHop.Options = []*layers.IPv6HopByHopOption{{OptionType: 5, OptionLength: 4, OptionData: []byte{0, 0, 0, 0}}}
+	header := []byte{byte(layers.IPProtocolUDP), 0, 0, 0}
+	flags := uint16(offset)
+	if more {
+		flags |= 1
+	}
+	binary.BigEndian.PutUint16(header[2:], flags)
+	header = binary.BigEndian.AppendUint32(header, 0xdeadbeef)
+	packet := serializeTestFrame(t, layers.LayerTypeEthernet, fragmentTestEthernet(layers.EthernetTypeIPv6), ip, hopByHop,
+		gopacket.Payload(append(header, data...)))
+	return AppPacket{Data: packet, CreatedAt: at, UpdatedAt: at, DeviceID: "eth0", LinkType: layers.LinkTypeEthernet}
+}
+
+func TestDefragmenterReassemblesIPv4(t *testing.T) {
+	defragmenter := NewDefragmenter(1<<20, time.Minute)
+	start := time.Now()
+	datagram := udpTestDatagram(t, &layers.IPv4{SrcIP: net.IP{10, 0, 0, 5}, DstIP: net.IP{10, 0, 0, 9}}, 72)
+	fragment := func(offset, end int, more bool) AppPacket {
+		return ipv4TestFragment(t, layers.IPProtocolUDP, 7, datagram[offset:end], offset, more, start.Add(time.Duration(offset)*time.Millisecond))
+	}
+
+	// Out of order, the datagram completes with its first fragment.
+	if out := defragmenter.Add(fragment(48, 72, false)); len(out) != 0 {
+		t.Fatalf("expected the last fragment held, got %d packets", len(out))
+	}
+	if out := defragmenter.Add(fragment(24, 48, true)); len(out) != 0 {
+		t.Fatalf("expected the middle fragment held, got %d packets", len(out))
+	}
+	out := defragmenter.Add(fragment(0, 24, true))
+	if len(out) != 1 {
+		t.Fatalf("expected the reassembled datagram, got %d packets", len(out))
+	}
+	saved := decodeTestPacket(t, out[0].Data)
+	if saved.Protocol != ProtocolUDP || *saved.SourcePort != 5000 || *saved.DestinationPort != 6000 || saved.SourceIP != "10.0.0.5" ||
+		saved.MoreFragments == nil || *saved.MoreFragments || *saved.IPID != 7 {
+		t.Errorf("unexpected reassembled packet %+v", saved)
+	}
+	udp, ok := out[0].Data.Layer(layers.LayerTypeUDP).(*layers.UDP)
+	if !ok || !bytes.Equal(append(udp.Contents, udp.Payload...), datagram) {
+		t.Errorf("expected the original datagram, got %v", out[0].Data)
+	}
+	if out[0].Data.Layer(layers.LayerTypeEthernet) == nil || out[0].DeviceID != "eth0" {
+		t.Errorf("expected the link header and device kept, got %+v", out[0])
+	}
+
+	plain := pingTestPacket(t, "10.0.0.5", "10.0.0.1", false, 1, 1, 64, start)
+	if out := defragmenter.Add(plain); len(out) != 1 || out[0].Data != plain.Data {
+		t.Errorf("expected an unfragmented packet passed through, got %+v", out)
+	}
+	stats := defragmenter.Stats()
+	if stats.Fragments != 3 || stats.Reassembled != 1 || stats.Pending != 0 || stats.PendingBytes != 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	if alerts := defragmenter.Take(); len(alerts) != 0 {
+		t.Errorf("expected no alert, got %+v", alerts)
+	}
+}
+
+func TestDefragmenterReassemblesShortIPv4LastFragment(t *testing.T) {
+	defragmenter := NewDefragmenter(1<<20, time.Minute)
+	start := time.Now()
+	datagram := udpTestDatagram(t, &layers.IPv4{SrcIP: net.IP{10, 0, 0, 5}, DstIP: net.IP{10, 0, 0, 9}}, 28)
+
+	// The last fragment may carry less than the 8 bytes others must.
+	defragmenter.Add(ipv4TestFragment(t, layers.IPProtocolUDP, 9, datagram[:24], 0, true, start))
+	out := defragmenter.Add(ipv4TestFragment(t, layers.IPProtocolUDP, 9, datagram[24:], 24, false, start))
+	if len(out) != 1 {
+		t.Fatalf("expected the reassembled datagram, got %d packets", len(out))
+	}
+	ip, ok := out[0].Data.Layer(layers.LayerTypeIPv4).(*layers.IPv4)
+	if !ok || ip.Length != 48 || ip.Flags != 0 || ip.FragOffset != 0 || ipv4HeaderChecksum(ip.Contents) != 0 {
+		t.Errorf("expected a whole IPv4 header with a valid checksum, got %+v", ip)
+	}
+	udp, ok := out[0].Data.Layer(layers.LayerTypeUDP).(*layers.UDP)
+	if !ok || !bytes.Equal(append(udp.Contents, udp.Payload...), datagram) {
+		t.Errorf("expected the original datagram, got %v", out[0].Data)
+	}
+	if stats := defragmenter.Stats(); stats.Reassembled != 1 || stats.Invalid != 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestDefragmenterReassemblesIPv6(t *testing.T) {
+	defragmenter := NewDefragmenter(1<<20, time.Minute)
+	start := time.Now()
+	datagram := udpTestDatagram(t, &layers.IPv6{SrcIP: ipv6TestSource, DstIP: ipv6TestDestination}, 40)
+	fragment := func(offset, end int, more bool) AppPacket {
+		return ipv6TestFragment(t, datagram[offset:end], offset, more, start)
+	}
+
+	if out := defragmenter.Add(fragment(0, 16, true)); len(out) != 0 {
+		t.Fatalf("expected the first fragment held, got %d packets", len(out))
+	}
+	out := defragmenter.Add(fragment(16, 40, false))
+	if len(out) != 1 {
+		t.Fatalf("expected the reassembled datagram, got %d packets", len(out))
+	}
+	if out[0].Data.Layer(layers.LayerTypeIPv6Fragment) != nil || out[0].Data.Layer(layers.LayerTypeIPv6HopByHop) == nil {
+		t.Errorf("expected the fragment header removed and the hop-by-hop header kept, got %v", out[0].Data)
+	}
+	saved := decodeTestPacket(t, out[0].Data)
+	if saved.Protocol != ProtocolUDP || *saved.SourcePort != 5000 || *saved.DestinationPort != 6000 || saved.DestinationIP != "2001:db8::9" {
+		t.Errorf("unexpected reassembled packet %+v", saved)
+	}
+	udp, ok := out[0].Data.Layer(layers.LayerTypeUDP).(*layers.UDP)
+	if !ok || !bytes.Equal(append(udp.Contents, udp.Payload...), datagram) {
+		t.Errorf("expected the original datagram, got %v", out[0].Data)
+	}
+}
+
+func TestDefragmenterWaitsForIPv6Gaps(t *testing.T) {
+	defragmenter := NewDefragmenter(1<<20, time.Minute)
+	start := time.Now()
+	datagram := udpTestDatagram(t, &layers.IPv6{SrcIP: ipv6TestSource, DstIP: ipv6TestDestination}, 104)
+	fragment := func(offset, end int, more bool) AppPacket {
+		return ipv6TestFragment(t, datagram[offset:end], offset, more, start)
+	}
+
+	// The first and last fragments leave a gap the same size as the
+	// middle one.
+	defragmenter.Add(fragment(0, 40, true))
+	if out := defragmenter.Add(fragment(64, 104, false)); len(out) != 0 {
+		t.Fatalf("expected the fragments held until the gap is filled, got %d packets", len(out))
+	}
+	out := defragmenter.Add(fragment(40, 64, true))
+	if len(out) != 1 {
+		t.Fatalf("expected the reassembled datagram, got %d packets", len(out))
+	}
+	if udp, ok := out[0].Data.Layer(layers.LayerTypeUDP).(*layers.UDP); !ok || !bytes.Equal(append(udp.Contents, udp.Payload...), datagram) {
+		t.Errorf("expected the original datagram, got %v", out[0].Data)
+	}
+}
+
+func TestDefragmenterDropsIPv6DataPastTheEnd(t *testing.T) {
+	start := time.Now()
+	data := make([]byte, 224)
+	fragment := func(offset, end int, more bool) AppPacket {
+		return ipv6TestFragment(t, data[offset:end], offset, more, start)
+	}
+	tests := []struct {
+		name      string
+		fragments []AppPacket
+	}{
+		// As many bytes as the last fragment ends at, but with a gap.
+		{"before the last fragment", []AppPacket{fragment(0, 40, true), fragment(200, 224, true), fragment(64, 104, false)}},
+		{"after the last fragment", []AppPacket{fragment(64, 104, false), fragment(200, 224, true)}},
+		{"second last fragment", []AppPacket{fragment(64, 104, false), fragment(200, 224, false)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defragmenter := NewDefragmenter(1<<20, time.Minute)
+			var out []AppPacket
+			for _, packet := range tt.fragments {
+				out = defragmenter.Add(packet)
+			}
+			if len(out) != len(tt.fragments) {
+				t.Fatalf("expected the %d fragments released as captured, got %d packets", len(tt.fragments), len(out))
+			}
+			for i, packet := range out {
+				if packet.Data != tt.fragments[i].Data {
+					t.Errorf("expected fragment %d released, got %v", i, packet.Data)
+				}
+			}
+			if stats := defragmenter.Stats(); stats.Invalid != 1 || stats.Reassembled != 0 || stats.Pending != 0 {
+				t.Errorf("unexpected stats %+v", stats)
+			}
+		})
+	}
+}
+
+func TestDefragmenterDropsDatagramsTooLong(t *testing.T) {
+	start := time.Now()
+	// The data ends at the largest offset, so the headers before it make
+	// the datagram longer than its length field allows.
+	data := make([]byte, 0xffff)
+	tests := []struct {
+		name     string
+		fragment func(offset, end int, more bool) AppPacket
+	}{
+		{"IPv4", func(offset, end int, more bool) AppPacket {
+			return ipv4TestFragment(t, layers.IPProtocolUDP, 3, data[offset:end], offset, more, start)
+		}},
+		{"IPv6", func(offset, end int, more bool) AppPacket {
+			return ipv6TestFragment(t, data[offset:end], offset, more, start)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defragmenter := NewDefragmenter(1<<20, time.Minute)
+			fragments := []AppPacket{tt.fragment(0, 32768, true), tt.fragment(32768, len(data), false)}
+			var out []AppPacket
+			for _, packet := range fragments {
+				out = defragmenter.Add(packet)
+			}
+			if len(out) != len(fragments) || out[0].Data != fragments[0].Data || out[1].Data != fragments[1].Data {
+				t.Fatalf("expected the %d fragments released as captured, got %d packets", len(fragments), len(out))
+			}
+			if stats := defragmenter.Stats(); stats.Invalid != 1 || stats.Reassembled != 0 || stats.Pending != 0 {
+				t.Errorf("unexpected stats %+v", stats)
+			}
+		})
+	}
+}
+
+func TestDefragmenterAlertsOnOverlap(t *testing.T) {
+	defragmenter := NewDefragmenter(1<<20, time.Minute)
+	start := time.Now()
+	data := make([]byte, 48)
+
+	first := ipv4TestFragment(t, layers.IPProtocolUDP, 1, data[:24], 0, true, start)
+	defragmenter.Add(first)
+	// The same fragment again is a duplicate, not an attack.
+	if out := defragmenter.Add(ipv4TestFragment(t, layers.IPProtocolUDP, 1, data[:24], 0, true, start)); len(out) != 1 {
+		t.Fatalf("expected the duplicate passed through, got %d packets", len(out))
+	}
+	if alerts := defragmenter.Take(); len(alerts) != 0 {
+		t.Fatalf("expected no alert for a duplicate, got %+v", alerts)
+	}
+
+	overlapping := ipv4TestFragment(t, layers.IPProtocolUDP, 1, data[16:48], 16, false, start.Add(time.Millisecond))
+	out := defragmenter.Add(overlapping)
+	if len(out) != 2 || out[0].Data != first.Data || out[1].Data != overlapping.Data {
+		t.Fatalf("expected both fragments released as captured, got %+v", out)
+	}
+	alerts := defragmenter.Take()
+	if len(alerts) != 1 || alerts[0].Type != AlertIPFragmentOverlap || alerts[0].IP != "10.0.0.5" || alerts[0].DeviceID != "eth0" || len(alerts[0].Evidence) != 2 {
+		t.Fatalf("expected an overlap alert with both fragments, got %+v", alerts)
+	}
+	stats := defragmenter.Stats()
+	if stats.Overlapping != 1 || stats.Pending != 0 || stats.Reassembled != 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestDefragmenterAlertsOnTinyFragment(t *testing.T) {
+	defragmenter := NewDefragmenter(1<<20, time.Minute)
+	start := time.Now()
+	tcp := &layers.TCP{SrcPort: 40000, DstPort: 22, SYN: true, Window: 1024}
+	tcp.SetNetworkLayerForChecksum(&layers.IPv4{SrcIP: net.IP{10, 0, 0, 5}, DstIP: net.IP{10, 0, 0, 9}})
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}, tcp, gopacket.Payload(make([]byte, 12))); err != nil {
+		t.Fatalf("failed to serialize segment: %v", err)
+	}
+	segment := buf.Bytes()
+
+	// The first fragment leaves the TCP flags to the second.
+	defragmenter.Add(ipv4TestFragment(t, layers.IPProtocolTCP, 2, segment[:8], 0, true, start))
+	out := defragmenter.Add(ipv4TestFragment(t, layers.IPProtocolTCP, 2, segment[8:], 8, false, start))
+	if len(out) != 1 {
+		t.Fatalf("expected the segment reassembled, got %d packets", len(out))
+	}
+	if saved := decodeTestPacket(t, out[0].Data); saved.Protocol != ProtocolTCP || *saved.DestinationPort != 22 {
+		t.Errorf("unexpected reassembled packet %+v", saved)
+	}
+	alerts := defragmenter.Take()
+	if len(alerts) != 1 || alerts[0].Type != AlertIPTinyFragment || len(alerts[0].Evidence) != 1 {
+		t.Fatalf("expected a tiny fragment alert, got %+v", alerts)
+	}
+	if stats := defragmenter.Stats(); stats.Tiny != 1 || stats.Reassembled != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestDefragmenterReleasesFragments(t *testing.T) {
+	start := time.Now()
+	data := make([]byte, 64)
+
+	t.Run("timeout", func(t *testing.T) {
+		defragmenter := NewDefragmenter(1<<20, 30*time.Second)
+		fragment := ipv4TestFragment(t, layers.IPProtocolUDP, 3, data[:32], 0, true, start)
+		defragmenter.Add(fragment)
+		plain := pingTestPacket(t, "10.0.0.5", "10.0.0.1", false, 1, 1, 64, start.Add(30*time.Second))
+		out := defragmenter.Add(plain)
+		if len(out) != 2 || out[0].Data != fragment.Data || out[1].Data != plain.Data {
+			t.Fatalf("expected the timed out fragment before the packet, got %+v", out)
+		}
+		if stats := defragmenter.Stats(); stats.TimedOut != 1 || stats.Pending != 0 {
+			t.Errorf("unexpected stats %+v", stats)
+		}
+	})
+
+	t.Run("memory limit", func(t *testing.T) {
+		first := ipv4TestFragment(t, layers.IPProtocolUDP, 4, data[:32], 0, true, start)
+		second := ipv4TestFragment(t, layers.IPProtocolUDP, 5, data[:32], 0, true, start.Add(time.Millisecond))
+		defragmenter := NewDefragmenter(len(first.Data.Data())+10, time.Minute)
+		defragmenter.Add(first)
+		out := defragmenter.Add(second)
+		if len(out) != 1 || out[0].Data != first.Data {
+			t.Fatalf("expected the oldest datagram released, got %+v", out)
+		}
+		if stats := defragmenter.Stats(); stats.Evicted != 1 || stats.Pending != 1 || stats.PendingBytes != len(second.Data.Data()) {
+			t.Errorf("unexpected stats %+v", stats)
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		defragmenter := NewDefragmenter(0, time.Minute)
+		fragment := ipv4TestFragment(t, layers.IPProtocolUDP, 6, data[:32], 0, true, start)
+		if out := defragmenter.Add(fragment); len(out) != 1 || out[0].Data != fragment.Data {
+			t.Errorf("expected the fragment passed through, got %+v", out)
+		}
+	})
+}
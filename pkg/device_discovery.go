@@ -0,0 +1,139 @@
+package pkg
+
+import (
+	"net"
+	"sort"
+	"sync"
+
+	"github.com/google/gopacket/pcap"
+)
+
+// libpcap interface flags, see PCAP_IF_* in pcap.h.
+const (
+	pcapIfLoopback = 0x1
+	pcapIfUp       = 0x2
+	pcapIfRunning  = 0x4
+)
+
+var findAllDevs = pcap.FindAllDevs
+
+// DeviceStatus is the discovery view of a device as returned by the API.
+type DeviceStatus struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	MAC         string   `json:"mac"`
+	Addresses   []string `json:"addresses"`
+	Up          bool     `json:"up"`
+	Running     bool     `json:"running"`
+	Loopback    bool     `json:"loopback"`
+	Capturing   bool     `json:"capturing"`
+}
+
+var activeCaptures = struct {
+	sync.Mutex
+	counts map[string]int
+}{counts: make(map[string]int)}
+
+// markCapturing records that a capture is running on the device until the
+// returned function is called.
+func markCapturing(name string) func() {
+	activeCaptures.Lock()
+	activeCaptures.counts[name]++
+	activeCaptures.Unlock()
+	return func() {
+		activeCaptures.Lock()
+		defer activeCaptures.Unlock()
+		activeCaptures.counts[name]--
+		if activeCaptures.counts[name] <= 0 {
+			delete(activeCaptures.counts, name)
+		}
+	}
+}
+
+func IsCapturing(name string) bool {
+	activeCaptures.Lock()
+	defer activeCaptures.Unlock()
+	return activeCaptures.counts[name] > 0
+}
+
+// DiscoverDevices lists the interfaces libpcap can capture on, completed
+// with the MAC address and link state known to the kernel.
+func DiscoverDevices() ([]Device, error) {
+	pcapDevs, pcapErr := findAllDevs()
+	ifaces, ifaceErr := listInterfaces()
+	if pcapErr != nil && ifaceErr != nil {
+		return nil, pcapErr
+	}
+
+	byName := make(map[string]*Device)
+	var names []string
+	get := func(name string) *Device {
+		if d, ok := byName[name]; ok {
+			return d
+		}
+		d := &Device{Name: name}
+		byName[name] = d
+		names = append(names, name)
+		return d
+	}
+
+	for _, dev := range pcapDevs {
+		d := get(dev.Name)
+		d.Description = dev.Description
+		d.Up = dev.Flags&pcapIfUp != 0
+		d.Running = dev.Flags&pcapIfRunning != 0
+		d.Loopback = dev.Flags&pcapIfLoopback != 0
+		for _, addr := range dev.Addresses {
+			d.Addresses = append(d.Addresses, Addrs{IP: addr.IP, Netmask: addr.Netmask})
+		}
+	}
+
+	for _, iface := range ifaces {
+		d := get(iface.Name)
+		d.ID = iface.Index
+		d.MAC = iface.HardwareAddr
+		d.Up = d.Up || iface.Flags&net.FlagUp != 0
+		d.Running = d.Running || iface.Flags&net.FlagRunning != 0
+		d.Loopback = d.Loopback || iface.Flags&net.FlagLoopback != 0
+		if len(d.Addresses) > 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok {
+				d.Addresses = append(d.Addresses, Addrs{IP: ipnet.IP, Netmask: ipnet.Mask})
+			}
+		}
+	}
+
+	sort.Strings(names)
+	devices := make([]Device, 0, len(names))
+	for _, name := range names {
+		devices = append(devices, *byName[name])
+	}
+	return devices, nil
+}
+
+func (d Device) Status() DeviceStatus {
+	status := DeviceStatus{
+		Name:        d.Name,
+		Description: d.Description,
+		MAC:         d.MAC.String(),
+		Addresses:   make([]string, 0, len(d.Addresses)),
+		Up:          d.Up,
+		Running:     d.Running,
+		Loopback:    d.Loopback,
+		Capturing:   IsCapturing(d.Name),
+	}
+	for _, addr := range d.Addresses {
+		if addr.Netmask == nil {
+			status.Addresses = append(status.Addresses, addr.IP.String())
+			continue
+		}
+		status.Addresses = append(status.Addresses, (&net.IPNet{IP: addr.IP, Mask: addr.Netmask}).String())
+	}
+	return status
+}
@@ -0,0 +1,107 @@
+package pkg
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/google/gopacket/pcap"
+)
+
+func TestDiscoverDevicesMergesPcapAndKernelInterfaces(t *testing.T) {
+	originalFind := findAllDevs
+	originalList := listInterfaces
+	defer func() {
+		findAllDevs = originalFind
+		listInterfaces = originalList
+	}()
+
+	mac, _ := net.ParseMAC("00:11:22:33:44:55")
+	findAllDevs = func() ([]pcap.Interface, error) {
+		return []pcap.Interface{
+			{
+				Name:        "eth0",
+				Description: "Ethernet adapter",
+				Flags:       pcapIfUp | pcapIfRunning,
+				Addresses: []pcap.InterfaceAddress{
+					{IP: net.IPv4(10, 0, 0, 5).To4(), Netmask: net.CIDRMask(24, 32)},
+				},
+			},
+			{Name: "any", Description: "Pseudo-device that captures on all interfaces", Flags: pcapIfUp | pcapIfRunning},
+		}, nil
+	}
+	listInterfaces = func() ([]net.Interface, error) {
+		return []net.Interface{
+			{Index: 2, Name: "eth0", HardwareAddr: mac, Flags: net.FlagUp},
+			{Index: 3, Name: "wlan0", Flags: 0},
+		}, nil
+	}
+
+	devices, err := DiscoverDevices()
+	if err != nil {
+		t.Fatalf("DiscoverDevices returned error: %v", err)
+	}
+	if len(devices) != 3 {
+		t.Fatalf("expected 3 devices, got %d", len(devices))
+	}
+	if devices[0].Name != "any" || devices[1].Name != "eth0" || devices[2].Name != "wlan0" {
+		t.Fatalf("expected devices sorted by name, got %v, %v, %v", devices[0].Name, devices[1].Name, devices[2].Name)
+	}
+
+	eth0 := devices[1]
+	if eth0.ID != 2 || eth0.MAC.String() != mac.String() || eth0.Description != "Ethernet adapter" {
+		t.Fatalf("unexpected eth0: %+v", eth0)
+	}
+	if !eth0.Up || !eth0.Running || eth0.Loopback {
+		t.Fatalf("unexpected eth0 link state: %+v", eth0)
+	}
+	if len(eth0.Addresses) != 1 || !eth0.Addresses[0].IP.Equal(net.IPv4(10, 0, 0, 5)) {
+		t.Fatalf("unexpected eth0 addresses: %+v", eth0.Addresses)
+	}
+	if devices[2].Up {
+		t.Fatal("expected wlan0 to be down")
+	}
+}
+
+func TestDiscoverDevicesFailsWhenNoSourceWorks(t *testing.T) {
+	originalFind := findAllDevs
+	originalList := listInterfaces
+	defer func() {
+		findAllDevs = originalFind
+		listInterfaces = originalList
+	}()
+
+	findAllDevs = func() ([]pcap.Interface, error) { return nil, errors.New("pcap failed") }
+	listInterfaces = func() ([]net.Interface, error) { return nil, errors.New("netlink failed") }
+
+	if _, err := DiscoverDevices(); err == nil {
+		t.Fatal("expected error when discovery fails")
+	}
+}
+
+func TestDeviceStatusReportsActiveCapture(t *testing.T) {
+	dev := Device{
+		Name:      "eth9",
+		Addresses: []Addrs{{IP: net.IPv4(192, 168, 0, 1).To4(), Netmask: net.CIDRMask(16, 32)}},
+	}
+	if dev.Status().Capturing {
+		t.Fatal("expected device not to be capturing")
+	}
+
+	done := markCapturing("eth9")
+	status := dev.Status()
+	if !status.Capturing {
+		t.Fatal("expected device to be capturing")
+	}
+	if len(status.Addresses) != 1 || status.Addresses[0] != "192.168.0.1/16" {
+		t.Fatalf("unexpected addresses: %v", status.Addresses)
+	}
+	if status.MAC != "" {
+		t.Fatalf("expected empty MAC, got %s", status.MAC)
+	}
+
+	done()
+	if IsCapturing("eth9") {
+		t.Fatal("expected capture to be marked as stopped")
+	}
+}
@@ -0,0 +1,682 @@
+package pkg
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// DisplayFilter is a parsed Wireshark style display filter such as
+// `ip.src == 10.0.0.0/8 && tcp.dstport in {80,443} && !dns`, compiled to a
+// where clause on saved_packets.
+type DisplayFilter struct {
+	Expression string
+	sql        string
+	args       []any
+	match      func(packet *SavedPacket) bool
+}
+
+// DisplayFilterError is a parse error of a display filter. Position is the
+// 1-based offset of the offending character in the expression.
+type DisplayFilterError struct {
+	Position int
+	Message  string
+}
+
+func (e *DisplayFilterError) Error() string {
+	return fmt.Sprintf("invalid display filter at position %d: %s", e.Position, e.Message)
+}
+
+func (e *DisplayFilterError) Unwrap() error {
+	return ErrInvalidPacketFilter
+}
+
+// ParseDisplayFilter parses and compiles a display filter. Expressions
+// combine comparisons, fields and protocol names with &&, || and !, or
+// and, or and not:
+//
+//	ip.addr == 192.168.0.0/16 and not tcp.port in {22 443 8000..8100}
+//	eth.src == 00:11:22:33:44:55 || (udp && frame.len > 1000)
+//	frame.time >= "2024-01-01T00:00:00Z" && tcp.flags.reset == 1
+func ParseDisplayFilter(expression string) (*DisplayFilter, error) {
+	tokens, err := tokenizeDisplayFilter(expression)
+	if err != nil {
+		return nil, err
+	}
+	parser := &displayFilterParser{tokens: tokens}
+	if parser.peek().kind == tokenEOF {
+		return nil, parser.errorAt(parser.peek(), "empty filter")
+	}
+	compiled, err := parser.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if next := parser.peek(); next.kind != tokenEOF {
+		return nil, parser.errorAt(next, fmt.Sprintf("unexpected %q, expected && or ||", next.text))
+	}
+	return &DisplayFilter{Expression: expression, sql: compiled.sql, args: compiled.args, match: compiled.match}, nil
+}
+
+func (f *DisplayFilter) String() string {
+	return f.Expression
+}
+
+// Matches evaluates the filter on a packet in memory, as the where clause
+// would on the stored packet.
+func (f *DisplayFilter) Matches(packet *SavedPacket) bool {
+	return f.match(packet)
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenWord
+	tokenString
+	tokenCompare
+	tokenIn
+	tokenAnd
+	tokenOr
+	tokenNot
+	tokenLParen
+	tokenRParen
+	tokenLBrace
+	tokenRBrace
+	tokenComma
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	// pos is the byte offset of the token in the expression.
+	pos int
+}
+
+// wordOperators are the keyword spellings of the operators.
+var wordOperators = map[string]token{
+	"and":      {kind: tokenAnd, text: "&&"},
+	"or":       {kind: tokenOr, text: "||"},
+	"not":      {kind: tokenNot, text: "!"},
+	"in":       {kind: tokenIn, text: "in"},
+	"eq":       {kind: tokenCompare, text: "=="},
+	"ne":       {kind: tokenCompare, text: "!="},
+	"gt":       {kind: tokenCompare, text: ">"},
+	"ge":       {kind: tokenCompare, text: ">="},
+	"lt":       {kind: tokenCompare, text: "<"},
+	"le":       {kind: tokenCompare, text: "<="},
+	"contains": {kind: tokenCompare, text: "contains"},
+}
+
+func isWordChar(c rune) bool {
+	return unicode.IsLetter(c) || unicode.IsDigit(c) || strings.ContainsRune("._:/-", c)
+}
+
+func tokenizeDisplayFilter(expression string) ([]token, error) {
+	var tokens []token
+	runes := []rune(expression)
+	// offsets maps rune indexes to byte offsets for error positions.
+	offsets := make([]int, len(runes)+1)
+	for i, offset := 0, 0; i < len(runes); i++ {
+		offsets[i] = offset
+		offset += len(string(runes[i]))
+		offsets[i+1] = offset
+	}
+	fail := func(i int, message string) error {
+		return &DisplayFilterError{Position: offsets[i] + 1, Message: message}
+	}
+	two := func(i int, s string) bool {
+		return i+1 < len(runes) && string(runes[i:i+2]) == s
+	}
+
+	for i := 0; i < len(runes); {
+		c := runes[i]
+		start := i
+		switch {
+		case unicode.IsSpace(c):
+			i++
+			continue
+		case c == '(' || c == ')' || c == '{' || c == '}' || c == ',':
+			kind := map[rune]tokenKind{'(': tokenLParen, ')': tokenRParen, '{': tokenLBrace, '}': tokenRBrace, ',': tokenComma}[c]
+			tokens = append(tokens, token{kind: kind, text: string(c), pos: offsets[i]})
+			i++
+		case two(i, "&&"):
+			tokens = append(tokens, token{kind: tokenAnd, text: "&&", pos: offsets[i]})
+			i += 2
+		case two(i, "||"):
+			tokens = append(tokens, token{kind: tokenOr, text: "||", pos: offsets[i]})
+			i += 2
+		case two(i, "==") || two(i, "!=") || two(i, "<=") || two(i, ">="):
+			tokens = append(tokens, token{kind: tokenCompare, text: string(runes[i : i+2]), pos: offsets[i]})
+			i += 2
+		case c == '<' || c == '>':
+			tokens = append(tokens, token{kind: tokenCompare, text: string(c), pos: offsets[i]})
+			i++
+		case c == '!':
+			tokens = append(tokens, token{kind: tokenNot, text: "!", pos: offsets[i]})
+			i++
+		case c == '=':
+			return nil, fail(i, "use == to compare")
+		case c == '&' || c == '|':
+			return nil, fail(i, fmt.Sprintf("use %c%c", c, c))
+		case c == '"':
+			var value strings.Builder
+			for i++; ; i++ {
+				if i >= len(runes) {
+					return nil, fail(start, "unterminated string")
+				}
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				} else if runes[i] == '"' {
+					break
+				}
+				value.WriteRune(runes[i])
+			}
+			i++
+			tokens = append(tokens, token{kind: tokenString, text: value.String(), pos: offsets[start]})
+		case isWordChar(c):
+			for i < len(runes) && isWordChar(runes[i]) {
+				i++
+			}
+			word := string(runes[start:i])
+			if operator, ok := wordOperators[strings.ToLower(word)]; ok {
+				operator.pos = offsets[start]
+				tokens = append(tokens, operator)
+			} else {
+				tokens = append(tokens, token{kind: tokenWord, text: word, pos: offsets[start]})
+			}
+		default:
+			return nil, fail(i, fmt.Sprintf("unexpected character %q", c))
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(expression)}), nil
+}
+
+// sqlCondition is a where clause that is never NULL, so that negating it
+// keeps the packets without the field, and match the same test on a packet
+// that is not stored.
+type sqlCondition struct {
+	sql   string
+	args  []any
+	match func(packet *SavedPacket) bool
+}
+
+func (c sqlCondition) and(other sqlCondition) sqlCondition {
+	return sqlCondition{
+		sql:   "(" + c.sql + " AND " + other.sql + ")",
+		args:  append(append([]any{}, c.args...), other.args...),
+		match: func(packet *SavedPacket) bool { return c.match(packet) && other.match(packet) },
+	}
+}
+
+func (c sqlCondition) or(other sqlCondition) sqlCondition {
+	return sqlCondition{
+		sql:   "(" + c.sql + " OR " + other.sql + ")",
+		args:  append(append([]any{}, c.args...), other.args...),
+		match: func(packet *SavedPacket) bool { return c.match(packet) || other.match(packet) },
+	}
+}
+
+func (c sqlCondition) not() sqlCondition {
+	return sqlCondition{
+		sql:   "NOT " + c.sql,
+		args:  c.args,
+		match: func(packet *SavedPacket) bool { return !c.match(packet) },
+	}
+}
+
+// compare compares a nullable column with one of =, <>, <, <=, >, >=, IN
+// and BETWEEN, false when the column is NULL.
+func compare(column, operator string, args ...any) sqlCondition {
+	placeholder := "?"
+	if operator == "BETWEEN" {
+		placeholder = "? AND ?"
+	}
+	return sqlCondition{
+		sql:  "(" + column + " IS NOT NULL AND " + column + " " + operator + " " + placeholder + ")",
+		args: args,
+		match: func(packet *SavedPacket) bool {
+			value := packetColumn(packet, column)
+			return value != nil && compareColumn(value, operator, args)
+		},
+	}
+}
+
+// present tests that the packet has a value in column, text columns are
+// empty rather than NULL without one.
+func present(column string, kind fieldKind) sqlCondition {
+	switch kind {
+	case fieldIP, fieldMAC, fieldString:
+		return compare(column, "<>", "")
+	}
+	return sqlCondition{
+		sql:   column + " IS NOT NULL",
+		match: func(packet *SavedPacket) bool { return packetColumn(packet, column) != nil },
+	}
+}
+
+// containsText tests that a text column contains value, case sensitively.
+func containsText(column, value string) sqlCondition {
+	return sqlCondition{
+		sql:  "(" + column + " IS NOT NULL AND instr(" + column + ", ?) > 0)",
+		args: []any{value},
+		match: func(packet *SavedPacket) bool {
+			text, ok := packetColumn(packet, column).(string)
+			return ok && strings.Contains(text, value)
+		},
+	}
+}
+
+type fieldKind int
+
+const (
+	fieldInt fieldKind = iota
+	fieldBool
+	fieldIP
+	fieldMAC
+	fieldString
+	fieldTime
+)
+
+// displayField maps a filter field to its columns. Fields with two columns
+// match either side of the packet.
+type displayField struct {
+	kind     fieldKind
+	columns  []string
+	protocol string
+	version  int
+}
+
+func (f displayField) restrict(condition sqlCondition) sqlCondition {
+	if f.protocol != "" {
+		condition = compare("protocol", "=", f.protocol).and(condition)
+	}
+	if f.version != 0 {
+		condition = compare("ip_version", "=", f.version).and(condition)
+	}
+	return condition
+}
+
+var displayFields = func() map[string]displayField {
+	fields := map[string]displayField{
+		"frame.time":               {kind: fieldTime, columns: []string{"created_at"}},
+		"frame.len":                {kind: fieldInt, columns: []string{"frame_length"}},
+		"frame.cap_len":            {kind: fieldInt, columns: []string{"captured_length"}},
+		"frame.interface_name":     {kind: fieldString, columns: []string{"device_id"}},
+		"frame.direction":          {kind: fieldString, columns: []string{"direction"}},
+		"eth.src":                  {kind: fieldMAC, columns: []string{"source_mac"}},
+		"eth.dst":                  {kind: fieldMAC, columns: []string{"destination_mac"}},
+		"eth.addr":                 {kind: fieldMAC, columns: []string{"source_mac", "destination_mac"}},
+		"eth.type":                 {kind: fieldInt, columns: []string{"ether_type"}},
+		"vlan.id":                  {kind: fieldInt, columns: []string{"vlan_id"}},
+		"arp.src.proto_ipv4":       {kind: fieldIP, columns: []string{"source_ip"}, protocol: ProtocolARP},
+		"arp.dst.proto_ipv4":       {kind: fieldIP, columns: []string{"destination_ip"}, protocol: ProtocolARP},
+		"ip.version":               {kind: fieldInt, columns: []string{"ip_version"}},
+		"ip.ttl":                   {kind: fieldInt, columns: []string{"ttl"}, version: 4},
+		"ip.id":                    {kind: fieldInt, columns: []string{"ip_id"}, version: 4},
+		"ip.dsfield.dscp":          {kind: fieldInt, columns: []string{"dscp"}, version: 4},
+		"ip.dsfield.ecn":           {kind: fieldInt, columns: []string{"ecn"}, version: 4},
+		"ip.len":                   {kind: fieldInt, columns: []string{"total_length"}, version: 4},
+		"ip.flags.df":              {kind: fieldBool, columns: []string{"dont_fragment"}, version: 4},
+		"ip.flags.mf":              {kind: fieldBool, columns: []string{"more_fragments"}, version: 4},
+		"ip.frag_offset":           {kind: fieldInt, columns: []string{"fragment_offset"}, version: 4},
+		"ipv6.hlim":                {kind: fieldInt, columns: []string{"ttl"}, version: 6},
+		"ipv6.tclass.dscp":         {kind: fieldInt, columns: []string{"dscp"}, version: 6},
+		"tcp.seq":                  {kind: fieldInt, columns: []string{"tcp_seq"}},
+		"tcp.ack":                  {kind: fieldInt, columns: []string{"tcp_ack"}},
+		"tcp.window_size_value":    {kind: fieldInt, columns: []string{"tcp_window"}},
+		"tcp.options.mss_val":      {kind: fieldInt, columns: []string{"tcp_mss"}},
+		"tcp.options.wscale.shift": {kind: fieldInt, columns: []string{"tcp_window_scale"}},
+		"tcp.flags.syn":            {kind: fieldBool, columns: []string{"tcp_flag_syn"}},
+		"tcp.flags.ack":            {kind: fieldBool, columns: []string{"tcp_flag_ack"}},
+		"tcp.flags.fin":            {kind: fieldBool, columns: []string{"tcp_flag_fin"}},
+		"tcp.flags.reset":          {kind: fieldBool, columns: []string{"tcp_flag_rst"}},
+		"tcp.flags.push":           {kind: fieldBool, columns: []string{"tcp_flag_psh"}},
+		"tcp.flags.urg":            {kind: fieldBool, columns: []string{"tcp_flag_urg"}},
+		"tcp.flags.ece":            {kind: fieldBool, columns: []string{"tcp_flag_ece"}},
+		"tcp.flags.cwr":            {kind: fieldBool, columns: []string{"tcp_flag_cwr"}},
+	}
+	for _, icmp := range []struct{ prefix, protocol, ident, seq string }{
+		{"icmp", ProtocolICMPv4, "icmp.ident", "icmp.seq"},
+		{"icmpv6", ProtocolICMPv6, "icmpv6.echo.identifier", "icmpv6.echo.sequence_number"},
+	} {
+		fields[icmp.prefix+".type"] = displayField{kind: fieldInt, columns: []string{"icmp_type"}, protocol: icmp.protocol}
+		fields[icmp.prefix+".code"] = displayField{kind: fieldInt, columns: []string{"icmp_code"}, protocol: icmp.protocol}
+		fields[icmp.ident] = displayField{kind: fieldInt, columns: []string{"icmp_echo_id"}, protocol: icmp.protocol}
+		fields[icmp.seq] = displayField{kind: fieldInt, columns: []string{"icmp_echo_seq"}, protocol: icmp.protocol}
+	}
+	for prefix, version := range map[string]int{"ip": 4, "ipv6": 6} {
+		fields[prefix+".src"] = displayField{kind: fieldIP, columns: []string{"source_ip"}, version: version}
+		fields[prefix+".dst"] = displayField{kind: fieldIP, columns: []string{"destination_ip"}, version: version}
+		fields[prefix+".addr"] = displayField{kind: fieldIP, columns: []string{"source_ip", "destination_ip"}, version: version}
+	}
+	for prefix, protocol := range map[string]string{"tcp": ProtocolTCP, "udp": ProtocolUDP, "udplite": ProtocolUDPLite, "sctp": ProtocolSCTP} {
+		fields[prefix+".srcport"] = displayField{kind: fieldInt, columns: []string{"source_port"}, protocol: protocol}
+		fields[prefix+".dstport"] = displayField{kind: fieldInt, columns: []string{"destination_port"}, protocol: protocol}
+		fields[prefix+".port"] = displayField{kind: fieldInt, columns: []string{"source_port", "destination_port"}, protocol: protocol}
+	}
+	return fields
+}()
+
+// displayProtocols are the protocol names a filter can test on their own.
+// Application protocols are recognised by their well known ports.
+var displayProtocols = func() map[string]sqlCondition {
+	protocols := map[string]sqlCondition{
+		"eth":  present("ether_type", fieldInt),
+		"vlan": present("vlan_id", fieldInt),
+		"ip":   compare("ip_version", "=", 4),
+		"ipv6": compare("ip_version", "=", 6),
+		"icmp": compare("protocol", "=", ProtocolICMPv4),
+	}
+	for name, protocol := range protocolNames {
+		if _, ok := protocols[name]; !ok {
+			protocols[name] = compare("protocol", "=", protocol)
+		}
+	}
+	ports := func(transports []string, numbers ...int) sqlCondition {
+		condition := compare("protocol", "IN", transports)
+		var either sqlCondition
+		for i, port := range numbers {
+			match := compare("source_port", "=", port).or(compare("destination_port", "=", port))
+			if i == 0 {
+				either = match
+			} else {
+				either = either.or(match)
+			}
+		}
+		return condition.and(either)
+	}
+	udp := []string{ProtocolUDP}
+	protocols["dns"] = ports([]string{ProtocolTCP, ProtocolUDP}, 53)
+	protocols["mdns"] = ports(udp, 5353)
+	protocols["dhcp"] = ports(udp, 67, 68)
+	protocols["dhcpv6"] = ports(udp, 546, 547)
+	protocols["ntp"] = ports(udp, 123)
+	return protocols
+}()
+
+type displayFilterParser struct {
+	tokens []token
+	index  int
+}
+
+func (p *displayFilterParser) peek() token {
+	return p.tokens[p.index]
+}
+
+func (p *displayFilterParser) next() token {
+	t := p.tokens[p.index]
+	if t.kind != tokenEOF {
+		p.index++
+	}
+	return t
+}
+
+func (p *displayFilterParser) errorAt(t token, message string) error {
+	return &DisplayFilterError{Position: t.pos + 1, Message: message}
+}
+
+func (p *displayFilterParser) parseOr() (sqlCondition, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.peek().kind == tokenOr {
+		p.next()
+		var right sqlCondition
+		if right, err = p.parseAnd(); err == nil {
+			left = left.or(right)
+		}
+	}
+	return left, err
+}
+
+func (p *displayFilterParser) parseAnd() (sqlCondition, error) {
+	left, err := p.parseNot()
+	for err == nil && p.peek().kind == tokenAnd {
+		p.next()
+		var right sqlCondition
+		if right, err = p.parseNot(); err == nil {
+			left = left.and(right)
+		}
+	}
+	return left, err
+}
+
+func (p *displayFilterParser) parseNot() (sqlCondition, error) {
+	if p.peek().kind != tokenNot {
+		return p.parsePrimary()
+	}
+	p.next()
+	condition, err := p.parseNot()
+	return condition.not(), err
+}
+
+func (p *displayFilterParser) parsePrimary() (sqlCondition, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenLParen:
+		condition, err := p.parseOr()
+		if err != nil {
+			return condition, err
+		}
+		if closing := p.next(); closing.kind != tokenRParen {
+			return condition, p.errorAt(closing, "expected )")
+		}
+		return condition, nil
+	case tokenWord:
+		name := strings.ToLower(t.text)
+		if field, ok := displayFields[name]; ok {
+			return p.parseField(t, field)
+		}
+		if protocol, ok := displayProtocols[name]; ok {
+			if next := p.peek(); next.kind == tokenCompare || next.kind == tokenIn {
+				return sqlCondition{}, p.errorAt(next, fmt.Sprintf("%s is a protocol and cannot be compared", t.text))
+			}
+			return protocol, nil
+		}
+		return sqlCondition{}, p.errorAt(t, fmt.Sprintf("unknown field or protocol %q", t.text))
+	case tokenEOF:
+		return sqlCondition{}, p.errorAt(t, "unexpected end of filter")
+	default:
+		return sqlCondition{}, p.errorAt(t, fmt.Sprintf("unexpected %q, expected a field or protocol", t.text))
+	}
+}
+
+// parseField parses the comparison after a field. A field on its own
+// tests that the packet has it.
+func (p *displayFilterParser) parseField(name token, field displayField) (sqlCondition, error) {
+	operator := p.peek()
+	switch operator.kind {
+	case tokenCompare:
+		p.next()
+		value := p.next()
+		condition, err := compareField(field, operator.text, value)
+		if err != nil {
+			return condition, p.errorAt(value, err.Error())
+		}
+		if operator.text == "!=" {
+			return field.restrict(condition).not(), nil
+		}
+		return field.restrict(condition), nil
+	case tokenIn:
+		p.next()
+		condition, err := p.parseSet(field)
+		return field.restrict(condition), err
+	default:
+		var has sqlCondition
+		for i, column := range field.columns {
+			if i == 0 {
+				has = present(column, field.kind)
+			} else {
+				has = has.or(present(column, field.kind))
+			}
+		}
+		return field.restrict(has), nil
+	}
+}
+
+// parseSet parses `{80 443 8000..8100}`, commas between the members are
+// optional.
+func (p *displayFilterParser) parseSet(field displayField) (sqlCondition, error) {
+	if open := p.next(); open.kind != tokenLBrace {
+		return sqlCondition{}, p.errorAt(open, "expected { after in")
+	}
+	var set sqlCondition
+	members := 0
+	for {
+		value := p.next()
+		switch value.kind {
+		case tokenRBrace:
+			if members == 0 {
+				return set, p.errorAt(value, "empty set")
+			}
+			return set, nil
+		case tokenComma:
+			if members == 0 {
+				return set, p.errorAt(value, "expected a value")
+			}
+			continue
+		case tokenEOF:
+			return set, p.errorAt(value, "expected }")
+		}
+		var member sqlCondition
+		var err error
+		if low, high, isRange := strings.Cut(value.text, ".."); isRange && value.kind == tokenWord && field.kind == fieldInt {
+			member, err = compareIntRange(field, low, high)
+		} else {
+			member, err = compareField(field, "==", value)
+		}
+		if err != nil {
+			return set, p.errorAt(value, err.Error())
+		}
+		if members == 0 {
+			set = member
+		} else {
+			set = set.or(member)
+		}
+		members++
+	}
+}
+
+// compareField compares every column of field with value, != compares for
+// equality and is negated by the caller.
+func compareField(field displayField, operator string, value token) (sqlCondition, error) {
+	if value.kind != tokenWord && value.kind != tokenString {
+		return sqlCondition{}, fmt.Errorf("expected a value")
+	}
+	if operator == "!=" {
+		operator = "=="
+	}
+	ordered := operator != "==" && operator != "contains"
+	if operator == "contains" && field.kind != fieldString {
+		return sqlCondition{}, fmt.Errorf("contains only applies to text fields")
+	}
+
+	var column func(string) (sqlCondition, error)
+	switch field.kind {
+	case fieldInt:
+		number, err := strconv.ParseInt(value.text, 0, 64)
+		if err != nil {
+			return sqlCondition{}, fmt.Errorf("%q is not a number", value.text)
+		}
+		column = func(name string) (sqlCondition, error) {
+			return compare(name, sqlOperator(operator), number), nil
+		}
+	case fieldBool:
+		flag, err := strconv.ParseBool(value.text)
+		if err != nil || ordered {
+			return sqlCondition{}, fmt.Errorf("expected == 1 or == 0")
+		}
+		column = func(name string) (sqlCondition, error) {
+			return compare(name, "=", flag), nil
+		}
+	case fieldIP:
+		if ordered {
+			return sqlCondition{}, fmt.Errorf("addresses only compare with ==, != and in")
+		}
+		network, err := parseIPNet(value.text)
+		if err != nil {
+			return sqlCondition{}, err
+		}
+		column = func(name string) (sqlCondition, error) {
+			if ones, bits := network.Mask.Size(); ones == bits {
+				return compare(name, "=", network.IP.String()), nil
+			}
+			low, high := ipKeyRange(network)
+			return compare(name+"_key", "BETWEEN", low, high), nil
+		}
+	case fieldMAC:
+		mac, err := net.ParseMAC(value.text)
+		if err != nil || ordered {
+			return sqlCondition{}, fmt.Errorf("expected a MAC address compared with ==, != or in")
+		}
+		column = func(name string) (sqlCondition, error) {
+			return compare(name, "=", mac.String()), nil
+		}
+	case fieldString:
+		if ordered {
+			return sqlCondition{}, fmt.Errorf("text only compares with ==, !=, in and contains")
+		}
+		column = func(name string) (sqlCondition, error) {
+			if operator == "contains" {
+				return containsText(name, value.text), nil
+			}
+			return compare(name, "=", value.text), nil
+		}
+	case fieldTime:
+		t, err := time.Parse(time.RFC3339, value.text)
+		if err != nil {
+			return sqlCondition{}, fmt.Errorf("%q is not an RFC 3339 time", value.text)
+		}
+		column = func(name string) (sqlCondition, error) {
+			return compare(name, sqlOperator(operator), sqlTime(t)), nil
+		}
+	}
+
+	var condition sqlCondition
+	for i, name := range field.columns {
+		match, err := column(name)
+		if err != nil {
+			return condition, err
+		}
+		if i == 0 {
+			condition = match
+		} else {
+			condition = condition.or(match)
+		}
+	}
+	return condition, nil
+}
+
+func compareIntRange(field displayField, low, high string) (sqlCondition, error) {
+	lowValue, lowErr := strconv.ParseInt(low, 0, 64)
+	highValue, highErr := strconv.ParseInt(high, 0, 64)
+	if lowErr != nil || highErr != nil || lowValue > highValue {
+		return sqlCondition{}, fmt.Errorf("invalid range %s..%s", low, high)
+	}
+	var condition sqlCondition
+	for i, name := range field.columns {
+		match := compare(name, "BETWEEN", lowValue, highValue)
+		if i == 0 {
+			condition = match
+		} else {
+			condition = condition.or(match)
+		}
+	}
+	return condition, nil
+}
+
+func sqlOperator(operator string) string {
+	if operator == "==" {
+		return "="
+	}
+	return operator
+}
+
+func parseIPNet(value string) (*net.IPNet, error) {
+	nets, err := ParseIPNets(value)
+	if err != nil || len(nets) != 1 {
+		return nil, fmt.Errorf("%q is not an IP address or network", value)
+	}
+	return nets[0], nil
+}
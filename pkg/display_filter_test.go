@@ -0,0 +1,136 @@
+package pkg
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseDisplayFilterErrors(t *testing.T) {
+	testCases := []struct {
+		expression string
+		position   int
+	}{
+		{"", 1},
+		{"   ", 4},
+		{"ip.src = 10.0.0.1", 8},
+		{"tcp & udp", 5},
+		{"ip.src == 10.0.0.300", 11},
+		{"tcp.port == http", 13},
+		{"foo.bar == 1", 1},
+		{"tcp.port in {80, 443", 21},
+		{"tcp.port in {}", 14},
+		{"tcp.port in 80", 13},
+		{"(tcp || udp", 12},
+		{"tcp udp", 5},
+		{"tcp.port ==", 12},
+		{"ip.src > 10.0.0.1", 10},
+		{"tcp == 1", 5},
+		{"eth.src contains 00", 18},
+		{`frame.interface_name == "eth0`, 25},
+		{"tcp.port in {90..80}", 14},
+		{"frame.time > yesterday", 14},
+		{"ip.src == 10.0.0.1 $", 20},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.expression, func(t *testing.T) {
+			_, err := ParseDisplayFilter(tc.expression)
+			var displayErr *DisplayFilterError
+			if !errors.As(err, &displayErr) {
+				t.Fatalf("expected a DisplayFilterError, got %v", err)
+			}
+			if !errors.Is(err, ErrInvalidPacketFilter) {
+				t.Errorf("expected the error to wrap ErrInvalidPacketFilter")
+			}
+			if displayErr.Position != tc.position {
+				t.Errorf("expected position %d, got %d (%v)", tc.position, displayErr.Position, err)
+			}
+		})
+	}
+}
+
+func TestGetPacketsWithDisplayFilter(t *testing.T) {
+	repo := setupTestDB(t)
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	stored := []SavedPacket{
+		{SourceIP: "10.1.2.3", DestinationIP: "192.168.1.10", SourcePort: intPtr(40000), DestinationPort: intPtr(443), Protocol: ProtocolTCP, IPVersion: intPtr(4), TTL: intPtr(64), TCPFlagSYN: ptr(true), SourceMAC: "00:11:22:33:44:55", EtherType: intPtr(0x0800), DeviceID: "eth0"},
+		{SourceIP: "192.168.1.10", DestinationIP: "10.1.2.3", SourcePort: intPtr(443), DestinationPort: intPtr(40000), Protocol: ProtocolTCP, IPVersion: intPtr(4), TTL: intPtr(128), TCPFlagSYN: ptr(false), DeviceID: "eth0"},
+		{SourceIP: "10.1.2.3", DestinationIP: "8.8.8.8", SourcePort: intPtr(5353), DestinationPort: intPtr(53), Protocol: ProtocolUDP, IPVersion: intPtr(4), TTL: intPtr(64), VLANID: intPtr(10), DeviceID: "wlan0"},
+		{SourceIP: "2001:db8::1", DestinationIP: "2001:db8::2", Protocol: ProtocolICMPv6, IPVersion: intPtr(6), TTL: intPtr(255), ICMPType: intPtr(128), ICMPEchoID: intPtr(7), DeviceID: "wlan0"},
+		{SourceIP: "10.1.2.3", DestinationIP: "10.1.2.1", Protocol: ProtocolARP, DeviceID: "eth0"},
+	}
+	for i := range stored {
+		packet := &stored[i]
+		packet.CreatedAt = start.Add(time.Duration(i) * time.Minute)
+		packet.UpdatedAt = packet.CreatedAt
+		packet.SourceIPKey = ipKey(packet.SourceIP)
+		packet.DestinationIPKey = ipKey(packet.DestinationIP)
+		if err := repo.db.Create(packet).Error; err != nil {
+			t.Fatalf("failed to create packet: %v", err)
+		}
+	}
+
+	testCases := []struct {
+		expression string
+		expected   []uint
+	}{
+		{"tcp", []uint{1, 2}},
+		{"ip.src == 10.0.0.0/8 && tcp.dstport in {80,443} && !dns", []uint{1}},
+		{"!dns", []uint{1, 2, 4, 5}},
+		{"dns", []uint{3}},
+		{"ip.addr == 10.1.2.3", []uint{1, 2, 3}},
+		{"ip.addr != 10.1.2.3", []uint{4, 5}},
+		{"arp.src.proto_ipv4 == 10.1.2.3", []uint{5}},
+		{"ipv6.addr == 2001:db8::/32", []uint{4}},
+		{"tcp.port in {400..500 8000}", []uint{1, 2}},
+		{"udp.port == 443", nil},
+		{"ip.ttl > 64 or ipv6.hlim ge 255", []uint{2, 4}},
+		{"not (ip.ttl == 64)", []uint{2, 4, 5}},
+		{"tcp.flags.syn == 1", []uint{1}},
+		{"tcp.flags.syn", []uint{1, 2}},
+		{"eth.src == 00-11-22-33-44-55", []uint{1}},
+		{"eth.type == 0x0800", []uint{1}},
+		{"eth", []uint{1}},
+		{"vlan.id == 10 || icmpv6", []uint{3, 4}},
+		{"vlan", []uint{3}},
+		{`frame.interface_name == "wlan0" && frame.time >= "2024-01-01T12:03:00Z"`, []uint{4}},
+		{`frame.interface_name contains "lan"`, []uint{3, 4}},
+		{"ICMPv6 || ARP", []uint{4, 5}},
+		{"icmpv6.type == 128 && icmpv6.echo.identifier == 7", []uint{4}},
+		{"icmp.type == 128", nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.expression, func(t *testing.T) {
+			query, err := ParseDisplayFilter(tc.expression)
+			if err != nil {
+				t.Fatalf("ParseDisplayFilter returned error: %v", err)
+			}
+			packets, err := repo.GetPackets(PacketFilter{Sort: "id", Order: SortAscending, Query: query})
+			if err != nil {
+				t.Fatalf("GetPackets returned error: %v", err)
+			}
+			if len(packets) != len(tc.expected) {
+				t.Fatalf("expected packets %v, got %d: %+v", tc.expected, len(packets), packets)
+			}
+			for i, packet := range packets {
+				if packet.ID != tc.expected[i] {
+					t.Fatalf("expected packets %v, got packet %d at %d", tc.expected, packet.ID, i)
+				}
+			}
+
+			// The filter matches the same packets in memory.
+			var matched []uint
+			for i := range stored {
+				if query.Matches(&stored[i]) {
+					matched = append(matched, stored[i].ID)
+				}
+			}
+			if !slices.Equal(matched, tc.expected) {
+				t.Errorf("expected Matches to select %v, got %v", tc.expected, matched)
+			}
+		})
+	}
+}
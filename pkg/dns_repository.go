@@ -0,0 +1,199 @@
+package pkg
+
+import (
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DNSEvent is a DNS query with its response. Time is when the query was
+// seen, or the response when the query was not captured. The response
+// fields are empty for queries that got no answer.
+type DNSEvent struct {
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	Time          time.Time  `gorm:"not null;index" json:"time"`
+	RespondedAt   *time.Time `json:"responded_at"`
+	LatencyMicros *int64     `json:"latency_us"`
+	DeviceID      string     `gorm:"not null" json:"device"`
+	ClientIP      string     `gorm:"not null" json:"client_ip"`
+	ClientIPKey   string     `gorm:"index" json:"-"`
+	ClientPort    int        `json:"client_port"`
+	ServerIP      string     `gorm:"not null" json:"server_ip"`
+	ServerPort    int        `json:"server_port"`
+	TransactionID uint16     `json:"transaction_id"`
+	// Name is lower case without the final dot, ReversedName has its labels
+	// in reverse order for suffix searches.
+	Name         string      `gorm:"not null" json:"name"`
+	ReversedName string      `gorm:"index" json:"-"`
+	Type         string      `gorm:"index" json:"type"`
+	RCode        string      `gorm:"column:rcode;index" json:"rcode"`
+	Answers      []DNSAnswer `gorm:"serializer:json" json:"answers"`
+}
+
+type DNSAnswer struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	TTL  uint32 `json:"ttl"`
+	Data string `json:"data"`
+}
+
+// PassiveDNSRecord is an address seen in the answers to a name, the
+// passive DNS map used to name the endpoints of packets.
+type PassiveDNSRecord struct {
+	ID        uint      `gorm:"primaryKey"`
+	IP        string    `gorm:"not null;uniqueIndex:idx_passive_dns_ip_name"`
+	Name      string    `gorm:"not null;uniqueIndex:idx_passive_dns_ip_name"`
+	FirstSeen time.Time `gorm:"not null"`
+	LastSeen  time.Time `gorm:"not null"`
+}
+
+func (PassiveDNSRecord) TableName() string {
+	return "passive_dns"
+}
+
+// DNSFilter selects the events returned by GetDNSEvents, newest first.
+// Name matches the name and its subdomains.
+type DNSFilter struct {
+	Limit     int
+	From      time.Time
+	To        time.Time
+	Name      string
+	ClientIPs []*net.IPNet
+	Types     []string
+	RCodes    []string
+}
+
+type DNSRepository interface {
+	// SaveDNSEvents stores the events and adds their answers to the
+	// passive DNS map.
+	SaveDNSEvents(events []DNSEvent) error
+	GetDNSEvents(filter DNSFilter) ([]DNSEvent, error)
+	// ResolveHostnames returns the name last seen for each address that
+	// has one.
+	ResolveHostnames(ips []string) (map[string]string, error)
+}
+
+type SqlLiteDNSRepository struct {
+	db *gorm.DB
+}
+
+func (r *SqlLiteDNSRepository) SaveDNSEvents(events []DNSEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	records := make([]PassiveDNSRecord, 0)
+	for i := range events {
+		events[i].ClientIPKey = ipKey(events[i].ClientIP)
+		events[i].ReversedName = reverseDNSName(events[i].Name)
+		records = append(records, passiveDNSRecords(events[i])...)
+	}
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.CreateInBatches(events, 100).Error; err != nil {
+			return err
+		}
+		if len(records) == 0 {
+			return nil
+		}
+		// A late batch of older sightings does not move the times back.
+		return tx.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "ip"}, {Name: "name"}},
+			DoUpdates: clause.Set{
+				{Column: clause.Column{Name: "first_seen"}, Value: gorm.Expr("MIN(passive_dns.first_seen, excluded.first_seen)")},
+				{Column: clause.Column{Name: "last_seen"}, Value: gorm.Expr("MAX(passive_dns.last_seen, excluded.last_seen)")},
+			},
+		}).CreateInBatches(records, 100).Error
+	})
+}
+
+func (r *SqlLiteDNSRepository) GetDNSEvents(filter DNSFilter) ([]DNSEvent, error) {
+	events := make([]DNSEvent, 0)
+	query := r.db.Model(&DNSEvent{})
+	query = timeRange(query, "time", filter.From, filter.To)
+	if filter.Name != "" {
+		query = query.Where(nameCondition(query, filter.Name, "reversed_name"))
+	}
+	if len(filter.ClientIPs) > 0 {
+		query = query.Where(ipCondition(query, filter.ClientIPs, "client"))
+	}
+	if len(filter.Types) > 0 {
+		query = query.Where("type IN ?", filter.Types)
+	}
+	if len(filter.RCodes) > 0 {
+		query = query.Where("rcode IN ?", filter.RCodes)
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+	result := query.Order("time desc").Order("id desc").Find(&events)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return events, nil
+}
+
+func (r *SqlLiteDNSRepository) ResolveHostnames(ips []string) (map[string]string, error) {
+	names := make(map[string]string)
+	if len(ips) == 0 {
+		return names, nil
+	}
+	var records []PassiveDNSRecord
+	// The latest record of an address comes last and wins.
+	result := r.db.Where("ip IN ?", ips).Order("last_seen asc").Find(&records)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	for _, record := range records {
+		names[record.IP] = record.Name
+	}
+	return names, nil
+}
+
+// passiveDNSRecords maps the addresses of the answers to the name that was
+// asked, rather than to the CNAME they belong to.
+func passiveDNSRecords(event DNSEvent) []PassiveDNSRecord {
+	records := make([]PassiveDNSRecord, 0)
+	seen := event.Time
+	if event.RespondedAt != nil {
+		seen = *event.RespondedAt
+	}
+	for _, answer := range event.Answers {
+		if answer.Type != "A" && answer.Type != "AAAA" {
+			continue
+		}
+		if slices.ContainsFunc(records, func(r PassiveDNSRecord) bool { return r.IP == answer.Data }) {
+			continue
+		}
+		records = append(records, PassiveDNSRecord{IP: answer.Data, Name: event.Name, FirstSeen: seen, LastSeen: seen})
+	}
+	return records
+}
+
+// nameCondition matches a column of reversed names against a name and its
+// subdomains. The subdomains of a name sort right after it followed by a
+// dot, and before it followed by "/", the next character.
+func nameCondition(query *gorm.DB, name, column string) *gorm.DB {
+	reversed := reverseDNSName(name)
+	return query.Session(&gorm.Session{NewDB: true}).
+		Where(column+" = ? OR ("+column+" >= ? AND "+column+" < ?)", reversed, reversed+".", reversed+"/")
+}
+
+// reverseDNSName turns "www.example.com" into "com.example.www".
+func reverseDNSName(name string) string {
+	labels := strings.Split(normalizeDNSName(name), ".")
+	slices.Reverse(labels)
+	return strings.Join(labels, ".")
+}
+
+func normalizeDNSName(name string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+}
+
+func NewSqlLiteDNSRepository(db *gorm.DB) DNSRepository {
+	db.AutoMigrate(&DNSEvent{}, &PassiveDNSRecord{})
+
+	return &SqlLiteDNSRepository{db: db}
+}
@@ -0,0 +1,106 @@
+package pkg
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupDNSTestDB(t *testing.T) DNSRepository {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open test database: %v", err)
+	}
+	return NewSqlLiteDNSRepository(db)
+}
+
+func TestDNSRepositoryGetDNSEvents(t *testing.T) {
+	repo := setupDNSTestDB(t)
+	start := time.Now().Add(-time.Hour)
+	events := []DNSEvent{
+		{Time: start, DeviceID: "eth0", ClientIP: "10.0.0.5", ServerIP: "10.0.0.53", Name: "example.com", Type: "A", RCode: "NOERROR"},
+		{Time: start.Add(time.Minute), DeviceID: "eth0", ClientIP: "10.0.0.6", ServerIP: "10.0.0.53", Name: "www.example.com", Type: "AAAA", RCode: "NOERROR"},
+		{Time: start.Add(2 * time.Minute), DeviceID: "eth0", ClientIP: "10.0.1.7", ServerIP: "10.0.0.53", Name: "badexample.com", Type: "A", RCode: "NXDOMAIN"},
+		{Time: start.Add(3 * time.Minute), DeviceID: "eth0", ClientIP: "10.0.0.5", ServerIP: "10.0.0.53", Name: "example.com.evil.org", Type: "A"},
+	}
+	if err := repo.SaveDNSEvents(events); err != nil {
+		t.Fatalf("failed to save events: %v", err)
+	}
+
+	_, clients, _ := net.ParseCIDR("10.0.0.0/24")
+	tests := []struct {
+		name   string
+		filter DNSFilter
+		want   []string
+	}{
+		{"all newest first", DNSFilter{}, []string{"example.com.evil.org", "badexample.com", "www.example.com", "example.com"}},
+		{"name suffix", DNSFilter{Name: "Example.COM."}, []string{"www.example.com", "example.com"}},
+		{"subdomain", DNSFilter{Name: "www.example.com"}, []string{"www.example.com"}},
+		{"client network", DNSFilter{ClientIPs: []*net.IPNet{clients}}, []string{"example.com.evil.org", "www.example.com", "example.com"}},
+		{"type and rcode", DNSFilter{Types: []string{"A"}, RCodes: []string{"NOERROR"}}, []string{"example.com"}},
+		{"time range", DNSFilter{From: start.Add(time.Minute), To: start.Add(3 * time.Minute)}, []string{"badexample.com", "www.example.com"}},
+		{"limit", DNSFilter{Limit: 1}, []string{"example.com.evil.org"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := repo.GetDNSEvents(tt.filter)
+			if err != nil {
+				t.Fatalf("GetDNSEvents returned error: %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("expected %v, got %d events", tt.want, len(got))
+			}
+			for i, event := range got {
+				if event.Name != tt.want[i] {
+					t.Fatalf("expected %v, got %s at %d", tt.want, event.Name, i)
+				}
+			}
+		})
+	}
+}
+
+func TestDNSRepositoryResolveHostnames(t *testing.T) {
+	repo := setupDNSTestDB(t)
+	start := time.Now().Add(-time.Hour)
+	later := start.Add(time.Minute)
+	events := []DNSEvent{
+		{Time: start, DeviceID: "eth0", ClientIP: "10.0.0.5", ServerIP: "10.0.0.53", Name: "www.example.com", Type: "A", RCode: "NOERROR",
+			Answers: []DNSAnswer{
+				{Name: "www.example.com", Type: "CNAME", Data: "edge.example.net"},
+				{Name: "edge.example.net", Type: "A", Data: "93.184.216.34"},
+				{Name: "edge.example.net", Type: "AAAA", Data: "2606:2800:220:1::248"},
+			}},
+	}
+	if err := repo.SaveDNSEvents(events); err != nil {
+		t.Fatalf("failed to save events: %v", err)
+	}
+	// The same address answered for another name later wins.
+	events = []DNSEvent{
+		{Time: later, RespondedAt: &later, DeviceID: "eth0", ClientIP: "10.0.0.5", ServerIP: "10.0.0.53", Name: "cdn.example.org", Type: "A", RCode: "NOERROR",
+			Answers: []DNSAnswer{{Name: "cdn.example.org", Type: "A", Data: "93.184.216.34"}}},
+	}
+	if err := repo.SaveDNSEvents(events); err != nil {
+		t.Fatalf("failed to save events: %v", err)
+	}
+
+	// An older sighting stored late, from a lagging device, changes nothing.
+	earlier := start.Add(-time.Minute)
+	events = []DNSEvent{
+		{Time: earlier, RespondedAt: &earlier, DeviceID: "eth1", ClientIP: "10.0.0.6", ServerIP: "10.0.0.53", Name: "cdn.example.org", Type: "A", RCode: "NOERROR",
+			Answers: []DNSAnswer{{Name: "cdn.example.org", Type: "A", Data: "93.184.216.34"}}},
+	}
+	if err := repo.SaveDNSEvents(events); err != nil {
+		t.Fatalf("failed to save events: %v", err)
+	}
+
+	names, err := repo.ResolveHostnames([]string{"93.184.216.34", "2606:2800:220:1::248", "10.0.0.1"})
+	if err != nil {
+		t.Fatalf("ResolveHostnames returned error: %v", err)
+	}
+	if len(names) != 2 || names["93.184.216.34"] != "cdn.example.org" || names["2606:2800:220:1::248"] != "www.example.com" {
+		t.Errorf("unexpected names %v", names)
+	}
+}
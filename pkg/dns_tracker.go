@@ -0,0 +1,291 @@
+package pkg
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// dnsQueryTimeout is how long a query waits for its response before it is
+// emitted unanswered.
+const dnsQueryTimeout = 30 * time.Second
+
+// dnsResponseCodes are the names dig gives to the response codes.
+var dnsResponseCodes = map[layers.DNSResponseCode]string{
+	layers.DNSResponseCodeNoErr:    "NOERROR",
+	layers.DNSResponseCodeFormErr:  "FORMERR",
+	layers.DNSResponseCodeServFail: "SERVFAIL",
+	layers.DNSResponseCodeNXDomain: "NXDOMAIN",
+	layers.DNSResponseCodeNotImp:   "NOTIMP",
+	layers.DNSResponseCodeRefused:  "REFUSED",
+	layers.DNSResponseCodeYXDomain: "YXDOMAIN",
+	layers.DNSResponseCodeYXRRSet:  "YXRRSET",
+	layers.DNSResponseCodeNXRRSet:  "NXRRSET",
+	layers.DNSResponseCodeNotAuth:  "NOTAUTH",
+	layers.DNSResponseCodeNotZone:  "NOTZONE",
+}
+
+// DNSTracker matches the DNS queries carried over UDP, and over TCP in the
+// reassembled streams of the flows, with their responses by client,
+// server and transaction ID.
+type DNSTracker struct {
+	mu       sync.Mutex
+	pending  map[dnsQueryKey]*pendingDNSQuery
+	finished []DNSEvent
+}
+
+type dnsQueryKey struct {
+	device        string
+	client        string
+	clientPort    int
+	server        string
+	transactionID uint16
+}
+
+type pendingDNSQuery struct {
+	event   DNSEvent
+	arrival time.Time
+}
+
+func NewDNSTracker() *DNSTracker {
+	return &DNSTracker{pending: make(map[dnsQueryKey]*pendingDNSQuery)}
+}
+
+// Add records the DNS message of the packet, if it carries one over UDP.
+func (t *DNSTracker) Add(packet AppPacket) {
+	if packet.Data == nil {
+		return
+	}
+	dns, ok := packet.Data.Layer(layers.LayerTypeDNS).(*layers.DNS)
+	if !ok || len(dns.Questions) == 0 {
+		return
+	}
+	udp, ok := packet.Data.Layer(layers.LayerTypeUDP).(*layers.UDP)
+	if !ok || packet.Data.NetworkLayer() == nil {
+		return
+	}
+	netFlow := packet.Data.NetworkLayer().NetworkFlow()
+	message := dnsMessage{
+		dns:             dns,
+		device:          packet.DeviceID,
+		source:          net.IP(netFlow.Src().Raw()).String(),
+		sourcePort:      int(udp.SrcPort),
+		destination:     net.IP(netFlow.Dst().Raw()).String(),
+		destinationPort: int(udp.DstPort),
+		at:              packet.CreatedAt,
+		arrival:         packet.UpdatedAt,
+	}
+	if message.arrival.IsZero() {
+		message.arrival = packet.CreatedAt
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.addMessage(message)
+}
+
+// AddFlow records the DNS messages of the reassembled stream of a TCP flow
+// to or from port 53, each prefixed with its two byte length. Decoding a
+// direction stops at its first message that is cut or not DNS.
+func (t *DNSTracker) AddFlow(flow Flow) {
+	if flow.Protocol != ProtocolTCP || flow.Payload == nil || flow.SourcePort == nil || flow.DestinationPort == nil ||
+		*flow.SourcePort != 53 && *flow.DestinationPort != 53 {
+		return
+	}
+	messages := append(flowDNSMessages(flow, true), flowDNSMessages(flow, false)...)
+	// The queries go first for the responses to find them.
+	slices.SortStableFunc(messages, func(a, b dnsMessage) int {
+		if a.dns.QR == b.dns.QR {
+			return a.at.Compare(b.at)
+		}
+		if a.dns.QR {
+			return 1
+		}
+		return -1
+	})
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, message := range messages {
+		t.addMessage(message)
+	}
+}
+
+// dnsMessage is a DNS message with its endpoints. at is when it was
+// captured, arrival when it was received for the query timeout.
+type dnsMessage struct {
+	dns             *layers.DNS
+	device          string
+	source          string
+	sourcePort      int
+	destination     string
+	destinationPort int
+	at              time.Time
+	arrival         time.Time
+}
+
+// flowDNSMessages decodes the length prefixed DNS messages sent by one
+// side of a flow.
+func flowDNSMessages(flow Flow, fromSource bool) []dnsMessage {
+	data := flow.Payload.SourceData
+	template := dnsMessage{device: flow.DeviceID, source: flow.SourceIP, sourcePort: *flow.SourcePort, destination: flow.DestinationIP, destinationPort: *flow.DestinationPort}
+	if !fromSource {
+		data = flow.Payload.DestinationData
+		template.source, template.sourcePort, template.destination, template.destinationPort =
+			template.destination, template.destinationPort, template.source, template.sourcePort
+	}
+	times := newStreamTimes(flow.Payload.Segments, fromSource)
+
+	var messages []dnsMessage
+	for offset := 0; offset+2 <= len(data); {
+		length := int(binary.BigEndian.Uint16(data[offset:]))
+		end := offset + 2 + length
+		if length == 0 || end > len(data) {
+			break
+		}
+		dns := &layers.DNS{}
+		if err := dns.DecodeFromBytes(data[offset+2:end], gopacket.NilDecodeFeedback); err != nil {
+			break
+		}
+		if len(dns.Questions) > 0 {
+			message := template
+			message.dns = dns
+			if message.at = times.at(offset); message.at.IsZero() {
+				message.at = flow.StartedAt
+			}
+			message.arrival = message.at
+			messages = append(messages, message)
+		}
+		offset = end
+	}
+	return messages
+}
+
+// addMessage holds a query until its response comes, and completes the
+// event of the query with a response.
+func (t *DNSTracker) addMessage(message dnsMessage) {
+	dns := message.dns
+	if !dns.QR {
+		key := dnsQueryKey{message.device, message.source, message.sourcePort, message.destination, dns.ID}
+		t.pending[key] = &pendingDNSQuery{
+			event: DNSEvent{
+				Time:          message.at,
+				DeviceID:      message.device,
+				ClientIP:      message.source,
+				ClientPort:    message.sourcePort,
+				ServerIP:      message.destination,
+				ServerPort:    message.destinationPort,
+				TransactionID: dns.ID,
+				Name:          normalizeDNSName(string(dns.Questions[0].Name)),
+				Type:          dns.Questions[0].Type.String(),
+			},
+			arrival: message.arrival,
+		}
+		return
+	}
+
+	key := dnsQueryKey{message.device, message.destination, message.destinationPort, message.source, dns.ID}
+	event := DNSEvent{
+		Time:          message.at,
+		DeviceID:      message.device,
+		ClientIP:      message.destination,
+		ClientPort:    message.destinationPort,
+		ServerIP:      message.source,
+		ServerPort:    message.sourcePort,
+		TransactionID: dns.ID,
+		Name:          normalizeDNSName(string(dns.Questions[0].Name)),
+		Type:          dns.Questions[0].Type.String(),
+	}
+	if query, ok := t.pending[key]; ok {
+		delete(t.pending, key)
+		event = query.event
+		latency := message.at.Sub(event.Time).Microseconds()
+		event.LatencyMicros = &latency
+	}
+	respondedAt := message.at
+	event.RespondedAt = &respondedAt
+	event.RCode = dnsResponseCode(dns.ResponseCode)
+	event.Answers = make([]DNSAnswer, 0, len(dns.Answers))
+	for _, answer := range dns.Answers {
+		event.Answers = append(event.Answers, DNSAnswer{
+			Name: normalizeDNSName(string(answer.Name)),
+			Type: answer.Type.String(),
+			TTL:  answer.TTL,
+			Data: dnsRecordData(answer),
+		})
+	}
+	t.finished = append(t.finished, event)
+}
+
+// Expire returns the events completed since the last call and the queries
+// that got no response in time.
+func (t *DNSTracker) Expire(now time.Time) []DNSEvent {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for key, query := range t.pending {
+		if now.Sub(query.arrival) >= dnsQueryTimeout {
+			t.finished = append(t.finished, query.event)
+			delete(t.pending, key)
+		}
+	}
+	return t.takeFinished()
+}
+
+// Flush returns every event, including the queries still waiting.
+func (t *DNSTracker) Flush() []DNSEvent {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for key, query := range t.pending {
+		t.finished = append(t.finished, query.event)
+		delete(t.pending, key)
+	}
+	return t.takeFinished()
+}
+
+func (t *DNSTracker) takeFinished() []DNSEvent {
+	events := t.finished
+	t.finished = nil
+	slices.SortFunc(events, func(a, b DNSEvent) int { return a.Time.Compare(b.Time) })
+	return events
+}
+
+func dnsResponseCode(code layers.DNSResponseCode) string {
+	if name, ok := dnsResponseCodes[code]; ok {
+		return name
+	}
+	return fmt.Sprintf("RCODE%d", code)
+}
+
+// dnsRecordData formats the data of a record the way dig prints it.
+func dnsRecordData(rr layers.DNSResourceRecord) string {
+	switch rr.Type {
+	case layers.DNSTypeA, layers.DNSTypeAAAA:
+		return rr.IP.String()
+	case layers.DNSTypeCNAME:
+		return normalizeDNSName(string(rr.CNAME))
+	case layers.DNSTypeNS:
+		return normalizeDNSName(string(rr.NS))
+	case layers.DNSTypePTR:
+		return normalizeDNSName(string(rr.PTR))
+	case layers.DNSTypeMX:
+		return fmt.Sprintf("%d %s", rr.MX.Preference, normalizeDNSName(string(rr.MX.Name)))
+	case layers.DNSTypeSRV:
+		return fmt.Sprintf("%d %d %d %s", rr.SRV.Priority, rr.SRV.Weight, rr.SRV.Port, normalizeDNSName(string(rr.SRV.Name)))
+	case layers.DNSTypeSOA:
+		return fmt.Sprintf("%s %s %d", normalizeDNSName(string(rr.SOA.MName)), normalizeDNSName(string(rr.SOA.RName)), rr.SOA.Serial)
+	case layers.DNSTypeTXT:
+		texts := make([]string, len(rr.TXTs))
+		for i, text := range rr.TXTs {
+			texts[i] = fmt.Sprintf("%q", text)
+		}
+		return strings.Join(texts, " ")
+	}
+	return fmt.Sprintf("%x", rr.Data)
+}
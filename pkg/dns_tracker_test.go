@@ -0,0 +1,143 @@
+package pkg
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// dnsTestPacket builds a DNS message over UDP from src to dst. A message
+// with answers or a response code is a response.
+func dnsTestPacket(t *testing.T, src, dst string, srcPort, dstPort int, id uint16, name string, response bool, rcode layers.DNSResponseCode, answers []layers.DNSResourceRecord, at time.Time) AppPacket {
+	t.Helper()
+	ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: net.ParseIP(src), DstIP: net.ParseIP(dst)}
+	udp := &layers.UDP{SrcPort: layers.UDPPort(srcPort), DstPort: layers.UDPPort(dstPort)}
+	udp.SetNetworkLayerForChecksum(ip)
+	dns := &layers.DNS{
+		ID: id, QR: response, RD: true, ResponseCode: rcode,
+		Questions: []layers.DNSQuestion{{Name: []byte(name), Type: layers.DNSTypeA, Class: layers.DNSClassIN}},
+		Answers:   answers,
+	}
+	eth := &layers.Ethernet{SrcMAC: testSrcMAC, DstMAC: testDstMAC, EthernetType: layers.EthernetTypeIPv4}
+	packet := serializeTestFrame(t, layers.LayerTypeEthernet, eth, ip, udp, dns)
+	return AppPacket{Data: packet, CreatedAt: at, UpdatedAt: at, DeviceID: "eth0", LinkType: layers.LinkTypeEthernet}
+}
+
+func TestDNSTrackerMatchesResponses(t *testing.T) {
+	tracker := NewDNSTracker()
+	start := time.Now()
+	answers := []layers.DNSResourceRecord{
+		{Name: []byte("www.example.com"), Type: layers.DNSTypeCNAME, Class: layers.DNSClassIN, TTL: 300, CNAME: []byte("edge.example.net")},
+		{Name: []byte("edge.example.net"), Type: layers.DNSTypeA, Class: layers.DNSClassIN, TTL: 60, IP: net.ParseIP("93.184.216.34").To4()},
+	}
+	tracker.Add(dnsTestPacket(t, "10.0.0.5", "10.0.0.53", 40000, 53, 7, "WWW.Example.com", false, 0, nil, start))
+	// Same ID from another client port, never answered.
+	tracker.Add(dnsTestPacket(t, "10.0.0.5", "10.0.0.53", 40001, 53, 7, "other.example.com", false, 0, nil, start))
+	tracker.Add(dnsTestPacket(t, "10.0.0.53", "10.0.0.5", 53, 40000, 7, "www.example.com", true, layers.DNSResponseCodeNoErr, answers, start.Add(12*time.Millisecond)))
+
+	events := tracker.Expire(start.Add(time.Second))
+	if len(events) != 1 {
+		t.Fatalf("expected 1 answered query, got %+v", events)
+	}
+	event := events[0]
+	if event.Name != "www.example.com" || event.Type != "A" || event.RCode != "NOERROR" || event.TransactionID != 7 {
+		t.Errorf("unexpected event %+v", event)
+	}
+	if event.ClientIP != "10.0.0.5" || event.ClientPort != 40000 || event.ServerIP != "10.0.0.53" || event.ServerPort != 53 {
+		t.Errorf("unexpected endpoints %+v", event)
+	}
+	if event.LatencyMicros == nil || *event.LatencyMicros != 12000 || !event.Time.Equal(start) {
+		t.Errorf("expected 12ms latency from the query time, got %+v", event)
+	}
+	want := []DNSAnswer{
+		{Name: "www.example.com", Type: "CNAME", TTL: 300, Data: "edge.example.net"},
+		{Name: "edge.example.net", Type: "A", TTL: 60, Data: "93.184.216.34"},
+	}
+	if len(event.Answers) != len(want) || event.Answers[0] != want[0] || event.Answers[1] != want[1] {
+		t.Errorf("expected answers %+v, got %+v", want, event.Answers)
+	}
+
+	unanswered := tracker.Expire(start.Add(dnsQueryTimeout))
+	if len(unanswered) != 1 || unanswered[0].Name != "other.example.com" || unanswered[0].RCode != "" || unanswered[0].RespondedAt != nil {
+		t.Fatalf("expected the unanswered query, got %+v", unanswered)
+	}
+}
+
+func TestDNSTrackerResponseWithoutQuery(t *testing.T) {
+	tracker := NewDNSTracker()
+	at := time.Now()
+	tracker.Add(dnsTestPacket(t, "10.0.0.53", "10.0.0.5", 53, 40000, 9, "missing.example.com", true, layers.DNSResponseCodeNXDomain, nil, at))
+
+	events := tracker.Flush()
+	if len(events) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(events))
+	}
+	if events[0].RCode != "NXDOMAIN" || events[0].LatencyMicros != nil || events[0].ClientIP != "10.0.0.5" || !events[0].Time.Equal(at) {
+		t.Errorf("unexpected event %+v", events[0])
+	}
+}
+
+func TestDNSTrackerIgnoresOtherPackets(t *testing.T) {
+	tracker := NewDNSTracker()
+	tracker.Add(udpTestPacket(t, "10.0.0.1", "10.0.0.2", 4000, 4001, time.Now()))
+	tracker.Add(AppPacket{})
+
+	if events := tracker.Flush(); len(events) != 0 {
+		t.Fatalf("expected no events, got %+v", events)
+	}
+}
+
+// tcpDNSTestMessage returns a DNS message with its two byte length prefix,
+// as sent over TCP.
+func tcpDNSTestMessage(t *testing.T, dns *layers.DNS) []byte {
+	t.Helper()
+	buf := gopacket.NewSerializeBuffer()
+	if err := dns.SerializeTo(buf, gopacket.SerializeOptions{FixLengths: true}); err != nil {
+		t.Fatalf("failed to serialize DNS: %v", err)
+	}
+	return append(binary.BigEndian.AppendUint16(nil, uint16(len(buf.Bytes()))), buf.Bytes()...)
+}
+
+func TestDNSTrackerDecodesTCPStreams(t *testing.T) {
+	tracker := NewDNSTracker()
+	start := time.Now()
+	question := []layers.DNSQuestion{{Name: []byte("example.com"), Type: layers.DNSTypeA, Class: layers.DNSClassIN}}
+	answers := []layers.DNSResourceRecord{{Name: []byte("www.example.com"), Type: layers.DNSTypeA, Class: layers.DNSClassIN, TTL: 300, IP: net.ParseIP("10.0.0.80").To4()}}
+	query := tcpDNSTestMessage(t, &layers.DNS{ID: 9, Questions: question})
+	first := tcpDNSTestMessage(t, &layers.DNS{ID: 9, QR: true, Questions: question, Answers: answers})
+	second := tcpDNSTestMessage(t, &layers.DNS{ID: 9, QR: true, Questions: question, Answers: answers})
+	flow := Flow{
+		DeviceID: "eth0", Protocol: ProtocolTCP, StartedAt: start,
+		SourceIP: "10.0.0.5", SourcePort: intPtr(40000), DestinationIP: "10.0.0.53", DestinationPort: intPtr(53),
+		Payload: &FlowPayload{
+			SourceData: query,
+			// The second response is cut by the payload limit.
+			DestinationData: append(first, second[:len(second)-1]...),
+			Segments: []StreamSegment{
+				{FromSource: true, Length: len(query), Time: start},
+				{Length: len(first), Time: start.Add(5 * time.Millisecond)},
+				{Length: len(second) - 1, Time: start.Add(6 * time.Millisecond)},
+			},
+		},
+	}
+	tracker.AddFlow(flow)
+	// UDP flows are read packet by packet.
+	flow.Protocol = ProtocolUDP
+	tracker.AddFlow(flow)
+
+	events := tracker.Flush()
+	if len(events) != 1 {
+		t.Fatalf("expected 1 event, got %+v", events)
+	}
+	event := events[0]
+	if event.Name != "example.com" || event.Type != "A" || event.ClientIP != "10.0.0.5" || event.ClientPort != 40000 || event.ServerPort != 53 {
+		t.Errorf("unexpected event %+v", event)
+	}
+	if event.LatencyMicros == nil || *event.LatencyMicros != 5000 || len(event.Answers) != 1 || event.Answers[0].Data != "10.0.0.80" {
+		t.Errorf("expected the matched response, got %+v", event)
+	}
+}
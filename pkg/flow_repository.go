@@ -0,0 +1,186 @@
+package pkg
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Reasons a flow record was emitted.
+const (
+	FlowEndIdle     = "idle"
+	FlowEndActive   = "active"
+	FlowEndFIN      = "fin"
+	FlowEndRST      = "rst"
+	FlowEndShutdown = "shutdown"
+)
+
+// TCP states of a flow, as far as the captured segments tell.
+const (
+	TCPStateSynSent     = "syn_sent"
+	TCPStateSynReceived = "syn_received"
+	TCPStateEstablished = "established"
+	TCPStateFinWait     = "fin_wait"
+	TCPStateClosed      = "closed"
+	TCPStateReset       = "reset"
+)
+
+var ErrFlowNotFound = errors.New("flow not found")
+
+// Flow is a finished conversation between two endpoints. The source is the
+// side that opened it: the sender of the SYN for TCP, of the first packet
+// otherwise. A flow longer than the active timeout is stored as several
+// records, each counting its own packets.
+type Flow struct {
+	ID        uint   `gorm:"primaryKey" json:"id"`
+	DeviceID  string `gorm:"not null;index" json:"device"`
+	VLANID    *int   `json:"vlan_id"`
+	Protocol  string `gorm:"not null;index" json:"protocol"`
+	IPVersion int    `json:"ip_version"`
+
+	SourceIP         string `gorm:"not null" json:"source_ip"`
+	SourcePort       *int   `json:"source_port"`
+	DestinationIP    string `gorm:"not null" json:"destination_ip"`
+	DestinationPort  *int   `json:"destination_port"`
+	SourceIPKey      string `gorm:"index" json:"-"`
+	DestinationIPKey string `gorm:"index" json:"-"`
+
+	StartedAt time.Time `gorm:"not null;index" json:"started_at"`
+	EndedAt   time.Time `gorm:"not null;index" json:"ended_at"`
+
+	// Bytes count whole frames, as on the wire.
+	SourcePackets      uint64 `json:"source_packets"`
+	SourceBytes        uint64 `json:"source_bytes"`
+	DestinationPackets uint64 `json:"destination_packets"`
+	DestinationBytes   uint64 `json:"destination_bytes"`
+
+	// TCP only, TCPFlags lists every flag seen in either direction.
+	TCPState string   `json:"tcp_state,omitempty"`
+	TCPFlags []string `gorm:"serializer:json" json:"tcp_flags,omitempty"`
+
+	EndReason string `gorm:"not null" json:"end_reason"`
+
+	// QUIC only, read from the Initial packets: the version and the
+	// connection IDs in hex, the one the client first sent to and those
+	// each side chose for itself.
+	QUICVersion      string `gorm:"column:quic_version" json:"quic_version,omitempty"`
+	QUICOriginalDCID string `gorm:"column:quic_original_dcid;index" json:"quic_original_dcid,omitempty"`
+	QUICClientCID    string `gorm:"column:quic_client_cid;index" json:"quic_client_cid,omitempty"`
+	QUICServerCID    string `gorm:"column:quic_server_cid;index" json:"quic_server_cid,omitempty"`
+
+	// TLS is the handshake read from the payload, if any.
+	TLS *TLSSession `gorm:"foreignKey:FlowID" json:"tls,omitempty"`
+
+	// Payload is the reassembled TCP payload and HTTPTransactions the HTTP
+	// read from it, only set on the flows being stored.
+	Payload          *FlowPayload      `gorm:"foreignKey:FlowID" json:"-"`
+	HTTPTransactions []HTTPTransaction `gorm:"foreignKey:FlowID" json:"-"`
+}
+
+// FlowFilter selects the flows returned by GetFlows, newest first. Zero
+// fields do not filter. From and To select the flows active in between,
+// ConnectionIDs the QUIC flows with any of them.
+type FlowFilter struct {
+	Limit     int
+	From      time.Time
+	To        time.Time
+	IPs       []*net.IPNet
+	Ports     []PortRange
+	Protocols []string
+	Devices   []string
+	// ConnectionIDs are lower case hex.
+	ConnectionIDs []string
+}
+
+type FlowRepository interface {
+	SaveFlows(flows []Flow) error
+	GetFlows(filter FlowFilter) ([]Flow, error)
+	GetFlow(id uint) (Flow, error)
+	GetFlowPayload(flowID uint) (FlowPayload, error)
+}
+
+type SqlLiteFlowRepository struct {
+	db *gorm.DB
+}
+
+func (r *SqlLiteFlowRepository) SaveFlows(flows []Flow) error {
+	if len(flows) == 0 {
+		return nil
+	}
+	for i := range flows {
+		flows[i].SourceIPKey = ipKey(flows[i].SourceIP)
+		flows[i].DestinationIPKey = ipKey(flows[i].DestinationIP)
+		if session := flows[i].TLS; session != nil {
+			session.ClientIPKey = ipKey(session.ClientIP)
+			session.ServerIPKey = ipKey(session.ServerIP)
+			session.ReversedServerName = reverseDNSName(session.ServerName)
+		}
+		for j := range flows[i].HTTPTransactions {
+			transaction := &flows[i].HTTPTransactions[j]
+			transaction.ClientIPKey = ipKey(transaction.ClientIP)
+			transaction.ServerIPKey = ipKey(transaction.ServerIP)
+		}
+	}
+	return r.db.CreateInBatches(flows, 100).Error
+}
+
+func (r *SqlLiteFlowRepository) GetFlows(filter FlowFilter) ([]Flow, error) {
+	flows := make([]Flow, 0)
+	query := r.db.Model(&Flow{})
+	query = spanRange(query, "started_at", "ended_at", filter.From, filter.To)
+	if len(filter.IPs) > 0 {
+		query = query.Where(ipCondition(query, filter.IPs, "source").Or(ipCondition(query, filter.IPs, "destination")))
+	}
+	if len(filter.Ports) > 0 {
+		query = query.Where(portCondition(query, filter.Ports, "source_port").Or(portCondition(query, filter.Ports, "destination_port")))
+	}
+	if len(filter.Protocols) > 0 {
+		query = query.Where("protocol IN ?", filter.Protocols)
+	}
+	if len(filter.Devices) > 0 {
+		query = query.Where("device_id IN ?", filter.Devices)
+	}
+	if len(filter.ConnectionIDs) > 0 {
+		query = query.Where("quic_original_dcid IN ? OR quic_client_cid IN ? OR quic_server_cid IN ?", filter.ConnectionIDs, filter.ConnectionIDs, filter.ConnectionIDs)
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+	result := query.Preload("TLS").Order("started_at desc").Order("id desc").Find(&flows)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return flows, nil
+}
+
+func (r *SqlLiteFlowRepository) GetFlow(id uint) (Flow, error) {
+	var flow Flow
+	result := r.db.Preload("TLS").First(&flow, id)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return Flow{}, ErrFlowNotFound
+	}
+	if result.Error != nil {
+		return Flow{}, result.Error
+	}
+	return flow, nil
+}
+
+func (r *SqlLiteFlowRepository) GetFlowPayload(flowID uint) (FlowPayload, error) {
+	var payload FlowPayload
+	result := r.db.Where("flow_id = ?", flowID).First(&payload)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return FlowPayload{}, ErrFlowPayloadNotFound
+	}
+	if result.Error != nil {
+		return FlowPayload{}, result.Error
+	}
+	return payload, nil
+}
+
+func NewSqlLiteFlowRepository(db *gorm.DB) FlowRepository {
+	db.AutoMigrate(&Flow{}, &FlowPayload{}, &HTTPTransaction{}, &TLSSession{})
+
+	return &SqlLiteFlowRepository{db: db}
+}
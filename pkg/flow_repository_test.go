@@ -0,0 +1,75 @@
+package pkg
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupFlowTestDB(t *testing.T) FlowRepository {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open test database: %v", err)
+	}
+	return NewSqlLiteFlowRepository(db)
+}
+
+func TestFlowRepositoryGetFlows(t *testing.T) {
+	repo := setupFlowTestDB(t)
+	start := time.Now().Add(-time.Hour)
+	flows := []Flow{
+		{DeviceID: "eth0", Protocol: ProtocolTCP, SourceIP: "10.0.0.1", SourcePort: intPtr(40000), DestinationIP: "192.168.1.1", DestinationPort: intPtr(443),
+			StartedAt: start, EndedAt: start.Add(time.Minute), TCPState: TCPStateClosed, TCPFlags: []string{"SYN", "ACK"}, EndReason: FlowEndFIN},
+		{DeviceID: "eth0", Protocol: ProtocolUDP, SourceIP: "10.0.0.2", SourcePort: intPtr(5353), DestinationIP: "10.0.0.53", DestinationPort: intPtr(53),
+			StartedAt: start.Add(10 * time.Minute), EndedAt: start.Add(11 * time.Minute), EndReason: FlowEndIdle,
+			QUICVersion: "1", QUICOriginalDCID: "8394c8f03e515708", QUICClientCID: "c1c2", QUICServerCID: "515253"},
+		{DeviceID: "eth1", Protocol: ProtocolTCP, SourceIP: "172.16.0.1", SourcePort: intPtr(50000), DestinationIP: "10.0.0.1", DestinationPort: intPtr(22),
+			StartedAt: start.Add(20 * time.Minute), EndedAt: start.Add(40 * time.Minute), EndReason: FlowEndActive},
+	}
+	if err := repo.SaveFlows(flows); err != nil {
+		t.Fatalf("failed to save flows: %v", err)
+	}
+
+	_, network, _ := net.ParseCIDR("10.0.0.0/24")
+	tests := []struct {
+		name   string
+		filter FlowFilter
+		want   []string
+	}{
+		{"all newest first", FlowFilter{}, []string{"172.16.0.1", "10.0.0.2", "10.0.0.1"}},
+		{"limit", FlowFilter{Limit: 1}, []string{"172.16.0.1"}},
+		{"network on either side", FlowFilter{IPs: []*net.IPNet{network}}, []string{"172.16.0.1", "10.0.0.2", "10.0.0.1"}},
+		{"port", FlowFilter{Ports: []PortRange{{Low: 53, High: 53}}}, []string{"10.0.0.2"}},
+		{"protocol and device", FlowFilter{Protocols: []string{ProtocolTCP}, Devices: []string{"eth0"}}, []string{"10.0.0.1"}},
+		{"quic connection id", FlowFilter{ConnectionIDs: []string{"515253", "ffff"}}, []string{"10.0.0.2"}},
+		{"active in range", FlowFilter{From: start.Add(5 * time.Minute), To: start.Add(25 * time.Minute)}, []string{"172.16.0.1", "10.0.0.2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := repo.GetFlows(tt.filter)
+			if err != nil {
+				t.Fatalf("GetFlows returned error: %v", err)
+			}
+			sources := make([]string, len(got))
+			for i, flow := range got {
+				sources[i] = flow.SourceIP
+			}
+			if len(sources) != len(tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, sources)
+			}
+			for i := range sources {
+				if sources[i] != tt.want[i] {
+					t.Fatalf("expected %v, got %v", tt.want, sources)
+				}
+			}
+		})
+	}
+
+	got, _ := repo.GetFlows(FlowFilter{Limit: 1, Protocols: []string{ProtocolTCP}, Devices: []string{"eth0"}})
+	if len(got[0].TCPFlags) != 2 || got[0].TCPState != TCPStateClosed {
+		t.Errorf("expected TCP fields to round trip, got %+v", got[0])
+	}
+}
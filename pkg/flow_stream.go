@@ -0,0 +1,264 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/reassembly"
+)
+
+// Views of a reassembled TCP stream, those of Wireshark's Follow TCP
+// Stream.
+const (
+	StreamFormatASCII = "ascii"
+	StreamFormatHex   = "hex"
+	StreamFormatRaw   = "raw"
+)
+
+var (
+	ErrFlowPayloadNotFound = errors.New("flow has no reassembled payload")
+	ErrInvalidStreamFormat = errors.New("invalid stream format")
+)
+
+// FlowPayload is the reassembled TCP payload of a flow record, each side
+// capped at the configured size. Segments tell in which order the sides
+// spoke, each holds the length of consecutive data from one side and the
+// time of the packet that delivered its first bytes.
+type FlowPayload struct {
+	ID              uint            `gorm:"primaryKey" json:"-"`
+	FlowID          uint            `gorm:"not null;uniqueIndex" json:"flow_id"`
+	SourceData      []byte          `json:"-"`
+	DestinationData []byte          `json:"-"`
+	Segments        []StreamSegment `gorm:"serializer:json" json:"segments"`
+	// Truncated is set when a side went past the size cap, MissingBytes
+	// counts the bytes lost in sequence gaps.
+	Truncated    bool `json:"truncated"`
+	MissingBytes int  `json:"missing_bytes"`
+}
+
+type StreamSegment struct {
+	FromSource bool      `json:"from_source"`
+	Length     int       `json:"length"`
+	Time       time.Time `json:"time"`
+}
+
+// flowContext hands the flow entry of a segment and its arrival time to
+// the assembler, whose timeouts then follow those of the flow table. time
+// is the capture time of the packet.
+type flowContext struct {
+	info  gopacket.CaptureInfo
+	entry *flowEntry
+	time  time.Time
+}
+
+func (c *flowContext) GetCaptureInfo() gopacket.CaptureInfo {
+	return c.info
+}
+
+// The out of order pages, of about 1900 bytes each, the assembler of a
+// device and VLAN buffers in total and per connection. Past them it
+// hands over the data it has and counts the gaps as missing.
+const (
+	flowMaxBufferedPagesTotal         = 4096
+	flowMaxBufferedPagesPerConnection = 64
+)
+
+// flowStreamFactory creates the assembler streams. Data is accounted to
+// the flow entry of the segment that completed it, so that a connection
+// outliving its entry feeds the next one.
+type flowStreamFactory struct {
+	table *FlowTable
+}
+
+type flowStream struct {
+	table      *FlowTable
+	clientIP   string
+	clientPort int
+}
+
+func (f *flowStreamFactory) New(netFlow, tcpFlow gopacket.Flow, tcp *layers.TCP, ac reassembly.AssemblerContext) reassembly.Stream {
+	return &flowStream{table: f.table, clientIP: netFlow.Src().String(), clientPort: int(tcp.SrcPort)}
+}
+
+// Accept takes every segment and starts the stream on the first one seen
+// when the handshake was missed.
+func (s *flowStream) Accept(tcp *layers.TCP, ci gopacket.CaptureInfo, dir reassembly.TCPFlowDirection, nextSeq reassembly.Sequence, start *bool, ac reassembly.AssemblerContext) bool {
+	*start = true
+	return true
+}
+
+func (s *flowStream) ReassembledSG(sg reassembly.ScatterGather, ac reassembly.AssemblerContext) {
+	context, ok := ac.(*flowContext)
+	if !ok || context.entry == nil {
+		return
+	}
+	length, _ := sg.Lengths()
+	dir, _, _, skip := sg.Info()
+	// The assembler calls client the sender of the first segment it saw,
+	// which is not always the source of the flow.
+	flow := &context.entry.flow
+	clientIsSource := s.clientIP == flow.SourceIP && s.clientPort == portOrZero(flow.SourcePort)
+	fromSource := (dir == reassembly.TCPDirClientToServer) == clientIsSource
+
+	payload := context.entry.payload
+	if payload == nil {
+		payload = &FlowPayload{}
+		context.entry.payload = payload
+	}
+	if skip > 0 {
+		payload.MissingBytes += skip
+	}
+	if length == 0 {
+		return
+	}
+	data := &payload.DestinationData
+	if fromSource {
+		data = &payload.SourceData
+	}
+	// Each side is capped, and so are the payloads of all the flows in
+	// progress.
+	room := min(s.table.payloadMaxBytes-len(*data), s.table.payloadBudget-s.table.payloadBytes)
+	if length > room {
+		payload.Truncated = true
+		length = max(room, 0)
+	}
+	if length == 0 {
+		return
+	}
+	*data = append(*data, sg.Fetch(length)...)
+	s.table.payloadBytes += length
+	if last := len(payload.Segments) - 1; last >= 0 && payload.Segments[last].FromSource == fromSource {
+		payload.Segments[last].Length += length
+	} else {
+		payload.Segments = append(payload.Segments, StreamSegment{FromSource: fromSource, Length: length, Time: context.time})
+	}
+}
+
+func (s *flowStream) ReassemblyComplete(ac reassembly.AssemblerContext) bool {
+	return true
+}
+
+// assemblerScope separates the assemblers of devices and VLANs, the
+// assembler keys its connections on the addresses and ports only.
+type assemblerScope struct {
+	device string
+	vlan   int
+}
+
+// assemble feeds a TCP segment of the entry to the assembler of its scope.
+func (t *FlowTable) assemble(entry *flowEntry, key flowKey, packet gopacket.Packet, captured, arrival time.Time) {
+	tcp, ok := packet.TransportLayer().(*layers.TCP)
+	if !ok || packet.NetworkLayer() == nil {
+		return
+	}
+	scope := assemblerScope{device: key.device, vlan: key.vlan}
+	assembler, ok := t.assemblers[scope]
+	if !ok {
+		assembler = reassembly.NewAssembler(reassembly.NewStreamPool(&flowStreamFactory{table: t}))
+		assembler.MaxBufferedPagesTotal = flowMaxBufferedPagesTotal
+		assembler.MaxBufferedPagesPerConnection = flowMaxBufferedPagesPerConnection
+		t.assemblers[scope] = assembler
+	}
+	info := packet.Metadata().CaptureInfo
+	info.Timestamp = arrival
+	assembler.AssembleWithContext(packet.NetworkLayer().NetworkFlow(), tcp, &flowContext{info: info, entry: entry, time: captured})
+}
+
+// flushAssemblers hands over the data waiting for a missing segment since
+// before wait, and closes the connections idle since before idle.
+func (t *FlowTable) flushAssemblers(wait, idle time.Time) {
+	for _, assembler := range t.assemblers {
+		assembler.FlushWithOptions(reassembly.FlushOptions{T: wait, TC: idle})
+	}
+}
+
+// WriteFlowStream writes the conversation of a flow in the format of
+// tshark's follow statistics: the destination side is indented by a tab,
+// each block starts with its length in the ascii view.
+func WriteFlowStream(w io.Writer, flow Flow, payload FlowPayload, format string) error {
+	if format != StreamFormatASCII && format != StreamFormatHex && format != StreamFormatRaw {
+		return fmt.Errorf("%w %q, expected ascii, hex or raw", ErrInvalidStreamFormat, format)
+	}
+	separator := strings.Repeat("=", 67)
+	fmt.Fprintln(w, separator)
+	fmt.Fprintf(w, "Follow: tcp,%s\n", format)
+	fmt.Fprintf(w, "Node 0: %s\n", flowEndpoint(flow.SourceIP, flow.SourcePort))
+	fmt.Fprintf(w, "Node 1: %s\n", flowEndpoint(flow.DestinationIP, flow.DestinationPort))
+
+	var sourceOffset, destinationOffset int
+	for _, segment := range payload.Segments {
+		indent := ""
+		data := payload.SourceData
+		offset := &sourceOffset
+		if !segment.FromSource {
+			indent = "\t"
+			data = payload.DestinationData
+			offset = &destinationOffset
+		}
+		end := min(*offset+segment.Length, len(data))
+		chunk := data[min(*offset, end):end]
+		switch format {
+		case StreamFormatASCII:
+			fmt.Fprintf(w, "%s%d\n%s\n", indent, len(chunk), printableASCII(chunk))
+		case StreamFormatHex:
+			writeHexDump(w, indent, chunk, *offset)
+		case StreamFormatRaw:
+			fmt.Fprintf(w, "%s%x\n", indent, chunk)
+		}
+		*offset = end
+	}
+	if payload.Truncated {
+		fmt.Fprintln(w, "[truncated]")
+	}
+	_, err := fmt.Fprintln(w, separator)
+	return err
+}
+
+func flowEndpoint(ip string, port *int) string {
+	if strings.Contains(ip, ":") {
+		return fmt.Sprintf("[%s]:%d", ip, portOrZero(port))
+	}
+	return fmt.Sprintf("%s:%d", ip, portOrZero(port))
+}
+
+// printableASCII keeps the printable characters and the line breaks, the
+// other bytes become dots.
+func printableASCII(data []byte) string {
+	text := make([]byte, len(data))
+	for i, b := range data {
+		if (b >= 0x20 && b < 0x7f) || b == '\n' || b == '\r' || b == '\t' {
+			text[i] = b
+		} else {
+			text[i] = '.'
+		}
+	}
+	return string(text)
+}
+
+// writeHexDump writes 16 bytes a line with their offset in the side of
+// the conversation and their printable form.
+func writeHexDump(w io.Writer, indent string, data []byte, offset int) {
+	for start := 0; start < len(data); start += 16 {
+		line := data[start:min(start+16, len(data))]
+		hex := make([]string, 16)
+		for i := range hex {
+			hex[i] = "  "
+			if i < len(line) {
+				hex[i] = fmt.Sprintf("%02x", line[i])
+			}
+		}
+		ascii := []byte(printableASCII(line))
+		for i, b := range ascii {
+			if b < 0x20 {
+				ascii[i] = '.'
+			}
+		}
+		fmt.Fprintf(w, "%s%08X  %s  %s  %s\n", indent, offset+start,
+			strings.Join(hex[:8], " "), strings.Join(hex[8:], " "), ascii)
+	}
+}
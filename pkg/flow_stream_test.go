@@ -0,0 +1,166 @@
+package pkg
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFlowTableReassemblesTCPPayload(t *testing.T) {
+	table := NewFlowTable(time.Minute, 0, 1024, 1<<20)
+	start := time.Now()
+	client, server := "10.0.0.1", "10.0.0.2"
+	at := func(ms int) time.Time { return start.Add(time.Duration(ms) * time.Millisecond) }
+	segments := []AppPacket{
+		tcpSegmentTestPacket(t, client, server, 40000, 80, "S", 1000, "", at(0)),
+		tcpSegmentTestPacket(t, server, client, 80, 40000, "SA", 5000, "", at(1)),
+		tcpSegmentTestPacket(t, client, server, 40000, 80, "A", 1001, "", at(2)),
+		// The second half of the request arrives first, then the first
+		// half twice.
+		tcpSegmentTestPacket(t, client, server, 40000, 80, "PA", 1015, "Host: a\r\n\r\n", at(3)),
+		tcpSegmentTestPacket(t, client, server, 40000, 80, "A", 1001, "GET / HTTP/1.1", at(4)),
+		tcpSegmentTestPacket(t, client, server, 40000, 80, "A", 1001, "GET / HTTP/1.1", at(5)),
+		tcpSegmentTestPacket(t, server, client, 80, 40000, "PA", 5001, "HTTP/1.1 200 OK\r\n\r\n", at(6)),
+		tcpSegmentTestPacket(t, server, client, 80, 40000, "FA", 5020, "", at(7)),
+		tcpSegmentTestPacket(t, client, server, 40000, 80, "FA", 1026, "", at(8)),
+	}
+	for _, segment := range segments {
+		table.Add(segment)
+	}
+
+	flows := table.Expire(start.Add(time.Minute))
+	if len(flows) != 1 || flows[0].Payload == nil {
+		t.Fatalf("expected 1 flow with payload, got %+v", flows)
+	}
+	payload := flows[0].Payload
+	if got := string(payload.SourceData); got != "GET / HTTP/1.1Host: a\r\n\r\n" {
+		t.Errorf("unexpected request %q", got)
+	}
+	if got := string(payload.DestinationData); got != "HTTP/1.1 200 OK\r\n\r\n" {
+		t.Errorf("unexpected response %q", got)
+	}
+	// The request is timed by the segment that completed its start.
+	want := []StreamSegment{{FromSource: true, Length: 25, Time: at(4)}, {FromSource: false, Length: 19, Time: at(6)}}
+	if !slices.Equal(payload.Segments, want) {
+		t.Errorf("expected segments %v, got %v", want, payload.Segments)
+	}
+	if payload.Truncated || payload.MissingBytes != 0 {
+		t.Errorf("unexpected truncation %+v", payload)
+	}
+}
+
+func TestFlowTableReassemblyPicksUpMidStream(t *testing.T) {
+	table := NewFlowTable(time.Minute, 0, 8, 1<<20)
+	start := time.Now()
+	table.Add(tcpSegmentTestPacket(t, "10.0.0.1", "10.0.0.2", 40000, 22, "PA", 100, "0123456789", start))
+	table.Add(tcpSegmentTestPacket(t, "10.0.0.2", "10.0.0.1", 22, 40000, "PA", 900, "abc", start))
+
+	flows := table.Flush()
+	if len(flows) != 1 || flows[0].Payload == nil {
+		t.Fatalf("expected 1 flow with payload, got %+v", flows)
+	}
+	payload := flows[0].Payload
+	if string(payload.SourceData) != "01234567" || string(payload.DestinationData) != "abc" || !payload.Truncated {
+		t.Errorf("expected capped payload, got %+v", payload)
+	}
+}
+
+func TestFlowTableReassemblyBudget(t *testing.T) {
+	table := NewFlowTable(time.Minute, 0, 1024, 12)
+	start := time.Now()
+	table.Add(tcpSegmentTestPacket(t, "10.0.0.1", "10.0.0.2", 40000, 22, "PA", 100, "0123456789", start))
+	// The second flow only gets what the first one left of the budget.
+	table.Add(tcpSegmentTestPacket(t, "10.0.0.3", "10.0.0.2", 40001, 22, "PA", 100, "abcdefghij", start))
+
+	flows := table.Flush()
+	if len(flows) != 2 || flows[0].Payload == nil || flows[1].Payload == nil {
+		t.Fatalf("expected 2 flows with payload, got %+v", flows)
+	}
+	total := len(flows[0].Payload.SourceData) + len(flows[1].Payload.SourceData)
+	if total != 12 || !(flows[0].Payload.Truncated || flows[1].Payload.Truncated) {
+		t.Errorf("expected the payloads capped at 12 bytes in total, got %q and %q", flows[0].Payload.SourceData, flows[1].Payload.SourceData)
+	}
+	if table.payloadBytes != 0 {
+		t.Errorf("expected the budget given back by the finished flows, %d bytes still held", table.payloadBytes)
+	}
+	for _, assembler := range table.assemblers {
+		if assembler.MaxBufferedPagesTotal != flowMaxBufferedPagesTotal || assembler.MaxBufferedPagesPerConnection != flowMaxBufferedPagesPerConnection {
+			t.Errorf("expected the buffered pages capped, got %+v", assembler.AssemblerOptions)
+		}
+	}
+}
+
+func TestFlowTableWithoutReassembly(t *testing.T) {
+	table := NewFlowTable(time.Minute, 0, 0, 0)
+	table.Add(tcpSegmentTestPacket(t, "10.0.0.1", "10.0.0.2", 40000, 22, "PA", 100, "data", time.Now()))
+
+	flows := table.Flush()
+	if len(flows) != 1 || flows[0].Payload != nil {
+		t.Fatalf("expected a flow without payload, got %+v", flows)
+	}
+}
+
+func TestWriteFlowStream(t *testing.T) {
+	flow := Flow{SourceIP: "10.0.0.1", SourcePort: intPtr(40000), DestinationIP: "10.0.0.2", DestinationPort: intPtr(80)}
+	payload := FlowPayload{
+		SourceData:      []byte("GET /\r\n"),
+		DestinationData: []byte("OK\x00"),
+		Segments:        []StreamSegment{{FromSource: true, Length: 7}, {FromSource: false, Length: 3}},
+	}
+	separator := "===================================================================\n"
+	header := separator + "Follow: tcp,%s\nNode 0: 10.0.0.1:40000\nNode 1: 10.0.0.2:80\n"
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{StreamFormatASCII, "7\nGET /\r\n\n\t3\nOK.\n"},
+		{StreamFormatRaw, "474554202f0d0a\n\t4f4b00\n"},
+		{StreamFormatHex, "00000000  47 45 54 20 2f 0d 0a" + strings.Repeat(" ", 30) + "GET /..\n" +
+			"\t00000000  4f 4b 00" + strings.Repeat(" ", 42) + "OK.\n"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := WriteFlowStream(&buf, flow, payload, tt.format); err != nil {
+			t.Fatalf("%s: WriteFlowStream returned error: %v", tt.format, err)
+		}
+		want := fmt.Sprintf(header, tt.format) + tt.want + separator
+		if buf.String() != want {
+			t.Errorf("%s: expected\n%q\ngot\n%q", tt.format, want, buf.String())
+		}
+	}
+
+	if err := WriteFlowStream(&bytes.Buffer{}, flow, payload, "yaml"); !errors.Is(err, ErrInvalidStreamFormat) {
+		t.Errorf("expected ErrInvalidStreamFormat, got %v", err)
+	}
+}
+
+func TestFlowRepositoryStoresPayload(t *testing.T) {
+	repo := setupFlowTestDB(t)
+	now := time.Now()
+	flows := []Flow{
+		{DeviceID: "eth0", Protocol: ProtocolTCP, SourceIP: "10.0.0.1", DestinationIP: "10.0.0.2", StartedAt: now, EndedAt: now, EndReason: FlowEndFIN,
+			Payload: &FlowPayload{SourceData: []byte("ping"), DestinationData: []byte("pong"), Segments: []StreamSegment{{FromSource: true, Length: 4}, {Length: 4}}}},
+		{DeviceID: "eth0", Protocol: ProtocolUDP, SourceIP: "10.0.0.1", DestinationIP: "10.0.0.3", StartedAt: now, EndedAt: now, EndReason: FlowEndIdle},
+	}
+	if err := repo.SaveFlows(flows); err != nil {
+		t.Fatalf("failed to save flows: %v", err)
+	}
+
+	payload, err := repo.GetFlowPayload(flows[0].ID)
+	if err != nil {
+		t.Fatalf("GetFlowPayload returned error: %v", err)
+	}
+	if string(payload.SourceData) != "ping" || string(payload.DestinationData) != "pong" || len(payload.Segments) != 2 {
+		t.Errorf("unexpected payload %+v", payload)
+	}
+	if _, err := repo.GetFlowPayload(flows[1].ID); !errors.Is(err, ErrFlowPayloadNotFound) {
+		t.Errorf("expected ErrFlowPayloadNotFound, got %v", err)
+	}
+	if _, err := repo.GetFlow(999); !errors.Is(err, ErrFlowNotFound) {
+		t.Errorf("expected ErrFlowNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,368 @@
+package pkg
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/reassembly"
+)
+
+// flowCloseTimeout is how long a closed or reset TCP flow is kept for the
+// segments still in flight before it is emitted.
+const flowCloseTimeout = 10 * time.Second
+
+const (
+	tcpFIN uint8 = 1 << iota
+	tcpSYN
+	tcpRST
+	tcpPSH
+	tcpACK
+	tcpURG
+	tcpECE
+	tcpCWR
+)
+
+var tcpFlagNames = []string{"FIN", "SYN", "RST", "PSH", "ACK", "URG", "ECE", "CWR"}
+
+// FlowTable groups the captured IP packets into bidirectional flows keyed
+// on device, VLAN, protocol and both endpoints. Flows are emitted by Expire
+// once idle for the idle timeout, closed, or at every active timeout for
+// the long ones. TCP payloads are reassembled along, up to payloadMaxBytes
+// a side and payloadBudget for all the flows in progress, which hold
+// payloadBytes.
+type FlowTable struct {
+	mu              sync.Mutex
+	flows           map[flowKey]*flowEntry
+	finished        []Flow
+	idleTimeout     time.Duration
+	activeTimeout   time.Duration
+	payloadMaxBytes int
+	payloadBudget   int
+	payloadBytes    int
+	assemblers      map[assemblerScope]*reassembly.Assembler
+}
+
+// flowKey orders the endpoints so that both directions share the key.
+type flowKey struct {
+	device   string
+	vlan     int
+	protocol string
+	lowIP    string
+	lowPort  int
+	highIP   string
+	highPort int
+}
+
+type flowEntry struct {
+	flow Flow
+	// firstSeen and lastSeen are arrival times, so that timeouts do not
+	// depend on the timestamps of replayed captures.
+	firstSeen      time.Time
+	lastSeen       time.Time
+	flags          uint8
+	sourceFIN      bool
+	destinationFIN bool
+	payload        *FlowPayload
+	quic           *quicState
+}
+
+// NewFlowTable returns an empty table. An active timeout of zero lets
+// flows run until they are idle or closed, a payload size or budget of
+// zero disables TCP reassembly.
+func NewFlowTable(idleTimeout, activeTimeout time.Duration, payloadMaxBytes, payloadBudget int) *FlowTable {
+	return &FlowTable{
+		flows:           make(map[flowKey]*flowEntry),
+		idleTimeout:     idleTimeout,
+		activeTimeout:   activeTimeout,
+		payloadMaxBytes: payloadMaxBytes,
+		payloadBudget:   payloadBudget,
+		assemblers:      make(map[assemblerScope]*reassembly.Assembler),
+	}
+}
+
+// Add accounts the packet to its flow. Packets without an IP layer are
+// ignored.
+func (t *FlowTable) Add(packet AppPacket) {
+	saved, err := mapPacketToSavedPacket(packet)
+	if err != nil {
+		return
+	}
+	arrival := packet.UpdatedAt
+	if arrival.IsZero() {
+		arrival = packet.CreatedAt
+	}
+	t.add(saved, packet.Data, arrival)
+}
+
+// add accounts a decoded packet, data is only needed for reassembly and
+// the QUIC handshake.
+func (t *FlowTable) add(packet *SavedPacket, data gopacket.Packet, arrival time.Time) {
+	if packet.IPVersion == nil || packet.SourceIP == "" {
+		return
+	}
+	key := newFlowKey(packet)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	entry, ok := t.flows[key]
+	// A new connection reusing the ports of a closed one starts a new flow.
+	if ok && entry.closed() && tcpFlags(packet) == tcpSYN {
+		t.finish(entry, entry.closeReason())
+		ok = false
+	}
+	if !ok {
+		entry = newFlowEntry(packet, arrival)
+		t.flows[key] = entry
+	}
+	entry.update(packet, arrival)
+	if t.payloadMaxBytes > 0 && t.payloadBudget > 0 && data != nil && packet.Protocol == ProtocolTCP {
+		t.assemble(entry, key, data, packet.CreatedAt, arrival)
+	}
+	if data != nil && packet.Protocol == ProtocolUDP {
+		entry.addQUIC(packet, data)
+	}
+}
+
+// Expire removes the flows that timed out or were closed and returns the
+// records emitted since the last call.
+func (t *FlowTable) Expire(now time.Time) []Flow {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	closeTimeout := min(flowCloseTimeout, t.idleTimeout)
+	// Segments still missing by then will not come, the data after them
+	// is handed over before the flows are emitted.
+	t.flushAssemblers(now.Add(-closeTimeout), now.Add(-t.idleTimeout))
+	for key, entry := range t.flows {
+		idle := now.Sub(entry.lastSeen)
+		switch {
+		case entry.closed() && idle >= closeTimeout:
+			t.finish(entry, entry.closeReason())
+			delete(t.flows, key)
+		case idle >= t.idleTimeout:
+			t.finish(entry, FlowEndIdle)
+			delete(t.flows, key)
+		case t.activeTimeout > 0 && now.Sub(entry.firstSeen) >= t.activeTimeout:
+			t.finish(entry, FlowEndActive)
+			entry.restart(now)
+		}
+	}
+	return t.takeFinished()
+}
+
+// Flush emits every flow of the table, on shutdown.
+func (t *FlowTable) Flush() []Flow {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, assembler := range t.assemblers {
+		assembler.FlushAll()
+	}
+	for key, entry := range t.flows {
+		reason := FlowEndShutdown
+		if entry.closed() {
+			reason = entry.closeReason()
+		}
+		t.finish(entry, reason)
+		delete(t.flows, key)
+	}
+	return t.takeFinished()
+}
+
+// Len returns the number of flows in progress.
+func (t *FlowTable) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.flows)
+}
+
+// finish queues the record of the entry, unless it has no packet since
+// the last active timeout.
+func (t *FlowTable) finish(entry *flowEntry, reason string) {
+	if entry.flow.SourcePackets+entry.flow.DestinationPackets == 0 {
+		return
+	}
+	flow := entry.flow
+	flow.EndReason = reason
+	flow.Payload = entry.payload
+	if entry.payload != nil {
+		t.payloadBytes -= len(entry.payload.SourceData) + len(entry.payload.DestinationData)
+	}
+	entry.payload = nil
+	if entry.quic != nil && entry.quic.quic() {
+		entry.quic.setFlow(&flow)
+		flow.TLS = entry.quic.takeTLSSession(flow)
+	}
+	if flow.Protocol == ProtocolTCP {
+		flow.TCPFlags = make([]string, 0, len(tcpFlagNames))
+		for i, name := range tcpFlagNames {
+			if entry.flags&(1<<i) != 0 {
+				flow.TCPFlags = append(flow.TCPFlags, name)
+			}
+		}
+	}
+	t.finished = append(t.finished, flow)
+}
+
+func (t *FlowTable) takeFinished() []Flow {
+	flows := t.finished
+	t.finished = nil
+	slices.SortFunc(flows, func(a, b Flow) int { return a.StartedAt.Compare(b.StartedAt) })
+	return flows
+}
+
+func newFlowKey(packet *SavedPacket) flowKey {
+	key := flowKey{device: packet.DeviceID, vlan: -1, protocol: packet.Protocol}
+	if packet.VLANID != nil {
+		key.vlan = *packet.VLANID
+	}
+	source := ipKey(packet.SourceIP)
+	destination := ipKey(packet.DestinationIP)
+	sourcePort := portOrZero(packet.SourcePort)
+	destinationPort := portOrZero(packet.DestinationPort)
+	if source < destination || (source == destination && sourcePort <= destinationPort) {
+		key.lowIP, key.lowPort, key.highIP, key.highPort = source, sourcePort, destination, destinationPort
+	} else {
+		key.lowIP, key.lowPort, key.highIP, key.highPort = destination, destinationPort, source, sourcePort
+	}
+	return key
+}
+
+// newFlowEntry starts a flow from its first packet. A SYN-ACK comes from
+// the server, so the flow is turned around.
+func newFlowEntry(packet *SavedPacket, arrival time.Time) *flowEntry {
+	flow := Flow{
+		DeviceID:        packet.DeviceID,
+		VLANID:          packet.VLANID,
+		Protocol:        packet.Protocol,
+		IPVersion:       *packet.IPVersion,
+		SourceIP:        packet.SourceIP,
+		SourcePort:      packet.SourcePort,
+		DestinationIP:   packet.DestinationIP,
+		DestinationPort: packet.DestinationPort,
+	}
+	if tcpFlags(packet) == tcpSYN|tcpACK {
+		flow.SourceIP, flow.DestinationIP = flow.DestinationIP, flow.SourceIP
+		flow.SourcePort, flow.DestinationPort = flow.DestinationPort, flow.SourcePort
+	}
+	return &flowEntry{flow: flow, firstSeen: arrival}
+}
+
+func (e *flowEntry) update(packet *SavedPacket, arrival time.Time) {
+	fromSource := e.fromSource(packet)
+	if fromSource {
+		e.flow.SourcePackets++
+		e.flow.SourceBytes += uint64(packet.FrameLength)
+	} else {
+		e.flow.DestinationPackets++
+		e.flow.DestinationBytes += uint64(packet.FrameLength)
+	}
+	if e.flow.StartedAt.IsZero() || packet.CreatedAt.Before(e.flow.StartedAt) {
+		e.flow.StartedAt = packet.CreatedAt
+	}
+	if packet.CreatedAt.After(e.flow.EndedAt) {
+		e.flow.EndedAt = packet.CreatedAt
+	}
+	e.lastSeen = arrival
+	if packet.TCPFlagSYN != nil {
+		e.updateTCP(tcpFlags(packet), fromSource)
+	}
+}
+
+func (e *flowEntry) fromSource(packet *SavedPacket) bool {
+	return packet.SourceIP == e.flow.SourceIP && portOrZero(packet.SourcePort) == portOrZero(e.flow.SourcePort)
+}
+
+// addQUIC reads the datagrams that may be QUIC long header packets, until
+// the flow turns out to be something else.
+func (e *flowEntry) addQUIC(packet *SavedPacket, data gopacket.Packet) {
+	udp, ok := data.TransportLayer().(*layers.UDP)
+	if !ok {
+		return
+	}
+	payload := udp.Payload
+	if e.quic == nil && (len(payload) == 0 || payload[0]&0xc0 != 0xc0) {
+		return
+	}
+	if e.quic == nil {
+		e.quic = &quicState{}
+	}
+	e.quic.addDatagram(payload, e.fromSource(packet), packet.CreatedAt)
+}
+
+// updateTCP follows the handshake and the teardown. A flow picked up in
+// the middle is taken as established.
+func (e *flowEntry) updateTCP(flags uint8, fromSource bool) {
+	e.flags |= flags
+	state := e.flow.TCPState
+	switch {
+	case state == TCPStateReset:
+	case flags&tcpRST != 0:
+		state = TCPStateReset
+	case flags&tcpFIN != 0:
+		if fromSource {
+			e.sourceFIN = true
+		} else {
+			e.destinationFIN = true
+		}
+		state = TCPStateFinWait
+		if e.sourceFIN && e.destinationFIN {
+			state = TCPStateClosed
+		}
+	case state == TCPStateFinWait || state == TCPStateClosed:
+	case flags&(tcpSYN|tcpACK) == tcpSYN|tcpACK:
+		state = TCPStateSynReceived
+	case flags&tcpSYN != 0:
+		state = TCPStateSynSent
+	case state == "" || flags&tcpACK != 0:
+		state = TCPStateEstablished
+	}
+	e.flow.TCPState = state
+}
+
+// restart begins the next record of a flow that reached the active
+// timeout. The endpoints and the TCP state carry over.
+func (e *flowEntry) restart(now time.Time) {
+	e.flow.StartedAt = time.Time{}
+	e.flow.EndedAt = time.Time{}
+	e.flow.SourcePackets = 0
+	e.flow.SourceBytes = 0
+	e.flow.DestinationPackets = 0
+	e.flow.DestinationBytes = 0
+	e.flags = 0
+	e.firstSeen = now
+}
+
+func (e *flowEntry) closed() bool {
+	return e.flow.TCPState == TCPStateClosed || e.flow.TCPState == TCPStateReset
+}
+
+func (e *flowEntry) closeReason() string {
+	if e.flow.TCPState == TCPStateReset {
+		return FlowEndRST
+	}
+	return FlowEndFIN
+}
+
+func tcpFlags(packet *SavedPacket) uint8 {
+	var flags uint8
+	// In the bit order of the tcpFIN to tcpCWR constants.
+	fields := [...]*bool{
+		packet.TCPFlagFIN, packet.TCPFlagSYN, packet.TCPFlagRST, packet.TCPFlagPSH,
+		packet.TCPFlagACK, packet.TCPFlagURG, packet.TCPFlagECE, packet.TCPFlagCWR,
+	}
+	for i, set := range fields {
+		if set != nil && *set {
+			flags |= 1 << i
+		}
+	}
+	return flags
+}
+
+func portOrZero(port *int) int {
+	if port == nil {
+		return 0
+	}
+	return *port
+}
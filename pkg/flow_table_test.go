@@ -0,0 +1,216 @@
+package pkg
+
+import (
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// tcpTestPacket builds an Ethernet TCP segment captured on eth0 at the
+// given time. flags holds the letters of the flags set, "SA" for a SYN-ACK.
+func tcpTestPacket(t *testing.T, src, dst string, srcPort, dstPort int, flags string, at time.Time) AppPacket {
+	t.Helper()
+	return tcpSegmentTestPacket(t, src, dst, srcPort, dstPort, flags, 0, "", at)
+}
+
+func tcpSegmentTestPacket(t *testing.T, src, dst string, srcPort, dstPort int, flags string, seq uint32, payload string, at time.Time) AppPacket {
+	t.Helper()
+	ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolTCP, SrcIP: net.ParseIP(src), DstIP: net.ParseIP(dst)}
+	tcp := &layers.TCP{SrcPort: layers.TCPPort(srcPort), DstPort: layers.TCPPort(dstPort), Seq: seq, Window: 1024}
+	for _, flag := range flags {
+		switch flag {
+		case 'S':
+			tcp.SYN = true
+		case 'A':
+			tcp.ACK = true
+		case 'F':
+			tcp.FIN = true
+		case 'R':
+			tcp.RST = true
+		case 'P':
+			tcp.PSH = true
+		}
+	}
+	tcp.SetNetworkLayerForChecksum(ip)
+	eth := &layers.Ethernet{SrcMAC: testSrcMAC, DstMAC: testDstMAC, EthernetType: layers.EthernetTypeIPv4}
+	packet := serializeTestFrame(t, layers.LayerTypeEthernet, eth, ip, tcp, gopacket.Payload(payload))
+	return AppPacket{Data: packet, CreatedAt: at, UpdatedAt: at, DeviceID: "eth0", LinkType: layers.LinkTypeEthernet}
+}
+
+func udpTestPacket(t *testing.T, src, dst string, srcPort, dstPort int, at time.Time) AppPacket {
+	t.Helper()
+	return udpDatagramTestPacket(t, src, dst, srcPort, dstPort, []byte("payload"), at)
+}
+
+func udpDatagramTestPacket(t *testing.T, src, dst string, srcPort, dstPort int, payload []byte, at time.Time) AppPacket {
+	t.Helper()
+	ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: net.ParseIP(src), DstIP: net.ParseIP(dst)}
+	udp := &layers.UDP{SrcPort: layers.UDPPort(srcPort), DstPort: layers.UDPPort(dstPort)}
+	udp.SetNetworkLayerForChecksum(ip)
+	eth := &layers.Ethernet{SrcMAC: testSrcMAC, DstMAC: testDstMAC, EthernetType: layers.EthernetTypeIPv4}
+	packet := serializeTestFrame(t, layers.LayerTypeEthernet, eth, ip, udp, gopacket.Payload(payload))
+	return AppPacket{Data: packet, CreatedAt: at, UpdatedAt: at, DeviceID: "eth0", LinkType: layers.LinkTypeEthernet}
+}
+
+func TestFlowTableTCPHandshakeAndTeardown(t *testing.T) {
+	table := NewFlowTable(time.Minute, 0, 0, 0)
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	client, server := "10.0.0.1", "10.0.0.2"
+	segments := []AppPacket{
+		tcpTestPacket(t, client, server, 40000, 443, "S", start),
+		tcpTestPacket(t, server, client, 443, 40000, "SA", start.Add(time.Millisecond)),
+		tcpTestPacket(t, client, server, 40000, 443, "A", start.Add(2*time.Millisecond)),
+		tcpTestPacket(t, client, server, 40000, 443, "PA", start.Add(3*time.Millisecond)),
+		tcpTestPacket(t, server, client, 443, 40000, "FA", start.Add(4*time.Millisecond)),
+		tcpTestPacket(t, client, server, 40000, 443, "FA", start.Add(5*time.Millisecond)),
+	}
+	for _, segment := range segments {
+		table.Add(segment)
+	}
+	if table.Len() != 1 {
+		t.Fatalf("expected both directions in one flow, got %d flows", table.Len())
+	}
+	if flows := table.Expire(start.Add(time.Second)); len(flows) != 0 {
+		t.Fatalf("expected closed flow to wait for late segments, got %+v", flows)
+	}
+
+	flows := table.Expire(start.Add(flowCloseTimeout + time.Second))
+	if len(flows) != 1 {
+		t.Fatalf("expected 1 flow, got %d", len(flows))
+	}
+	flow := flows[0]
+	if flow.SourceIP != client || *flow.SourcePort != 40000 || flow.DestinationIP != server || *flow.DestinationPort != 443 {
+		t.Errorf("expected client to server flow, got %+v", flow)
+	}
+	if flow.SourcePackets != 4 || flow.DestinationPackets != 2 {
+		t.Errorf("expected 4 and 2 packets, got %d and %d", flow.SourcePackets, flow.DestinationPackets)
+	}
+	frameLength := uint64(len(segments[0].Data.Data()))
+	if flow.SourceBytes != 4*frameLength || flow.DestinationBytes != 2*frameLength {
+		t.Errorf("unexpected byte counts %d and %d", flow.SourceBytes, flow.DestinationBytes)
+	}
+	if !flow.StartedAt.Equal(start) || !flow.EndedAt.Equal(start.Add(5*time.Millisecond)) {
+		t.Errorf("unexpected times %v to %v", flow.StartedAt, flow.EndedAt)
+	}
+	if flow.TCPState != TCPStateClosed || flow.EndReason != FlowEndFIN {
+		t.Errorf("expected closed flow ended by FIN, got %s and %s", flow.TCPState, flow.EndReason)
+	}
+	if want := []string{"FIN", "SYN", "PSH", "ACK"}; !slices.Equal(flow.TCPFlags, want) {
+		t.Errorf("expected flags %v, got %v", want, flow.TCPFlags)
+	}
+	if table.Len() != 0 {
+		t.Errorf("expected empty table, got %d flows", table.Len())
+	}
+}
+
+func TestFlowTableStartsFromSynAck(t *testing.T) {
+	table := NewFlowTable(time.Minute, 0, 0, 0)
+	start := time.Now()
+	table.Add(tcpTestPacket(t, "10.0.0.2", "10.0.0.1", 443, 40000, "SA", start))
+	table.Add(tcpTestPacket(t, "10.0.0.1", "10.0.0.2", 40000, 443, "A", start))
+
+	flows := table.Flush()
+	if len(flows) != 1 {
+		t.Fatalf("expected 1 flow, got %d", len(flows))
+	}
+	if flows[0].SourceIP != "10.0.0.1" || flows[0].SourcePackets != 1 || flows[0].DestinationPackets != 1 {
+		t.Errorf("expected flow turned around to the client, got %+v", flows[0])
+	}
+	if flows[0].TCPState != TCPStateEstablished || flows[0].EndReason != FlowEndShutdown {
+		t.Errorf("unexpected state %s and reason %s", flows[0].TCPState, flows[0].EndReason)
+	}
+}
+
+func TestFlowTableReset(t *testing.T) {
+	table := NewFlowTable(time.Minute, 0, 0, 0)
+	start := time.Now()
+	table.Add(tcpTestPacket(t, "10.0.0.1", "10.0.0.2", 40000, 22, "S", start))
+	table.Add(tcpTestPacket(t, "10.0.0.2", "10.0.0.1", 22, 40000, "RA", start))
+	// The port is reused right away for a new connection.
+	table.Add(tcpTestPacket(t, "10.0.0.1", "10.0.0.2", 40000, 22, "S", start.Add(time.Second)))
+
+	flows := table.Expire(start.Add(2 * time.Second))
+	if len(flows) != 1 || flows[0].TCPState != TCPStateReset || flows[0].EndReason != FlowEndRST {
+		t.Fatalf("expected the reset flow, got %+v", flows)
+	}
+	if table.Len() != 1 {
+		t.Errorf("expected the new connection to be tracked, got %d flows", table.Len())
+	}
+}
+
+func TestFlowTableIdleTimeout(t *testing.T) {
+	table := NewFlowTable(time.Minute, 0, 0, 0)
+	start := time.Now()
+	table.Add(udpTestPacket(t, "10.0.0.1", "10.0.0.53", 5353, 53, start))
+	table.Add(udpTestPacket(t, "10.0.0.53", "10.0.0.1", 53, 5353, start.Add(time.Second)))
+	table.Add(udpTestPacket(t, "10.0.0.1", "10.0.0.53", 5354, 53, start.Add(30*time.Second)))
+
+	flows := table.Expire(start.Add(61 * time.Second))
+	if len(flows) != 1 {
+		t.Fatalf("expected 1 idle flow, got %d", len(flows))
+	}
+	flow := flows[0]
+	if flow.Protocol != ProtocolUDP || flow.EndReason != FlowEndIdle || flow.TCPState != "" || flow.TCPFlags != nil {
+		t.Errorf("unexpected flow %+v", flow)
+	}
+	if *flow.SourcePort != 5353 || flow.SourcePackets != 1 || flow.DestinationPackets != 1 {
+		t.Errorf("unexpected flow %+v", flow)
+	}
+	if table.Len() != 1 {
+		t.Errorf("expected the recent flow to stay, got %d flows", table.Len())
+	}
+}
+
+func TestFlowTableActiveTimeout(t *testing.T) {
+	table := NewFlowTable(time.Minute, 5*time.Minute, 0, 0)
+	start := time.Now()
+	for i := range 10 {
+		table.Add(udpTestPacket(t, "10.0.0.1", "10.0.0.2", 4000, 4001, start.Add(time.Duration(i)*time.Minute)))
+		table.Expire(start.Add(time.Duration(i) * time.Minute))
+	}
+
+	flows := table.Flush()
+	if len(flows) != 1 || flows[0].SourcePackets != 4 {
+		t.Fatalf("expected the second record with 4 packets, got %+v", flows)
+	}
+	if flows[0].EndReason != FlowEndShutdown || !flows[0].StartedAt.Equal(start.Add(6*time.Minute)) {
+		t.Errorf("unexpected record %+v", flows[0])
+	}
+}
+
+func TestFlowTableKeysOnVLANAndDevice(t *testing.T) {
+	table := NewFlowTable(time.Minute, 0, 0, 0)
+	start := time.Now()
+	packet := udpTestPacket(t, "10.0.0.1", "10.0.0.2", 4000, 4001, start)
+	table.Add(packet)
+	other := packet
+	other.DeviceID = "eth1"
+	table.Add(other)
+
+	saved, _ := mapPacketToSavedPacket(packet)
+	saved.VLANID = intPtr(10)
+	table.add(saved, nil, start)
+
+	if table.Len() != 3 {
+		t.Fatalf("expected 3 flows, got %d", table.Len())
+	}
+}
+
+func TestFlowTableIgnoresPacketsWithoutIP(t *testing.T) {
+	table := NewFlowTable(time.Minute, 0, 0, 0)
+	arp := &layers.ARP{
+		AddrType: layers.LinkTypeEthernet, Protocol: layers.EthernetTypeIPv4, HwAddressSize: 6, ProtAddressSize: 4,
+		Operation: layers.ARPRequest, SourceHwAddress: testSrcMAC, SourceProtAddress: net.IPv4(10, 0, 0, 1).To4(),
+		DstHwAddress: make(net.HardwareAddr, 6), DstProtAddress: net.IPv4(10, 0, 0, 2).To4(),
+	}
+	eth := &layers.Ethernet{SrcMAC: testSrcMAC, DstMAC: testDstMAC, EthernetType: layers.EthernetTypeARP}
+	table.Add(AppPacket{Data: serializeTestFrame(t, layers.LayerTypeEthernet, eth, arp), CreatedAt: time.Now(), DeviceID: "eth0"})
+
+	if table.Len() != 0 {
+		t.Fatalf("expected ARP to be ignored, got %d flows", table.Len())
+	}
+}
@@ -0,0 +1,194 @@
+package pkg
+
+import (
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Sources of the addresses of a host.
+const (
+	HostSourceARP    = "arp"
+	HostSourceNDP    = "ndp"
+	HostSourceDHCP   = "dhcp"
+	HostSourceDHCPv6 = "dhcpv6"
+)
+
+// Host is a MAC address seen on the wire with the addresses it announced
+// over ARP and NDP or got from DHCP, and what its DHCP requests tell
+// about it. Vendor comes from the OUI of the MAC.
+type Host struct {
+	ID          uint          `gorm:"primaryKey" json:"id"`
+	MAC         string        `gorm:"not null;uniqueIndex" json:"mac"`
+	Vendor      string        `gorm:"index" json:"vendor,omitempty"`
+	Hostname    string        `gorm:"index" json:"hostname,omitempty"`
+	VendorClass string        `json:"vendor_class,omitempty"`
+	RequestedIP string        `json:"requested_ip,omitempty"`
+	DeviceID    string        `gorm:"not null" json:"device"`
+	FirstSeen   time.Time     `gorm:"not null" json:"first_seen"`
+	LastSeen    time.Time     `gorm:"not null;index" json:"last_seen"`
+	Addresses   []HostAddress `gorm:"foreignKey:HostID;constraint:OnDelete:CASCADE" json:"addresses"`
+}
+
+// HostAddress is an IP address of a host. Source tells how it was last
+// seen, LeaseExpiresAt is set for the addresses leased over DHCP.
+type HostAddress struct {
+	ID             uint       `gorm:"primaryKey" json:"-"`
+	HostID         uint       `gorm:"not null;uniqueIndex:idx_host_address" json:"-"`
+	IP             string     `gorm:"column:address_ip;not null;uniqueIndex:idx_host_address" json:"ip"`
+	IPKey          string     `gorm:"column:address_ip_key;index" json:"-"`
+	Source         string     `gorm:"not null" json:"source"`
+	LeaseExpiresAt *time.Time `json:"lease_expires_at,omitempty"`
+	FirstSeen      time.Time  `gorm:"not null" json:"first_seen"`
+	LastSeen       time.Time  `gorm:"not null" json:"last_seen"`
+}
+
+// HostFilter selects the hosts returned by GetHosts, last seen first. Zero
+// fields do not filter. From and To select the hosts seen in between, IPs
+// the hosts with an address in them. MACs and Hostnames are lower case,
+// Vendor matches part of the vendor name.
+type HostFilter struct {
+	Limit     int
+	From      time.Time
+	To        time.Time
+	MACs      []string
+	IPs       []*net.IPNet
+	Hostnames []string
+	Vendor    string
+}
+
+type HostRepository interface {
+	// SaveHosts merges the hosts into the inventory by MAC.
+	SaveHosts(hosts []Host) error
+	GetHosts(filter HostFilter) ([]Host, error)
+	// GetHostsByMAC returns the hosts of the MACs that have one, without
+	// their addresses.
+	GetHostsByMAC(macs []string) (map[string]Host, error)
+}
+
+type SqlLiteHostRepository struct {
+	db *gorm.DB
+}
+
+func (r *SqlLiteHostRepository) SaveHosts(hosts []Host) error {
+	if len(hosts) == 0 {
+		return nil
+	}
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		for _, seen := range hosts {
+			var host Host
+			if err := tx.Preload("Addresses").Where("mac = ?", seen.MAC).Limit(1).Find(&host).Error; err != nil {
+				return err
+			}
+			host.merge(seen)
+			for i := range host.Addresses {
+				host.Addresses[i].IPKey = ipKey(host.Addresses[i].IP)
+			}
+			if err := tx.Session(&gorm.Session{FullSaveAssociations: true}).Save(&host).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *SqlLiteHostRepository) GetHosts(filter HostFilter) ([]Host, error) {
+	hosts := make([]Host, 0)
+	query := r.db.Model(&Host{})
+	query = spanRange(query, "first_seen", "last_seen", filter.From, filter.To)
+	if len(filter.MACs) > 0 {
+		query = query.Where("mac IN ?", filter.MACs)
+	}
+	if len(filter.IPs) > 0 {
+		addresses := r.db.Model(&HostAddress{}).Select("host_id")
+		query = query.Where("id IN (?)", addresses.Where(ipCondition(addresses, filter.IPs, "address")))
+	}
+	if len(filter.Hostnames) > 0 {
+		query = query.Where("LOWER(hostname) IN ?", filter.Hostnames)
+	}
+	if filter.Vendor != "" {
+		query = query.Where("LOWER(vendor) LIKE ?", "%"+strings.ToLower(filter.Vendor)+"%")
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+	result := query.Preload("Addresses", func(db *gorm.DB) *gorm.DB {
+		return db.Order("last_seen desc")
+	}).Order("last_seen desc").Order("id desc").Find(&hosts)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return hosts, nil
+}
+
+func (r *SqlLiteHostRepository) GetHostsByMAC(macs []string) (map[string]Host, error) {
+	hosts := make(map[string]Host)
+	if len(macs) == 0 {
+		return hosts, nil
+	}
+	var found []Host
+	if err := r.db.Where("mac IN ?", macs).Find(&found).Error; err != nil {
+		return nil, err
+	}
+	for _, host := range found {
+		hosts[host.MAC] = host
+	}
+	return hosts, nil
+}
+
+// merge adds a later sighting of the host to it. Sightings from several
+// devices may come out of order, the latest one tells where the host is
+// and how its addresses were seen.
+func (h *Host) merge(seen Host) {
+	if h.MAC == "" {
+		*h = seen
+		h.Addresses = slices.Clone(seen.Addresses)
+		return
+	}
+	if h.FirstSeen.IsZero() || seen.FirstSeen.Before(h.FirstSeen) {
+		h.FirstSeen = seen.FirstSeen
+	}
+	if !seen.LastSeen.Before(h.LastSeen) {
+		h.LastSeen = seen.LastSeen
+		h.DeviceID = seen.DeviceID
+	}
+	if h.Vendor == "" {
+		h.Vendor = seen.Vendor
+	}
+	if seen.Hostname != "" {
+		h.Hostname = seen.Hostname
+	}
+	if seen.VendorClass != "" {
+		h.VendorClass = seen.VendorClass
+	}
+	if seen.RequestedIP != "" {
+		h.RequestedIP = seen.RequestedIP
+	}
+	for _, address := range seen.Addresses {
+		i := slices.IndexFunc(h.Addresses, func(a HostAddress) bool { return a.IP == address.IP })
+		if i < 0 {
+			h.Addresses = append(h.Addresses, address)
+			continue
+		}
+		known := &h.Addresses[i]
+		if address.FirstSeen.Before(known.FirstSeen) {
+			known.FirstSeen = address.FirstSeen
+		}
+		if !address.LastSeen.Before(known.LastSeen) {
+			known.LastSeen = address.LastSeen
+			known.Source = address.Source
+		}
+		if address.LeaseExpiresAt != nil && (known.LeaseExpiresAt == nil || address.LeaseExpiresAt.After(*known.LeaseExpiresAt)) {
+			known.LeaseExpiresAt = address.LeaseExpiresAt
+		}
+	}
+}
+
+func NewSqlLiteHostRepository(db *gorm.DB) HostRepository {
+	db.AutoMigrate(&Host{}, &HostAddress{})
+
+	return &SqlLiteHostRepository{db: db}
+}
@@ -0,0 +1,123 @@
+package pkg
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupHostTestDB(t *testing.T) HostRepository {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open test database: %v", err)
+	}
+	return NewSqlLiteHostRepository(db)
+}
+
+func TestHostRepositoryMergesSightings(t *testing.T) {
+	repo := setupHostTestDB(t)
+	start := time.Now().Add(-time.Hour)
+	lease := start.Add(24 * time.Hour)
+
+	first := []Host{{
+		MAC: "00:50:56:01:02:03", Vendor: "VMware", Hostname: "laptop-7", DeviceID: "eth0", FirstSeen: start, LastSeen: start,
+		Addresses: []HostAddress{{IP: "10.0.0.23", Source: HostSourceDHCP, LeaseExpiresAt: &lease, FirstSeen: start, LastSeen: start}},
+	}}
+	if err := repo.SaveHosts(first); err != nil {
+		t.Fatalf("SaveHosts failed: %v", err)
+	}
+	// Later seen over ARP on another device, then an older sighting comes in.
+	later := []Host{{
+		MAC: "00:50:56:01:02:03", Vendor: "VMware", DeviceID: "eth1", FirstSeen: start.Add(time.Minute), LastSeen: start.Add(time.Minute),
+		Addresses: []HostAddress{
+			{IP: "10.0.0.23", Source: HostSourceARP, FirstSeen: start.Add(time.Minute), LastSeen: start.Add(time.Minute)},
+			{IP: "fe80::250:56ff:fe01:203", Source: HostSourceNDP, FirstSeen: start.Add(time.Minute), LastSeen: start.Add(time.Minute)},
+		},
+	}}
+	older := []Host{{MAC: "00:50:56:01:02:03", DeviceID: "eth2", FirstSeen: start.Add(-time.Minute), LastSeen: start.Add(-time.Minute)}}
+	for _, hosts := range [][]Host{later, older} {
+		if err := repo.SaveHosts(hosts); err != nil {
+			t.Fatalf("SaveHosts failed: %v", err)
+		}
+	}
+
+	hosts, err := repo.GetHosts(HostFilter{})
+	if err != nil {
+		t.Fatalf("GetHosts failed: %v", err)
+	}
+	if len(hosts) != 1 {
+		t.Fatalf("expected 1 host, got %+v", hosts)
+	}
+	host := hosts[0]
+	if host.Hostname != "laptop-7" || host.DeviceID != "eth1" {
+		t.Errorf("expected the hostname kept and the latest device, got %+v", host)
+	}
+	if !host.FirstSeen.Equal(start.Add(-time.Minute)) || !host.LastSeen.Equal(start.Add(time.Minute)) {
+		t.Errorf("unexpected first and last seen %v %v", host.FirstSeen, host.LastSeen)
+	}
+	if len(host.Addresses) != 2 {
+		t.Fatalf("expected 2 addresses, got %+v", host.Addresses)
+	}
+	for _, address := range host.Addresses {
+		if address.IP == "10.0.0.23" && (address.Source != HostSourceARP || address.LeaseExpiresAt == nil || !address.FirstSeen.Equal(start)) {
+			t.Errorf("expected the lease kept on the address seen over ARP, got %+v", address)
+		}
+	}
+}
+
+func TestHostRepositoryGetHosts(t *testing.T) {
+	repo := setupHostTestDB(t)
+	start := time.Now().Add(-time.Hour)
+	hosts := []Host{
+		{MAC: "00:50:56:00:00:01", Vendor: "VMware", Hostname: "Build-01", DeviceID: "eth0", FirstSeen: start, LastSeen: start.Add(time.Minute),
+			Addresses: []HostAddress{{IP: "10.0.0.1", Source: HostSourceARP, FirstSeen: start, LastSeen: start}}},
+		{MAC: "b8:27:eb:00:00:02", Vendor: "Raspberry Pi Foundation", DeviceID: "eth0", FirstSeen: start.Add(10 * time.Minute), LastSeen: start.Add(20 * time.Minute),
+			Addresses: []HostAddress{{IP: "192.168.1.7", Source: HostSourceDHCP, FirstSeen: start, LastSeen: start}}},
+		{MAC: "02:00:00:00:00:03", DeviceID: "eth1", FirstSeen: start.Add(30 * time.Minute), LastSeen: start.Add(40 * time.Minute)},
+	}
+	if err := repo.SaveHosts(hosts); err != nil {
+		t.Fatalf("SaveHosts failed: %v", err)
+	}
+	_, network, _ := net.ParseCIDR("192.168.0.0/16")
+
+	tests := []struct {
+		name   string
+		filter HostFilter
+		want   []string
+	}{
+		{"all, last seen first", HostFilter{}, []string{"02:00:00:00:00:03", "b8:27:eb:00:00:02", "00:50:56:00:00:01"}},
+		{"limit", HostFilter{Limit: 1}, []string{"02:00:00:00:00:03"}},
+		{"mac", HostFilter{MACs: []string{"00:50:56:00:00:01"}}, []string{"00:50:56:00:00:01"}},
+		{"address in network", HostFilter{IPs: []*net.IPNet{network}}, []string{"b8:27:eb:00:00:02"}},
+		{"hostname case insensitive", HostFilter{Hostnames: []string{"build-01"}}, []string{"00:50:56:00:00:01"}},
+		{"vendor part", HostFilter{Vendor: "raspberry"}, []string{"b8:27:eb:00:00:02"}},
+		{"seen in range", HostFilter{From: start.Add(5 * time.Minute), To: start.Add(35 * time.Minute)}, []string{"02:00:00:00:00:03", "b8:27:eb:00:00:02"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := repo.GetHosts(tt.filter)
+			if err != nil {
+				t.Fatalf("GetHosts failed: %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("expected %v, got %+v", tt.want, got)
+			}
+			for i, host := range got {
+				if host.MAC != tt.want[i] {
+					t.Errorf("expected %v, got %s at %d", tt.want, host.MAC, i)
+				}
+			}
+		})
+	}
+
+	byMAC, err := repo.GetHostsByMAC([]string{"00:50:56:00:00:01", "ff:ff:ff:ff:ff:ff"})
+	if err != nil {
+		t.Fatalf("GetHostsByMAC failed: %v", err)
+	}
+	if len(byMAC) != 1 || byMAC["00:50:56:00:00:01"].ID == 0 {
+		t.Errorf("expected the one known host, got %+v", byMAC)
+	}
+}
@@ -0,0 +1,293 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// dhcpInfiniteLease is the lease time of the addresses that never expire.
+const dhcpInfiniteLease = 0xffffffff
+
+// HostTracker builds the passive inventory of the hosts on the wire from
+// their ARP, NDP, DHCP and DHCPv6 traffic. It keeps what was seen of each
+// MAC since the last Take.
+type HostTracker struct {
+	mu    sync.Mutex
+	hosts map[string]*Host
+}
+
+func NewHostTracker() *HostTracker {
+	return &HostTracker{hosts: make(map[string]*Host)}
+}
+
+// Add records what the packet tells about its hosts, if anything.
+func (t *HostTracker) Add(packet AppPacket) {
+	if packet.Data == nil {
+		return
+	}
+	var sightings []Host
+	for _, layer := range packet.Data.Layers() {
+		switch l := layer.(type) {
+		case *layers.ARP:
+			sightings = arpSightings(l)
+		case *layers.ICMPv6NeighborSolicitation:
+			sightings = ndpSighting(packet.Data, l.Options, layers.ICMPv6OptSourceAddress, nil)
+		case *layers.ICMPv6NeighborAdvertisement:
+			sightings = ndpSighting(packet.Data, l.Options, layers.ICMPv6OptTargetAddress, l.TargetAddress)
+		case *layers.ICMPv6RouterSolicitation:
+			sightings = ndpSighting(packet.Data, l.Options, layers.ICMPv6OptSourceAddress, nil)
+		case *layers.ICMPv6RouterAdvertisement:
+			sightings = ndpSighting(packet.Data, l.Options, layers.ICMPv6OptSourceAddress, nil)
+		case *layers.DHCPv4:
+			sightings = dhcpSightings(l, packet.CreatedAt)
+		case *layers.DHCPv6:
+			sightings = dhcpv6Sightings(packet.Data, l, packet.CreatedAt)
+		}
+	}
+	if len(sightings) == 0 {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, seen := range sightings {
+		seen.Vendor = macVendor(seen.MAC)
+		seen.DeviceID = packet.DeviceID
+		seen.FirstSeen, seen.LastSeen = packet.CreatedAt, packet.CreatedAt
+		for i := range seen.Addresses {
+			seen.Addresses[i].FirstSeen, seen.Addresses[i].LastSeen = packet.CreatedAt, packet.CreatedAt
+		}
+		host, ok := t.hosts[seen.MAC]
+		if !ok {
+			host = &Host{}
+			t.hosts[seen.MAC] = host
+		}
+		host.merge(seen)
+	}
+}
+
+// Take returns the hosts seen since the last call, first seen first.
+func (t *HostTracker) Take() []Host {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	hosts := make([]Host, 0, len(t.hosts))
+	for mac, host := range t.hosts {
+		hosts = append(hosts, *host)
+		delete(t.hosts, mac)
+	}
+	slices.SortFunc(hosts, func(a, b Host) int { return a.FirstSeen.Compare(b.FirstSeen) })
+	return hosts
+}
+
+// hostSighting is a MAC with one address, if known.
+func hostSighting(mac net.HardwareAddr, ip net.IP, source string) Host {
+	host := Host{MAC: mac.String()}
+	if ip != nil && !ip.IsUnspecified() {
+		host.Addresses = []HostAddress{{IP: ip.String(), Source: source}}
+	}
+	return host
+}
+
+// usableMAC tells whether a MAC can be the address of a host rather than
+// a broadcast, multicast or placeholder one.
+func usableMAC(mac net.HardwareAddr) bool {
+	return len(mac) == 6 && mac[0]&0x01 == 0 && !bytes.Equal(mac, make(net.HardwareAddr, 6))
+}
+
+// arpSightings reads the sender of ARP requests and replies, and the
+// target of the replies. Probes have no sender address.
+func arpSightings(arp *layers.ARP) []Host {
+	if arp.AddrType != layers.LinkTypeEthernet || arp.Protocol != layers.EthernetTypeIPv4 || arp.HwAddressSize != 6 || arp.ProtAddressSize != 4 {
+		return nil
+	}
+	var sightings []Host
+	if sender := net.HardwareAddr(arp.SourceHwAddress); usableMAC(sender) {
+		sightings = append(sightings, hostSighting(sender, net.IP(arp.SourceProtAddress), HostSourceARP))
+	}
+	if target := net.HardwareAddr(arp.DstHwAddress); arp.Operation == layers.ARPReply && usableMAC(target) {
+		sightings = append(sightings, hostSighting(target, net.IP(arp.DstProtAddress), HostSourceARP))
+	}
+	return sightings
+}
+
+// ndpSighting reads the link-layer address option of a neighbor or router
+// discovery message. It belongs to the target address of advertisements
+// and to the sender of the other messages. Advertisements without the
+// option come from the target itself.
+func ndpSighting(packet gopacket.Packet, options layers.ICMPv6Options, option layers.ICMPv6Opt, target net.IP) []Host {
+	ip, ok := packet.NetworkLayer().(*layers.IPv6)
+	if !ok {
+		return nil
+	}
+	var mac net.HardwareAddr
+	for _, o := range options {
+		if o.Type == option && len(o.Data) >= 6 {
+			mac = net.HardwareAddr(o.Data[:6])
+		}
+	}
+	if eth, ok := packet.LinkLayer().(*layers.Ethernet); ok && mac == nil && target != nil {
+		mac = eth.SrcMAC
+	}
+	if !usableMAC(mac) {
+		return nil
+	}
+	address := ip.SrcIP
+	if target != nil {
+		address = target
+	}
+	return []Host{hostSighting(mac, address, HostSourceNDP)}
+}
+
+// dhcpSightings reads the client requests for their hostname, vendor
+// class and the address they ask for, and the acknowledgments for the
+// address they lease.
+func dhcpSightings(dhcp *layers.DHCPv4, at time.Time) []Host {
+	if dhcp.HardwareType != layers.LinkTypeEthernet || !usableMAC(dhcp.ClientHWAddr) {
+		return nil
+	}
+	var messageType layers.DHCPMsgType
+	options := make(map[layers.DHCPOpt][]byte)
+	for _, option := range dhcp.Options {
+		options[option.Type] = option.Data
+		if option.Type == layers.DHCPOptMessageType && len(option.Data) == 1 {
+			messageType = layers.DHCPMsgType(option.Data[0])
+		}
+	}
+	switch messageType {
+	case layers.DHCPMsgTypeDiscover, layers.DHCPMsgTypeRequest, layers.DHCPMsgTypeInform:
+		host := hostSighting(dhcp.ClientHWAddr, dhcp.ClientIP, HostSourceDHCP)
+		host.Hostname = dhcpString(options[layers.DHCPOptHostname])
+		host.VendorClass = dhcpString(options[layers.DHCPOptClassID])
+		if requested := options[layers.DHCPOptRequestIP]; len(requested) == 4 {
+			host.RequestedIP = net.IP(requested).String()
+		}
+		return []Host{host}
+	case layers.DHCPMsgTypeAck:
+		host := hostSighting(dhcp.ClientHWAddr, dhcp.YourClientIP, HostSourceDHCP)
+		if lease := options[layers.DHCPOptLeaseTime]; len(host.Addresses) == 1 && len(lease) == 4 {
+			host.Addresses[0].LeaseExpiresAt = leaseExpiry(at, binary.BigEndian.Uint32(lease))
+		}
+		return []Host{host}
+	}
+	return nil
+}
+
+// dhcpv6Sightings reads the client messages for their FQDN, vendor class
+// and the address they ask for, and the replies for the addresses they
+// lease. The client is told by the link-layer address of its DUID, or by
+// the MAC it sends from or is answered to.
+func dhcpv6Sightings(packet gopacket.Packet, dhcp *layers.DHCPv6, at time.Time) []Host {
+	eth, _ := packet.LinkLayer().(*layers.Ethernet)
+	var client net.HardwareAddr
+	var addresses []HostAddress
+	var host Host
+	for _, option := range dhcp.Options {
+		switch option.Code {
+		case layers.DHCPv6OptClientID:
+			var duid layers.DHCPv6DUID
+			if duid.DecodeFromBytes(option.Data) == nil && (duid.Type == layers.DHCPv6DUIDTypeLL || duid.Type == layers.DHCPv6DUIDTypeLLT) &&
+				bytes.Equal(duid.HardwareType, []byte{0, 1}) {
+				client = duid.LinkLayerAddress
+			}
+		case layers.DHCPv6OptIANA:
+			addresses = append(addresses, dhcpv6Addresses(option.Data, at)...)
+		case layers.DHCPv6OptClientFQDN:
+			if len(option.Data) > 1 {
+				host.Hostname = dhcpv6Name(option.Data[1:])
+			}
+		case layers.DHCPv6OptVendorClass:
+			// The enterprise number, then length prefixed strings.
+			if len(option.Data) > 6 {
+				host.VendorClass = dhcpString(option.Data[6:min(len(option.Data), 6+int(binary.BigEndian.Uint16(option.Data[4:6])))])
+			}
+		}
+	}
+	switch dhcp.MsgType {
+	case layers.DHCPv6MsgTypeSolicit, layers.DHCPv6MsgTypeRequest, layers.DHCPv6MsgTypeConfirm, layers.DHCPv6MsgTypeRenew,
+		layers.DHCPv6MsgTypeRebind, layers.DHCPv6MsgTypeInformationRequest:
+		if client == nil && eth != nil {
+			client = eth.SrcMAC
+		}
+		if len(addresses) > 0 {
+			host.RequestedIP = addresses[0].IP
+		}
+	case layers.DHCPv6MsgTypeReply:
+		if client == nil && eth != nil {
+			client = eth.DstMAC
+		}
+		host = Host{}
+		for _, address := range addresses {
+			if address.Source != "" {
+				host.Addresses = append(host.Addresses, address)
+			}
+		}
+	default:
+		return nil
+	}
+	if !usableMAC(client) {
+		return nil
+	}
+	host.MAC = client.String()
+	return []Host{host}
+}
+
+// dhcpv6Addresses reads the addresses of an IA_NA option. Addresses with a
+// valid lifetime are leased, the others are only asked for.
+func dhcpv6Addresses(iana []byte, at time.Time) []HostAddress {
+	if len(iana) < 12 {
+		return nil
+	}
+	var addresses []HostAddress
+	for options := iana[12:]; len(options) >= 4; {
+		code := layers.DHCPv6Opt(binary.BigEndian.Uint16(options[:2]))
+		length := int(binary.BigEndian.Uint16(options[2:4]))
+		if len(options) < 4+length {
+			break
+		}
+		data := options[4 : 4+length]
+		options = options[4+length:]
+		if code != layers.DHCPv6OptIAAddr || len(data) < 24 {
+			continue
+		}
+		address := HostAddress{IP: net.IP(data[:16]).String()}
+		if valid := binary.BigEndian.Uint32(data[20:24]); valid > 0 {
+			address.Source = HostSourceDHCPv6
+			address.LeaseExpiresAt = leaseExpiry(at, valid)
+		}
+		addresses = append(addresses, address)
+	}
+	return addresses
+}
+
+// dhcpv6Name decodes the domain name of a Client FQDN option, which may be
+// partial and miss its final empty label.
+func dhcpv6Name(data []byte) string {
+	var labels []string
+	for len(data) > 0 && data[0] > 0 && int(data[0]) < len(data) {
+		labels = append(labels, string(data[1:1+data[0]]))
+		data = data[1+data[0]:]
+	}
+	return dhcpString([]byte(strings.Join(labels, ".")))
+}
+
+func leaseExpiry(at time.Time, seconds uint32) *time.Time {
+	if seconds == dhcpInfiniteLease {
+		return nil
+	}
+	expiry := at.Add(time.Duration(seconds) * time.Second)
+	return &expiry
+}
+
+// dhcpString reads an option holding text, without the NUL some clients
+// end it with.
+func dhcpString(data []byte) string {
+	return strings.TrimSpace(strings.TrimRight(string(data), "\x00"))
+}
@@ -0,0 +1,196 @@
+package pkg
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// vmwareMAC is in the IEEE OUI registry.
+var vmwareMAC = net.HardwareAddr{0x00, 0x50, 0x56, 0x01, 0x02, 0x03}
+
+func arpTestPacket(t *testing.T, operation uint16, srcMAC net.HardwareAddr, srcIP string, dstMAC net.HardwareAddr, dstIP string, at time.Time) AppPacket {
+	t.Helper()
+	eth := &layers.Ethernet{SrcMAC: srcMAC, DstMAC: layers.EthernetBroadcast, EthernetType: layers.EthernetTypeARP}
+	arp := &layers.ARP{
+		AddrType: layers.LinkTypeEthernet, Protocol: layers.EthernetTypeIPv4, HwAddressSize: 6, ProtAddressSize: 4, Operation: operation,
+		SourceHwAddress: srcMAC, SourceProtAddress: net.ParseIP(srcIP).To4(), DstHwAddress: dstMAC, DstProtAddress: net.ParseIP(dstIP).To4(),
+	}
+	packet := serializeTestFrame(t, layers.LayerTypeEthernet, eth, arp)
+	return AppPacket{Data: packet, CreatedAt: at, UpdatedAt: at, DeviceID: "eth0", LinkType: layers.LinkTypeEthernet}
+}
+
+func dhcpTestPacket(t *testing.T, operation layers.DHCPOp, clientMAC net.HardwareAddr, yourIP string, at time.Time, options ...layers.DHCPOption) AppPacket {
+	t.Helper()
+	ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: net.IPv4zero, DstIP: net.IPv4bcast}
+	udp := &layers.UDP{SrcPort: 68, DstPort: 67}
+	if operation == layers.DHCPOpReply {
+		udp.SrcPort, udp.DstPort = 67, 68
+	}
+	udp.SetNetworkLayerForChecksum(ip)
+	dhcp := &layers.DHCPv4{
+		Operation: operation, HardwareType: layers.LinkTypeEthernet, HardwareLen: 6, Xid: 42,
+		ClientHWAddr: clientMAC, YourClientIP: net.ParseIP(yourIP), Options: append(options, layers.NewDHCPOption(layers.DHCPOptEnd, nil)),
+	}
+	eth := &layers.Ethernet{SrcMAC: clientMAC, DstMAC: layers.EthernetBroadcast, EthernetType: layers.EthernetTypeIPv4}
+	packet := serializeTestFrame(t, layers.LayerTypeEthernet, eth, ip, udp, dhcp)
+	return AppPacket{Data: packet, CreatedAt: at, UpdatedAt: at, DeviceID: "eth0", LinkType: layers.LinkTypeEthernet}
+}
+
+func TestMACVendor(t *testing.T) {
+	tests := []struct{ mac, want string }{
+		{"00:00:0c:01:02:03", "Cisco Systems, Inc"},
+		{"b8:27:eb:00:00:02", "Raspberry Pi Foundation"},
+		{"3c:22:fb:aa:bb:cc", "Apple, Inc."},
+		// Locally administered, as randomized MACs are.
+		{"02:00:00:00:00:01", ""},
+		{"not a mac", ""},
+	}
+	for _, tt := range tests {
+		if got := macVendor(tt.mac); got != tt.want {
+			t.Errorf("macVendor(%q): expected %q, got %q", tt.mac, tt.want, got)
+		}
+	}
+}
+
+func TestHostTrackerARP(t *testing.T) {
+	tracker := NewHostTracker()
+	start := time.Now()
+	other := net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x09}
+	// A probe has no sender address, the request asks for the target.
+	tracker.Add(arpTestPacket(t, layers.ARPRequest, vmwareMAC, "0.0.0.0", net.HardwareAddr{0, 0, 0, 0, 0, 0}, "10.0.0.5", start))
+	tracker.Add(arpTestPacket(t, layers.ARPRequest, vmwareMAC, "10.0.0.5", net.HardwareAddr{0, 0, 0, 0, 0, 0}, "10.0.0.1", start.Add(time.Second)))
+	tracker.Add(arpTestPacket(t, layers.ARPReply, other, "10.0.0.1", vmwareMAC, "10.0.0.5", start.Add(2*time.Second)))
+
+	hosts := tracker.Take()
+	if len(hosts) != 2 {
+		t.Fatalf("expected 2 hosts, got %+v", hosts)
+	}
+	host := hosts[0]
+	if host.MAC != vmwareMAC.String() || host.Vendor != "VMware, Inc." || host.DeviceID != "eth0" {
+		t.Errorf("unexpected host %+v", host)
+	}
+	if !host.FirstSeen.Equal(start) || !host.LastSeen.Equal(start.Add(2*time.Second)) {
+		t.Errorf("expected the host seen from the probe to the reply, got %v to %v", host.FirstSeen, host.LastSeen)
+	}
+	if len(host.Addresses) != 1 || host.Addresses[0].IP != "10.0.0.5" || host.Addresses[0].Source != HostSourceARP ||
+		!host.Addresses[0].FirstSeen.Equal(start.Add(time.Second)) {
+		t.Errorf("unexpected addresses %+v", host.Addresses)
+	}
+	if hosts[1].MAC != other.String() || hosts[1].Vendor != "" || hosts[1].Addresses[0].IP != "10.0.0.1" {
+		t.Errorf("expected the locally administered replier without vendor, got %+v", hosts[1])
+	}
+	if len(tracker.Take()) != 0 {
+		t.Error("expected Take to reset the tracker")
+	}
+}
+
+func TestHostTrackerDHCP(t *testing.T) {
+	tracker := NewHostTracker()
+	start := time.Now()
+	tracker.Add(dhcpTestPacket(t, layers.DHCPOpRequest, vmwareMAC, "0.0.0.0", start,
+		layers.NewDHCPOption(layers.DHCPOptMessageType, []byte{byte(layers.DHCPMsgTypeRequest)}),
+		layers.NewDHCPOption(layers.DHCPOptHostname, []byte("laptop-7\x00")),
+		layers.NewDHCPOption(layers.DHCPOptClassID, []byte("MSFT 5.0")),
+		layers.NewDHCPOption(layers.DHCPOptRequestIP, net.ParseIP("10.0.0.23").To4())))
+	tracker.Add(dhcpTestPacket(t, layers.DHCPOpReply, vmwareMAC, "10.0.0.23", start.Add(time.Millisecond),
+		layers.NewDHCPOption(layers.DHCPOptMessageType, []byte{byte(layers.DHCPMsgTypeAck)}),
+		layers.NewDHCPOption(layers.DHCPOptLeaseTime, binary.BigEndian.AppendUint32(nil, 3600))))
+
+	hosts := tracker.Take()
+	if len(hosts) != 1 {
+		t.Fatalf("expected 1 host, got %+v", hosts)
+	}
+	host := hosts[0]
+	if host.Hostname != "laptop-7" || host.VendorClass != "MSFT 5.0" || host.RequestedIP != "10.0.0.23" {
+		t.Errorf("unexpected DHCP details %+v", host)
+	}
+	if len(host.Addresses) != 1 || host.Addresses[0].IP != "10.0.0.23" || host.Addresses[0].Source != HostSourceDHCP {
+		t.Fatalf("unexpected addresses %+v", host.Addresses)
+	}
+	if lease := host.Addresses[0].LeaseExpiresAt; lease == nil || !lease.Equal(start.Add(time.Millisecond+time.Hour)) {
+		t.Errorf("expected the lease to expire in an hour, got %v", lease)
+	}
+}
+
+func TestHostTrackerNDP(t *testing.T) {
+	tracker := NewHostTracker()
+	at := time.Now()
+	ip := &layers.IPv6{Version: 6, NextHeader: layers.IPProtocolICMPv6, HopLimit: 255, SrcIP: net.ParseIP("fe80::250:56ff:fe01:203"), DstIP: net.ParseIP("ff02::1")}
+	icmp := &layers.ICMPv6{TypeCode: layers.CreateICMPv6TypeCode(layers.ICMPv6TypeNeighborAdvertisement, 0)}
+	icmp.SetNetworkLayerForChecksum(ip)
+	advertisement := &layers.ICMPv6NeighborAdvertisement{
+		Flags: 0x20, TargetAddress: net.ParseIP("2001:db8::5"),
+		Options: layers.ICMPv6Options{{Type: layers.ICMPv6OptTargetAddress, Data: vmwareMAC}},
+	}
+	eth := &layers.Ethernet{SrcMAC: testSrcMAC, DstMAC: net.HardwareAddr{0x33, 0x33, 0, 0, 0, 1}, EthernetType: layers.EthernetTypeIPv6}
+	packet := serializeTestFrame(t, layers.LayerTypeEthernet, eth, ip, icmp, advertisement)
+	tracker.Add(AppPacket{Data: packet, CreatedAt: at, UpdatedAt: at, DeviceID: "eth0", LinkType: layers.LinkTypeEthernet})
+
+	hosts := tracker.Take()
+	if len(hosts) != 1 || hosts[0].MAC != vmwareMAC.String() || len(hosts[0].Addresses) != 1 {
+		t.Fatalf("expected the advertised target, got %+v", hosts)
+	}
+	if address := hosts[0].Addresses[0]; address.IP != "2001:db8::5" || address.Source != HostSourceNDP {
+		t.Errorf("unexpected address %+v", address)
+	}
+}
+
+func TestHostTrackerDHCPv6(t *testing.T) {
+	tracker := NewHostTracker()
+	start := time.Now()
+	duid := append([]byte{0, byte(layers.DHCPv6DUIDTypeLL), 0, 1}, vmwareMAC...)
+	iaAddress := append(net.ParseIP("2001:db8::23").To16(), 0, 0, 0x0e, 0x10, 0, 0, 0x1c, 0x20)
+	iana := append(make([]byte, 12), 0, byte(layers.DHCPv6OptIAAddr), 0, byte(len(iaAddress)))
+	iana = append(iana, iaAddress...)
+	fqdn := append([]byte{0x01, 7}, "desktop"...)
+	fqdn = append(append(fqdn, 4), "corp"...)
+	vendorClass := append([]byte{0, 0, 0x01, 0x37, 0, 8}, "MSFT 5.0"...)
+
+	send := func(messageType layers.DHCPv6MsgType, src, dst string, srcMAC, dstMAC net.HardwareAddr, srcPort, dstPort int, at time.Time, options ...layers.DHCPv6Option) {
+		ip := &layers.IPv6{Version: 6, NextHeader: layers.IPProtocolUDP, HopLimit: 1, SrcIP: net.ParseIP(src), DstIP: net.ParseIP(dst)}
+		udp := &layers.UDP{SrcPort: layers.UDPPort(srcPort), DstPort: layers.UDPPort(dstPort)}
+		udp.SetNetworkLayerForChecksum(ip)
+		dhcp := &layers.DHCPv6{MsgType: messageType, TransactionID: []byte{1, 2, 3}, Options: options}
+		eth := &layers.Ethernet{SrcMAC: srcMAC, DstMAC: dstMAC, EthernetType: layers.EthernetTypeIPv6}
+		packet := serializeTestFrame(t, layers.LayerTypeEthernet, eth, ip, udp, dhcp)
+		tracker.Add(AppPacket{Data: packet, CreatedAt: at, UpdatedAt: at, DeviceID: "eth0", LinkType: layers.LinkTypeEthernet})
+	}
+	// The request is relayed from another MAC, the DUID tells the client.
+	send(layers.DHCPv6MsgTypeRequest, "fe80::1", "ff02::1:2", testSrcMAC, net.HardwareAddr{0x33, 0x33, 0, 1, 0, 2}, 546, 547, start,
+		layers.NewDHCPv6Option(layers.DHCPv6OptClientID, duid),
+		layers.NewDHCPv6Option(layers.DHCPv6OptIANA, iana),
+		layers.NewDHCPv6Option(layers.DHCPv6OptClientFQDN, fqdn),
+		layers.NewDHCPv6Option(layers.DHCPv6OptVendorClass, vendorClass))
+	send(layers.DHCPv6MsgTypeReply, "fe80::2", "fe80::1", testDstMAC, testSrcMAC, 547, 546, start.Add(time.Millisecond),
+		layers.NewDHCPv6Option(layers.DHCPv6OptClientID, duid),
+		layers.NewDHCPv6Option(layers.DHCPv6OptIANA, iana))
+
+	hosts := tracker.Take()
+	if len(hosts) != 1 || hosts[0].MAC != vmwareMAC.String() {
+		t.Fatalf("expected the client of the DUID, got %+v", hosts)
+	}
+	host := hosts[0]
+	if host.Hostname != "desktop.corp" || host.VendorClass != "MSFT 5.0" || host.RequestedIP != "2001:db8::23" {
+		t.Errorf("unexpected DHCPv6 details %+v", host)
+	}
+	if len(host.Addresses) != 1 || host.Addresses[0].IP != "2001:db8::23" || host.Addresses[0].Source != HostSourceDHCPv6 {
+		t.Fatalf("unexpected addresses %+v", host.Addresses)
+	}
+	if lease := host.Addresses[0].LeaseExpiresAt; lease == nil || !lease.Equal(start.Add(time.Millisecond+2*time.Hour)) {
+		t.Errorf("expected the valid lifetime as lease, got %v", lease)
+	}
+}
+
+func TestHostTrackerIgnoresOtherTraffic(t *testing.T) {
+	tracker := NewHostTracker()
+	tracker.Add(udpTestPacket(t, "10.0.0.1", "10.0.0.2", 4000, 53, time.Now()))
+	tracker.Add(AppPacket{Data: gopacket.NewPacket(nil, layers.LayerTypeEthernet, gopacket.Default)})
+	if hosts := tracker.Take(); len(hosts) != 0 {
+		t.Errorf("expected no hosts, got %+v", hosts)
+	}
+}
@@ -0,0 +1,204 @@
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// httpMethods are the methods a client side must start with to be parsed
+// as HTTP.
+var httpMethods = []string{"GET", "HEAD", "POST", "PUT", "DELETE", "CONNECT", "OPTIONS", "TRACE", "PATCH"}
+
+// ParseHTTPTransactions reads the plaintext HTTP/1.0 and 1.1 requests of a
+// reassembled TCP flow and pairs them with their responses in order, so
+// that keep-alive and pipelined connections give one transaction a
+// request. The client is the side that starts with a request line, which
+// tells it even for flows picked up in the middle. Parsing stops at the
+// first data that is not HTTP, such as after a sequence gap or an upgrade.
+// Bodies are kept up to bodyMaxBytes, zero keeps none.
+func ParseHTTPTransactions(flow Flow, payload FlowPayload, bodyMaxBytes int) []HTTPTransaction {
+	clientIsSource := true
+	clientData, serverData := payload.SourceData, payload.DestinationData
+	if !startsWithHTTPRequest(clientData) {
+		clientIsSource = false
+		clientData, serverData = serverData, clientData
+		if !startsWithHTTPRequest(clientData) {
+			return nil
+		}
+	}
+	clientTimes := newStreamTimes(payload.Segments, clientIsSource)
+	serverTimes := newStreamTimes(payload.Segments, !clientIsSource)
+
+	var transactions []HTTPTransaction
+	var requests []*http.Request
+	reader := newHTTPReader(clientData)
+	for reader.more() {
+		start := reader.offset()
+		request, err := http.ReadRequest(reader.buffer)
+		if err != nil {
+			break
+		}
+		body, err := readHTTPBody(request.Body, bodyMaxBytes)
+		transactions = append(transactions, newHTTPTransaction(flow, clientIsSource, request, body, clientTimes.at(start)))
+		requests = append(requests, request)
+		if err != nil {
+			break
+		}
+	}
+
+	reader = newHTTPReader(serverData)
+	for i, request := range requests {
+		start := reader.offset()
+		response, err := http.ReadResponse(reader.buffer, request)
+		// Interim responses precede the final one.
+		for err == nil && response.StatusCode >= 100 && response.StatusCode < 200 && response.StatusCode != http.StatusSwitchingProtocols {
+			start = reader.offset()
+			response, err = http.ReadResponse(reader.buffer, request)
+		}
+		if err != nil {
+			break
+		}
+		body, err := readHTTPBody(response.Body, bodyMaxBytes)
+		setHTTPResponse(&transactions[i], response, body, serverTimes.at(start), serverTimes.at(reader.offset()-1))
+		if err != nil || response.StatusCode == http.StatusSwitchingProtocols || request.Method == http.MethodConnect {
+			break
+		}
+	}
+	return transactions
+}
+
+func startsWithHTTPRequest(data []byte) bool {
+	method, _, found := bytes.Cut(data[:min(len(data), 8)], []byte(" "))
+	return found && slices.Contains(httpMethods, string(method))
+}
+
+// httpReader tells how far the parsing of a side got.
+type httpReader struct {
+	data   *bytes.Reader
+	buffer *bufio.Reader
+	size   int
+}
+
+func newHTTPReader(data []byte) *httpReader {
+	reader := bytes.NewReader(data)
+	return &httpReader{data: reader, buffer: bufio.NewReader(reader), size: len(data)}
+}
+
+func (r *httpReader) offset() int {
+	return r.size - r.data.Len() - r.buffer.Buffered()
+}
+
+func (r *httpReader) more() bool {
+	return r.offset() < r.size
+}
+
+// readHTTPBody reads the body through, keeping up to maxBytes of it. The
+// error tells that the data ended in the middle of the body.
+func readHTTPBody(body io.ReadCloser, maxBytes int) ([]byte, error) {
+	defer body.Close()
+	var kept []byte
+	if maxBytes > 0 {
+		var err error
+		if kept, err = io.ReadAll(io.LimitReader(body, int64(maxBytes))); err != nil {
+			return kept, err
+		}
+	}
+	_, err := io.Copy(io.Discard, body)
+	return kept, err
+}
+
+func newHTTPTransaction(flow Flow, clientIsSource bool, request *http.Request, body []byte, at time.Time) HTTPTransaction {
+	transaction := HTTPTransaction{
+		FlowID:             flow.ID,
+		Time:               at,
+		DeviceID:           flow.DeviceID,
+		ClientIP:           flow.SourceIP,
+		ClientPort:         portOrZero(flow.SourcePort),
+		ServerIP:           flow.DestinationIP,
+		ServerPort:         portOrZero(flow.DestinationPort),
+		Method:             request.Method,
+		Host:               httpHost(request.Host),
+		Path:               request.RequestURI,
+		Version:            request.Proto,
+		UserAgent:          request.UserAgent(),
+		RequestContentType: request.Header.Get("Content-Type"),
+		RequestBody:        body,
+	}
+	if !clientIsSource {
+		transaction.ClientIP, transaction.ServerIP = transaction.ServerIP, transaction.ClientIP
+		transaction.ClientPort, transaction.ServerPort = transaction.ServerPort, transaction.ClientPort
+	}
+	if transaction.Time.IsZero() {
+		transaction.Time = flow.StartedAt
+	}
+	if request.Header.Get("Content-Length") != "" {
+		transaction.RequestContentLength = &request.ContentLength
+	}
+	return transaction
+}
+
+// setHTTPResponse completes a transaction with its response, which started
+// at start and ended at end.
+func setHTTPResponse(transaction *HTTPTransaction, response *http.Response, body []byte, start, end time.Time) {
+	transaction.StatusCode = &response.StatusCode
+	transaction.ResponseContentType = response.Header.Get("Content-Type")
+	if response.Header.Get("Content-Length") != "" {
+		transaction.ResponseContentLength = &response.ContentLength
+	}
+	transaction.ResponseBody = body
+	if start.IsZero() {
+		return
+	}
+	transaction.RespondedAt = &start
+	latency := start.Sub(transaction.Time).Microseconds()
+	duration := end.Sub(transaction.Time).Microseconds()
+	transaction.LatencyMicros = &latency
+	transaction.DurationMicros = &duration
+}
+
+// httpHost drops the port of a Host header.
+func httpHost(host string) string {
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		host = name
+	}
+	return strings.ToLower(strings.Trim(host, "[]"))
+}
+
+// streamTimes maps the offsets of one side of a payload to the time its
+// segments were captured, in the order of the offsets.
+type streamTimes []streamMark
+
+type streamMark struct {
+	offset int
+	time   time.Time
+}
+
+func newStreamTimes(segments []StreamSegment, fromSource bool) streamTimes {
+	var times streamTimes
+	offset := 0
+	for _, segment := range segments {
+		if segment.FromSource == fromSource {
+			times = append(times, streamMark{offset: offset, time: segment.Time})
+			offset += segment.Length
+		}
+	}
+	return times
+}
+
+// at returns the time of the segment holding the byte at offset.
+func (t streamTimes) at(offset int) time.Time {
+	var at time.Time
+	for _, mark := range t {
+		if mark.offset > offset {
+			break
+		}
+		at = mark.time
+	}
+	return at
+}
@@ -0,0 +1,143 @@
+package pkg
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// httpTestPayload builds a payload from the blocks of a conversation, the
+// client ones first in each pair, each block one millisecond apart.
+func httpTestPayload(start time.Time, blocks ...string) FlowPayload {
+	var payload FlowPayload
+	for i, block := range blocks {
+		fromSource := i%2 == 0
+		if fromSource {
+			payload.SourceData = append(payload.SourceData, block...)
+		} else {
+			payload.DestinationData = append(payload.DestinationData, block...)
+		}
+		at := start.Add(time.Duration(i) * time.Millisecond)
+		payload.Segments = append(payload.Segments, StreamSegment{FromSource: fromSource, Length: len(block), Time: at})
+	}
+	return payload
+}
+
+func httpTestFlow(start time.Time) Flow {
+	sourcePort, destinationPort := 40000, 80
+	return Flow{
+		ID: 3, DeviceID: "eth0", Protocol: ProtocolTCP, StartedAt: start,
+		SourceIP: "10.0.0.1", SourcePort: &sourcePort, DestinationIP: "10.0.0.2", DestinationPort: &destinationPort,
+	}
+}
+
+func TestParseHTTPTransactionsKeepAlive(t *testing.T) {
+	start := time.Now()
+	payload := httpTestPayload(start,
+		"GET /index.html?q=1 HTTP/1.1\r\nHost: Example.COM:8080\r\nUser-Agent: curl/8.0\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nContent-Type: text/html\r\nContent-Length: 5\r\n\r\nhello",
+		"POST /submit HTTP/1.1\r\nHost: example.com\r\nContent-Type: application/json\r\nContent-Length: 7\r\n\r\n{\"a\":1}",
+		"HTTP/1.1 201 Created\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n",
+		// The rest of the chunked body comes later.
+		"",
+		"2\r\nde\r\n0\r\n\r\n",
+	)
+
+	transactions := ParseHTTPTransactions(httpTestFlow(start), payload, 4)
+	if len(transactions) != 2 {
+		t.Fatalf("expected 2 transactions, got %+v", transactions)
+	}
+	get := transactions[0]
+	if get.FlowID != 3 || get.Method != "GET" || get.Host != "example.com" || get.Path != "/index.html?q=1" || get.Version != "HTTP/1.1" || get.UserAgent != "curl/8.0" {
+		t.Errorf("unexpected request %+v", get)
+	}
+	if get.ClientIP != "10.0.0.1" || get.ClientPort != 40000 || get.ServerIP != "10.0.0.2" || get.ServerPort != 80 {
+		t.Errorf("unexpected endpoints %+v", get)
+	}
+	if get.StatusCode == nil || *get.StatusCode != 200 || get.ResponseContentType != "text/html" || get.ResponseContentLength == nil || *get.ResponseContentLength != 5 {
+		t.Errorf("unexpected response %+v", get)
+	}
+	if get.RequestContentLength != nil || string(get.ResponseBody) != "hell" || len(get.RequestBody) != 0 {
+		t.Errorf("expected the body cut at 4 bytes, got %+v", get)
+	}
+	if !get.Time.Equal(start) || get.LatencyMicros == nil || *get.LatencyMicros != 1000 || *get.DurationMicros != 1000 {
+		t.Errorf("unexpected timing %+v", get)
+	}
+
+	post := transactions[1]
+	if post.Method != "POST" || post.RequestContentType != "application/json" || *post.RequestContentLength != 7 || string(post.RequestBody) != "{\"a\"" {
+		t.Errorf("unexpected request %+v", post)
+	}
+	if *post.StatusCode != 201 || post.ResponseContentLength != nil || string(post.ResponseBody) != "abcd" {
+		t.Errorf("unexpected response %+v", post)
+	}
+	if !post.Time.Equal(start.Add(2*time.Millisecond)) || *post.LatencyMicros != 1000 || *post.DurationMicros != 3000 {
+		t.Errorf("unexpected timing %+v", post)
+	}
+}
+
+func TestParseHTTPTransactionsPipelined(t *testing.T) {
+	start := time.Now()
+	payload := httpTestPayload(start,
+		"HEAD /a HTTP/1.1\r\nHost: a\r\n\r\nGET /b HTTP/1.1\r\nHost: a\r\nExpect: 100-continue\r\n\r\nGET /c HTTP/1.1\r\nHost: a\r\n\r\n",
+		// The HEAD response announces a length without a body.
+		"HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nHTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 404 Not Found\r\nContent-Length: 0\r\n\r\n",
+	)
+
+	transactions := ParseHTTPTransactions(httpTestFlow(start), payload, 0)
+	if len(transactions) != 3 {
+		t.Fatalf("expected 3 transactions, got %+v", transactions)
+	}
+	for i, want := range []string{"/a", "/b", "/c"} {
+		if transactions[i].Path != want {
+			t.Errorf("expected %s at %d, got %s", want, i, transactions[i].Path)
+		}
+	}
+	if *transactions[0].StatusCode != 200 || *transactions[0].ResponseContentLength != 10 {
+		t.Errorf("unexpected HEAD response %+v", transactions[0])
+	}
+	if *transactions[1].StatusCode != 404 {
+		t.Errorf("expected the final response after 100 Continue, got %d", *transactions[1].StatusCode)
+	}
+	if transactions[2].StatusCode != nil || transactions[2].RespondedAt != nil {
+		t.Errorf("expected the last request unanswered, got %+v", transactions[2])
+	}
+}
+
+func TestParseHTTPTransactionsClientIsDestination(t *testing.T) {
+	start := time.Now()
+	// Picked up in the middle: the first packet came from the server, and
+	// the payload has no segment times.
+	payload := FlowPayload{
+		SourceData:      []byte("HTTP/1.0 200 OK\r\n\r\nbody until close"),
+		DestinationData: []byte("GET / HTTP/1.0\r\nHost: b\r\n\r\n"),
+	}
+	transactions := ParseHTTPTransactions(httpTestFlow(start), payload, 1024)
+	if len(transactions) != 1 {
+		t.Fatalf("expected 1 transaction, got %+v", transactions)
+	}
+	transaction := transactions[0]
+	if transaction.ClientIP != "10.0.0.2" || transaction.ClientPort != 80 || transaction.ServerIP != "10.0.0.1" || transaction.ServerPort != 40000 {
+		t.Errorf("expected the sides turned around, got %+v", transaction)
+	}
+	if string(transaction.ResponseBody) != "body until close" || !transaction.Time.Equal(start) || transaction.LatencyMicros != nil {
+		t.Errorf("unexpected transaction %+v", transaction)
+	}
+}
+
+func TestParseHTTPTransactionsStopsAtOtherData(t *testing.T) {
+	start := time.Now()
+	tls := httpTestPayload(start, "\x16\x03\x01\x02\x00\x01\x00\x01\xfc\x03\x03", "\x16\x03\x03\x00\x7a")
+	if transactions := ParseHTTPTransactions(httpTestFlow(start), tls, 0); len(transactions) != 0 {
+		t.Errorf("expected no transaction from TLS, got %+v", transactions)
+	}
+
+	upgrade := httpTestPayload(start,
+		"GET /chat HTTP/1.1\r\nHost: a\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n"+strings.Repeat("\x81\x05hello", 2),
+		"HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n\x81\x02hi",
+	)
+	transactions := ParseHTTPTransactions(httpTestFlow(start), upgrade, 0)
+	if len(transactions) != 1 || *transactions[0].StatusCode != 101 {
+		t.Errorf("expected only the upgrade, got %+v", transactions)
+	}
+}
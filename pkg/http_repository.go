@@ -0,0 +1,104 @@
+package pkg
+
+import (
+	"net"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// HTTPTransaction is an HTTP/1.x request of a TCP flow with its response.
+// Time is when the request started, RespondedAt when the response did.
+// LatencyMicros runs until the first byte of the response, DurationMicros
+// until its last. The response fields are empty for requests that got no
+// response in the flow record.
+type HTTPTransaction struct {
+	ID             uint       `gorm:"primaryKey" json:"id"`
+	FlowID         uint       `gorm:"not null;index" json:"flow_id"`
+	Time           time.Time  `gorm:"not null;index" json:"time"`
+	RespondedAt    *time.Time `json:"responded_at"`
+	LatencyMicros  *int64     `json:"latency_us"`
+	DurationMicros *int64     `json:"duration_us"`
+	DeviceID       string     `gorm:"not null" json:"device"`
+	ClientIP       string     `gorm:"not null" json:"client_ip"`
+	ClientIPKey    string     `gorm:"index" json:"-"`
+	ClientPort     int        `json:"client_port"`
+	ServerIP       string     `gorm:"not null" json:"server_ip"`
+	ServerIPKey    string     `gorm:"index" json:"-"`
+	ServerPort     int        `json:"server_port"`
+
+	// Host is lower case without the port.
+	Method               string `gorm:"not null;index" json:"method"`
+	Host                 string `gorm:"index" json:"host"`
+	Path                 string `json:"path"`
+	Version              string `json:"version"`
+	UserAgent            string `json:"user_agent"`
+	RequestContentType   string `json:"request_content_type"`
+	RequestContentLength *int64 `json:"request_content_length"`
+
+	StatusCode            *int   `gorm:"index" json:"status_code"`
+	ResponseContentType   string `json:"response_content_type"`
+	ResponseContentLength *int64 `json:"response_content_length"`
+
+	// The bodies are only captured when enabled, up to the configured
+	// size, as sent: chunks are joined but nothing is decompressed.
+	RequestBody  []byte `json:"request_body,omitempty"`
+	ResponseBody []byte `json:"response_body,omitempty"`
+}
+
+// HTTPFilter selects the transactions returned by GetHTTPTransactions,
+// newest first. IPs match the client or the server.
+type HTTPFilter struct {
+	Limit       int
+	From        time.Time
+	To          time.Time
+	FlowID      uint
+	Hosts       []string
+	Methods     []string
+	StatusCodes []int
+	IPs         []*net.IPNet
+}
+
+type HTTPRepository interface {
+	GetHTTPTransactions(filter HTTPFilter) ([]HTTPTransaction, error)
+}
+
+// The transactions are stored along with their flow by SaveFlows.
+type SqlLiteHTTPRepository struct {
+	db *gorm.DB
+}
+
+func (r *SqlLiteHTTPRepository) GetHTTPTransactions(filter HTTPFilter) ([]HTTPTransaction, error) {
+	transactions := make([]HTTPTransaction, 0)
+	query := r.db.Model(&HTTPTransaction{})
+	query = timeRange(query, "time", filter.From, filter.To)
+	if filter.FlowID != 0 {
+		query = query.Where("flow_id = ?", filter.FlowID)
+	}
+	if len(filter.Hosts) > 0 {
+		query = query.Where("host IN ?", filter.Hosts)
+	}
+	if len(filter.Methods) > 0 {
+		query = query.Where("method IN ?", filter.Methods)
+	}
+	if len(filter.StatusCodes) > 0 {
+		query = query.Where("status_code IN ?", filter.StatusCodes)
+	}
+	if len(filter.IPs) > 0 {
+		query = query.Where(ipCondition(query, filter.IPs, "client").Or(ipCondition(query, filter.IPs, "server")))
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+	result := query.Order("time desc").Order("id desc").Find(&transactions)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return transactions, nil
+}
+
+func NewSqlLiteHTTPRepository(db *gorm.DB) HTTPRepository {
+	db.AutoMigrate(&HTTPTransaction{})
+
+	return &SqlLiteHTTPRepository{db: db}
+}
@@ -0,0 +1,67 @@
+package pkg
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestHTTPRepositoryGetHTTPTransactions(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open test database: %v", err)
+	}
+	flows := NewSqlLiteFlowRepository(db)
+	repo := NewSqlLiteHTTPRepository(db)
+
+	start := time.Now().Add(-time.Hour)
+	ok, notFound := 200, 404
+	stored := []Flow{
+		{DeviceID: "eth0", Protocol: ProtocolTCP, SourceIP: "10.0.0.1", DestinationIP: "10.0.1.80", StartedAt: start, EndedAt: start, EndReason: FlowEndFIN,
+			HTTPTransactions: []HTTPTransaction{
+				{Time: start, DeviceID: "eth0", ClientIP: "10.0.0.1", ServerIP: "10.0.1.80", Method: "GET", Host: "example.com", Path: "/", StatusCode: &ok},
+				{Time: start.Add(time.Minute), DeviceID: "eth0", ClientIP: "10.0.0.1", ServerIP: "10.0.1.80", Method: "POST", Host: "example.com", Path: "/login", StatusCode: &notFound},
+			}},
+		{DeviceID: "eth0", Protocol: ProtocolTCP, SourceIP: "10.0.0.2", DestinationIP: "10.0.2.80", StartedAt: start, EndedAt: start, EndReason: FlowEndFIN,
+			HTTPTransactions: []HTTPTransaction{
+				{Time: start.Add(2 * time.Minute), DeviceID: "eth0", ClientIP: "10.0.0.2", ServerIP: "10.0.2.80", Method: "GET", Host: "other.org", Path: "/x"},
+			}},
+	}
+	if err := flows.SaveFlows(stored); err != nil {
+		t.Fatalf("failed to save flows: %v", err)
+	}
+
+	_, servers, _ := net.ParseCIDR("10.0.1.0/24")
+	tests := []struct {
+		name   string
+		filter HTTPFilter
+		want   []string
+	}{
+		{"all newest first", HTTPFilter{}, []string{"/x", "/login", "/"}},
+		{"flow", HTTPFilter{FlowID: stored[0].ID}, []string{"/login", "/"}},
+		{"host", HTTPFilter{Hosts: []string{"other.org"}}, []string{"/x"}},
+		{"method and status", HTTPFilter{Methods: []string{"GET"}, StatusCodes: []int{200}}, []string{"/"}},
+		{"server network", HTTPFilter{IPs: []*net.IPNet{servers}}, []string{"/login", "/"}},
+		{"time range", HTTPFilter{From: start.Add(time.Minute), To: start.Add(2 * time.Minute)}, []string{"/login"}},
+		{"limit", HTTPFilter{Limit: 1}, []string{"/x"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := repo.GetHTTPTransactions(tt.filter)
+			if err != nil {
+				t.Fatalf("GetHTTPTransactions returned error: %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("expected %v, got %d transactions", tt.want, len(got))
+			}
+			for i, transaction := range got {
+				if transaction.Path != tt.want[i] {
+					t.Fatalf("expected %v, got %s at %d", tt.want, transaction.Path, i)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,256 @@
+package pkg
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Ping is an echo request with its reply. Time is when the request was
+// seen, or the reply when the request was not captured. The reply fields
+// are empty for requests that got no reply.
+type Ping struct {
+	ID               uint       `gorm:"primaryKey" json:"id"`
+	Time             time.Time  `gorm:"not null;index" json:"time"`
+	RepliedAt        *time.Time `json:"replied_at"`
+	RTTMicros        *int64     `gorm:"column:rtt_micros" json:"rtt_us"`
+	DeviceID         string     `gorm:"not null" json:"device"`
+	Protocol         string     `gorm:"not null" json:"protocol"`
+	SourceIP         string     `gorm:"not null" json:"source_ip"`
+	SourceIPKey      string     `gorm:"index" json:"-"`
+	DestinationIP    string     `gorm:"not null" json:"destination_ip"`
+	DestinationIPKey string     `gorm:"index" json:"-"`
+	Identifier       uint16     `json:"identifier"`
+	Sequence         uint16     `json:"sequence"`
+	ReplyTTL         *int       `json:"reply_ttl"`
+}
+
+// PingFilter selects the pings returned by GetPings, newest first. Lost
+// keeps the requests that got no reply.
+type PingFilter struct {
+	Limit        int
+	From         time.Time
+	To           time.Time
+	Sources      []*net.IPNet
+	Destinations []*net.IPNet
+	Lost         bool
+}
+
+// UnreachableFilter selects the destination unreachable messages summed
+// up by GetUnreachable. Sources match the sender of the rejected packets
+// and Limit caps the destinations returned.
+type UnreachableFilter struct {
+	Limit   int
+	From    time.Time
+	To      time.Time
+	Sources []*net.IPNet
+}
+
+// UnreachableSource is a host whose packets were rejected with destination
+// unreachable messages, with the destinations they were rejected for.
+type UnreachableSource struct {
+	SourceIP     string                   `json:"source_ip"`
+	Count        int64                    `json:"count"`
+	Destinations []UnreachableDestination `json:"destinations"`
+}
+
+// UnreachableDestination is where the rejected packets were going, read
+// from the headers the messages quote, and who rejected them why.
+type UnreachableDestination struct {
+	DestinationIP   string    `json:"destination_ip"`
+	Protocol        string    `json:"protocol"`
+	DestinationPort *int      `json:"destination_port"`
+	ReportedBy      string    `json:"reported_by"`
+	ICMPProtocol    string    `json:"icmp_protocol"`
+	Code            int       `json:"code"`
+	Reason          string    `json:"reason"`
+	Count           int64     `json:"count"`
+	FirstSeen       time.Time `json:"first_seen"`
+	LastSeen        time.Time `json:"last_seen"`
+}
+
+// icmpv4UnreachableReasons and icmpv6UnreachableReasons name the codes of
+// the destination unreachable messages.
+var icmpv4UnreachableReasons = map[int]string{
+	0:  "net unreachable",
+	1:  "host unreachable",
+	2:  "protocol unreachable",
+	3:  "port unreachable",
+	4:  "fragmentation needed",
+	5:  "source route failed",
+	6:  "net unknown",
+	7:  "host unknown",
+	9:  "net prohibited",
+	10: "host prohibited",
+	11: "net unreachable for TOS",
+	12: "host unreachable for TOS",
+	13: "communication prohibited",
+	14: "host precedence violation",
+	15: "precedence cutoff",
+}
+
+var icmpv6UnreachableReasons = map[int]string{
+	0: "no route",
+	1: "communication prohibited",
+	2: "beyond scope of source",
+	3: "address unreachable",
+	4: "port unreachable",
+	5: "source address failed policy",
+	6: "reject route",
+	7: "error in source routing header",
+}
+
+type ICMPRepository interface {
+	SavePings(pings []Ping) error
+	GetPings(filter PingFilter) ([]Ping, error)
+	// GetUnreachable sums up the stored destination unreachable messages
+	// by the source of the packets they reject, most rejected first.
+	GetUnreachable(filter UnreachableFilter) ([]UnreachableSource, error)
+}
+
+type SqlLiteICMPRepository struct {
+	db *gorm.DB
+}
+
+func (r *SqlLiteICMPRepository) SavePings(pings []Ping) error {
+	if len(pings) == 0 {
+		return nil
+	}
+	for i := range pings {
+		pings[i].SourceIPKey = ipKey(pings[i].SourceIP)
+		pings[i].DestinationIPKey = ipKey(pings[i].DestinationIP)
+	}
+	return r.db.CreateInBatches(pings, 100).Error
+}
+
+func (r *SqlLiteICMPRepository) GetPings(filter PingFilter) ([]Ping, error) {
+	pings := make([]Ping, 0)
+	query := r.db.Model(&Ping{})
+	query = timeRange(query, "time", filter.From, filter.To)
+	if len(filter.Sources) > 0 {
+		query = query.Where(ipCondition(query, filter.Sources, "source"))
+	}
+	if len(filter.Destinations) > 0 {
+		query = query.Where(ipCondition(query, filter.Destinations, "destination"))
+	}
+	if filter.Lost {
+		query = query.Where("replied_at IS NULL")
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+	result := query.Order("time desc").Order("id desc").Find(&pings)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return pings, nil
+}
+
+// unreachableRow is a group of destination unreachable messages. The
+// first and last packet IDs stand for the times, which sqlite returns as
+// text once aggregated.
+type unreachableRow struct {
+	OriginalSource      string
+	OriginalDestination string
+	OriginalProtocol    string
+	OriginalPort        *int
+	Reporter            string
+	ICMPProtocol        string
+	Code                int
+	Count               int64
+	FirstID             uint
+	LastID              uint
+}
+
+func (r *SqlLiteICMPRepository) GetUnreachable(filter UnreachableFilter) ([]UnreachableSource, error) {
+	query := r.db.Model(&SavedPacket{}).
+		Select("icmp_original_source_ip AS original_source, icmp_original_destination_ip AS original_destination, "+
+			"icmp_original_protocol AS original_protocol, icmp_original_destination_port AS original_port, "+
+			"source_ip AS reporter, protocol AS icmp_protocol, icmp_code AS code, "+
+			"COUNT(*) AS count, MIN(id) AS first_id, MAX(id) AS last_id").
+		Where("(protocol = ? AND icmp_type = 3) OR (protocol = ? AND icmp_type = 1)", ProtocolICMPv4, ProtocolICMPv6).
+		Where("icmp_original_source_ip <> ''")
+	query = timeRange(query, "created_at", filter.From, filter.To)
+	if len(filter.Sources) > 0 {
+		query = query.Where(ipCondition(query, filter.Sources, "icmp_original_source"))
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+	var rows []unreachableRow
+	result := query.Group("icmp_original_source_ip, icmp_original_destination_ip, icmp_original_protocol, " +
+		"icmp_original_destination_port, source_ip, protocol, icmp_code").
+		Order("count desc").Order("last_id desc").Scan(&rows)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	times, err := r.packetTimes(rows)
+	if err != nil {
+		return nil, err
+	}
+	sources := make([]UnreachableSource, 0)
+	index := make(map[string]int)
+	for _, row := range rows {
+		i, ok := index[row.OriginalSource]
+		if !ok {
+			i = len(sources)
+			index[row.OriginalSource] = i
+			sources = append(sources, UnreachableSource{SourceIP: row.OriginalSource})
+		}
+		sources[i].Count += row.Count
+		sources[i].Destinations = append(sources[i].Destinations, UnreachableDestination{
+			DestinationIP:   row.OriginalDestination,
+			Protocol:        row.OriginalProtocol,
+			DestinationPort: row.OriginalPort,
+			ReportedBy:      row.Reporter,
+			ICMPProtocol:    row.ICMPProtocol,
+			Code:            row.Code,
+			Reason:          unreachableReason(row.ICMPProtocol, row.Code),
+			Count:           row.Count,
+			FirstSeen:       times[row.FirstID],
+			LastSeen:        times[row.LastID],
+		})
+	}
+	return sources, nil
+}
+
+// packetTimes reads the capture times of the first and last packets of
+// the groups.
+func (r *SqlLiteICMPRepository) packetTimes(rows []unreachableRow) (map[uint]time.Time, error) {
+	times := make(map[uint]time.Time)
+	if len(rows) == 0 {
+		return times, nil
+	}
+	ids := make([]uint, 0, 2*len(rows))
+	for _, row := range rows {
+		ids = append(ids, row.FirstID, row.LastID)
+	}
+	var packets []SavedPacket
+	if err := r.db.Select("id", "created_at").Where("id IN ?", ids).Find(&packets).Error; err != nil {
+		return nil, err
+	}
+	for _, packet := range packets {
+		times[packet.ID] = packet.CreatedAt
+	}
+	return times, nil
+}
+
+func unreachableReason(protocol string, code int) string {
+	reasons := icmpv4UnreachableReasons
+	if protocol == ProtocolICMPv6 {
+		reasons = icmpv6UnreachableReasons
+	}
+	if reason, ok := reasons[code]; ok {
+		return reason
+	}
+	return fmt.Sprintf("code %d", code)
+}
+
+func NewSqlLiteICMPRepository(db *gorm.DB) ICMPRepository {
+	db.AutoMigrate(&Ping{})
+
+	return &SqlLiteICMPRepository{db: db}
+}
@@ -0,0 +1,133 @@
+package pkg
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupICMPTestDB(t *testing.T) (ICMPRepository, *gorm.DB) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open test database: %v", err)
+	}
+	if err := db.AutoMigrate(&SavedPacket{}); err != nil {
+		t.Fatalf("failed to migrate packets: %v", err)
+	}
+	return NewSqlLiteICMPRepository(db), db
+}
+
+func TestICMPRepositoryGetPings(t *testing.T) {
+	repo, _ := setupICMPTestDB(t)
+	start := time.Now().Add(-time.Hour)
+	replied := start.Add(time.Millisecond)
+	rtt := int64(1000)
+	pings := []Ping{
+		{Time: start, RepliedAt: &replied, RTTMicros: &rtt, DeviceID: "eth0", Protocol: ProtocolICMPv4, SourceIP: "10.0.0.5", DestinationIP: "10.0.0.1", Sequence: 1},
+		{Time: start.Add(time.Minute), DeviceID: "eth0", Protocol: ProtocolICMPv4, SourceIP: "10.0.0.5", DestinationIP: "8.8.8.8", Sequence: 2},
+		{Time: start.Add(2 * time.Minute), DeviceID: "eth0", Protocol: ProtocolICMPv6, SourceIP: "2001:db8::5", DestinationIP: "2001:db8::1", Sequence: 3},
+	}
+	if err := repo.SavePings(pings); err != nil {
+		t.Fatalf("SavePings failed: %v", err)
+	}
+	_, local, _ := net.ParseCIDR("10.0.0.0/8")
+
+	tests := []struct {
+		name   string
+		filter PingFilter
+		want   []uint16
+	}{
+		{"all newest first", PingFilter{}, []uint16{3, 2, 1}},
+		{"limit", PingFilter{Limit: 1}, []uint16{3}},
+		{"source network", PingFilter{Sources: []*net.IPNet{local}}, []uint16{2, 1}},
+		{"destination network", PingFilter{Destinations: []*net.IPNet{local}}, []uint16{1}},
+		{"lost", PingFilter{Lost: true}, []uint16{3, 2}},
+		{"time range", PingFilter{From: start.Add(30 * time.Second), To: start.Add(90 * time.Second)}, []uint16{2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := repo.GetPings(tt.filter)
+			if err != nil {
+				t.Fatalf("GetPings failed: %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("expected %v, got %+v", tt.want, got)
+			}
+			for i, ping := range got {
+				if ping.Sequence != tt.want[i] {
+					t.Errorf("expected %v, got %d at %d", tt.want, ping.Sequence, i)
+				}
+			}
+		})
+	}
+}
+
+func TestICMPRepositoryGetUnreachable(t *testing.T) {
+	repo, db := setupICMPTestDB(t)
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	unreachable := func(at time.Duration, protocol string, typ, code int, reporter, source, destination string, port int) SavedPacket {
+		return SavedPacket{
+			CreatedAt: start.Add(at), UpdatedAt: start.Add(at), DeviceID: "eth0", Protocol: protocol,
+			SourceIP: reporter, DestinationIP: source, ICMPType: intPtr(typ), ICMPCode: intPtr(code),
+			ICMPOriginalProtocol: ProtocolUDP, ICMPOriginalSourceIP: source, ICMPOriginalSourceIPKey: ipKey(source),
+			ICMPOriginalDestinationIP: destination, ICMPOriginalSourcePort: intPtr(40000), ICMPOriginalDestinationPort: intPtr(port),
+		}
+	}
+	packets := []SavedPacket{
+		unreachable(0, ProtocolICMPv4, 3, 3, "10.0.0.9", "10.0.0.5", "10.0.0.9", 53),
+		unreachable(time.Minute, ProtocolICMPv4, 3, 3, "10.0.0.9", "10.0.0.5", "10.0.0.9", 53),
+		unreachable(2*time.Minute, ProtocolICMPv4, 3, 13, "10.0.0.254", "10.0.0.5", "192.0.2.1", 443),
+		unreachable(3*time.Minute, ProtocolICMPv6, 1, 4, "2001:db8::9", "2001:db8::5", "2001:db8::9", 123),
+		// A time exceeded is no unreachable.
+		unreachable(4*time.Minute, ProtocolICMPv4, 11, 0, "10.0.0.254", "10.0.0.6", "192.0.2.1", 443),
+		{CreatedAt: start, UpdatedAt: start, DeviceID: "eth0", Protocol: ProtocolUDP, SourceIP: "10.0.0.5", DestinationIP: "10.0.0.9"},
+	}
+	if err := db.Create(&packets).Error; err != nil {
+		t.Fatalf("failed to create packets: %v", err)
+	}
+
+	sources, err := repo.GetUnreachable(UnreachableFilter{})
+	if err != nil {
+		t.Fatalf("GetUnreachable failed: %v", err)
+	}
+	if len(sources) != 2 || sources[0].SourceIP != "10.0.0.5" || sources[0].Count != 3 || sources[1].SourceIP != "2001:db8::5" {
+		t.Fatalf("expected the two rejected sources, got %+v", sources)
+	}
+	destinations := sources[0].Destinations
+	if len(destinations) != 2 {
+		t.Fatalf("expected 2 destinations, got %+v", destinations)
+	}
+	first := destinations[0]
+	if first.DestinationIP != "10.0.0.9" || first.Protocol != ProtocolUDP || *first.DestinationPort != 53 || first.ReportedBy != "10.0.0.9" ||
+		first.Reason != "port unreachable" || first.Count != 2 {
+		t.Errorf("unexpected destination %+v", first)
+	}
+	if !first.FirstSeen.Equal(start) || !first.LastSeen.Equal(start.Add(time.Minute)) {
+		t.Errorf("unexpected first and last seen %v %v", first.FirstSeen, first.LastSeen)
+	}
+	if destinations[1].Reason != "communication prohibited" || destinations[1].ReportedBy != "10.0.0.254" {
+		t.Errorf("unexpected destination %+v", destinations[1])
+	}
+	if reason := sources[1].Destinations[0].Reason; reason != "port unreachable" {
+		t.Errorf("expected the ICMPv6 port unreachable, got %s", reason)
+	}
+
+	_, v6, _ := net.ParseCIDR("2001:db8::/32")
+	filtered, err := repo.GetUnreachable(UnreachableFilter{Sources: []*net.IPNet{v6}})
+	if err != nil {
+		t.Fatalf("GetUnreachable failed: %v", err)
+	}
+	if len(filtered) != 1 || filtered[0].SourceIP != "2001:db8::5" {
+		t.Errorf("expected the IPv6 source only, got %+v", filtered)
+	}
+	recent, err := repo.GetUnreachable(UnreachableFilter{From: start.Add(90 * time.Second)})
+	if err != nil {
+		t.Fatalf("GetUnreachable failed: %v", err)
+	}
+	if len(recent) != 2 || recent[0].Count != 1 {
+		t.Errorf("expected the later messages only, got %+v", recent)
+	}
+}
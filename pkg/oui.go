@@ -0,0 +1,19 @@
+package pkg
+
+import (
+	"net"
+
+	"github.com/google/gopacket/macs"
+)
+
+// macVendor returns the vendor the IEEE assigned the prefix of a MAC to,
+// empty when it is not in the registry or the address is locally
+// administered, as randomized addresses are. The registry is the MA-L
+// table gopacket generates from the IEEE oui.csv.
+func macVendor(mac string) string {
+	hw, err := net.ParseMAC(mac)
+	if err != nil || len(hw) < 3 || hw[0]&0x02 != 0 {
+		return ""
+	}
+	return macs.ValidMACPrefixMap[[3]byte{hw[0], hw[1], hw[2]}]
+}
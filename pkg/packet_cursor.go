@@ -0,0 +1,104 @@
+package pkg
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// packetCountLimit is the number of matching packets CountPackets counts
+// exactly before it falls back to an estimate.
+const packetCountLimit = 10000
+
+// PacketCursor is a position in the packet listing sorted by created_at,
+// the packet with ID breaks ties. Backward cursors list the packets before
+// the position instead of after it.
+type PacketCursor struct {
+	CreatedAt time.Time
+	ID        uint
+	Backward  bool
+}
+
+type encodedCursor struct {
+	CreatedAt time.Time `json:"t"`
+	ID        uint      `json:"i"`
+	Backward  bool      `json:"b,omitempty"`
+}
+
+// NewPacketCursor returns the cursor at packet, listing the packets after
+// it or, when backward, before it.
+func NewPacketCursor(packet SavedPacket, backward bool) *PacketCursor {
+	return &PacketCursor{CreatedAt: packet.CreatedAt, ID: packet.ID, Backward: backward}
+}
+
+// Encode returns the cursor as an opaque URL safe string.
+func (c PacketCursor) Encode() string {
+	data, _ := json.Marshal(encodedCursor{CreatedAt: c.CreatedAt, ID: c.ID, Backward: c.Backward})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// Reverse returns the cursor at the same position in the other direction.
+func (c PacketCursor) Reverse() *PacketCursor {
+	c.Backward = !c.Backward
+	return &c
+}
+
+// DecodePacketCursor parses a cursor returned by Encode.
+func DecodePacketCursor(value string) (*PacketCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid cursor", ErrInvalidPacketFilter)
+	}
+	var decoded encodedCursor
+	if err := json.Unmarshal(data, &decoded); err != nil || decoded.CreatedAt.IsZero() {
+		return nil, fmt.Errorf("%w: invalid cursor", ErrInvalidPacketFilter)
+	}
+	return &PacketCursor{CreatedAt: decoded.CreatedAt, ID: decoded.ID, Backward: decoded.Backward}, nil
+}
+
+// PacketCount is the number of packets matching a filter. Counts above
+// packetCountLimit are estimated and not Exact.
+type PacketCount struct {
+	Count int64
+	Exact bool
+}
+
+// Pageable tells whether the filter sorts the packets in the order cursors
+// page through.
+func (f PacketFilter) Pageable() bool {
+	return f.Sort == "" || f.Sort == "created_at"
+}
+
+// page restricts query to the packets after the cursor of the filter. A
+// backward cursor selects the packets before it in the reverse order, the
+// caller reverses them back.
+func (f PacketFilter) page(query *gorm.DB) *gorm.DB {
+	cursor := f.Cursor
+	if cursor == nil {
+		return query.Order(f.order())
+	}
+	descending := f.Order != SortAscending
+	if cursor.Backward {
+		descending = !descending
+	}
+	comparison, order := ">", SortAscending
+	if descending {
+		comparison, order = "<", SortDescending
+	}
+	createdAt := sqlTime(cursor.CreatedAt)
+	return query.
+		Where("created_at "+comparison+" ? OR (created_at = ? AND id "+comparison+" ?)", createdAt, createdAt, cursor.ID).
+		Order("created_at " + order + ", id " + order)
+}
+
+// filtered tells whether the filter selects a subset of the packets.
+func (f PacketFilter) filtered() bool {
+	return !f.From.IsZero() || !f.To.IsZero() ||
+		len(f.SourceIPs) > 0 || len(f.DestinationIPs) > 0 || len(f.IPs) > 0 ||
+		len(f.SourcePorts) > 0 || len(f.DestinationPorts) > 0 || len(f.Ports) > 0 ||
+		len(f.Protocols) > 0 || len(f.Devices) > 0 || f.Direction != "" || f.Tag != "" ||
+		f.Query != nil
+}
@@ -0,0 +1,133 @@
+package pkg
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPacketCursorEncode(t *testing.T) {
+	cursor := PacketCursor{CreatedAt: time.Date(2024, 1, 1, 12, 0, 0, 5, time.UTC), ID: 42, Backward: true}
+
+	decoded, err := DecodePacketCursor(cursor.Encode())
+	if err != nil {
+		t.Fatalf("DecodePacketCursor returned error: %v", err)
+	}
+	if !decoded.CreatedAt.Equal(cursor.CreatedAt) || decoded.ID != 42 || !decoded.Backward {
+		t.Errorf("expected %+v, got %+v", cursor, decoded)
+	}
+	if reversed := decoded.Reverse(); reversed.Backward || !decoded.Backward {
+		t.Errorf("expected Reverse to return a forward copy, got %+v", reversed)
+	}
+
+	for _, value := range []string{"not base64!", "bm90IGpzb24", "e30"} {
+		if _, err := DecodePacketCursor(value); !errors.Is(err, ErrInvalidPacketFilter) {
+			t.Errorf("%q: expected ErrInvalidPacketFilter, got %v", value, err)
+		}
+	}
+}
+
+func TestPacketFilterCursorNeedsCreatedAtSort(t *testing.T) {
+	cursor := &PacketCursor{CreatedAt: time.Now(), ID: 1}
+	if err := (PacketFilter{Sort: "ttl", Cursor: cursor}).Validate(); !errors.Is(err, ErrInvalidPacketFilter) {
+		t.Errorf("expected ErrInvalidPacketFilter, got %v", err)
+	}
+	if err := (PacketFilter{Sort: "created_at", Cursor: cursor}).Validate(); err != nil {
+		t.Errorf("expected valid filter, got %v", err)
+	}
+}
+
+func TestGetPacketsWithCursor(t *testing.T) {
+	repo := setupTestDB(t)
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	// Packets 2 and 3 share a timestamp, the id orders them.
+	for _, offset := range []int{0, 1, 1, 2, 3} {
+		created := start.Add(time.Duration(offset) * time.Second)
+		repo.db.Create(&SavedPacket{Protocol: "TCP", CreatedAt: created, UpdatedAt: created, DeviceID: "eth0"})
+	}
+	ids := func(packets []SavedPacket) []uint {
+		var ids []uint
+		for _, packet := range packets {
+			ids = append(ids, packet.ID)
+		}
+		return ids
+	}
+	get := func(filter PacketFilter) []uint {
+		t.Helper()
+		packets, err := repo.GetPackets(filter)
+		if err != nil {
+			t.Fatalf("GetPackets returned error: %v", err)
+		}
+		return ids(packets)
+	}
+	packet := func(id uint) SavedPacket {
+		var saved SavedPacket
+		repo.db.First(&saved, id)
+		return saved
+	}
+
+	testCases := []struct {
+		name     string
+		filter   PacketFilter
+		expected []uint
+	}{
+		{"after 3 descending", PacketFilter{Limit: 2, Cursor: NewPacketCursor(packet(3), false)}, []uint{2, 1}},
+		{"after 4 descending", PacketFilter{Limit: 2, Cursor: NewPacketCursor(packet(4), false)}, []uint{3, 2}},
+		{"before 2 descending", PacketFilter{Limit: 2, Cursor: NewPacketCursor(packet(2), true)}, []uint{4, 3}},
+		{"before 1 descending", PacketFilter{Limit: 10, Cursor: NewPacketCursor(packet(1), true)}, []uint{5, 4, 3, 2}},
+		{"after 2 ascending", PacketFilter{Order: SortAscending, Limit: 2, Cursor: NewPacketCursor(packet(2), false)}, []uint{3, 4}},
+		{"before 4 ascending", PacketFilter{Order: SortAscending, Limit: 2, Cursor: NewPacketCursor(packet(4), true)}, []uint{2, 3}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := get(tc.filter)
+			if len(got) != len(tc.expected) {
+				t.Fatalf("expected %v, got %v", tc.expected, got)
+			}
+			for i := range got {
+				if got[i] != tc.expected[i] {
+					t.Fatalf("expected %v, got %v", tc.expected, got)
+				}
+			}
+		})
+	}
+}
+
+func TestCountPackets(t *testing.T) {
+	repo := setupTestDB(t)
+	createFilterTestPackets(t, repo)
+
+	count, err := repo.CountPackets(PacketFilter{Devices: []string{"eth0"}, Limit: 1})
+	if err != nil {
+		t.Fatalf("CountPackets returned error: %v", err)
+	}
+	if count.Count != 2 || !count.Exact {
+		t.Errorf("expected an exact count of 2, got %+v", count)
+	}
+}
+
+func TestCountPacketsEstimatesLargeTables(t *testing.T) {
+	repo := setupTestDB(t)
+	now := time.Now()
+	packets := make([]SavedPacket, packetCountLimit+10)
+	for i := range packets {
+		packets[i] = SavedPacket{Protocol: "UDP", CreatedAt: now, UpdatedAt: now, DeviceID: "eth0"}
+	}
+	if err := repo.db.CreateInBatches(packets, 500).Error; err != nil {
+		t.Fatalf("failed to create packets: %v", err)
+	}
+
+	count, err := repo.CountPackets(PacketFilter{})
+	if err != nil {
+		t.Fatalf("CountPackets returned error: %v", err)
+	}
+	if count.Exact || count.Count != int64(len(packets)) {
+		t.Errorf("expected an estimate of %d, got %+v", len(packets), count)
+	}
+
+	count, _ = repo.CountPackets(PacketFilter{Protocols: []string{ProtocolUDP}})
+	if count.Exact || count.Count != packetCountLimit {
+		t.Errorf("expected the count limit for a filtered listing, got %+v", count)
+	}
+}
@@ -0,0 +1,71 @@
+package pkg
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+var (
+	ErrPacketNotFound     = errors.New("packet not found")
+	ErrPacketDataNotFound = errors.New("raw packet data not stored")
+)
+
+// PacketData holds the frame bytes of a stored packet together with the
+// capture info needed to write it back into a pcap file. Data is cut to
+// the configured cap, CaptureLength is the length before that cut.
+type PacketData struct {
+	PacketID      uint      `gorm:"primaryKey" json:"packet_id"`
+	Timestamp     time.Time `gorm:"not null" json:"timestamp"`
+	LinkType      int       `gorm:"not null" json:"link_type"`
+	CaptureLength int       `gorm:"not null" json:"capture_length"`
+	Length        int       `gorm:"not null" json:"length"`
+	Truncated     bool      `gorm:"not null" json:"truncated"`
+	Data          []byte    `json:"-"`
+}
+
+func (PacketData) TableName() string {
+	return "packet_data"
+}
+
+// newPacketData copies the frame bytes of packet, keeping at most maxBytes
+// of them.
+func newPacketData(packet AppPacket, maxBytes int) *PacketData {
+	data := packet.Data.Data()
+	info := packet.Data.Metadata().CaptureInfo
+	raw := &PacketData{
+		Timestamp:     packet.CreatedAt,
+		LinkType:      int(packet.LinkType),
+		CaptureLength: len(data),
+		Length:        info.Length,
+	}
+	if raw.Length < raw.CaptureLength {
+		raw.Length = raw.CaptureLength
+	}
+	if len(data) > maxBytes {
+		data = data[:maxBytes]
+		raw.Truncated = true
+	}
+	raw.Data = append([]byte(nil), data...)
+	return raw
+}
+
+// WritePacketPcap writes a pcap file holding the single stored frame.
+func WritePacketPcap(w io.Writer, data PacketData) error {
+	writer := newPcapWriter(w)
+	snaplen := uint32(SNAPSHOTLENGTH)
+	if len(data.Data) > SNAPSHOTLENGTH {
+		snaplen = uint32(len(data.Data))
+	}
+	if err := writer.WriteFileHeader(snaplen, layers.LinkType(data.LinkType)); err != nil {
+		return err
+	}
+	return writer.WritePacket(gopacket.CaptureInfo{
+		Timestamp:     data.Timestamp,
+		CaptureLength: len(data.Data),
+		Length:        data.Length,
+	}, data.Data)
+}
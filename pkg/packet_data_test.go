@@ -0,0 +1,56 @@
+package pkg
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+func TestNewPacketDataKeepsFullFrameUnderCap(t *testing.T) {
+	packet := mustBuildPacket(t, "10.0.0.1", "10.0.0.2", 1234, 80)
+
+	data := newPacketData(AppPacket{Data: packet, LinkType: layers.LinkTypeRaw}, 65535)
+	if data.Truncated || !bytes.Equal(data.Data, packet.Data()) {
+		t.Fatal("expected the whole frame to be kept")
+	}
+	if data.Length != len(packet.Data()) {
+		t.Errorf("expected length %d, got %d", len(packet.Data()), data.Length)
+	}
+}
+
+func TestWritePacketPcap(t *testing.T) {
+	packet := mustBuildPacket(t, "10.0.0.1", "10.0.0.2", 1234, 80)
+	timestamp := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	data := PacketData{
+		PacketID:      1,
+		Timestamp:     timestamp,
+		LinkType:      int(layers.LinkTypeRaw),
+		CaptureLength: len(packet.Data()),
+		Length:        len(packet.Data()),
+		Data:          packet.Data()[:20],
+		Truncated:     true,
+	}
+
+	var buf bytes.Buffer
+	if err := WritePacketPcap(&buf, data); err != nil {
+		t.Fatalf("WritePacketPcap returned error: %v", err)
+	}
+
+	reader, err := pcapgo.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("failed to read pcap: %v", err)
+	}
+	if reader.LinkType() != layers.LinkTypeRaw {
+		t.Errorf("expected link type Raw, got %v", reader.LinkType())
+	}
+	frame, ci, err := reader.ReadPacketData()
+	if err != nil {
+		t.Fatalf("failed to read packet: %v", err)
+	}
+	if !bytes.Equal(frame, data.Data) || ci.Length != len(packet.Data()) || !ci.Timestamp.Equal(timestamp) {
+		t.Errorf("unexpected packet in pcap: %d bytes, info %+v", len(frame), ci)
+	}
+}
@@ -0,0 +1,371 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// Protocols stored in SavedPacket.Protocol. A packet is labelled with the
+// innermost layer of this list that was decoded, so an ICMPv6 echo is
+// "ICMPv6" and a frame that only decodes up to Ethernet is "Ethernet".
+const (
+	ProtocolUnknown  = "Unknown"
+	ProtocolEthernet = "Ethernet"
+	ProtocolLLDP     = "LLDP"
+	ProtocolSTP      = "STP"
+	ProtocolEAPOL    = "EAPOL"
+	ProtocolARP      = "ARP"
+	ProtocolIPv4     = "IPv4"
+	ProtocolIPv6     = "IPv6"
+	ProtocolICMPv4   = "ICMPv4"
+	ProtocolICMPv6   = "ICMPv6"
+	ProtocolIGMP     = "IGMP"
+	ProtocolGRE      = "GRE"
+	ProtocolESP      = "ESP"
+	ProtocolAH       = "AH"
+	ProtocolTCP      = "TCP"
+	ProtocolUDP      = "UDP"
+	ProtocolUDPLite  = "UDPLite"
+	ProtocolSCTP     = "SCTP"
+)
+
+var ErrPacketWithoutData = errors.New("packet has no data")
+
+var protocolsByLayer = map[gopacket.LayerType]string{
+	layers.LayerTypeEthernet:           ProtocolEthernet,
+	layers.LayerTypeLinuxSLL:           ProtocolEthernet,
+	layers.LayerTypeLinkLayerDiscovery: ProtocolLLDP,
+	layers.LayerTypeSTP:                ProtocolSTP,
+	layers.LayerTypeEAPOL:              ProtocolEAPOL,
+	layers.LayerTypeARP:                ProtocolARP,
+	layers.LayerTypeIPv4:               ProtocolIPv4,
+	layers.LayerTypeIPv6:               ProtocolIPv6,
+	layers.LayerTypeICMPv4:             ProtocolICMPv4,
+	layers.LayerTypeICMPv6:             ProtocolICMPv6,
+	layers.LayerTypeIGMP:               ProtocolIGMP,
+	layers.LayerTypeGRE:                ProtocolGRE,
+	layers.LayerTypeIPSecESP:           ProtocolESP,
+	layers.LayerTypeIPSecAH:            ProtocolAH,
+	layers.LayerTypeTCP:                ProtocolTCP,
+	layers.LayerTypeUDP:                ProtocolUDP,
+	layers.LayerTypeUDPLite:            ProtocolUDPLite,
+	layers.LayerTypeSCTP:               ProtocolSCTP,
+}
+
+// decodePacket fills saved with every field the packet carries, layer by
+// layer, and leaves the others empty. Tunnels are not followed: the fields
+// of a GRE or VXLAN packet are those of the outer headers.
+func decodePacket(packet gopacket.Packet, saved *SavedPacket) error {
+	if packet == nil {
+		return ErrPacketWithoutData
+	}
+	saved.Protocol = ProtocolUnknown
+	saved.CapturedLength = len(packet.Data())
+	saved.FrameLength = saved.CapturedLength
+	if metadata := packet.Metadata(); metadata != nil && metadata.Length > 0 {
+		saved.FrameLength = metadata.Length
+	}
+
+	var networkSeen, transportSeen bool
+decode:
+	for _, layer := range packet.Layers() {
+		// gopacket adds a layer before decoding it, so the layer that
+		// failed comes without contents and its fields are not trusted.
+		if len(layer.LayerContents()) == 0 {
+			continue
+		}
+		if protocol, ok := protocolsByLayer[layer.LayerType()]; ok {
+			saved.Protocol = protocol
+		}
+		switch l := layer.(type) {
+		case *layers.Ethernet:
+			if saved.SourceMAC == "" {
+				saved.SourceMAC = l.SrcMAC.String()
+				saved.DestinationMAC = l.DstMAC.String()
+				saved.EtherType = ptr(int(l.EthernetType))
+			}
+		case *layers.LinuxSLL:
+			if saved.SourceMAC == "" && l.AddrLen == 6 {
+				saved.SourceMAC = l.Addr.String()
+			}
+			saved.EtherType = ptr(int(l.EthernetType))
+		case *layers.Dot1Q:
+			if networkSeen {
+				continue
+			}
+			if saved.VLANID == nil {
+				saved.VLANID = ptr(int(l.VLANIdentifier))
+			} else if saved.InnerVLANID == nil {
+				saved.InnerVLANID = ptr(int(l.VLANIdentifier))
+			}
+			saved.EtherType = ptr(int(l.Type))
+		case *layers.ARP:
+			saved.SourceIP = net.IP(l.SourceProtAddress).String()
+			saved.DestinationIP = net.IP(l.DstProtAddress).String()
+		case *layers.IPv4:
+			if !networkSeen {
+				decodeIPv4(l, saved)
+				networkSeen = true
+			}
+		case *layers.IPv6:
+			if !networkSeen {
+				decodeIPv6(l, saved)
+				networkSeen = true
+			}
+		case *layers.IPv6Fragment:
+			if saved.IPVersion != nil && *saved.IPVersion == 6 && saved.IPID == nil {
+				saved.IPID = ptr(int(l.Identification))
+				saved.MoreFragments = ptr(l.MoreFragments)
+				saved.FragmentOffset = ptr(int(l.FragmentOffset))
+			}
+		case *layers.ICMPv4:
+			if saved.ICMPType == nil {
+				decodeICMPv4(l, saved)
+			}
+		case *layers.ICMPv6:
+			if saved.ICMPType == nil {
+				decodeICMPv6(l, saved)
+			}
+		case *layers.TCP:
+			if !transportSeen {
+				decodeTCP(l, saved)
+			}
+			setPorts(saved, &transportSeen, int(l.SrcPort), int(l.DstPort))
+		case *layers.UDP:
+			setPorts(saved, &transportSeen, int(l.SrcPort), int(l.DstPort))
+		case *layers.UDPLite:
+			setPorts(saved, &transportSeen, int(l.SrcPort), int(l.DstPort))
+		case *layers.SCTP:
+			setPorts(saved, &transportSeen, int(l.SrcPort), int(l.DstPort))
+		case *layers.GRE, *layers.VXLAN, *layers.Geneve:
+			break decode
+		}
+	}
+
+	// A partial decode is recorded on the packet instead of rejecting it:
+	// the layers before the failure are still stored.
+	if errLayer := packet.ErrorLayer(); errLayer != nil && errLayer.Error() != nil {
+		message := errLayer.Error().Error()
+		saved.DecodeError = &message
+	}
+	return nil
+}
+
+// packetDirection tells whether the packet was received or sent by the
+// capturing host, from the Linux cooked header or from the MAC of the
+// device. It is empty for traffic between other hosts.
+func packetDirection(packet gopacket.Packet, deviceMAC net.HardwareAddr) string {
+	switch link := packet.LinkLayer().(type) {
+	case *layers.LinuxSLL:
+		switch link.PacketType {
+		case layers.LinuxSLLPacketTypeOutgoing:
+			return DirectionOut
+		case layers.LinuxSLLPacketTypeHost, layers.LinuxSLLPacketTypeBroadcast, layers.LinuxSLLPacketTypeMulticast:
+			return DirectionIn
+		}
+	case *layers.Ethernet:
+		if len(deviceMAC) == 0 {
+			return ""
+		}
+		if bytes.Equal(link.SrcMAC, deviceMAC) {
+			return DirectionOut
+		}
+		// The group bit covers broadcast and multicast destinations.
+		if bytes.Equal(link.DstMAC, deviceMAC) || (len(link.DstMAC) > 0 && link.DstMAC[0]&0x01 != 0) {
+			return DirectionIn
+		}
+	}
+	return ""
+}
+
+func decodeIPv4(ip *layers.IPv4, saved *SavedPacket) {
+	saved.SourceIP = ip.SrcIP.String()
+	saved.DestinationIP = ip.DstIP.String()
+	saved.IPVersion = ptr(4)
+	saved.TTL = ptr(int(ip.TTL))
+	saved.DSCP = ptr(int(ip.TOS >> 2))
+	saved.ECN = ptr(int(ip.TOS & 0x3))
+	saved.IPID = ptr(int(ip.Id))
+	saved.DontFragment = ptr(ip.Flags&layers.IPv4DontFragment != 0)
+	saved.MoreFragments = ptr(ip.Flags&layers.IPv4MoreFragments != 0)
+	saved.FragmentOffset = ptr(int(ip.FragOffset))
+	saved.TotalLength = ptr(int(ip.Length))
+}
+
+func decodeIPv6(ip *layers.IPv6, saved *SavedPacket) {
+	saved.SourceIP = ip.SrcIP.String()
+	saved.DestinationIP = ip.DstIP.String()
+	saved.IPVersion = ptr(6)
+	saved.TTL = ptr(int(ip.HopLimit))
+	saved.DSCP = ptr(int(ip.TrafficClass >> 2))
+	saved.ECN = ptr(int(ip.TrafficClass & 0x3))
+	// Length is the payload length, the fixed header is 40 bytes.
+	saved.TotalLength = ptr(int(ip.Length) + 40)
+}
+
+func decodeTCP(tcp *layers.TCP, saved *SavedPacket) {
+	saved.TCPFlagSYN = ptr(tcp.SYN)
+	saved.TCPFlagACK = ptr(tcp.ACK)
+	saved.TCPFlagFIN = ptr(tcp.FIN)
+	saved.TCPFlagRST = ptr(tcp.RST)
+	saved.TCPFlagPSH = ptr(tcp.PSH)
+	saved.TCPFlagURG = ptr(tcp.URG)
+	saved.TCPFlagECE = ptr(tcp.ECE)
+	saved.TCPFlagCWR = ptr(tcp.CWR)
+	saved.TCPSeq = ptr(int64(tcp.Seq))
+	saved.TCPAck = ptr(int64(tcp.Ack))
+	saved.TCPWindow = ptr(int(tcp.Window))
+
+	for _, option := range tcp.Options {
+		data := option.OptionData
+		switch option.OptionType {
+		case layers.TCPOptionKindMSS:
+			if len(data) == 2 {
+				saved.TCPMSS = ptr(int(binary.BigEndian.Uint16(data)))
+			}
+		case layers.TCPOptionKindWindowScale:
+			if len(data) == 1 {
+				saved.TCPWindowScale = ptr(int(data[0]))
+			}
+		case layers.TCPOptionKindSACKPermitted:
+			saved.TCPSACKPermitted = ptr(true)
+		case layers.TCPOptionKindTimestamps:
+			if len(data) == 8 {
+				saved.TCPTimestamp = ptr(int64(binary.BigEndian.Uint32(data[:4])))
+				saved.TCPTimestampEcho = ptr(int64(binary.BigEndian.Uint32(data[4:])))
+			}
+		}
+	}
+}
+
+func decodeICMPv4(icmp *layers.ICMPv4, saved *SavedPacket) {
+	saved.ICMPType = ptr(int(icmp.TypeCode.Type()))
+	saved.ICMPCode = ptr(int(icmp.TypeCode.Code()))
+	switch icmp.TypeCode.Type() {
+	case layers.ICMPv4TypeEchoRequest, layers.ICMPv4TypeEchoReply:
+		saved.ICMPEchoID = ptr(int(icmp.Id))
+		saved.ICMPEchoSeq = ptr(int(icmp.Seq))
+	case layers.ICMPv4TypeDestinationUnreachable, layers.ICMPv4TypeSourceQuench, layers.ICMPv4TypeRedirect,
+		layers.ICMPv4TypeTimeExceeded, layers.ICMPv4TypeParameterProblem:
+		decodeICMPOriginal(icmp.Payload, saved)
+	}
+}
+
+func decodeICMPv6(icmp *layers.ICMPv6, saved *SavedPacket) {
+	saved.ICMPType = ptr(int(icmp.TypeCode.Type()))
+	saved.ICMPCode = ptr(int(icmp.TypeCode.Code()))
+	if identifier, sequence, ok := icmpv6Echo(icmp); ok {
+		saved.ICMPEchoID = ptr(int(identifier))
+		saved.ICMPEchoSeq = ptr(int(sequence))
+	}
+	switch icmp.TypeCode.Type() {
+	case layers.ICMPv6TypeDestinationUnreachable, layers.ICMPv6TypePacketTooBig,
+		layers.ICMPv6TypeTimeExceeded, layers.ICMPv6TypeParameterProblem:
+		// The quoted packet follows the unused, MTU or pointer field.
+		if len(icmp.Payload) > 4 {
+			decodeICMPOriginal(icmp.Payload[4:], saved)
+		}
+	}
+}
+
+// icmpv6Echo reads the identifier and sequence number of an echo request
+// or reply. They are read from the payload: the echo layer of gopacket
+// keeps no contents.
+func icmpv6Echo(icmp *layers.ICMPv6) (uint16, uint16, bool) {
+	switch icmp.TypeCode.Type() {
+	case layers.ICMPv6TypeEchoRequest, layers.ICMPv6TypeEchoReply:
+		if len(icmp.Payload) >= 4 {
+			return binary.BigEndian.Uint16(icmp.Payload[0:2]), binary.BigEndian.Uint16(icmp.Payload[2:4]), true
+		}
+	}
+	return 0, 0, false
+}
+
+// decodeICMPOriginal reads the packet quoted by an ICMP error: its
+// addresses, its transport and, when the quote reaches them, its ports.
+// The quote is often cut short, so it is parsed by hand rather than
+// decoded as a packet.
+func decodeICMPOriginal(data []byte, saved *SavedPacket) {
+	var protocol layers.IPProtocol
+	var payload []byte
+	switch {
+	case len(data) >= 20 && data[0]>>4 == 4:
+		headerLength := int(data[0]&0x0f) * 4
+		if headerLength < 20 || len(data) < headerLength {
+			return
+		}
+		protocol = layers.IPProtocol(data[9])
+		saved.ICMPOriginalSourceIP = net.IP(data[12:16]).String()
+		saved.ICMPOriginalDestinationIP = net.IP(data[16:20]).String()
+		// Only the first fragment carries the transport header.
+		if binary.BigEndian.Uint16(data[6:8])&0x1fff == 0 {
+			payload = data[headerLength:]
+		}
+	case len(data) >= 40 && data[0]>>4 == 6:
+		protocol, payload = skipIPv6Extensions(layers.IPProtocol(data[6]), data[40:])
+		saved.ICMPOriginalSourceIP = net.IP(data[8:24]).String()
+		saved.ICMPOriginalDestinationIP = net.IP(data[24:40]).String()
+	default:
+		return
+	}
+	saved.ICMPOriginalProtocol = ProtocolUnknown
+	if name, ok := protocolsByLayer[protocol.LayerType()]; ok {
+		saved.ICMPOriginalProtocol = name
+	}
+	switch protocol {
+	case layers.IPProtocolTCP, layers.IPProtocolUDP, layers.IPProtocolUDPLite, layers.IPProtocolSCTP:
+		if len(payload) >= 4 {
+			saved.ICMPOriginalSourcePort = ptr(int(binary.BigEndian.Uint16(payload[0:2])))
+			saved.ICMPOriginalDestinationPort = ptr(int(binary.BigEndian.Uint16(payload[2:4])))
+		}
+	}
+}
+
+// skipIPv6Extensions follows the extension headers of an IPv6 packet to
+// its transport. The payload is nil when the quote ends within them or
+// the packet is a fragment other than the first.
+func skipIPv6Extensions(next layers.IPProtocol, payload []byte) (layers.IPProtocol, []byte) {
+	for {
+		switch next {
+		case layers.IPProtocolIPv6HopByHop, layers.IPProtocolIPv6Routing, layers.IPProtocolIPv6Destination:
+			if len(payload) < 2 {
+				return next, nil
+			}
+			length := (int(payload[1]) + 1) * 8
+			next = layers.IPProtocol(payload[0])
+			if len(payload) < length {
+				return next, nil
+			}
+			payload = payload[length:]
+		case layers.IPProtocolIPv6Fragment:
+			if len(payload) < 8 {
+				return next, nil
+			}
+			next = layers.IPProtocol(payload[0])
+			if binary.BigEndian.Uint16(payload[2:4])>>3 != 0 {
+				return next, nil
+			}
+			payload = payload[8:]
+		default:
+			return next, payload
+		}
+	}
+}
+
+func setPorts(saved *SavedPacket, seen *bool, src, dst int) {
+	if *seen {
+		return
+	}
+	saved.SourcePort = &src
+	saved.DestinationPort = &dst
+	*seen = true
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,436 @@
+package pkg
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+var (
+	testSrcMAC = net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}
+	testDstMAC = net.HardwareAddr{0x66, 0x77, 0x88, 0x99, 0xaa, 0xbb}
+)
+
+func serializeTestFrame(t *testing.T, first gopacket.LayerType, serializable ...gopacket.SerializableLayer) gopacket.Packet {
+	t.Helper()
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, serializable...); err != nil {
+		t.Fatalf("failed to serialize packet: %v", err)
+	}
+	return gopacket.NewPacket(buf.Bytes(), first, gopacket.Default)
+}
+
+func decodeTestPacket(t *testing.T, packet gopacket.Packet) SavedPacket {
+	t.Helper()
+	var saved SavedPacket
+	if err := decodePacket(packet, &saved); err != nil {
+		t.Fatalf("decodePacket returned error: %v", err)
+	}
+	return saved
+}
+
+func TestDecodePacketARP(t *testing.T) {
+	packet := serializeTestFrame(t, layers.LayerTypeEthernet,
+		&layers.Ethernet{SrcMAC: testSrcMAC, DstMAC: layers.EthernetBroadcast, EthernetType: layers.EthernetTypeARP},
+		&layers.ARP{
+			AddrType:          layers.LinkTypeEthernet,
+			Protocol:          layers.EthernetTypeIPv4,
+			HwAddressSize:     6,
+			ProtAddressSize:   4,
+			Operation:         layers.ARPRequest,
+			SourceHwAddress:   testSrcMAC,
+			SourceProtAddress: []byte{192, 168, 1, 10},
+			DstHwAddress:      make([]byte, 6),
+			DstProtAddress:    []byte{192, 168, 1, 1},
+		},
+	)
+
+	saved := decodeTestPacket(t, packet)
+	if saved.Protocol != ProtocolARP {
+		t.Errorf("expected protocol ARP, got %s", saved.Protocol)
+	}
+	if saved.SourceIP != "192.168.1.10" || saved.DestinationIP != "192.168.1.1" {
+		t.Errorf("unexpected addresses %s -> %s", saved.SourceIP, saved.DestinationIP)
+	}
+	if saved.SourcePort != nil || saved.DestinationPort != nil {
+		t.Error("expected no ports for ARP")
+	}
+}
+
+func TestDecodePacketICMPv4(t *testing.T) {
+	packet := serializeTestFrame(t, layers.LayerTypeIPv4,
+		&layers.IPv4{Version: 4, IHL: 5, TTL: 64, Protocol: layers.IPProtocolICMPv4, SrcIP: net.IP{10, 0, 0, 1}, DstIP: net.IP{10, 0, 0, 2}},
+		&layers.ICMPv4{TypeCode: layers.CreateICMPv4TypeCode(layers.ICMPv4TypeEchoRequest, 0), Id: 1, Seq: 1},
+		gopacket.Payload([]byte("ping")),
+	)
+
+	saved := decodeTestPacket(t, packet)
+	if saved.Protocol != ProtocolICMPv4 {
+		t.Errorf("expected protocol ICMPv4, got %s", saved.Protocol)
+	}
+	if saved.SourceIP != "10.0.0.1" || saved.DestinationIP != "10.0.0.2" {
+		t.Errorf("unexpected addresses %s -> %s", saved.SourceIP, saved.DestinationIP)
+	}
+	if saved.SourcePort != nil {
+		t.Error("expected no ports for ICMP")
+	}
+	if *saved.ICMPType != 8 || *saved.ICMPCode != 0 || *saved.ICMPEchoID != 1 || *saved.ICMPEchoSeq != 1 {
+		t.Errorf("unexpected ICMP fields: type %d code %d id %d seq %d", *saved.ICMPType, *saved.ICMPCode, *saved.ICMPEchoID, *saved.ICMPEchoSeq)
+	}
+	if saved.ICMPOriginalProtocol != "" {
+		t.Error("expected no original header for an echo")
+	}
+}
+
+func TestDecodePacketICMPv6Echo(t *testing.T) {
+	ip := &layers.IPv6{Version: 6, NextHeader: layers.IPProtocolICMPv6, HopLimit: 64, SrcIP: net.ParseIP("2001:db8::1"), DstIP: net.ParseIP("2001:db8::2")}
+	icmp := &layers.ICMPv6{TypeCode: layers.CreateICMPv6TypeCode(layers.ICMPv6TypeEchoReply, 0)}
+	icmp.SetNetworkLayerForChecksum(ip)
+	packet := serializeTestFrame(t, layers.LayerTypeIPv6, ip, icmp, &layers.ICMPv6Echo{Identifier: 7, SeqNumber: 3})
+
+	saved := decodeTestPacket(t, packet)
+	if *saved.ICMPType != 129 || *saved.ICMPEchoID != 7 || *saved.ICMPEchoSeq != 3 {
+		t.Errorf("unexpected ICMPv6 fields: type %d id %v seq %v", *saved.ICMPType, saved.ICMPEchoID, saved.ICMPEchoSeq)
+	}
+}
+
+func TestDecodePacketICMPErrorQuotesOriginal(t *testing.T) {
+	quotedIPv4 := func(flags layers.IPv4Flag, offset uint16) []byte {
+		ip := &layers.IPv4{Version: 4, IHL: 5, TTL: 1, Flags: flags, FragOffset: offset, Protocol: layers.IPProtocolUDP, SrcIP: net.IP{10, 0, 0, 2}, DstIP: net.IP{10, 0, 0, 9}}
+		udp := &layers.UDP{SrcPort: 5353, DstPort: 53}
+		udp.SetNetworkLayerForChecksum(ip)
+		// Routers quote the IP header and the first 8 bytes of its payload.
+		return serializeTestFrame(t, layers.LayerTypeIPv4, ip, udp, gopacket.Payload("query")).Data()[:28]
+	}
+	quotedIPv6 := func(serializable ...gopacket.SerializableLayer) []byte {
+		return serializeTestFrame(t, layers.LayerTypeIPv6, serializable...).Data()
+	}
+	ip6 := &layers.IPv6{Version: 6, NextHeader: layers.IPProtocolTCP, HopLimit: 64, SrcIP: net.ParseIP("2001:db8::1"), DstIP: net.ParseIP("2001:db8::2")}
+	tcp := &layers.TCP{SrcPort: 40000, DstPort: 443, SYN: true}
+	tcp.SetNetworkLayerForChecksum(ip6)
+	hopIP := &layers.IPv6{Version: 6, NextHeader: layers.IPProtocolIPv6HopByHop, HopLimit: 64, SrcIP: net.ParseIP("2001:db8::1"), DstIP: net.ParseIP("2001:db8::3")}
+	hopByHop := &layers.IPv6HopByHop{}
+	hopByHop.NextHeader = layers.IPProtocolUDP
+	hopByHop.Options = []*layers.IPv6HopByHopOption{{OptionType: 5, OptionLength: 4, OptionData: []byte{0, 0, 0, 0}}}
+	udp6 := &layers.UDP{SrcPort: 546, DstPort: 547}
+	udp6.SetNetworkLayerForChecksum(hopIP)
+
+	icmp4 := func(typ, code uint8, quote []byte) gopacket.Packet {
+		return serializeTestFrame(t, layers.LayerTypeIPv4,
+			&layers.IPv4{Version: 4, IHL: 5, TTL: 64, Protocol: layers.IPProtocolICMPv4, SrcIP: net.IP{10, 0, 0, 254}, DstIP: net.IP{10, 0, 0, 2}},
+			&layers.ICMPv4{TypeCode: layers.CreateICMPv4TypeCode(typ, code)},
+			gopacket.Payload(quote))
+	}
+	icmp6 := func(typ, code uint8, quote []byte) gopacket.Packet {
+		ip := &layers.IPv6{Version: 6, NextHeader: layers.IPProtocolICMPv6, HopLimit: 64, SrcIP: net.ParseIP("2001:db8::fe"), DstIP: net.ParseIP("2001:db8::1")}
+		icmp := &layers.ICMPv6{TypeCode: layers.CreateICMPv6TypeCode(typ, code)}
+		icmp.SetNetworkLayerForChecksum(ip)
+		return serializeTestFrame(t, layers.LayerTypeIPv6, ip, icmp, gopacket.Payload(append(make([]byte, 4), quote...)))
+	}
+
+	tests := []struct {
+		name             string
+		packet           gopacket.Packet
+		protocol         string
+		source, dest     string
+		srcPort, dstPort *int
+	}{
+		{"port unreachable", icmp4(layers.ICMPv4TypeDestinationUnreachable, layers.ICMPv4CodePort, quotedIPv4(0, 0)), ProtocolUDP, "10.0.0.2", "10.0.0.9", intPtr(5353), intPtr(53)},
+		{"later fragment has no ports", icmp4(layers.ICMPv4TypeTimeExceeded, 1, quotedIPv4(0, 10)), ProtocolUDP, "10.0.0.2", "10.0.0.9", nil, nil},
+		{"IPv6 prohibited", icmp6(layers.ICMPv6TypeDestinationUnreachable, 1, quotedIPv6(ip6, tcp)), ProtocolTCP, "2001:db8::1", "2001:db8::2", intPtr(40000), intPtr(443)},
+		{"IPv6 after extension header", icmp6(layers.ICMPv6TypeTimeExceeded, 0, quotedIPv6(hopIP, hopByHop, udp6)), ProtocolUDP, "2001:db8::1", "2001:db8::3", intPtr(546), intPtr(547)},
+		{"quote too short", icmp4(layers.ICMPv4TypeDestinationUnreachable, layers.ICMPv4CodeHost, quotedIPv4(0, 0)[:12]), "", "", "", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			saved := decodeTestPacket(t, tt.packet)
+			if saved.ICMPType == nil || saved.SourcePort != nil {
+				t.Fatalf("expected an ICMP error without ports, got %+v", saved)
+			}
+			if saved.ICMPOriginalProtocol != tt.protocol || saved.ICMPOriginalSourceIP != tt.source || saved.ICMPOriginalDestinationIP != tt.dest {
+				t.Errorf("unexpected original %s %s -> %s", saved.ICMPOriginalProtocol, saved.ICMPOriginalSourceIP, saved.ICMPOriginalDestinationIP)
+			}
+			if !reflect.DeepEqual(saved.ICMPOriginalSourcePort, tt.srcPort) || !reflect.DeepEqual(saved.ICMPOriginalDestinationPort, tt.dstPort) {
+				t.Errorf("unexpected original ports %v -> %v", saved.ICMPOriginalSourcePort, saved.ICMPOriginalDestinationPort)
+			}
+		})
+	}
+}
+
+func TestDecodePacketICMPv6AfterExtensionHeader(t *testing.T) {
+	ip := &layers.IPv6{
+		Version:    6,
+		NextHeader: layers.IPProtocolIPv6HopByHop,
+		HopLimit:   1,
+		SrcIP:      net.ParseIP("fe80::1"),
+		DstIP:      net.ParseIP("ff02::16"),
+	}
+	hopByHop := &layers.IPv6HopByHop{}
+	hopByHop.NextHeader = layers.IPProtocolICMPv6
+	hopByHop.Options = []*layers.IPv6HopByHopOption{{OptionType: 5, OptionLength: 4, OptionData: []byte{0, 0, 0, 0}}}
+	icmp := &layers.ICMPv6{TypeCode: layers.CreateICMPv6TypeCode(143, 0)}
+	icmp.SetNetworkLayerForChecksum(ip)
+
+	packet := serializeTestFrame(t, layers.LayerTypeIPv6, ip, hopByHop, icmp, gopacket.Payload(make([]byte, 4)))
+
+	saved := decodeTestPacket(t, packet)
+	if saved.Protocol != ProtocolICMPv6 {
+		t.Errorf("expected protocol ICMPv6, got %s", saved.Protocol)
+	}
+	if saved.SourceIP != "fe80::1" || saved.DestinationIP != "ff02::16" {
+		t.Errorf("unexpected addresses %s -> %s", saved.SourceIP, saved.DestinationIP)
+	}
+}
+
+func TestDecodePacketUDPOverQinQ(t *testing.T) {
+	ip := &layers.IPv4{
+		Version:  4,
+		IHL:      5,
+		TOS:      46<<2 | 1,
+		Id:       4242,
+		Flags:    layers.IPv4DontFragment,
+		TTL:      63,
+		Protocol: layers.IPProtocolUDP,
+		SrcIP:    net.IP{10, 0, 0, 1},
+		DstIP:    net.IP{10, 0, 0, 53},
+	}
+	udp := &layers.UDP{SrcPort: 40000, DstPort: 53}
+	udp.SetNetworkLayerForChecksum(ip)
+	packet := serializeTestFrame(t, layers.LayerTypeEthernet,
+		&layers.Ethernet{SrcMAC: testSrcMAC, DstMAC: testDstMAC, EthernetType: layers.EthernetTypeQinQ},
+		&layers.Dot1Q{VLANIdentifier: 100, Type: layers.EthernetTypeDot1Q},
+		&layers.Dot1Q{VLANIdentifier: 10, Type: layers.EthernetTypeIPv4},
+		ip, udp, gopacket.Payload([]byte{0x01}),
+	)
+
+	saved := decodeTestPacket(t, packet)
+	if saved.Protocol != ProtocolUDP {
+		t.Errorf("expected protocol UDP, got %s", saved.Protocol)
+	}
+	if saved.SourcePort == nil || *saved.SourcePort != 40000 || *saved.DestinationPort != 53 {
+		t.Errorf("unexpected ports %v -> %v", saved.SourcePort, saved.DestinationPort)
+	}
+	if saved.SourceMAC != testSrcMAC.String() || saved.DestinationMAC != testDstMAC.String() {
+		t.Errorf("unexpected MACs %s -> %s", saved.SourceMAC, saved.DestinationMAC)
+	}
+	if saved.VLANID == nil || *saved.VLANID != 100 || saved.InnerVLANID == nil || *saved.InnerVLANID != 10 {
+		t.Errorf("unexpected VLANs %v / %v", saved.VLANID, saved.InnerVLANID)
+	}
+	if saved.EtherType == nil || *saved.EtherType != int(layers.EthernetTypeIPv4) {
+		t.Errorf("expected EtherType IPv4, got %v", saved.EtherType)
+	}
+	if *saved.IPVersion != 4 || *saved.TTL != 63 || *saved.DSCP != 46 || *saved.ECN != 1 || *saved.IPID != 4242 {
+		t.Errorf("unexpected IPv4 header fields: version %d ttl %d dscp %d ecn %d id %d",
+			*saved.IPVersion, *saved.TTL, *saved.DSCP, *saved.ECN, *saved.IPID)
+	}
+	if !*saved.DontFragment || *saved.MoreFragments || *saved.FragmentOffset != 0 {
+		t.Error("unexpected fragment flags")
+	}
+	// The frame is padded to the 60 bytes Ethernet minimum.
+	if *saved.TotalLength != 29 || saved.CapturedLength != 60 || saved.FrameLength != saved.CapturedLength {
+		t.Errorf("unexpected lengths: total %d captured %d frame %d", *saved.TotalLength, saved.CapturedLength, saved.FrameLength)
+	}
+}
+
+func TestDecodePacketIPv6Fragment(t *testing.T) {
+	ip := &layers.IPv6{
+		Version:      6,
+		TrafficClass: 10<<2 | 2,
+		NextHeader:   layers.IPProtocolIPv6Fragment,
+		HopLimit:     32,
+		SrcIP:        net.ParseIP("2001:db8::1"),
+		DstIP:        net.ParseIP("2001:db8::2"),
+	}
+	fragment := []byte{byte(layers.IPProtocolUDP), 0, 0x00, 0x01, 0, 0, 0x30, 0x39}
+	packet := serializeTestFrame(t, layers.LayerTypeIPv6, ip, gopacket.Payload(append(fragment, make([]byte, 16)...)))
+
+	saved := decodeTestPacket(t, packet)
+	if *saved.IPVersion != 6 || *saved.TTL != 32 || *saved.DSCP != 10 || *saved.ECN != 2 {
+		t.Errorf("unexpected IPv6 header fields: version %d hop limit %d dscp %d ecn %d",
+			*saved.IPVersion, *saved.TTL, *saved.DSCP, *saved.ECN)
+	}
+	if saved.IPID == nil || *saved.IPID != 12345 || !*saved.MoreFragments || *saved.FragmentOffset != 0 {
+		t.Errorf("unexpected fragment fields: id %v more %v offset %v", saved.IPID, saved.MoreFragments, saved.FragmentOffset)
+	}
+	if saved.DontFragment != nil {
+		t.Error("expected no DF flag for IPv6")
+	}
+	if *saved.TotalLength != 40+24 {
+		t.Errorf("expected total length 64, got %d", *saved.TotalLength)
+	}
+}
+
+func TestDecodePacketGREKeepsOuterHeaders(t *testing.T) {
+	outer := &layers.IPv4{Version: 4, IHL: 5, TTL: 64, Protocol: layers.IPProtocolGRE, SrcIP: net.IP{172, 16, 0, 1}, DstIP: net.IP{172, 16, 0, 2}}
+	inner := &layers.IPv4{Version: 4, IHL: 5, TTL: 64, Protocol: layers.IPProtocolTCP, SrcIP: net.IP{10, 0, 0, 1}, DstIP: net.IP{10, 0, 0, 2}}
+	tcp := &layers.TCP{SrcPort: 1234, DstPort: 80}
+	tcp.SetNetworkLayerForChecksum(inner)
+	packet := serializeTestFrame(t, layers.LayerTypeIPv4, outer, &layers.GRE{Protocol: layers.EthernetTypeIPv4}, inner, tcp)
+
+	saved := decodeTestPacket(t, packet)
+	if saved.Protocol != ProtocolGRE {
+		t.Errorf("expected protocol GRE, got %s", saved.Protocol)
+	}
+	if saved.SourceIP != "172.16.0.1" || saved.DestinationIP != "172.16.0.2" {
+		t.Errorf("expected outer addresses, got %s -> %s", saved.SourceIP, saved.DestinationIP)
+	}
+	if saved.SourcePort != nil {
+		t.Error("expected no ports for a GRE packet")
+	}
+}
+
+func TestDecodePacketTruncatedTransport(t *testing.T) {
+	full := mustBuildPacket(t, "10.0.0.1", "10.0.0.2", 1234, 80).Data()
+	// Keep the IPv4 header and the first bytes of the TCP header only.
+	packet := gopacket.NewPacket(full[:24], layers.LayerTypeIPv4, gopacket.Default)
+
+	saved := decodeTestPacket(t, packet)
+	if saved.Protocol != ProtocolIPv4 {
+		t.Errorf("expected protocol IPv4, got %s", saved.Protocol)
+	}
+	if saved.SourceIP != "10.0.0.1" {
+		t.Errorf("expected the IPv4 source to be kept, got %s", saved.SourceIP)
+	}
+	if saved.SourcePort != nil {
+		t.Error("expected no ports when TCP does not decode")
+	}
+	if saved.DecodeError == nil {
+		t.Error("expected the decode error to be recorded")
+	}
+}
+
+func TestDecodePacketUnknownLinkLayer(t *testing.T) {
+	packet := gopacket.NewPacket([]byte{0xde, 0xad}, layers.LayerTypeEthernet, gopacket.Default)
+
+	saved := decodeTestPacket(t, packet)
+	if saved.Protocol != ProtocolUnknown {
+		t.Errorf("expected protocol Unknown, got %s", saved.Protocol)
+	}
+	if saved.DecodeError == nil {
+		t.Error("expected the decode error to be recorded")
+	}
+}
+
+func TestDecodePacketWithoutData(t *testing.T) {
+	var saved SavedPacket
+	if err := decodePacket(nil, &saved); !errors.Is(err, ErrPacketWithoutData) {
+		t.Fatalf("expected ErrPacketWithoutData, got %v", err)
+	}
+}
+
+func TestDecodePacketTCPHeader(t *testing.T) {
+	ip := &layers.IPv4{Version: 4, IHL: 5, TTL: 64, Protocol: layers.IPProtocolTCP, SrcIP: net.IP{10, 0, 0, 1}, DstIP: net.IP{10, 0, 0, 2}}
+	tcp := &layers.TCP{
+		SrcPort: 50000,
+		DstPort: 443,
+		Seq:     3000000000,
+		Ack:     0,
+		SYN:     true,
+		ECE:     true,
+		CWR:     true,
+		Window:  64240,
+		Options: []layers.TCPOption{
+			{OptionType: layers.TCPOptionKindMSS, OptionLength: 4, OptionData: []byte{0x05, 0xb4}},
+			{OptionType: layers.TCPOptionKindSACKPermitted, OptionLength: 2},
+			{OptionType: layers.TCPOptionKindTimestamps, OptionLength: 10, OptionData: []byte{0, 0, 0x30, 0x39, 0, 0, 0, 0}},
+			{OptionType: layers.TCPOptionKindNop},
+			{OptionType: layers.TCPOptionKindWindowScale, OptionLength: 3, OptionData: []byte{7}},
+		},
+	}
+	tcp.SetNetworkLayerForChecksum(ip)
+	packet := serializeTestFrame(t, layers.LayerTypeIPv4, ip, tcp)
+
+	saved := decodeTestPacket(t, packet)
+	if !*saved.TCPFlagSYN || *saved.TCPFlagACK || *saved.TCPFlagRST || !*saved.TCPFlagECE || !*saved.TCPFlagCWR {
+		t.Error("unexpected TCP flags")
+	}
+	if *saved.TCPSeq != 3000000000 || *saved.TCPAck != 0 || *saved.TCPWindow != 64240 {
+		t.Errorf("unexpected seq %d ack %d window %d", *saved.TCPSeq, *saved.TCPAck, *saved.TCPWindow)
+	}
+	if saved.TCPMSS == nil || *saved.TCPMSS != 1460 {
+		t.Errorf("expected MSS 1460, got %v", saved.TCPMSS)
+	}
+	if saved.TCPWindowScale == nil || *saved.TCPWindowScale != 7 {
+		t.Errorf("expected window scale 7, got %v", saved.TCPWindowScale)
+	}
+	if saved.TCPSACKPermitted == nil || !*saved.TCPSACKPermitted {
+		t.Error("expected SACK permitted")
+	}
+	if saved.TCPTimestamp == nil || *saved.TCPTimestamp != 12345 || *saved.TCPTimestampEcho != 0 {
+		t.Errorf("unexpected timestamps %v / %v", saved.TCPTimestamp, saved.TCPTimestampEcho)
+	}
+}
+
+func TestDecodePacketTCPWithoutOptions(t *testing.T) {
+	ip := &layers.IPv4{Version: 4, IHL: 5, TTL: 64, Protocol: layers.IPProtocolTCP, SrcIP: net.IP{10, 0, 0, 2}, DstIP: net.IP{10, 0, 0, 1}}
+	tcp := &layers.TCP{SrcPort: 443, DstPort: 50000, Seq: 1, Ack: 2, RST: true, ACK: true}
+	tcp.SetNetworkLayerForChecksum(ip)
+	packet := serializeTestFrame(t, layers.LayerTypeIPv4, ip, tcp)
+
+	saved := decodeTestPacket(t, packet)
+	if !*saved.TCPFlagRST || !*saved.TCPFlagACK || *saved.TCPWindow != 0 {
+		t.Error("expected a zero window RST/ACK")
+	}
+	if saved.TCPMSS != nil || saved.TCPWindowScale != nil || saved.TCPSACKPermitted != nil || saved.TCPTimestamp != nil {
+		t.Error("expected no TCP options")
+	}
+}
+
+func TestDecodePacketUDPHasNoTCPFields(t *testing.T) {
+	ip := &layers.IPv4{Version: 4, IHL: 5, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: net.IP{10, 0, 0, 1}, DstIP: net.IP{10, 0, 0, 2}}
+	udp := &layers.UDP{SrcPort: 5000, DstPort: 5001}
+	udp.SetNetworkLayerForChecksum(ip)
+	packet := serializeTestFrame(t, layers.LayerTypeIPv4, ip, udp, gopacket.Payload([]byte{1}))
+
+	saved := decodeTestPacket(t, packet)
+	if saved.TCPFlagSYN != nil || saved.TCPSeq != nil || saved.TCPWindow != nil {
+		t.Error("expected no TCP fields for UDP")
+	}
+}
+
+func TestPacketDirection(t *testing.T) {
+	device := testSrcMAC
+	other := net.HardwareAddr{0x02, 0, 0, 0, 0, 0x99}
+	frame := func(src, dst net.HardwareAddr) gopacket.Packet {
+		return serializeTestFrame(t, layers.LayerTypeEthernet,
+			&layers.Ethernet{SrcMAC: src, DstMAC: dst, EthernetType: layers.EthernetTypeLLC},
+			gopacket.Payload{0, 0, 0})
+	}
+	// SLL header: packet type, ARPHRD_ETHER, address length, address padded
+	// to 8 bytes and an IPv4 protocol with an empty payload.
+	cooked := func(packetType layers.LinuxSLLPacketType) gopacket.Packet {
+		header := []byte{0, byte(packetType), 0, 1, 0, 6}
+		header = append(header, device...)
+		header = append(header, 0, 0, 0x08, 0x00)
+		return gopacket.NewPacket(header, layers.LayerTypeLinuxSLL, gopacket.Default)
+	}
+
+	testCases := []struct {
+		name     string
+		packet   gopacket.Packet
+		mac      net.HardwareAddr
+		expected string
+	}{
+		{"sent", frame(device, other), device, DirectionOut},
+		{"received", frame(other, device), device, DirectionIn},
+		{"broadcast", frame(other, layers.EthernetBroadcast), device, DirectionIn},
+		{"other hosts", frame(other, testDstMAC), device, ""},
+		{"unknown device mac", frame(device, other), nil, ""},
+		{"cooked outgoing", cooked(layers.LinuxSLLPacketTypeOutgoing), nil, DirectionOut},
+		{"cooked to us", cooked(layers.LinuxSLLPacketTypeHost), nil, DirectionIn},
+		{"cooked other host", cooked(layers.LinuxSLLPacketTypeOtherhost), nil, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if direction := packetDirection(tc.packet, tc.mac); direction != tc.expected {
+				t.Errorf("expected %q, got %q", tc.expected, direction)
+			}
+		})
+	}
+}
@@ -0,0 +1,336 @@
+package pkg
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	SortAscending  = "asc"
+	SortDescending = "desc"
+
+	DirectionIn  = "in"
+	DirectionOut = "out"
+)
+
+var ErrInvalidPacketFilter = errors.New("invalid packet filter")
+
+// packetSortColumns are the columns GetPackets accepts as sort key.
+var packetSortColumns = map[string]bool{
+	"id":               true,
+	"created_at":       true,
+	"device_id":        true,
+	"direction":        true,
+	"source_ip":        true,
+	"destination_ip":   true,
+	"source_port":      true,
+	"destination_port": true,
+	"protocol":         true,
+	"source_mac":       true,
+	"destination_mac":  true,
+	"ether_type":       true,
+	"vlan_id":          true,
+	"ip_version":       true,
+	"ttl":              true,
+	"dscp":             true,
+	"ip_id":            true,
+	"total_length":     true,
+	"captured_length":  true,
+	"frame_length":     true,
+	"tcp_flag_rst":     true,
+	"tcp_seq":          true,
+	"tcp_window":       true,
+}
+
+// PacketFilter selects and orders the packets returned by GetPackets. Zero
+// fields do not filter. IPs and Ports match either side of the packet.
+type PacketFilter struct {
+	Limit  int
+	Sort   string
+	Order  string
+	Cursor *PacketCursor
+
+	From time.Time
+	To   time.Time
+
+	SourceIPs      []*net.IPNet
+	DestinationIPs []*net.IPNet
+	IPs            []*net.IPNet
+
+	SourcePorts      []PortRange
+	DestinationPorts []PortRange
+	Ports            []PortRange
+
+	Protocols []string
+	Devices   []string
+	Direction string
+	Tag       string
+
+	// Query is a display filter combined with the other fields.
+	Query *DisplayFilter
+}
+
+// PortRange is an inclusive range of ports, Low equals High for a single
+// port.
+type PortRange struct {
+	Low  int
+	High int
+}
+
+// ParseIPNets parses a comma separated list of IPs and CIDRs. A single IP
+// is returned as a host network.
+func ParseIPNets(value string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, item := range splitList(value) {
+		if strings.Contains(item, "/") {
+			_, network, err := net.ParseCIDR(item)
+			if err != nil {
+				return nil, fmt.Errorf("%w: invalid CIDR %q", ErrInvalidPacketFilter, item)
+			}
+			nets = append(nets, network)
+			continue
+		}
+		ip := net.ParseIP(item)
+		if ip == nil {
+			return nil, fmt.Errorf("%w: invalid IP %q", ErrInvalidPacketFilter, item)
+		}
+		bits := 128
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 32
+		}
+		nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+	}
+	return nets, nil
+}
+
+// ParsePortRanges parses a comma separated list of ports and ranges such
+// as "53,80,8000-8100".
+func ParsePortRanges(value string) ([]PortRange, error) {
+	var ranges []PortRange
+	for _, item := range splitList(value) {
+		low, high, isRange := strings.Cut(item, "-")
+		if !isRange {
+			high = low
+		}
+		lowPort, lowErr := parsePort(low)
+		highPort, highErr := parsePort(high)
+		if lowErr != nil || highErr != nil || lowPort > highPort {
+			return nil, fmt.Errorf("%w: invalid port range %q", ErrInvalidPacketFilter, item)
+		}
+		ranges = append(ranges, PortRange{Low: lowPort, High: highPort})
+	}
+	return ranges, nil
+}
+
+func parsePort(value string) (int, error) {
+	port, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || port < 0 || port > 65535 {
+		return 0, errors.New("invalid port")
+	}
+	return port, nil
+}
+
+// ParseProtocols parses a comma separated list of protocols, case
+// insensitively, into their stored names.
+func ParseProtocols(value string) ([]string, error) {
+	var protocols []string
+	for _, item := range splitList(value) {
+		protocol, ok := protocolNames[strings.ToLower(item)]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown protocol %q", ErrInvalidPacketFilter, item)
+		}
+		protocols = append(protocols, protocol)
+	}
+	return protocols, nil
+}
+
+var protocolNames = func() map[string]string {
+	names := map[string]string{ProtocolUnknown: ProtocolUnknown}
+	for _, protocol := range protocolsByLayer {
+		names[protocol] = protocol
+	}
+	lower := make(map[string]string, len(names))
+	for name, protocol := range names {
+		lower[strings.ToLower(name)] = protocol
+	}
+	return lower
+}()
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Validate checks the parts of the filter that end up in the SQL query as
+// identifiers rather than values.
+func (f PacketFilter) Validate() error {
+	if f.Sort != "" && !packetSortColumns[f.Sort] {
+		return fmt.Errorf("%w: cannot sort by %q", ErrInvalidPacketFilter, f.Sort)
+	}
+	if f.Order != "" && f.Order != SortAscending && f.Order != SortDescending {
+		return fmt.Errorf("%w: order must be %s or %s", ErrInvalidPacketFilter, SortAscending, SortDescending)
+	}
+	if f.Direction != "" && f.Direction != DirectionIn && f.Direction != DirectionOut {
+		return fmt.Errorf("%w: direction must be %s or %s", ErrInvalidPacketFilter, DirectionIn, DirectionOut)
+	}
+	if f.Cursor != nil && !f.Pageable() {
+		return fmt.Errorf("%w: cursors need the packets sorted by created_at", ErrInvalidPacketFilter)
+	}
+	if !f.From.IsZero() && !f.To.IsZero() && f.To.Before(f.From) {
+		return fmt.Errorf("%w: to is before from", ErrInvalidPacketFilter)
+	}
+	return nil
+}
+
+// apply adds the where clauses of the filter to query.
+func (f PacketFilter) apply(query *gorm.DB) *gorm.DB {
+	query = timeRange(query, "created_at", f.From, f.To)
+	if len(f.SourceIPs) > 0 {
+		query = query.Where(ipCondition(query, f.SourceIPs, "source"))
+	}
+	if len(f.DestinationIPs) > 0 {
+		query = query.Where(ipCondition(query, f.DestinationIPs, "destination"))
+	}
+	if len(f.IPs) > 0 {
+		query = query.Where(ipCondition(query, f.IPs, "source").Or(ipCondition(query, f.IPs, "destination")))
+	}
+	if len(f.SourcePorts) > 0 {
+		query = query.Where(portCondition(query, f.SourcePorts, "source_port"))
+	}
+	if len(f.DestinationPorts) > 0 {
+		query = query.Where(portCondition(query, f.DestinationPorts, "destination_port"))
+	}
+	if len(f.Ports) > 0 {
+		query = query.Where(portCondition(query, f.Ports, "source_port").Or(portCondition(query, f.Ports, "destination_port")))
+	}
+	if len(f.Protocols) > 0 {
+		query = query.Where("protocol IN ?", f.Protocols)
+	}
+	if len(f.Devices) > 0 {
+		query = query.Where("device_id IN ?", f.Devices)
+	}
+	if f.Direction != "" {
+		query = query.Where("direction = ?", f.Direction)
+	}
+	if f.Tag != "" {
+		query = query.Where("EXISTS (SELECT 1 FROM json_each(saved_packets.tags) WHERE json_each.value = ?)", f.Tag)
+	}
+	if f.Query != nil {
+		query = query.Where(f.Query.sql, f.Query.args...)
+	}
+	return query
+}
+
+// order returns the ORDER BY clause of the filter. The sort column comes
+// from the whitelist, id breaks ties in the same direction.
+func (f PacketFilter) order() string {
+	sort := f.Sort
+	if sort == "" {
+		sort = "created_at"
+	}
+	order := f.Order
+	if order == "" {
+		order = SortDescending
+	}
+	if sort == "id" {
+		return "id " + order
+	}
+	return sort + " " + order + ", id " + order
+}
+
+// sqlTime returns t as the stored times compare to it. sqlite compares
+// the times as text, so t must be in the zone they were stored in, the
+// local one.
+func sqlTime(t time.Time) time.Time {
+	return t.Local()
+}
+
+// timeRange restricts the query to the rows whose column is from from,
+// included, to to, excluded. Zero times do not restrict.
+func timeRange(query *gorm.DB, column string, from, to time.Time) *gorm.DB {
+	return spanRange(query, column, column, from, to)
+}
+
+// spanRange restricts the query to the rows whose span, from their start
+// to their end column, meets the range from from to to.
+func spanRange(query *gorm.DB, startColumn, endColumn string, from, to time.Time) *gorm.DB {
+	if !from.IsZero() {
+		query = query.Where(endColumn+" >= ?", sqlTime(from))
+	}
+	if !to.IsZero() {
+		query = query.Where(startColumn+" < ?", sqlTime(to))
+	}
+	return query
+}
+
+// ipCondition matches any of nets on the source or destination side. Host
+// networks compare the stored address, wider networks the sortable key.
+func ipCondition(query *gorm.DB, nets []*net.IPNet, side string) *gorm.DB {
+	base := query.Session(&gorm.Session{NewDB: true})
+	var condition *gorm.DB
+	for _, network := range nets {
+		var clause *gorm.DB
+		if ones, bits := network.Mask.Size(); ones == bits {
+			clause = base.Where(side+"_ip = ?", network.IP.String())
+		} else {
+			low, high := ipKeyRange(network)
+			clause = base.Where(side+"_ip_key BETWEEN ? AND ?", low, high)
+		}
+		condition = or(condition, clause)
+	}
+	return condition
+}
+
+func portCondition(query *gorm.DB, ranges []PortRange, column string) *gorm.DB {
+	base := query.Session(&gorm.Session{NewDB: true})
+	var condition *gorm.DB
+	for _, r := range ranges {
+		condition = or(condition, base.Where(column+" BETWEEN ? AND ?", r.Low, r.High))
+	}
+	return condition
+}
+
+func or(condition, clause *gorm.DB) *gorm.DB {
+	if condition == nil {
+		return clause
+	}
+	return condition.Or(clause)
+}
+
+// ipKey is the address as 32 hex digits of its 16 byte form, so that
+// string order is address order and a network is a key range.
+func ipKey(address string) string {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return ""
+	}
+	return hex.EncodeToString(ip.To16())
+}
+
+func ipKeyRange(network *net.IPNet) (string, string) {
+	ones, bits := network.Mask.Size()
+	if bits == 32 {
+		ones += 96
+	}
+	mask := net.CIDRMask(ones, 128)
+	ip := network.IP.To16()
+	low := make(net.IP, 16)
+	high := make(net.IP, 16)
+	for i := range ip {
+		low[i] = ip[i] & mask[i]
+		high[i] = ip[i] | ^mask[i]
+	}
+	return hex.EncodeToString(low), hex.EncodeToString(high)
+}
@@ -0,0 +1,203 @@
+package pkg
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestParseIPNets(t *testing.T) {
+	nets, err := ParseIPNets("10.0.0.1, 192.168.0.0/16,2001:db8::1")
+	if err != nil {
+		t.Fatalf("ParseIPNets returned error: %v", err)
+	}
+	if len(nets) != 3 {
+		t.Fatalf("expected 3 networks, got %d", len(nets))
+	}
+	if ones, bits := nets[0].Mask.Size(); ones != 32 || bits != 32 {
+		t.Errorf("expected a /32 host network, got /%d of %d", ones, bits)
+	}
+	if nets[1].String() != "192.168.0.0/16" {
+		t.Errorf("expected 192.168.0.0/16, got %s", nets[1])
+	}
+	if ones, bits := nets[2].Mask.Size(); ones != 128 || bits != 128 {
+		t.Errorf("expected a /128 host network, got /%d of %d", ones, bits)
+	}
+
+	for _, value := range []string{"10.0.0", "10.0.0.0/33", "host"} {
+		if _, err := ParseIPNets(value); !errors.Is(err, ErrInvalidPacketFilter) {
+			t.Errorf("%q: expected ErrInvalidPacketFilter, got %v", value, err)
+		}
+	}
+}
+
+func TestParsePortRanges(t *testing.T) {
+	ranges, err := ParsePortRanges("53, 8000-8100")
+	if err != nil {
+		t.Fatalf("ParsePortRanges returned error: %v", err)
+	}
+	if len(ranges) != 2 || ranges[0] != (PortRange{53, 53}) || ranges[1] != (PortRange{8000, 8100}) {
+		t.Fatalf("unexpected ranges: %+v", ranges)
+	}
+
+	for _, value := range []string{"http", "70000", "100-50", "1-2-3"} {
+		if _, err := ParsePortRanges(value); !errors.Is(err, ErrInvalidPacketFilter) {
+			t.Errorf("%q: expected ErrInvalidPacketFilter, got %v", value, err)
+		}
+	}
+}
+
+func TestParseProtocols(t *testing.T) {
+	protocols, err := ParseProtocols("tcp,ICMPv6,udplite")
+	if err != nil {
+		t.Fatalf("ParseProtocols returned error: %v", err)
+	}
+	if len(protocols) != 3 || protocols[0] != ProtocolTCP || protocols[1] != ProtocolICMPv6 || protocols[2] != ProtocolUDPLite {
+		t.Fatalf("unexpected protocols: %v", protocols)
+	}
+	if _, err := ParseProtocols("tcp,gopher"); !errors.Is(err, ErrInvalidPacketFilter) {
+		t.Errorf("expected ErrInvalidPacketFilter, got %v", err)
+	}
+}
+
+func TestPacketFilterValidate(t *testing.T) {
+	now := time.Now()
+	for name, filter := range map[string]PacketFilter{
+		"sql in sort":      {Sort: "created_at; DROP TABLE saved_packets"},
+		"unknown column":   {Sort: "data"},
+		"order":            {Order: "sideways"},
+		"direction":        {Direction: "up"},
+		"to before from":   {From: now, To: now.Add(-time.Minute)},
+		"sql in the order": {Order: "desc, (SELECT 1)"},
+	} {
+		if err := filter.Validate(); !errors.Is(err, ErrInvalidPacketFilter) {
+			t.Errorf("%s: expected ErrInvalidPacketFilter, got %v", name, err)
+		}
+	}
+
+	valid := PacketFilter{Sort: "source_port", Order: SortAscending, Direction: DirectionIn, From: now, To: now}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("expected valid filter, got %v", err)
+	}
+}
+
+func TestGetPacketsRejectsInvalidFilter(t *testing.T) {
+	repo := setupTestDB(t)
+
+	_, err := repo.GetPackets(PacketFilter{Sort: "id desc; DELETE FROM saved_packets"})
+	if !errors.Is(err, ErrInvalidPacketFilter) {
+		t.Fatalf("expected ErrInvalidPacketFilter, got %v", err)
+	}
+}
+
+func createFilterTestPackets(t *testing.T, repo *SqlLitePacketRepository) time.Time {
+	t.Helper()
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i, packet := range []SavedPacket{
+		{SourceIP: "10.0.0.1", DestinationIP: "192.168.1.10", SourcePort: intPtr(40000), DestinationPort: intPtr(443), Protocol: ProtocolTCP, DeviceID: "eth0", Direction: DirectionOut},
+		{SourceIP: "192.168.1.10", DestinationIP: "10.0.0.1", SourcePort: intPtr(443), DestinationPort: intPtr(40000), Protocol: ProtocolTCP, DeviceID: "eth0", Direction: DirectionIn, Tags: []string{"tls"}},
+		{SourceIP: "10.0.0.1", DestinationIP: "8.8.8.8", SourcePort: intPtr(5353), DestinationPort: intPtr(53), Protocol: ProtocolUDP, DeviceID: "wlan0", Direction: DirectionOut},
+		{SourceIP: "2001:db8::1", DestinationIP: "2001:db8::2", Protocol: ProtocolICMPv6, DeviceID: "wlan0"},
+	} {
+		packet.CreatedAt = start.Add(time.Duration(i) * time.Minute)
+		packet.UpdatedAt = packet.CreatedAt
+		packet.SourceIPKey = ipKey(packet.SourceIP)
+		packet.DestinationIPKey = ipKey(packet.DestinationIP)
+		if err := repo.db.Create(&packet).Error; err != nil {
+			t.Fatalf("failed to create packet: %v", err)
+		}
+	}
+	return start
+}
+
+func TestGetPacketsFiltered(t *testing.T) {
+	repo := setupTestDB(t)
+	start := createFilterTestPackets(t, repo)
+
+	mustNets := func(value string) []*net.IPNet {
+		nets, err := ParseIPNets(value)
+		if err != nil {
+			t.Fatalf("ParseIPNets(%q): %v", value, err)
+		}
+		return nets
+	}
+
+	testCases := []struct {
+		name     string
+		filter   PacketFilter
+		expected []string
+	}{
+		{"time range", PacketFilter{From: start.Add(time.Minute), To: start.Add(3 * time.Minute)}, []string{"192.168.1.10", "10.0.0.1"}},
+		{"source host", PacketFilter{SourceIPs: mustNets("192.168.1.10")}, []string{"192.168.1.10"}},
+		{"destination network", PacketFilter{DestinationIPs: mustNets("192.168.0.0/16,8.0.0.0/8")}, []string{"10.0.0.1", "10.0.0.1"}},
+		{"either side", PacketFilter{IPs: mustNets("8.8.8.8")}, []string{"10.0.0.1"}},
+		{"ipv6 network", PacketFilter{IPs: mustNets("2001:db8::/32")}, []string{"2001:db8::1"}},
+		{"ipv4 network does not match ipv6", PacketFilter{IPs: mustNets("0.0.0.0/0")}, []string{"10.0.0.1", "192.168.1.10", "10.0.0.1"}},
+		{"destination port range", PacketFilter{DestinationPorts: []PortRange{{1, 1024}}}, []string{"10.0.0.1", "10.0.0.1"}},
+		{"either port", PacketFilter{Ports: []PortRange{{443, 443}}}, []string{"10.0.0.1", "192.168.1.10"}},
+		{"protocol", PacketFilter{Protocols: []string{ProtocolUDP, ProtocolICMPv6}}, []string{"10.0.0.1", "2001:db8::1"}},
+		{"device", PacketFilter{Devices: []string{"eth0"}}, []string{"10.0.0.1", "192.168.1.10"}},
+		{"direction", PacketFilter{Direction: DirectionIn}, []string{"192.168.1.10"}},
+		{"tag", PacketFilter{Tag: "tls"}, []string{"192.168.1.10"}},
+		{"combined", PacketFilter{Devices: []string{"eth0"}, Ports: []PortRange{{40000, 40000}}, Direction: DirectionOut}, []string{"10.0.0.1"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.filter.Sort = "id"
+			tc.filter.Order = SortAscending
+			packets, err := repo.GetPackets(tc.filter)
+			if err != nil {
+				t.Fatalf("GetPackets returned error: %v", err)
+			}
+			if len(packets) != len(tc.expected) {
+				t.Fatalf("expected %d packets, got %d: %+v", len(tc.expected), len(packets), packets)
+			}
+			for i, packet := range packets {
+				if packet.SourceIP != tc.expected[i] {
+					t.Errorf("packet %d: expected source %s, got %s", i, tc.expected[i], packet.SourceIP)
+				}
+			}
+		})
+	}
+}
+
+func TestGetPacketsOrder(t *testing.T) {
+	repo := setupTestDB(t)
+	createFilterTestPackets(t, repo)
+
+	packets, err := repo.GetPackets(PacketFilter{Sort: "device_id", Order: SortAscending})
+	if err != nil {
+		t.Fatalf("GetPackets returned error: %v", err)
+	}
+	if len(packets) != 4 {
+		t.Fatalf("expected 4 packets, got %d", len(packets))
+	}
+	for i, id := range []uint{1, 2, 3, 4} {
+		if packets[i].ID != id {
+			t.Fatalf("expected ids in ascending order within each device, got %+v", packets)
+		}
+	}
+
+	packets, _ = repo.GetPackets(PacketFilter{Limit: 2})
+	if len(packets) != 2 || packets[0].ID != 4 || packets[1].ID != 3 {
+		t.Fatalf("expected the 2 newest packets first, got %+v", packets)
+	}
+}
+
+func TestMapPacketToSavedPacketSetsIPKeys(t *testing.T) {
+	saved, err := mapPacketToSavedPacket(AppPacket{
+		Data:      createTestPacket("10.0.0.1", "10.0.0.2", 1234, 80),
+		Direction: DirectionOut,
+	})
+	if err != nil {
+		t.Fatalf("mapPacketToSavedPacket returned error: %v", err)
+	}
+	if saved.SourceIPKey != "00000000000000000000ffff0a000001" || saved.DestinationIPKey != "00000000000000000000ffff0a000002" {
+		t.Errorf("unexpected keys %q %q", saved.SourceIPKey, saved.DestinationIPKey)
+	}
+	if saved.Direction != DirectionOut {
+		t.Errorf("expected direction out, got %q", saved.Direction)
+	}
+}
@@ -0,0 +1,77 @@
+package pkg
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+)
+
+// PacketHub fans the captured packets out to live subscribers before they
+// are stored. Each subscriber has a bounded buffer, packets that do not fit
+// are dropped and counted instead of slowing the capture down.
+type PacketHub struct {
+	mu          sync.RWMutex
+	subscribers map[*Subscription]struct{}
+	count       atomic.Int32
+}
+
+// Subscription receives the packets matching its filter on Packets. The
+// packets are not stored yet and have no ID.
+type Subscription struct {
+	Packets <-chan SavedPacket
+	packets chan SavedPacket
+	filter  PacketFilter
+	dropped atomic.Uint64
+}
+
+func NewPacketHub() *PacketHub {
+	return &PacketHub{subscribers: make(map[*Subscription]struct{})}
+}
+
+// Subscribe registers a subscriber buffering up to buffer packets.
+func (h *PacketHub) Subscribe(filter PacketFilter, buffer int) *Subscription {
+	packets := make(chan SavedPacket, max(buffer, 1))
+	subscription := &Subscription{Packets: packets, packets: packets, filter: filter}
+	h.mu.Lock()
+	h.subscribers[subscription] = struct{}{}
+	h.count.Store(int32(len(h.subscribers)))
+	h.mu.Unlock()
+	return subscription
+}
+
+func (h *PacketHub) Unsubscribe(subscription *Subscription) {
+	h.mu.Lock()
+	delete(h.subscribers, subscription)
+	h.count.Store(int32(len(h.subscribers)))
+	h.mu.Unlock()
+}
+
+// Publish decodes the packet and hands it to the matching subscribers
+// without blocking. Packets are only decoded when someone listens.
+func (h *PacketHub) Publish(packet AppPacket) {
+	if h.count.Load() == 0 {
+		return
+	}
+	saved, err := mapPacketToSavedPacket(packet)
+	if err != nil {
+		log.Println("Failed to decode packet for streaming:", err)
+		return
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for subscription := range h.subscribers {
+		if !subscription.filter.Matches(saved) {
+			continue
+		}
+		select {
+		case subscription.packets <- *saved:
+		default:
+			subscription.dropped.Add(1)
+		}
+	}
+}
+
+// TakeDropped returns the number of packets dropped since the last call.
+func (s *Subscription) TakeDropped() uint64 {
+	return s.dropped.Swap(0)
+}
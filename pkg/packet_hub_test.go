@@ -0,0 +1,86 @@
+package pkg
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPacketHubPublish(t *testing.T) {
+	hub := NewPacketHub()
+	ports, _ := ParsePortRanges("443")
+	https := hub.Subscribe(PacketFilter{Ports: ports}, 1)
+	all := hub.Subscribe(PacketFilter{}, 10)
+
+	for _, port := range []int{443, 80, 443} {
+		hub.Publish(AppPacket{Data: createTestPacket("10.0.0.1", "10.0.0.2", 40000, port), CreatedAt: time.Now(), DeviceID: "eth0"})
+	}
+
+	if len(all.Packets) != 3 || all.TakeDropped() != 0 {
+		t.Fatalf("expected 3 packets and none dropped, got %d", len(all.Packets))
+	}
+	packet := <-https.Packets
+	if *packet.DestinationPort != 443 || packet.DeviceID != "eth0" || packet.SourceIPKey == "" {
+		t.Errorf("unexpected packet %+v", packet)
+	}
+	if len(https.Packets) != 0 {
+		t.Errorf("expected the buffer of 1 to hold a single packet")
+	}
+	if dropped := https.TakeDropped(); dropped != 1 {
+		t.Errorf("expected 1 dropped packet, got %d", dropped)
+	}
+	if dropped := https.TakeDropped(); dropped != 0 {
+		t.Errorf("expected the dropped count to reset, got %d", dropped)
+	}
+
+	hub.Unsubscribe(https)
+	hub.Publish(AppPacket{Data: createTestPacket("10.0.0.1", "10.0.0.2", 40000, 443), CreatedAt: time.Now()})
+	if len(https.Packets) != 0 || https.TakeDropped() != 0 {
+		t.Errorf("expected no packets after unsubscribing")
+	}
+	if len(all.Packets) != 4 {
+		t.Errorf("expected the other subscriber to keep receiving, got %d", len(all.Packets))
+	}
+}
+
+func TestPacketFilterMatches(t *testing.T) {
+	now := time.Now()
+	packet := &SavedPacket{
+		SourceIP: "10.0.0.1", DestinationIP: "192.168.1.10", SourcePort: intPtr(40000), DestinationPort: intPtr(443),
+		Protocol: ProtocolTCP, DeviceID: "eth0", Direction: DirectionOut, CreatedAt: now,
+	}
+	_, network, _ := net.ParseCIDR("192.168.0.0/16")
+	query, err := ParseDisplayFilter("tcp.dstport == 443")
+	if err != nil {
+		t.Fatalf("ParseDisplayFilter returned error: %v", err)
+	}
+
+	testCases := []struct {
+		name     string
+		filter   PacketFilter
+		expected bool
+	}{
+		{"empty", PacketFilter{}, true},
+		{"time range", PacketFilter{From: now.Add(-time.Second), To: now.Add(time.Second)}, true},
+		{"before from", PacketFilter{From: now.Add(time.Second)}, false},
+		{"to excluded", PacketFilter{To: now}, false},
+		{"destination network", PacketFilter{DestinationIPs: []*net.IPNet{network}}, true},
+		{"source network", PacketFilter{SourceIPs: []*net.IPNet{network}}, false},
+		{"either side", PacketFilter{IPs: []*net.IPNet{network}}, true},
+		{"port range", PacketFilter{Ports: []PortRange{{400, 500}}}, true},
+		{"source port", PacketFilter{SourcePorts: []PortRange{{400, 500}}}, false},
+		{"protocol", PacketFilter{Protocols: []string{ProtocolUDP}}, false},
+		{"device", PacketFilter{Devices: []string{"eth0", "wlan0"}}, true},
+		{"direction", PacketFilter{Direction: DirectionIn}, false},
+		{"tag", PacketFilter{Tag: "x"}, false},
+		{"display filter", PacketFilter{Query: query}, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if matched := tc.filter.Matches(packet); matched != tc.expected {
+				t.Errorf("expected %t, got %t", tc.expected, matched)
+			}
+		})
+	}
+}
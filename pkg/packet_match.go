@@ -0,0 +1,211 @@
+package pkg
+
+import (
+	"cmp"
+	"net"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Matches tells whether a packet that is not stored yet passes the filter.
+// Limit, order and cursor do not apply.
+func (f PacketFilter) Matches(packet *SavedPacket) bool {
+	if !f.From.IsZero() && packet.CreatedAt.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !packet.CreatedAt.Before(f.To) {
+		return false
+	}
+	if len(f.SourceIPs) > 0 && !containsIP(f.SourceIPs, packet.SourceIP) {
+		return false
+	}
+	if len(f.DestinationIPs) > 0 && !containsIP(f.DestinationIPs, packet.DestinationIP) {
+		return false
+	}
+	if len(f.IPs) > 0 && !containsIP(f.IPs, packet.SourceIP) && !containsIP(f.IPs, packet.DestinationIP) {
+		return false
+	}
+	if len(f.SourcePorts) > 0 && !inPortRanges(f.SourcePorts, packet.SourcePort) {
+		return false
+	}
+	if len(f.DestinationPorts) > 0 && !inPortRanges(f.DestinationPorts, packet.DestinationPort) {
+		return false
+	}
+	if len(f.Ports) > 0 && !inPortRanges(f.Ports, packet.SourcePort) && !inPortRanges(f.Ports, packet.DestinationPort) {
+		return false
+	}
+	if len(f.Protocols) > 0 && !slices.Contains(f.Protocols, packet.Protocol) {
+		return false
+	}
+	if len(f.Devices) > 0 && !slices.Contains(f.Devices, packet.DeviceID) {
+		return false
+	}
+	if f.Direction != "" && packet.Direction != f.Direction {
+		return false
+	}
+	if f.Tag != "" && !slices.Contains(packet.Tags, f.Tag) {
+		return false
+	}
+	return f.Query == nil || f.Query.Matches(packet)
+}
+
+func containsIP(nets []*net.IPNet, address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+	for _, network := range nets {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func inPortRanges(ranges []PortRange, port *int) bool {
+	if port == nil {
+		return false
+	}
+	for _, r := range ranges {
+		if *port >= r.Low && *port <= r.High {
+			return true
+		}
+	}
+	return false
+}
+
+// packetColumns reads the columns display filters compare, nil stands for
+// NULL.
+var packetColumns = map[string]func(p *SavedPacket) any{
+	"created_at":         func(p *SavedPacket) any { return p.CreatedAt },
+	"device_id":          func(p *SavedPacket) any { return p.DeviceID },
+	"direction":          func(p *SavedPacket) any { return p.Direction },
+	"protocol":           func(p *SavedPacket) any { return p.Protocol },
+	"source_ip":          func(p *SavedPacket) any { return p.SourceIP },
+	"destination_ip":     func(p *SavedPacket) any { return p.DestinationIP },
+	"source_ip_key":      func(p *SavedPacket) any { return p.SourceIPKey },
+	"destination_ip_key": func(p *SavedPacket) any { return p.DestinationIPKey },
+	"source_mac":         func(p *SavedPacket) any { return p.SourceMAC },
+	"destination_mac":    func(p *SavedPacket) any { return p.DestinationMAC },
+	"source_port":        func(p *SavedPacket) any { return nullable(p.SourcePort) },
+	"destination_port":   func(p *SavedPacket) any { return nullable(p.DestinationPort) },
+	"ether_type":         func(p *SavedPacket) any { return nullable(p.EtherType) },
+	"vlan_id":            func(p *SavedPacket) any { return nullable(p.VLANID) },
+	"ip_version":         func(p *SavedPacket) any { return nullable(p.IPVersion) },
+	"ttl":                func(p *SavedPacket) any { return nullable(p.TTL) },
+	"dscp":               func(p *SavedPacket) any { return nullable(p.DSCP) },
+	"ecn":                func(p *SavedPacket) any { return nullable(p.ECN) },
+	"ip_id":              func(p *SavedPacket) any { return nullable(p.IPID) },
+	"dont_fragment":      func(p *SavedPacket) any { return nullable(p.DontFragment) },
+	"more_fragments":     func(p *SavedPacket) any { return nullable(p.MoreFragments) },
+	"fragment_offset":    func(p *SavedPacket) any { return nullable(p.FragmentOffset) },
+	"total_length":       func(p *SavedPacket) any { return nullable(p.TotalLength) },
+	"captured_length":    func(p *SavedPacket) any { return p.CapturedLength },
+	"frame_length":       func(p *SavedPacket) any { return p.FrameLength },
+	"tcp_flag_syn":       func(p *SavedPacket) any { return nullable(p.TCPFlagSYN) },
+	"tcp_flag_ack":       func(p *SavedPacket) any { return nullable(p.TCPFlagACK) },
+	"tcp_flag_fin":       func(p *SavedPacket) any { return nullable(p.TCPFlagFIN) },
+	"tcp_flag_rst":       func(p *SavedPacket) any { return nullable(p.TCPFlagRST) },
+	"tcp_flag_psh":       func(p *SavedPacket) any { return nullable(p.TCPFlagPSH) },
+	"tcp_flag_urg":       func(p *SavedPacket) any { return nullable(p.TCPFlagURG) },
+	"tcp_flag_ece":       func(p *SavedPacket) any { return nullable(p.TCPFlagECE) },
+	"tcp_flag_cwr":       func(p *SavedPacket) any { return nullable(p.TCPFlagCWR) },
+	"tcp_seq":            func(p *SavedPacket) any { return nullable(p.TCPSeq) },
+	"tcp_ack":            func(p *SavedPacket) any { return nullable(p.TCPAck) },
+	"tcp_window":         func(p *SavedPacket) any { return nullable(p.TCPWindow) },
+	"tcp_mss":            func(p *SavedPacket) any { return nullable(p.TCPMSS) },
+	"tcp_window_scale":   func(p *SavedPacket) any { return nullable(p.TCPWindowScale) },
+	"icmp_type":          func(p *SavedPacket) any { return nullable(p.ICMPType) },
+	"icmp_code":          func(p *SavedPacket) any { return nullable(p.ICMPCode) },
+	"icmp_echo_id":       func(p *SavedPacket) any { return nullable(p.ICMPEchoID) },
+	"icmp_echo_seq":      func(p *SavedPacket) any { return nullable(p.ICMPEchoSeq) },
+}
+
+func nullable[T any](value *T) any {
+	if value == nil {
+		return nil
+	}
+	return *value
+}
+
+func packetColumn(packet *SavedPacket, column string) any {
+	read, ok := packetColumns[column]
+	if !ok {
+		return nil
+	}
+	return read(packet)
+}
+
+// compareColumn applies a compare operator to a column value the way
+// sqlite does.
+func compareColumn(value any, operator string, args []any) bool {
+	switch operator {
+	case "IN":
+		values, _ := args[0].([]string)
+		text, ok := value.(string)
+		return ok && slices.Contains(values, text)
+	case "BETWEEN":
+		low, lowOK := compareValues(value, args[0])
+		high, highOK := compareValues(value, args[1])
+		return lowOK && highOK && low >= 0 && high <= 0
+	}
+	c, ok := compareValues(value, args[0])
+	if !ok {
+		return false
+	}
+	switch operator {
+	case "=":
+		return c == 0
+	case "<>":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	}
+	return false
+}
+
+func compareValues(a, b any) (int, bool) {
+	switch a := normalizeValue(a).(type) {
+	case int64:
+		b, ok := normalizeValue(b).(int64)
+		if !ok {
+			return 0, false
+		}
+		return cmp.Compare(a, b), true
+	case string:
+		b, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(a, b), true
+	case time.Time:
+		b, ok := b.(time.Time)
+		if !ok {
+			return 0, false
+		}
+		return a.Compare(b), true
+	}
+	return 0, false
+}
+
+// normalizeValue turns the integers and booleans of a packet into int64,
+// sqlite stores booleans as 0 and 1.
+func normalizeValue(value any) any {
+	switch v := value.(type) {
+	case int:
+		return int64(v)
+	case bool:
+		if v {
+			return int64(1)
+		}
+		return int64(0)
+	}
+	return value
+}
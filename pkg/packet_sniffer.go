@@ -1,6 +1,8 @@
 package pkg
 
 import (
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net"
@@ -24,6 +26,23 @@ type Device struct {
 	Description string
 	MAC         net.HardwareAddr
 	Addresses   []Addrs
+	Up          bool
+	Running     bool
+	Loopback    bool
+	Options     CaptureOptions
+
+	// linkType is the link type of the opened capture, copied into every
+	// packet pushed to the queue.
+	linkType layers.LinkType
+}
+
+// CaptureOptions are the libpcap settings a device is opened with. A zero
+// SnapLen falls back to SNAPSHOTLENGTH and an empty Filter captures every
+// packet.
+type CaptureOptions struct {
+	Filter      string
+	SnapLen     int32
+	Promiscuous bool
 }
 
 type Addrs struct {
@@ -38,7 +57,6 @@ const (
 )
 
 var outputfile = "output.pcap"
-var packetfilter = "tcp"
 
 var PacketsToCaptureQueue = PacketQueue{
 	ItemsChan: make(chan AppPacket),
@@ -46,7 +64,13 @@ var PacketsToCaptureQueue = PacketQueue{
 
 type packetStream struct {
 	packets <-chan gopacket.Packet
-	cleanup func()
+	// stop makes the source end so that packets gets closed.
+	stop     func()
+	cleanup  func()
+	linkType layers.LinkType
+	// setFilter replaces the BPF filter of a running stream, nil when the
+	// source cannot be filtered.
+	setFilter func(string) error
 }
 
 var packetStreamFactory = defaultPacketStreamFactory
@@ -60,6 +84,7 @@ type liveCapture interface {
 
 type pcapFileWriter interface {
 	WriteFileHeader(snaplen uint32, linkType layers.LinkType) error
+	WritePacket(ci gopacket.CaptureInfo, data []byte) error
 }
 
 var (
@@ -72,66 +97,150 @@ var (
 	newPcapWriter = func(w io.Writer) pcapFileWriter {
 		return pcapgo.NewWriter(w)
 	}
+	listInterfaces  = net.Interfaces
+	interfaceByName = net.InterfaceByName
 )
 
+const anyDevice = "any"
+
+func captureOptionsFromConfig(c config.DeviceConfig) CaptureOptions {
+	return CaptureOptions{
+		Filter:      c.Filter,
+		SnapLen:     int32(c.SnapLen),
+		Promiscuous: c.Promiscuous,
+	}
+}
+
+// NewDevices builds one device per configured interface. The "any" entry
+// expands to every interface that is up, unless it is configured explicitly.
+func NewDevices(configs []config.DeviceConfig) (Devices, error) {
+	var devices []Device
+	seen := make(map[string]bool)
+	var anyConfig *config.DeviceConfig
+	for i, c := range configs {
+		if c.Name == anyDevice {
+			anyConfig = &configs[i]
+			continue
+		}
+		if seen[c.Name] {
+			continue
+		}
+		seen[c.Name] = true
+		devices = append(devices, Device{Name: c.Name, Options: captureOptionsFromConfig(c)})
+	}
+	if anyConfig != nil {
+		ifaces, err := listInterfaces()
+		if err != nil {
+			return Devices{}, err
+		}
+		for _, iface := range ifaces {
+			if iface.Flags&net.FlagUp == 0 || seen[iface.Name] {
+				continue
+			}
+			seen[iface.Name] = true
+			devices = append(devices, Device{
+				ID:      iface.Index,
+				Name:    iface.Name,
+				MAC:     iface.HardwareAddr,
+				Options: captureOptionsFromConfig(*anyConfig),
+			})
+		}
+	}
+	if len(devices) == 0 {
+		return Devices{}, errors.New("no capture device configured")
+	}
+	return Devices{devices: devices}, nil
+}
+
+func (ds Devices) List() []Device {
+	return ds.devices
+}
+
 // Start capturing packets
 func (d *Device) Start() {
-	stream, err := packetStreamFactory(d)
-	if err != nil {
+	if err := d.startWith(packetStreamFactory); err != nil {
 		log.Fatal(err)
 	}
-	if stream.cleanup != nil {
-		defer stream.cleanup()
-	}
+}
 
-	d.processPackets(stream.packets)
+func (d *Device) startWith(factory func(*Device) (packetStream, error)) error {
+	capture, err := openCapture(*d, CaptureLimits{}, factory)
+	if err != nil {
+		return err
+	}
+	capture.Run()
+	return nil
 }
 
 func (d *Device) processPackets(packets <-chan gopacket.Packet) {
 	for packet := range packets {
-		log.Println("Pushing packet to queue")
-		PacketsToCaptureQueue.Push(AppPacket{
-			Data:      packet,
-			CreatedAt: time.Now(),
-			UpdatedAt: time.Now(),
-			DeviceID:  d.Name,
-		})
+		d.pushPacket(packet)
 	}
 }
 
+func (d *Device) pushPacket(packet gopacket.Packet) {
+	log.Println("Pushing packet to queue")
+	createdAt := packet.Metadata().Timestamp
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+	PacketsToCaptureQueue.Push(AppPacket{
+		Data:      packet,
+		CreatedAt: createdAt,
+		UpdatedAt: time.Now(),
+		DeviceID:  d.Name,
+		LinkType:  d.linkType,
+		Direction: packetDirection(packet, d.MAC),
+	})
+}
+
 func defaultPacketStreamFactory(d *Device) (packetStream, error) {
 	var cleanups []func()
+	snaplen := d.Options.SnapLen
+	if snaplen <= 0 {
+		snaplen = SNAPSHOTLENGTH
+	}
+	filter := d.Options.Filter
+
+	var writer *rollingPcapWriter
 	if outputfile != "" {
-		f, err := createOutputFile(outputfile)
+		w, err := newRollingPcapWriter(outputfile, d.Name, pcapRotation)
 		if err != nil {
 			return packetStream{}, err
 		}
-		writer := newPcapWriter(f)
-		if err := writer.WriteFileHeader(uint32(SNAPSHOTLENGTH), layers.LinkTypeEthernet); err != nil {
-			f.Close()
-			return packetStream{}, err
-		}
-		cleanups = append(cleanups, func() { f.Close() })
+		writer = w
+		cleanups = append(cleanups, func() { writer.Close() })
 	}
 
-	handler, err := openLiveCapture(d.Name, SNAPSHOTLENGTH, PROMISCUOUS, TIMEOUT)
+	handler, err := openLiveCapture(d.Name, snaplen, d.Options.Promiscuous, TIMEOUT)
 	if err != nil {
 		runCleanups(cleanups)
 		return packetStream{}, err
 	}
 	cleanups = append(cleanups, handler.Close)
 
-	if packetfilter != "" {
-		if err := handler.SetBPFFilter(packetfilter); err != nil {
+	if filter != "" {
+		if err := handler.SetBPFFilter(filter); err != nil {
 			runCleanups(cleanups)
-			return packetStream{}, err
+			return packetStream{}, fmt.Errorf("%w: %v", ErrInvalidFilter, err)
 		}
 	}
 
 	source := gopacket.NewPacketSource(handler, handler.LinkType())
+	var packets <-chan gopacket.Packet = source.Packets()
+	if writer != nil {
+		if err := writer.WriteFileHeader(uint32(snaplen), handler.LinkType()); err != nil {
+			runCleanups(cleanups)
+			return packetStream{}, err
+		}
+		packets = writePacketsTo(packets, writer)
+	}
 	return packetStream{
-		packets: source.Packets(),
-		cleanup: func() { runCleanups(cleanups) },
+		packets:   packets,
+		stop:      handler.Close,
+		cleanup:   func() { runCleanups(cleanups) },
+		linkType:  handler.LinkType(),
+		setFilter: handler.SetBPFFilter,
 	}, nil
 }
 
@@ -143,9 +252,20 @@ func runCleanups(cleanups []func()) {
 	}
 }
 
-func CreateNewDeviceAndStartSniffing(appconfig *config.AppConfig) {
-	device := Device{
-		Name: appconfig.DeviceName,
+// ConfigureCapture applies the pcap output and replay settings of
+// appconfig to every capture opened afterwards.
+func ConfigureCapture(appconfig *config.AppConfig) {
+	replaySpeed = appconfig.ReplaySpeed
+	if appconfig.PcapOutput == "none" {
+		outputfile = ""
+		return
+	}
+	if appconfig.PcapOutput != "" {
+		outputfile = appconfig.PcapOutput
+	}
+	pcapRotation = PcapRotation{
+		MaxFileSize:    int64(appconfig.PcapMaxFileSizeMB) << 20,
+		RotateInterval: appconfig.PcapRotateInterval,
+		MaxFiles:       appconfig.PcapMaxFiles,
 	}
-	go device.Start()
 }
@@ -4,11 +4,13 @@ import (
 	"bytes"
 	"errors"
 	"io"
+	"net"
 	"testing"
 	"time"
 
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
+	"github.com/impact-dryer/gotattletale/internal/config"
 )
 
 func TestDeviceProcessPacketsPushesToQueue(t *testing.T) {
@@ -83,17 +85,14 @@ func TestDefaultPacketStreamFactory(t *testing.T) {
 	originalCreate := createOutputFile
 	originalWriter := newPcapWriter
 	originalOutput := outputfile
-	originalFilter := packetfilter
 	defer func() {
 		openLiveCapture = originalOpen
 		createOutputFile = originalCreate
 		newPcapWriter = originalWriter
 		outputfile = originalOutput
-		packetfilter = originalFilter
 	}()
 
 	outputfile = "capture.pcap"
-	packetfilter = "udp"
 
 	writer := &stubPcapWriter{}
 	newPcapWriter = func(io.Writer) pcapFileWriter { return writer }
@@ -108,7 +107,7 @@ func TestDefaultPacketStreamFactory(t *testing.T) {
 		return capture, nil
 	}
 
-	stream, err := defaultPacketStreamFactory(&Device{Name: "eth-test"})
+	stream, err := defaultPacketStreamFactory(&Device{Name: "eth-test", Options: CaptureOptions{Filter: "udp"}})
 	if err != nil {
 		t.Fatalf("defaultPacketStreamFactory returned error: %v", err)
 	}
@@ -121,6 +120,8 @@ func TestDefaultPacketStreamFactory(t *testing.T) {
 	case <-time.After(time.Second):
 		t.Fatal("timed out waiting for packet")
 	}
+	for range stream.packets {
+	}
 
 	stream.cleanup()
 	if !capture.closed {
@@ -135,6 +136,12 @@ func TestDefaultPacketStreamFactory(t *testing.T) {
 	if capture.filter != "udp" {
 		t.Fatalf("expected filter udp, got %s", capture.filter)
 	}
+	if len(writer.packets) != 1 {
+		t.Fatalf("expected 1 packet written to pcap file, got %d", len(writer.packets))
+	}
+	if writer.packets[0].CaptureLength != len(packet.Data()) {
+		t.Fatalf("expected capture length %d, got %d", len(packet.Data()), writer.packets[0].CaptureLength)
+	}
 }
 
 func TestDefaultPacketStreamFactoryCreateFileError(t *testing.T) {
@@ -185,13 +192,13 @@ func TestDefaultPacketStreamFactoryOpenLiveErrorRunsCleanup(t *testing.T) {
 
 func TestDefaultPacketStreamFactoryFilterError(t *testing.T) {
 	originalOpen := openLiveCapture
-	originalFilter := packetfilter
+	originalCreate := createOutputFile
 	defer func() {
 		openLiveCapture = originalOpen
-		packetfilter = originalFilter
+		createOutputFile = originalCreate
 	}()
 
-	packetfilter = "tcp"
+	createOutputFile = func(string) (io.WriteCloser, error) { return &stubWriteCloser{}, nil }
 	capture := &fakeCapture{
 		setFilterErr: errors.New("filter failed"),
 	}
@@ -199,14 +206,130 @@ func TestDefaultPacketStreamFactoryFilterError(t *testing.T) {
 		return capture, nil
 	}
 
-	if _, err := defaultPacketStreamFactory(&Device{}); err == nil {
-		t.Fatal("expected error when filter setup fails")
+	_, err := defaultPacketStreamFactory(&Device{Options: CaptureOptions{Filter: "tcp"}})
+	if !errors.Is(err, ErrInvalidFilter) {
+		t.Fatalf("expected ErrInvalidFilter when filter setup fails, got %v", err)
 	}
 	if !capture.closed {
 		t.Fatal("expected capture closed after filter failure")
 	}
 }
 
+func TestDefaultPacketStreamFactoryUsesDeviceOptions(t *testing.T) {
+	originalOpen := openLiveCapture
+	originalOutput := outputfile
+	defer func() {
+		openLiveCapture = originalOpen
+		outputfile = originalOutput
+	}()
+
+	outputfile = ""
+	capture := &fakeCapture{}
+	var gotSnaplen int32
+	var gotPromisc bool
+	openLiveCapture = func(device string, snaplen int32, promisc bool, timeout time.Duration) (liveCapture, error) {
+		gotSnaplen = snaplen
+		gotPromisc = promisc
+		return capture, nil
+	}
+
+	dev := &Device{Name: "eth1", Options: CaptureOptions{Filter: "udp port 53", SnapLen: 128, Promiscuous: true}}
+	stream, err := defaultPacketStreamFactory(dev)
+	if err != nil {
+		t.Fatalf("defaultPacketStreamFactory returned error: %v", err)
+	}
+	stream.cleanup()
+
+	if gotSnaplen != 128 {
+		t.Fatalf("expected snaplen 128, got %d", gotSnaplen)
+	}
+	if !gotPromisc {
+		t.Fatal("expected promiscuous mode")
+	}
+	if capture.filter != "udp port 53" {
+		t.Fatalf("expected device filter, got %s", capture.filter)
+	}
+}
+
+func TestDefaultPacketStreamFactoryWithoutFilter(t *testing.T) {
+	originalOpen := openLiveCapture
+	originalOutput := outputfile
+	defer func() {
+		openLiveCapture = originalOpen
+		outputfile = originalOutput
+	}()
+
+	outputfile = ""
+	capture := &fakeCapture{}
+	openLiveCapture = func(string, int32, bool, time.Duration) (liveCapture, error) {
+		return capture, nil
+	}
+
+	stream, err := defaultPacketStreamFactory(&Device{Name: "eth1"})
+	if err != nil {
+		t.Fatalf("defaultPacketStreamFactory returned error: %v", err)
+	}
+	if capture.filterSet {
+		t.Fatal("expected no filter to be set")
+	}
+	if err := stream.setFilter("icmp"); err != nil {
+		t.Fatalf("setFilter returned error: %v", err)
+	}
+	if capture.filter != "icmp" {
+		t.Fatalf("expected filter icmp on the running capture, got %s", capture.filter)
+	}
+	stream.cleanup()
+}
+
+func TestNewDevices(t *testing.T) {
+	originalList := listInterfaces
+	defer func() { listInterfaces = originalList }()
+	listInterfaces = func() ([]net.Interface, error) {
+		return []net.Interface{
+			{Index: 1, Name: "lo", Flags: net.FlagUp | net.FlagLoopback},
+			{Index: 2, Name: "eth0", Flags: net.FlagUp},
+			{Index: 3, Name: "eth1"},
+			{Index: 4, Name: "br0", Flags: net.FlagUp},
+		}, nil
+	}
+
+	devices, err := NewDevices([]config.DeviceConfig{
+		{Name: "eth0", Filter: "tcp", SnapLen: 96, Promiscuous: true},
+		{Name: "any", Filter: "arp", SnapLen: 256},
+		{Name: "eth0", Filter: "ignored"},
+	})
+	if err != nil {
+		t.Fatalf("NewDevices returned error: %v", err)
+	}
+
+	list := devices.List()
+	names := make([]string, len(list))
+	for i, d := range list {
+		names[i] = d.Name
+	}
+	want := []string{"eth0", "lo", "br0"}
+	if len(names) != len(want) {
+		t.Fatalf("expected devices %v, got %v", want, names)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("expected devices %v, got %v", want, names)
+		}
+	}
+	if list[0].Options.Filter != "tcp" || list[0].Options.SnapLen != 96 || !list[0].Options.Promiscuous {
+		t.Fatalf("unexpected options for eth0: %+v", list[0].Options)
+	}
+	if list[2].Options.Filter != "arp" || list[2].Options.SnapLen != 256 || list[2].ID != 4 {
+		t.Fatalf("unexpected any-expanded device: %+v", list[2])
+	}
+}
+
+func TestNewDevicesRequiresADevice(t *testing.T) {
+	if _, err := NewDevices(nil); err == nil {
+		t.Fatal("expected error without configured devices")
+	}
+}
+
 func TestRunCleanups(t *testing.T) {
 	var order []int
 	runCleanups([]func(){
@@ -222,6 +345,7 @@ func TestRunCleanups(t *testing.T) {
 type fakeCapture struct {
 	packets      [][]byte
 	filter       string
+	filterSet    bool
 	closed       bool
 	setFilterErr error
 }
@@ -245,6 +369,7 @@ func (f *fakeCapture) LinkType() layers.LinkType {
 
 func (f *fakeCapture) SetBPFFilter(filter string) error {
 	f.filter = filter
+	f.filterSet = true
 	return f.setFilterErr
 }
 
@@ -260,6 +385,7 @@ func (s *stubWriteCloser) Close() error {
 
 type stubPcapWriter struct {
 	headerWritten bool
+	packets       []gopacket.CaptureInfo
 	err           error
 }
 
@@ -268,6 +394,11 @@ func (s *stubPcapWriter) WriteFileHeader(uint32, layers.LinkType) error {
 	return s.err
 }
 
+func (s *stubPcapWriter) WritePacket(ci gopacket.CaptureInfo, data []byte) error {
+	s.packets = append(s.packets, ci)
+	return s.err
+}
+
 func mustBuildPacket(t *testing.T, srcIP, dstIP string, srcPort, dstPort int) gopacket.Packet {
 	t.Helper()
 
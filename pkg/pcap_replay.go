@@ -0,0 +1,181 @@
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+var pcapngMagic = []byte{0x0a, 0x0d, 0x0d, 0x0a}
+
+// replaySpeed controls how fast capture files are pushed through the
+// pipeline: 0 replays as fast as possible, 1 in real time and any other
+// positive value accelerates or slows down the original timing.
+var replaySpeed float64
+
+var (
+	openCaptureFile = func(name string) (io.ReadCloser, error) {
+		return os.Open(name)
+	}
+	sleep = func(d time.Duration, stop <-chan struct{}) {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-stop:
+		}
+	}
+)
+
+// stoppableReader reports io.EOF once stopped, which gopacket's packet
+// source treats as the end of the capture.
+type stoppableReader struct {
+	r       io.Reader
+	stopped atomic.Bool
+}
+
+func (s *stoppableReader) Read(p []byte) (int, error) {
+	if s.stopped.Load() {
+		return 0, io.EOF
+	}
+	return s.r.Read(p)
+}
+
+type offlineCapture interface {
+	gopacket.PacketDataSource
+	LinkType() layers.LinkType
+}
+
+func newOfflineCapture(r io.Reader) (offlineCapture, error) {
+	buffered := bufio.NewReader(r)
+	magic, err := buffered.Peek(len(pcapngMagic))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(magic, pcapngMagic) {
+		return pcapgo.NewNgReader(buffered, pcapgo.DefaultNgReaderOptions)
+	}
+	return pcapgo.NewReader(buffered)
+}
+
+// offlinePacketStreamFactory reads the pcap or pcapng file named by the
+// device instead of opening a live interface.
+func offlinePacketStreamFactory(d *Device) (packetStream, error) {
+	f, err := openCaptureFile(d.Name)
+	if err != nil {
+		return packetStream{}, err
+	}
+	reader := &stoppableReader{r: f}
+	capture, err := newOfflineCapture(reader)
+	if err != nil {
+		f.Close()
+		return packetStream{}, fmt.Errorf("%s: %w", d.Name, err)
+	}
+
+	stopped := make(chan struct{})
+	var once sync.Once
+	source := gopacket.NewPacketSource(capture, capture.LinkType())
+	return packetStream{
+		packets: pacePackets(source.Packets(), replaySpeed, stopped),
+		stop: func() {
+			once.Do(func() {
+				reader.stopped.Store(true)
+				close(stopped)
+			})
+		},
+		cleanup:  func() { f.Close() },
+		linkType: capture.LinkType(),
+	}, nil
+}
+
+// pacePackets delays packets so that the gaps between their capture
+// timestamps are reproduced, divided by speed. Closing stop cancels the
+// remaining delays.
+func pacePackets(packets <-chan gopacket.Packet, speed float64, stop <-chan struct{}) <-chan gopacket.Packet {
+	if speed <= 0 {
+		return packets
+	}
+	out := make(chan gopacket.Packet)
+	go func() {
+		defer close(out)
+		var first time.Time
+		var started time.Time
+		for packet := range packets {
+			ts := packet.Metadata().Timestamp
+			if first.IsZero() {
+				first = ts
+				started = time.Now()
+			} else if ts.After(first) {
+				target := started.Add(time.Duration(float64(ts.Sub(first)) / speed))
+				if wait := time.Until(target); wait > 0 {
+					sleep(wait, stop)
+				}
+			}
+			out <- packet
+		}
+	}()
+	return out
+}
+
+// ExpandCaptureFiles resolves a capture file, a directory or a glob into
+// the sorted list of capture files it refers to.
+func ExpandCaptureFiles(pattern string) ([]string, error) {
+	info, err := os.Stat(pattern)
+	if err == nil && info.IsDir() {
+		var files []string
+		for _, ext := range []string{"*.pcap", "*.pcapng", "*.cap"} {
+			matches, err := filepath.Glob(filepath.Join(pattern, ext))
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, matches...)
+		}
+		sort.Strings(files)
+		return files, nil
+	}
+	if err == nil {
+		return []string{pattern}, nil
+	}
+	if !strings.ContainsAny(pattern, "*?[") {
+		return nil, err
+	}
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// ReplayCaptureFiles pushes every packet of the matching capture files
+// through the pipeline, one file after the other.
+func ReplayCaptureFiles(pattern string) error {
+	files, err := ExpandCaptureFiles(pattern)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no capture files match %s", pattern)
+	}
+	for _, file := range files {
+		log.Println("Replaying capture file", file)
+		device := Device{Name: file}
+		if err := device.startWith(offlinePacketStreamFactory); err != nil {
+			log.Println("Failed to replay capture file:", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+var replayBaseTime = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func writeTestPcap(t *testing.T, path string, packets ...gopacket.Packet) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("failed to create pcap: %v", err)
+	}
+	defer f.Close()
+	w := pcapgo.NewWriterNanos(f)
+	if err := w.WriteFileHeader(SNAPSHOTLENGTH, layers.LinkTypeRaw); err != nil {
+		t.Fatalf("failed to write header: %v", err)
+	}
+	for i, packet := range packets {
+		ci := gopacket.CaptureInfo{
+			Timestamp:     replayBaseTime.Add(time.Duration(i) * time.Second),
+			CaptureLength: len(packet.Data()),
+			Length:        len(packet.Data()),
+		}
+		if err := w.WritePacket(ci, packet.Data()); err != nil {
+			t.Fatalf("failed to write packet: %v", err)
+		}
+	}
+}
+
+func writeTestPcapng(t *testing.T, path string, packets ...gopacket.Packet) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("failed to create pcapng: %v", err)
+	}
+	defer f.Close()
+	w, err := pcapgo.NewNgWriter(f, layers.LinkTypeRaw)
+	if err != nil {
+		t.Fatalf("failed to create pcapng writer: %v", err)
+	}
+	for i, packet := range packets {
+		ci := gopacket.CaptureInfo{
+			Timestamp:     replayBaseTime.Add(time.Duration(i) * time.Second),
+			CaptureLength: len(packet.Data()),
+			Length:        len(packet.Data()),
+		}
+		if err := w.WritePacket(ci, packet.Data()); err != nil {
+			t.Fatalf("failed to write packet: %v", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("failed to flush pcapng: %v", err)
+	}
+}
+
+func TestOfflinePacketStreamFactoryReadsPcapAndPcapng(t *testing.T) {
+	dir := t.TempDir()
+	packet := mustBuildPacket(t, "10.0.0.1", "10.0.0.2", 1234, 80)
+	writeTestPcap(t, filepath.Join(dir, "a.pcap"), packet, packet)
+	writeTestPcapng(t, filepath.Join(dir, "b.pcapng"), packet, packet)
+
+	for _, name := range []string{"a.pcap", "b.pcapng"} {
+		t.Run(name, func(t *testing.T) {
+			stream, err := offlinePacketStreamFactory(&Device{Name: filepath.Join(dir, name)})
+			if err != nil {
+				t.Fatalf("offlinePacketStreamFactory returned error: %v", err)
+			}
+			defer stream.cleanup()
+
+			var got []gopacket.Packet
+			for p := range stream.packets {
+				got = append(got, p)
+			}
+			if len(got) != 2 {
+				t.Fatalf("expected 2 packets, got %d", len(got))
+			}
+			if !got[1].Metadata().Timestamp.Equal(replayBaseTime.Add(time.Second)) {
+				t.Fatalf("expected original timestamp, got %v", got[1].Metadata().Timestamp)
+			}
+			if got[0].NetworkLayer() == nil {
+				t.Fatal("expected decoded network layer")
+			}
+		})
+	}
+}
+
+func TestOfflinePacketStreamFactoryRejectsGarbage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bad.pcap")
+	if err := os.WriteFile(path, []byte("not a capture file"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	if _, err := offlinePacketStreamFactory(&Device{Name: path}); err == nil {
+		t.Fatal("expected error for invalid capture file")
+	}
+}
+
+func TestReplayCaptureFilesTagsPacketsWithSourceFile(t *testing.T) {
+	originalQueue := PacketsToCaptureQueue
+	defer func() { PacketsToCaptureQueue = originalQueue }()
+	PacketsToCaptureQueue = PacketQueue{ItemsChan: make(chan AppPacket, 10)}
+
+	dir := t.TempDir()
+	packet := mustBuildPacket(t, "10.0.0.1", "10.0.0.2", 1234, 80)
+	first := filepath.Join(dir, "1.pcap")
+	second := filepath.Join(dir, "2.pcapng")
+	writeTestPcap(t, first, packet)
+	writeTestPcapng(t, second, packet, packet)
+
+	if err := ReplayCaptureFiles(filepath.Join(dir, "*")); err != nil {
+		t.Fatalf("ReplayCaptureFiles returned error: %v", err)
+	}
+	close(PacketsToCaptureQueue.ItemsChan)
+
+	var devices []string
+	for p := range PacketsToCaptureQueue.ItemsChan {
+		devices = append(devices, p.DeviceID)
+		if !p.CreatedAt.Equal(replayBaseTime) && !p.CreatedAt.Equal(replayBaseTime.Add(time.Second)) {
+			t.Fatalf("expected capture timestamp as CreatedAt, got %v", p.CreatedAt)
+		}
+	}
+	want := []string{first, second, second}
+	if len(devices) != len(want) {
+		t.Fatalf("expected %d packets, got %d", len(want), len(devices))
+	}
+	for i := range want {
+		if devices[i] != want[i] {
+			t.Fatalf("packet %d: expected device %s, got %s", i, want[i], devices[i])
+		}
+	}
+}
+
+func TestExpandCaptureFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"b.pcapng", "a.pcap", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+
+	files, err := ExpandCaptureFiles(dir)
+	if err != nil {
+		t.Fatalf("ExpandCaptureFiles returned error: %v", err)
+	}
+	if len(files) != 2 || filepath.Base(files[0]) != "a.pcap" || filepath.Base(files[1]) != "b.pcapng" {
+		t.Fatalf("unexpected files for directory: %v", files)
+	}
+
+	files, err = ExpandCaptureFiles(filepath.Join(dir, "*.pcap"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("unexpected result for glob: %v, %v", files, err)
+	}
+
+	if _, err := ExpandCaptureFiles(filepath.Join(dir, "missing.pcap")); err == nil {
+		t.Fatal("expected error for missing file")
+	}
+}
+
+func TestPacePacketsScalesGaps(t *testing.T) {
+	originalSleep := sleep
+	defer func() { sleep = originalSleep }()
+	var waits []time.Duration
+	sleep = func(d time.Duration, stop <-chan struct{}) { waits = append(waits, d) }
+
+	packet := mustBuildPacket(t, "10.0.0.1", "10.0.0.2", 1234, 80)
+	in := make(chan gopacket.Packet, 2)
+	for i := 0; i < 2; i++ {
+		p := gopacket.NewPacket(packet.Data(), layers.LayerTypeIPv4, gopacket.Default)
+		p.Metadata().Timestamp = replayBaseTime.Add(time.Duration(i) * 10 * time.Second)
+		in <- p
+	}
+	close(in)
+
+	count := 0
+	for range pacePackets(in, 10, nil) {
+		count++
+	}
+	if count != 2 {
+		t.Fatalf("expected 2 packets, got %d", count)
+	}
+	if len(waits) != 1 || waits[0] > time.Second || waits[0] < 900*time.Millisecond {
+		t.Fatalf("expected a single wait of about 1s, got %v", waits)
+	}
+}
+
+func TestPacePacketsAsFastAsPossible(t *testing.T) {
+	in := make(chan gopacket.Packet)
+	if out := pacePackets(in, 0, nil); out != (<-chan gopacket.Packet)(in) {
+		t.Fatal("expected packets to pass through unchanged")
+	}
+}
@@ -0,0 +1,296 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// pcap global header and per-record header sizes, used to track file size.
+const (
+	pcapFileHeaderLen   = 24
+	pcapRecordHeaderLen = 16
+)
+
+const rotatedFileTimeFormat = "20060102T150405.000000"
+
+var ErrPcapFileNotFound = errors.New("pcap file not found")
+
+type PcapFile struct {
+	Name       string    `json:"name"`
+	Size       int64     `json:"size"`
+	ModifiedAt time.Time `json:"modified_at"`
+}
+
+// PcapRotation controls when the rolling writer starts a new file and how
+// many files it keeps. Zero values disable the corresponding limit.
+type PcapRotation struct {
+	MaxFileSize    int64
+	RotateInterval time.Duration
+	MaxFiles       int
+}
+
+var pcapRotation = PcapRotation{
+	MaxFileSize:    100 << 20,
+	RotateInterval: time.Hour,
+	MaxFiles:       10,
+}
+
+var (
+	removeOutputFile = os.Remove
+	globOutputFiles  = filepath.Glob
+	currentTime      = time.Now
+)
+
+// rollingPcapWriter writes captured packets into a sequence of pcap files
+// derived from a path template, one sequence per device.
+type rollingPcapWriter struct {
+	mu       sync.Mutex
+	path     string
+	device   string
+	rotation PcapRotation
+	snaplen  uint32
+	linkType layers.LinkType
+	header   bool
+	file     io.WriteCloser
+	writer   pcapFileWriter
+	size     int64
+	openedAt time.Time
+}
+
+func newRollingPcapWriter(path, device string, rotation PcapRotation) (*rollingPcapWriter, error) {
+	w := &rollingPcapWriter{
+		path:     path,
+		device:   device,
+		rotation: rotation,
+	}
+	if err := w.openFile(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// WriteFileHeader records the snaplen and link type used for every file of
+// the sequence and writes the header of the current file.
+func (w *rollingPcapWriter) WriteFileHeader(snaplen uint32, linkType layers.LinkType) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.snaplen = snaplen
+	w.linkType = linkType
+	w.header = true
+	return w.writeHeader()
+}
+
+func (w *rollingPcapWriter) WritePacket(ci gopacket.CaptureInfo, data []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil {
+		return errors.New("pcap writer is closed")
+	}
+	if ci.CaptureLength == 0 || ci.CaptureLength > len(data) {
+		ci.CaptureLength = len(data)
+	}
+	if ci.Length < ci.CaptureLength {
+		ci.Length = ci.CaptureLength
+	}
+	if w.shouldRotate(ci.CaptureLength) {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+	if err := w.writer.WritePacket(ci, data[:ci.CaptureLength]); err != nil {
+		return err
+	}
+	w.size += int64(pcapRecordHeaderLen + ci.CaptureLength)
+	return nil
+}
+
+func (w *rollingPcapWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	w.writer = nil
+	return err
+}
+
+func (w *rollingPcapWriter) shouldRotate(next int) bool {
+	if w.size <= pcapFileHeaderLen {
+		return false
+	}
+	if w.rotation.MaxFileSize > 0 && w.size+int64(pcapRecordHeaderLen+next) > w.rotation.MaxFileSize {
+		return true
+	}
+	return w.rotation.RotateInterval > 0 && currentTime().Sub(w.openedAt) >= w.rotation.RotateInterval
+}
+
+func (w *rollingPcapWriter) rotate() error {
+	if err := w.file.Close(); err != nil {
+		log.Println("Failed to close pcap file:", err)
+	}
+	w.file = nil
+	w.writer = nil
+	if err := w.openFile(); err != nil {
+		return err
+	}
+	return w.writeHeader()
+}
+
+func (w *rollingPcapWriter) openFile() error {
+	f, err := createOutputFile(rotatedFileName(w.path, w.device, currentTime()))
+	if err != nil {
+		return err
+	}
+	w.file = f
+	w.writer = newPcapWriter(f)
+	w.size = 0
+	w.openedAt = currentTime()
+	pruneRotatedFiles(w.path, w.device, w.rotation.MaxFiles)
+	return nil
+}
+
+func (w *rollingPcapWriter) writeHeader() error {
+	if !w.header || w.writer == nil {
+		return nil
+	}
+	if err := w.writer.WriteFileHeader(w.snaplen, w.linkType); err != nil {
+		return err
+	}
+	w.size = pcapFileHeaderLen
+	return nil
+}
+
+// writePacketsTo forwards every packet from packets after writing it to w.
+// The returned channel is closed once packets is drained, so callers that
+// range over it know every packet has been written.
+func writePacketsTo(packets <-chan gopacket.Packet, w *rollingPcapWriter) <-chan gopacket.Packet {
+	out := make(chan gopacket.Packet)
+	go func() {
+		defer close(out)
+		for packet := range packets {
+			if err := w.WritePacket(packet.Metadata().CaptureInfo, packet.Data()); err != nil {
+				log.Println("Failed to write packet to pcap file:", err)
+			}
+			out <- packet
+		}
+	}()
+	return out
+}
+
+func splitOutputPath(path string) (string, string) {
+	ext := filepath.Ext(path)
+	if ext == "" {
+		ext = ".pcap"
+	}
+	return strings.TrimSuffix(path, filepath.Ext(path)), ext
+}
+
+func sanitizeDeviceName(device string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '.':
+			return r
+		}
+		return '_'
+	}, device)
+}
+
+func rotatedFilePrefix(path, device string) string {
+	stem, _ := splitOutputPath(path)
+	if device == "" {
+		return stem + "-"
+	}
+	return stem + "-" + sanitizeDeviceName(device) + "-"
+}
+
+func rotatedFileName(path, device string, t time.Time) string {
+	_, ext := splitOutputPath(path)
+	return rotatedFilePrefix(path, device) + t.UTC().Format(rotatedFileTimeFormat) + ext
+}
+
+func pruneRotatedFiles(path, device string, keep int) {
+	if keep <= 0 {
+		return
+	}
+	_, ext := splitOutputPath(path)
+	prefix := rotatedFilePrefix(path, device)
+	matches, err := globOutputFiles(prefix + "*" + ext)
+	if err != nil {
+		log.Println("Failed to list pcap files:", err)
+		return
+	}
+	// The glob also matches the files of the devices whose name starts
+	// with this one's, keep those whose suffix is only a timestamp.
+	files := make([]string, 0, len(matches))
+	for _, match := range matches {
+		suffix := strings.TrimSuffix(strings.TrimPrefix(match, prefix), ext)
+		if _, err := time.Parse(rotatedFileTimeFormat, suffix); err == nil {
+			files = append(files, match)
+		}
+	}
+	sort.Strings(files)
+	for len(files) > keep {
+		if err := removeOutputFile(files[0]); err != nil {
+			log.Println("Failed to remove old pcap file:", err)
+		}
+		files = files[1:]
+	}
+}
+
+// ListPcapFiles returns the rotated capture files for the given path
+// template, newest first.
+func ListPcapFiles(path string) ([]PcapFile, error) {
+	stem, ext := splitOutputPath(path)
+	matches, err := globOutputFiles(stem + "-*" + ext)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]PcapFile, 0, len(matches))
+	for _, match := range matches {
+		info, err := os.Stat(match)
+		if err != nil || info.IsDir() {
+			continue
+		}
+		files = append(files, PcapFile{
+			Name:       filepath.Base(match),
+			Size:       info.Size(),
+			ModifiedAt: info.ModTime(),
+		})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].ModifiedAt.Equal(files[j].ModifiedAt) {
+			return files[i].Name > files[j].Name
+		}
+		return files[i].ModifiedAt.After(files[j].ModifiedAt)
+	})
+	return files, nil
+}
+
+// PcapFilePath resolves the name of a rotated capture file to its path,
+// refusing anything outside of the path template.
+func PcapFilePath(path, name string) (string, error) {
+	stem, ext := splitOutputPath(path)
+	base := filepath.Base(stem)
+	if name != filepath.Base(name) || !strings.HasPrefix(name, base+"-") || !strings.HasSuffix(name, ext) {
+		return "", ErrPcapFileNotFound
+	}
+	full := filepath.Join(filepath.Dir(stem), name)
+	info, err := os.Stat(full)
+	if err != nil || info.IsDir() {
+		return "", fmt.Errorf("%w: %s", ErrPcapFileNotFound, name)
+	}
+	return full, nil
+}
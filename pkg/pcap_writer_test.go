@@ -0,0 +1,199 @@
+package pkg
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+func TestRollingPcapWriterWritesPackets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.pcap")
+
+	w, err := newRollingPcapWriter(path, "eth0", PcapRotation{})
+	if err != nil {
+		t.Fatalf("newRollingPcapWriter returned error: %v", err)
+	}
+	if err := w.WriteFileHeader(SNAPSHOTLENGTH, layers.LinkTypeIPv4); err != nil {
+		t.Fatalf("WriteFileHeader returned error: %v", err)
+	}
+
+	packet := mustBuildPacket(t, "10.0.0.1", "10.0.0.2", 1234, 80)
+	ts := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	ci := gopacket.CaptureInfo{Timestamp: ts, CaptureLength: len(packet.Data()), Length: len(packet.Data()) + 10}
+	if err := w.WritePacket(ci, packet.Data()); err != nil {
+		t.Fatalf("WritePacket returned error: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close returned error: %v", err)
+	}
+
+	files, err := ListPcapFiles(path)
+	if err != nil {
+		t.Fatalf("ListPcapFiles returned error: %v", err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("expected 1 pcap file, got %d", len(files))
+	}
+
+	f, err := os.Open(filepath.Join(filepath.Dir(path), files[0].Name))
+	if err != nil {
+		t.Fatalf("failed to open pcap file: %v", err)
+	}
+	defer f.Close()
+	reader, err := pcapgo.NewReader(f)
+	if err != nil {
+		t.Fatalf("failed to read pcap header: %v", err)
+	}
+	if reader.LinkType() != layers.LinkTypeIPv4 {
+		t.Fatalf("expected link type IPv4, got %v", reader.LinkType())
+	}
+	data, got, err := reader.ReadPacketData()
+	if err != nil {
+		t.Fatalf("failed to read packet: %v", err)
+	}
+	if !got.Timestamp.Equal(ts) {
+		t.Fatalf("expected timestamp %v, got %v", ts, got.Timestamp)
+	}
+	if got.Length != ci.Length || len(data) != ci.CaptureLength {
+		t.Fatalf("expected lengths %d/%d, got %d/%d", ci.CaptureLength, ci.Length, len(data), got.Length)
+	}
+}
+
+func TestRollingPcapWriterRotatesBySize(t *testing.T) {
+	originalTime := currentTime
+	defer func() { currentTime = originalTime }()
+	clock := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	currentTime = func() time.Time {
+		clock = clock.Add(time.Millisecond)
+		return clock
+	}
+
+	path := filepath.Join(t.TempDir(), "capture.pcap")
+	packet := mustBuildPacket(t, "10.0.0.1", "10.0.0.2", 1234, 80)
+	recordSize := int64(pcapRecordHeaderLen + len(packet.Data()))
+
+	w, err := newRollingPcapWriter(path, "eth0", PcapRotation{MaxFileSize: pcapFileHeaderLen + 2*recordSize})
+	if err != nil {
+		t.Fatalf("newRollingPcapWriter returned error: %v", err)
+	}
+	if err := w.WriteFileHeader(SNAPSHOTLENGTH, layers.LinkTypeIPv4); err != nil {
+		t.Fatalf("WriteFileHeader returned error: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := w.WritePacket(packet.Metadata().CaptureInfo, packet.Data()); err != nil {
+			t.Fatalf("WritePacket returned error: %v", err)
+		}
+	}
+	w.Close()
+
+	files, err := ListPcapFiles(path)
+	if err != nil {
+		t.Fatalf("ListPcapFiles returned error: %v", err)
+	}
+	if len(files) != 3 {
+		t.Fatalf("expected 3 pcap files, got %d", len(files))
+	}
+	for _, f := range files {
+		if f.Size > pcapFileHeaderLen+2*recordSize {
+			t.Fatalf("file %s exceeds max size: %d", f.Name, f.Size)
+		}
+	}
+}
+
+func TestRollingPcapWriterRotatesByTimeAndKeepsMaxFiles(t *testing.T) {
+	originalTime := currentTime
+	defer func() { currentTime = originalTime }()
+	clock := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	currentTime = func() time.Time { return clock }
+
+	path := filepath.Join(t.TempDir(), "capture.pcap")
+	packet := mustBuildPacket(t, "10.0.0.1", "10.0.0.2", 1234, 80)
+
+	w, err := newRollingPcapWriter(path, "eth0", PcapRotation{RotateInterval: time.Minute, MaxFiles: 2})
+	if err != nil {
+		t.Fatalf("newRollingPcapWriter returned error: %v", err)
+	}
+	w.WriteFileHeader(SNAPSHOTLENGTH, layers.LinkTypeIPv4)
+	for i := 0; i < 4; i++ {
+		if err := w.WritePacket(packet.Metadata().CaptureInfo, packet.Data()); err != nil {
+			t.Fatalf("WritePacket returned error: %v", err)
+		}
+		clock = clock.Add(time.Minute)
+	}
+	w.Close()
+
+	files, err := ListPcapFiles(path)
+	if err != nil {
+		t.Fatalf("ListPcapFiles returned error: %v", err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("expected 2 pcap files to be kept, got %d", len(files))
+	}
+	want := filepath.Base(rotatedFileName(path, "eth0", clock.Add(-time.Minute)))
+	if files[0].Name != want {
+		t.Fatalf("expected newest file %s, got %s", want, files[0].Name)
+	}
+}
+
+func TestPcapFilePath(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "capture.pcap")
+	name := "capture-eth0-20240301T120000.000000.pcap"
+	if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+
+	got, err := PcapFilePath(path, name)
+	if err != nil {
+		t.Fatalf("PcapFilePath returned error: %v", err)
+	}
+	if got != filepath.Join(dir, name) {
+		t.Fatalf("unexpected path %s", got)
+	}
+
+	for _, bad := range []string{"../capture-eth0.pcap", "other.pcap", "capture-missing.pcap"} {
+		if _, err := PcapFilePath(path, bad); !errors.Is(err, ErrPcapFileNotFound) {
+			t.Fatalf("expected ErrPcapFileNotFound for %s, got %v", bad, err)
+		}
+	}
+}
+
+func TestRotatedFileNameSanitizesDevice(t *testing.T) {
+	ts := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	got := rotatedFileName("out/capture.pcap", `\Device\NPF_{1}`, ts)
+	want := "out/capture-_Device_NPF__1_-20240301T120000.000000.pcap"
+	if got != want {
+		t.Fatalf("expected %s, got %s", want, got)
+	}
+}
+
+func TestPruneRotatedFilesKeepsOtherDevices(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.pcap")
+	clock := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	var names []string
+	for i := 0; i < 3; i++ {
+		for _, device := range []string{"eth1", "eth1-backup"} {
+			name := rotatedFileName(path, device, clock.Add(time.Duration(i)*time.Minute))
+			if err := os.WriteFile(name, nil, 0o644); err != nil {
+				t.Fatalf("failed to create %s: %v", name, err)
+			}
+			names = append(names, name)
+		}
+	}
+
+	pruneRotatedFiles(path, "eth1", 1)
+
+	for i, name := range names {
+		_, err := os.Stat(name)
+		// Only the two oldest files of eth1 go.
+		if removed := i == 0 || i == 2; removed != os.IsNotExist(err) {
+			t.Errorf("%s: expected removed %v, got stat error %v", filepath.Base(name), removed, err)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package pkg
+
+import (
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+// pingReplyTimeout is how long an echo request waits for its reply before
+// it is emitted as lost.
+const pingReplyTimeout = 10 * time.Second
+
+// PingTracker matches the ICMP and ICMPv6 echo requests with their replies
+// by requester, target, identifier and sequence number.
+type PingTracker struct {
+	mu       sync.Mutex
+	pending  map[pingKey]*pendingPing
+	finished []Ping
+}
+
+type pingKey struct {
+	device     string
+	source     string
+	target     string
+	identifier uint16
+	sequence   uint16
+}
+
+type pendingPing struct {
+	ping    Ping
+	arrival time.Time
+}
+
+func NewPingTracker() *PingTracker {
+	return &PingTracker{pending: make(map[pingKey]*pendingPing)}
+}
+
+// Add records the echo request or reply of the packet, if it carries one.
+func (t *PingTracker) Add(packet AppPacket) {
+	if packet.Data == nil || packet.Data.NetworkLayer() == nil {
+		return
+	}
+	var protocol string
+	var request bool
+	var identifier, sequence uint16
+	if icmp, ok := packet.Data.Layer(layers.LayerTypeICMPv4).(*layers.ICMPv4); ok {
+		switch icmp.TypeCode.Type() {
+		case layers.ICMPv4TypeEchoRequest:
+			request = true
+		case layers.ICMPv4TypeEchoReply:
+		default:
+			return
+		}
+		protocol, identifier, sequence = ProtocolICMPv4, icmp.Id, icmp.Seq
+	} else if icmp, ok := packet.Data.Layer(layers.LayerTypeICMPv6).(*layers.ICMPv6); ok {
+		if identifier, sequence, ok = icmpv6Echo(icmp); !ok {
+			return
+		}
+		request = icmp.TypeCode.Type() == layers.ICMPv6TypeEchoRequest
+		protocol = ProtocolICMPv6
+	} else {
+		return
+	}
+	netFlow := packet.Data.NetworkLayer().NetworkFlow()
+	source := net.IP(netFlow.Src().Raw()).String()
+	destination := net.IP(netFlow.Dst().Raw()).String()
+	arrival := packet.UpdatedAt
+	if arrival.IsZero() {
+		arrival = packet.CreatedAt
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if request {
+		key := pingKey{packet.DeviceID, source, destination, identifier, sequence}
+		t.pending[key] = &pendingPing{
+			ping: Ping{
+				Time:          packet.CreatedAt,
+				DeviceID:      packet.DeviceID,
+				Protocol:      protocol,
+				SourceIP:      source,
+				DestinationIP: destination,
+				Identifier:    identifier,
+				Sequence:      sequence,
+			},
+			arrival: arrival,
+		}
+		return
+	}
+
+	// A reply to a broadcast or multicast request comes from another
+	// address and is kept on its own.
+	key := pingKey{packet.DeviceID, destination, source, identifier, sequence}
+	ping := Ping{
+		Time:          packet.CreatedAt,
+		DeviceID:      packet.DeviceID,
+		Protocol:      protocol,
+		SourceIP:      destination,
+		DestinationIP: source,
+		Identifier:    identifier,
+		Sequence:      sequence,
+	}
+	if waiting, ok := t.pending[key]; ok {
+		delete(t.pending, key)
+		ping = waiting.ping
+		rtt := packet.CreatedAt.Sub(ping.Time).Microseconds()
+		ping.RTTMicros = &rtt
+	}
+	repliedAt := packet.CreatedAt
+	ping.RepliedAt = &repliedAt
+	ping.ReplyTTL = replyTTL(packet)
+	t.finished = append(t.finished, ping)
+}
+
+// Expire returns the pings answered since the last call and the requests
+// that got no reply in time.
+func (t *PingTracker) Expire(now time.Time) []Ping {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for key, request := range t.pending {
+		if now.Sub(request.arrival) >= pingReplyTimeout {
+			t.finished = append(t.finished, request.ping)
+			delete(t.pending, key)
+		}
+	}
+	return t.takeFinished()
+}
+
+// Flush returns every ping, including the requests still waiting.
+func (t *PingTracker) Flush() []Ping {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for key, request := range t.pending {
+		t.finished = append(t.finished, request.ping)
+		delete(t.pending, key)
+	}
+	return t.takeFinished()
+}
+
+func (t *PingTracker) takeFinished() []Ping {
+	pings := t.finished
+	t.finished = nil
+	slices.SortFunc(pings, func(a, b Ping) int { return a.Time.Compare(b.Time) })
+	return pings
+}
+
+// replyTTL reads the TTL or hop limit the reply arrived with.
+func replyTTL(packet AppPacket) *int {
+	switch ip := packet.Data.NetworkLayer().(type) {
+	case *layers.IPv4:
+		return ptr(int(ip.TTL))
+	case *layers.IPv6:
+		return ptr(int(ip.HopLimit))
+	}
+	return nil
+}
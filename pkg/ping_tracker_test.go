@@ -0,0 +1,98 @@
+package pkg
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// pingTestPacket builds an echo request or reply from src to dst, over
+// ICMPv6 when the addresses are IPv6.
+func pingTestPacket(t *testing.T, src, dst string, reply bool, id, seq uint16, ttl uint8, at time.Time) AppPacket {
+	t.Helper()
+	var packet gopacket.Packet
+	if ip := net.ParseIP(src); ip.To4() == nil {
+		ip6 := &layers.IPv6{Version: 6, NextHeader: layers.IPProtocolICMPv6, HopLimit: ttl, SrcIP: ip, DstIP: net.ParseIP(dst)}
+		typ := uint8(layers.ICMPv6TypeEchoRequest)
+		if reply {
+			typ = layers.ICMPv6TypeEchoReply
+		}
+		icmp := &layers.ICMPv6{TypeCode: layers.CreateICMPv6TypeCode(typ, 0)}
+		icmp.SetNetworkLayerForChecksum(ip6)
+		echo := binary.BigEndian.AppendUint16(binary.BigEndian.AppendUint16(nil, id), seq)
+		packet = serializeTestFrame(t, layers.LayerTypeIPv6, ip6, icmp, gopacket.Payload(echo))
+	} else {
+		typ := uint8(layers.ICMPv4TypeEchoRequest)
+		if reply {
+			typ = layers.ICMPv4TypeEchoReply
+		}
+		packet = serializeTestFrame(t, layers.LayerTypeIPv4,
+			&layers.IPv4{Version: 4, IHL: 5, TTL: ttl, Protocol: layers.IPProtocolICMPv4, SrcIP: ip, DstIP: net.ParseIP(dst)},
+			&layers.ICMPv4{TypeCode: layers.CreateICMPv4TypeCode(typ, 0), Id: id, Seq: seq},
+			gopacket.Payload("abcdefgh"))
+	}
+	return AppPacket{Data: packet, CreatedAt: at, UpdatedAt: at, DeviceID: "eth0", LinkType: layers.LinkTypeRaw}
+}
+
+func TestPingTrackerMatchesReplies(t *testing.T) {
+	tracker := NewPingTracker()
+	start := time.Now()
+	tracker.Add(pingTestPacket(t, "10.0.0.5", "10.0.0.1", false, 42, 1, 64, start))
+	// Same identifier, next sequence number, never answered.
+	tracker.Add(pingTestPacket(t, "10.0.0.5", "10.0.0.1", false, 42, 2, 64, start.Add(time.Second)))
+	tracker.Add(pingTestPacket(t, "10.0.0.1", "10.0.0.5", true, 42, 1, 63, start.Add(1500*time.Microsecond)))
+	tracker.Add(pingTestPacket(t, "2001:db8::5", "2001:db8::1", false, 7, 9, 64, start))
+	tracker.Add(pingTestPacket(t, "2001:db8::1", "2001:db8::5", true, 7, 9, 60, start.Add(20*time.Millisecond)))
+
+	pings := tracker.Expire(start.Add(time.Second))
+	if len(pings) != 2 {
+		t.Fatalf("expected 2 answered pings, got %+v", pings)
+	}
+	for _, ping := range pings {
+		if ping.SourceIP != "10.0.0.5" && ping.SourceIP != "2001:db8::5" {
+			t.Errorf("expected the requester as source, got %+v", ping)
+		}
+		if !ping.Time.Equal(start) || ping.RepliedAt == nil || ping.RTTMicros == nil || ping.ReplyTTL == nil {
+			t.Errorf("expected a matched ping, got %+v", ping)
+		}
+	}
+	v4, v6 := pings[0], pings[1]
+	if v4.Protocol != ProtocolICMPv4 {
+		v4, v6 = v6, v4
+	}
+	if v4.DestinationIP != "10.0.0.1" || v4.Identifier != 42 || v4.Sequence != 1 || *v4.RTTMicros != 1500 || *v4.ReplyTTL != 63 {
+		t.Errorf("unexpected ICMPv4 ping %+v", v4)
+	}
+	if v6.Protocol != ProtocolICMPv6 || v6.Identifier != 7 || v6.Sequence != 9 || *v6.RTTMicros != 20000 || *v6.ReplyTTL != 60 {
+		t.Errorf("unexpected ICMPv6 ping %+v", v6)
+	}
+
+	lost := tracker.Expire(start.Add(time.Second + pingReplyTimeout))
+	if len(lost) != 1 || lost[0].Sequence != 2 || lost[0].RepliedAt != nil || lost[0].RTTMicros != nil {
+		t.Errorf("expected the second request lost, got %+v", lost)
+	}
+}
+
+func TestPingTrackerKeepsUnmatchedReplies(t *testing.T) {
+	tracker := NewPingTracker()
+	start := time.Now()
+	// A broadcast ping is answered from the address of each host.
+	tracker.Add(pingTestPacket(t, "10.0.0.5", "10.0.0.255", false, 1, 1, 64, start))
+	tracker.Add(pingTestPacket(t, "10.0.0.7", "10.0.0.5", true, 1, 1, 64, start.Add(time.Millisecond)))
+
+	pings := tracker.Flush()
+	if len(pings) != 2 {
+		t.Fatalf("expected the request and the reply, got %+v", pings)
+	}
+	reply := pings[1]
+	if reply.SourceIP != "10.0.0.5" || reply.DestinationIP != "10.0.0.7" || reply.RepliedAt == nil || reply.RTTMicros != nil {
+		t.Errorf("expected a reply without RTT, got %+v", reply)
+	}
+	if pings[0].DestinationIP != "10.0.0.255" || pings[0].RepliedAt != nil {
+		t.Errorf("expected the request unanswered, got %+v", pings[0])
+	}
+}
@@ -0,0 +1,456 @@
+package pkg
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"time"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// QUIC versions whose Initial packets can be decrypted.
+const (
+	quicVersion1 uint32 = 0x00000001
+	quicVersion2 uint32 = 0x6b3343cf
+)
+
+const (
+	// quicMaxCryptoBytes caps the handshake data buffered for one side of
+	// a connection, more than any ClientHello.
+	quicMaxCryptoBytes = 64 << 10
+	// quicMaxAttempts is how many datagrams of a UDP flow are tried as
+	// Initial packets before it is taken for something else.
+	quicMaxAttempts = 8
+)
+
+// The Initial salts and key labels of RFC 9001 and RFC 9369.
+var (
+	quicV1InitialSalt = []byte{0x38, 0x76, 0x2c, 0xf7, 0xf5, 0x59, 0x34, 0xb3, 0x4d, 0x17, 0x9a, 0xe6, 0xa4, 0xc8, 0x0c, 0xad, 0xcc, 0xbb, 0x7f, 0x0a}
+	quicV2InitialSalt = []byte{0x0d, 0xed, 0xe3, 0xde, 0xf7, 0x00, 0xa6, 0xdb, 0x81, 0x93, 0x81, 0xbe, 0x6e, 0x26, 0x9d, 0xcb, 0xf9, 0xbd, 0x2e, 0xd9}
+)
+
+// quicLongHeader is the clear part of a long header packet. The packet
+// number and the payload are still protected.
+type quicLongHeader struct {
+	version uint32
+	initial bool
+	dcid    []byte
+	scid    []byte
+	// pnOffset is where the packet number starts, end where the packet
+	// ends in the datagram.
+	pnOffset int
+	end      int
+}
+
+// quicKeys protect the Initial packets of one side.
+type quicKeys struct {
+	aead cipher.AEAD
+	iv   []byte
+	hp   cipher.Block
+}
+
+// quicState follows the Initial packets of a QUIC connection until both
+// hellos are read. A flow is only taken for QUIC once an Initial packet
+// decrypts.
+type quicState struct {
+	attempts     int
+	version      uint32
+	originalDCID []byte
+	clientCID    []byte
+	serverCID    []byte
+	// The keys are derived from the connection ID the client chose first.
+	clientKeys *quicKeys
+	serverKeys *quicKeys
+	// clientIsSource tells which side of the flow sent the ClientHello.
+	clientIsSource bool
+	clientCrypto   quicCryptoStream
+	serverCrypto   quicCryptoStream
+	clientHello    *tlsClientHello
+	serverHello    *tlsServerHello
+	helloTime      time.Time
+	reported       bool
+}
+
+// quicCryptoStream reassembles the CRYPTO frames of one side.
+type quicCryptoStream struct {
+	data     []byte
+	pending  map[uint64][]byte
+	complete bool
+}
+
+// parseQUICLongHeader reads the long header packet at the start of data.
+// Version negotiation packets are not.
+func parseQUICLongHeader(data []byte) (quicLongHeader, bool) {
+	var header quicLongHeader
+	s := cryptobyte.String(data)
+	var first uint8
+	var dcid, scid cryptobyte.String
+	if !s.ReadUint8(&first) || first&0xc0 != 0xc0 || !s.ReadUint32(&header.version) || header.version == 0 ||
+		!s.ReadUint8LengthPrefixed(&dcid) || !s.ReadUint8LengthPrefixed(&scid) || len(dcid) > 20 || len(scid) > 20 {
+		return header, false
+	}
+	header.dcid, header.scid = dcid, scid
+	packetType := (first >> 4) & 0x03
+	switch header.version {
+	case quicVersion1:
+		header.initial = packetType == 0
+	case quicVersion2:
+		header.initial = packetType == 1
+	default:
+		// Only the invariant fields are known.
+		header.end = len(data)
+		return header, true
+	}
+	// Retry packets carry no length.
+	if packetType == 3 && header.version == quicVersion1 || packetType == 0 && header.version == quicVersion2 {
+		header.end = len(data)
+		return header, true
+	}
+	if header.initial {
+		var token uint64
+		if !readQUICVarint(&s, &token) || !s.Skip(int(token)) {
+			return header, false
+		}
+	}
+	var length uint64
+	if !readQUICVarint(&s, &length) {
+		return header, false
+	}
+	header.pnOffset = len(data) - len(s)
+	header.end = header.pnOffset + int(length)
+	if length > uint64(len(s)) || header.end > len(data) {
+		return header, false
+	}
+	return header, true
+}
+
+func readQUICVarint(s *cryptobyte.String, value *uint64) bool {
+	var first uint8
+	if !s.ReadUint8(&first) {
+		return false
+	}
+	length := 1 << (first >> 6)
+	*value = uint64(first & 0x3f)
+	for range length - 1 {
+		var b uint8
+		if !s.ReadUint8(&b) {
+			return false
+		}
+		*value = *value<<8 | uint64(b)
+	}
+	return true
+}
+
+// newQUICInitialKeys derives the Initial keys of one side from the
+// connection ID the client chose first.
+func newQUICInitialKeys(version uint32, dcid []byte, server bool) (*quicKeys, error) {
+	salt, prefix := quicV1InitialSalt, "quic "
+	if version == quicVersion2 {
+		salt, prefix = quicV2InitialSalt, "quicv2 "
+	}
+	initial, err := hkdf.Extract(sha256.New, dcid, salt)
+	if err != nil {
+		return nil, err
+	}
+	label := "client in"
+	if server {
+		label = "server in"
+	}
+	secret, err := hkdfExpandLabel(initial, label, 32)
+	if err != nil {
+		return nil, err
+	}
+	key, err := hkdfExpandLabel(secret, prefix+"key", 16)
+	if err != nil {
+		return nil, err
+	}
+	iv, err := hkdfExpandLabel(secret, prefix+"iv", 12)
+	if err != nil {
+		return nil, err
+	}
+	hpKey, err := hkdfExpandLabel(secret, prefix+"hp", 16)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	hp, err := aes.NewCipher(hpKey)
+	if err != nil {
+		return nil, err
+	}
+	return &quicKeys{aead: aead, iv: iv, hp: hp}, nil
+}
+
+// hkdfExpandLabel is the HKDF-Expand-Label of TLS 1.3 without context.
+func hkdfExpandLabel(secret []byte, label string, length int) ([]byte, error) {
+	var b cryptobyte.Builder
+	b.AddUint16(uint16(length))
+	b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes([]byte("tls13 " + label))
+	})
+	b.AddUint8(0)
+	return hkdf.Expand(sha256.New, secret, string(b.BytesOrPanic()), length)
+}
+
+// open removes the header protection of an Initial packet and decrypts
+// its payload. The packet is left untouched.
+func (k *quicKeys) open(packet []byte, header quicLongHeader) ([]byte, error) {
+	sampleOffset := header.pnOffset + 4
+	if sampleOffset+aes.BlockSize > header.end {
+		return nil, fmt.Errorf("quic packet too short")
+	}
+	mask := make([]byte, aes.BlockSize)
+	k.hp.Encrypt(mask, packet[sampleOffset:sampleOffset+aes.BlockSize])
+	unprotected := slices.Clone(packet[:header.end])
+	unprotected[0] ^= mask[0] & 0x0f
+	pnLength := int(unprotected[0]&0x03) + 1
+	var pn uint64
+	for i := range pnLength {
+		unprotected[header.pnOffset+i] ^= mask[1+i]
+		pn = pn<<8 | uint64(unprotected[header.pnOffset+i])
+	}
+	// Early packets are numbered low enough that the truncated number is
+	// the full one.
+	nonce := slices.Clone(k.iv)
+	for i := range 8 {
+		nonce[len(nonce)-1-i] ^= byte(pn >> (8 * i))
+	}
+	payloadOffset := header.pnOffset + pnLength
+	return k.aead.Open(nil, nonce, unprotected[payloadOffset:], unprotected[:payloadOffset])
+}
+
+// addDatagram reads the Initial packets of a UDP datagram of the flow,
+// captured at at. It stops once both hellos are read.
+func (q *quicState) addDatagram(data []byte, fromSource bool, at time.Time) {
+	if q.clientKeys == nil && q.attempts >= quicMaxAttempts {
+		return
+	}
+	for len(data) > 0 && !q.done() {
+		header, ok := parseQUICLongHeader(data)
+		if !ok {
+			break
+		}
+		if header.initial {
+			q.addInitial(data, header, fromSource, at)
+		}
+		data = data[header.end:]
+	}
+	if q.clientKeys == nil {
+		q.attempts++
+	}
+}
+
+// quic tells whether the flow was taken for QUIC.
+func (q *quicState) quic() bool {
+	return q.clientKeys != nil
+}
+
+func (q *quicState) done() bool {
+	return q.clientHello != nil && q.serverHello != nil
+}
+
+func (q *quicState) addInitial(packet []byte, header quicLongHeader, fromSource bool, at time.Time) {
+	if q.clientKeys == nil || fromSource == q.clientIsSource {
+		plaintext, err := q.openClientInitial(packet, header, fromSource)
+		if err != nil {
+			return
+		}
+		if q.addFrames(plaintext, true) {
+			q.helloTime = at
+		}
+		return
+	}
+	if plaintext, err := q.serverKeys.open(packet, header); err == nil {
+		q.serverCID = slices.Clone(header.scid)
+		q.addFrames(plaintext, false)
+	}
+}
+
+// openClientInitial decrypts an Initial of the client. The keys come from
+// the destination ID of its first Initial, or of the first one after a
+// Retry.
+func (q *quicState) openClientInitial(packet []byte, header quicLongHeader, fromSource bool) ([]byte, error) {
+	if q.clientKeys != nil {
+		if plaintext, err := q.clientKeys.open(packet, header); err == nil {
+			return plaintext, nil
+		}
+	}
+	clientKeys, err := newQUICInitialKeys(header.version, header.dcid, false)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := clientKeys.open(packet, header)
+	if err != nil {
+		return nil, err
+	}
+	serverKeys, err := newQUICInitialKeys(header.version, header.dcid, true)
+	if err != nil {
+		return nil, err
+	}
+	q.version = header.version
+	q.originalDCID = slices.Clone(header.dcid)
+	q.clientCID = slices.Clone(header.scid)
+	q.clientKeys, q.serverKeys = clientKeys, serverKeys
+	q.clientIsSource = fromSource
+	return plaintext, nil
+}
+
+// addFrames collects the CRYPTO frames of a decrypted payload and parses
+// the hello once its side is complete, which it reports.
+func (q *quicState) addFrames(payload []byte, client bool) bool {
+	stream := &q.serverCrypto
+	if client {
+		stream = &q.clientCrypto
+	}
+	for _, frame := range quicCryptoFrames(payload) {
+		stream.add(frame.offset, frame.data)
+	}
+	if stream.complete || !stream.messageComplete() {
+		return false
+	}
+	stream.complete = true
+	messages := splitTLSHandshake(stream.data)
+	stream.data, stream.pending = nil, nil
+	if client && messages[0].typ == tlsClientHelloType {
+		q.clientHello, _ = parseTLSClientHello(messages[0].body)
+	} else if !client && messages[0].typ == tlsServerHelloType {
+		q.serverHello, _ = parseTLSServerHello(messages[0].body)
+	}
+	return true
+}
+
+type quicCryptoFrame struct {
+	offset uint64
+	data   []byte
+}
+
+// quicCryptoFrames returns the CRYPTO frames of an Initial payload. Initial
+// packets only carry PADDING, PING, ACK, CRYPTO and CONNECTION_CLOSE frames.
+func quicCryptoFrames(payload []byte) []quicCryptoFrame {
+	var frames []quicCryptoFrame
+	s := cryptobyte.String(payload)
+	for !s.Empty() {
+		var typ uint64
+		if !readQUICVarint(&s, &typ) {
+			return frames
+		}
+		switch typ {
+		case 0x00, 0x01:
+		case 0x02, 0x03:
+			var largest, delay, count, first uint64
+			if !readQUICVarint(&s, &largest) || !readQUICVarint(&s, &delay) || !readQUICVarint(&s, &count) || !readQUICVarint(&s, &first) {
+				return frames
+			}
+			for range count * 2 {
+				var value uint64
+				if !readQUICVarint(&s, &value) {
+					return frames
+				}
+			}
+			if typ == 0x03 {
+				var ect0, ect1, ce uint64
+				if !readQUICVarint(&s, &ect0) || !readQUICVarint(&s, &ect1) || !readQUICVarint(&s, &ce) {
+					return frames
+				}
+			}
+		case 0x06:
+			var offset, length uint64
+			var data cryptobyte.String
+			if !readQUICVarint(&s, &offset) || !readQUICVarint(&s, &length) || !s.ReadBytes((*[]byte)(&data), int(length)) {
+				return frames
+			}
+			frames = append(frames, quicCryptoFrame{offset: offset, data: data})
+		default:
+			// CONNECTION_CLOSE or a frame not allowed here, nothing more
+			// to read.
+			return frames
+		}
+	}
+	return frames
+}
+
+// add places the data of a CRYPTO frame, keeping the frames that come
+// before a gap for later.
+func (c *quicCryptoStream) add(offset uint64, data []byte) {
+	if c.complete || offset+uint64(len(data)) > quicMaxCryptoBytes {
+		return
+	}
+	if c.pending == nil {
+		c.pending = make(map[uint64][]byte)
+	}
+	c.pending[offset] = slices.Clone(data)
+	for progress := true; progress; {
+		progress = false
+		for offset, data := range c.pending {
+			end := offset + uint64(len(data))
+			if offset > uint64(len(c.data)) {
+				continue
+			}
+			if end > uint64(len(c.data)) {
+				c.data = append(c.data, data[uint64(len(c.data))-offset:]...)
+				progress = true
+			}
+			delete(c.pending, offset)
+		}
+	}
+}
+
+// messageComplete tells whether the first handshake message is in.
+func (c *quicCryptoStream) messageComplete() bool {
+	if len(c.data) < 4 {
+		return false
+	}
+	length := int(c.data[1])<<16 | int(binary.BigEndian.Uint16(c.data[2:4]))
+	return len(c.data) >= 4+length
+}
+
+// quicVersionName names the version of a connection, its hex value for
+// the unknown ones.
+func quicVersionName(version uint32) string {
+	switch version {
+	case quicVersion1:
+		return "1"
+	case quicVersion2:
+		return "2"
+	}
+	return fmt.Sprintf("0x%08x", version)
+}
+
+// setFlow tags a flow record with the version and the connection IDs.
+func (q *quicState) setFlow(flow *Flow) {
+	flow.QUICVersion = quicVersionName(q.version)
+	flow.QUICOriginalDCID = hex.EncodeToString(q.originalDCID)
+	flow.QUICClientCID = hex.EncodeToString(q.clientCID)
+	flow.QUICServerCID = hex.EncodeToString(q.serverCID)
+}
+
+// takeTLSSession returns the handshake read from the Initial packets,
+// once for the connection.
+func (q *quicState) takeTLSSession(flow Flow) *TLSSession {
+	if q.clientHello == nil || q.reported {
+		return nil
+	}
+	q.reported = true
+	session := newTLSSession(flow, q.clientHello, 'q')
+	session.Time = q.helloTime
+	if !q.clientIsSource {
+		session.ClientIP, session.ServerIP = session.ServerIP, session.ClientIP
+		session.ClientPort, session.ServerPort = session.ServerPort, session.ClientPort
+	}
+	if q.serverHello != nil {
+		session.setServerHello(q.serverHello)
+	}
+	return session
+}
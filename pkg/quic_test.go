@@ -0,0 +1,211 @@
+package pkg
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func quicTestVarint(value uint64) []byte {
+	switch {
+	case value < 1<<6:
+		return []byte{byte(value)}
+	case value < 1<<14:
+		return binary.BigEndian.AppendUint16(nil, uint16(value)|0x4000)
+	}
+	return binary.BigEndian.AppendUint32(nil, uint32(value)|0x80000000)
+}
+
+func quicTestCryptoFrame(offset int, data []byte) []byte {
+	frame := append([]byte{0x06}, quicTestVarint(uint64(offset))...)
+	frame = append(frame, quicTestVarint(uint64(len(data)))...)
+	return append(frame, data...)
+}
+
+// quicTestPacketType returns the long header type bits of a packet type,
+// numbered as in version 1.
+func quicTestPacketType(version uint32, packetType byte) byte {
+	if version == quicVersion2 {
+		packetType = (packetType + 1) & 0x03
+	}
+	return 0xc0 | packetType<<4
+}
+
+// quicTestInitial seals an Initial packet with the keys derived from
+// keyDCID, padded to carry a header protection sample.
+func quicTestInitial(t *testing.T, version uint32, server bool, keyDCID, dcid, scid []byte, pn byte, frames ...[]byte) []byte {
+	t.Helper()
+	keys, err := newQUICInitialKeys(version, keyDCID, server)
+	if err != nil {
+		t.Fatalf("failed to derive keys: %v", err)
+	}
+	payload := bytes.Join(frames, nil)
+	payload = append(payload, make([]byte, 32)...)
+	header := []byte{quicTestPacketType(version, 0)}
+	header = binary.BigEndian.AppendUint32(header, version)
+	header = append(append(header, byte(len(dcid))), dcid...)
+	header = append(append(header, byte(len(scid))), scid...)
+	header = append(header, 0)
+	header = append(header, quicTestVarint(uint64(1+len(payload)+keys.aead.Overhead()))...)
+	pnOffset := len(header)
+	header = append(header, pn)
+
+	nonce := slices.Clone(keys.iv)
+	nonce[len(nonce)-1] ^= pn
+	packet := keys.aead.Seal(header, nonce, payload, header)
+	mask := make([]byte, aes.BlockSize)
+	keys.hp.Encrypt(mask, packet[pnOffset+4:pnOffset+4+aes.BlockSize])
+	packet[0] ^= mask[0] & 0x0f
+	packet[pnOffset] ^= mask[1]
+	return packet
+}
+
+// quicTestHandshakeMessage returns the handshake message in the first
+// record of a side.
+func quicTestHandshakeMessage(records []byte) []byte {
+	length := int(binary.BigEndian.Uint16(records[3:5]))
+	return records[5 : 5+length]
+}
+
+func TestQUICInitialKeys(t *testing.T) {
+	// RFC 9001 appendix A.1.
+	dcid, _ := hex.DecodeString("8394c8f03e515708")
+	tests := []struct {
+		server      bool
+		key, iv, hp string
+	}{
+		{false, "1f369613dd76d5467730efcbe3b1a22d", "fa044b2f42a3fd3b46fb255c", "9f50449e04a0e810283a1e9933adedd2"},
+		{true, "cf3a5331653c364c88f0f379b6067e37", "0ac1493ca1905853b0bba03e", "c206b8d9b9f0f37644430b490eeaa314"},
+	}
+	for _, tt := range tests {
+		keys, err := newQUICInitialKeys(quicVersion1, dcid, tt.server)
+		if err != nil {
+			t.Fatalf("failed to derive keys: %v", err)
+		}
+		if got := hex.EncodeToString(keys.iv); got != tt.iv {
+			t.Errorf("expected iv %s, got %s", tt.iv, got)
+		}
+		key, _ := hex.DecodeString(tt.key)
+		block, _ := aes.NewCipher(key)
+		aead, _ := cipher.NewGCM(block)
+		sealed := keys.aead.Seal(nil, keys.iv, []byte("hello"), nil)
+		if !bytes.Equal(sealed, aead.Seal(nil, keys.iv, []byte("hello"), nil)) {
+			t.Errorf("unexpected key, want %s", tt.key)
+		}
+		hpKey, _ := hex.DecodeString(tt.hp)
+		hp, _ := aes.NewCipher(hpKey)
+		got, want := make([]byte, aes.BlockSize), make([]byte, aes.BlockSize)
+		keys.hp.Encrypt(got, make([]byte, aes.BlockSize))
+		hp.Encrypt(want, make([]byte, aes.BlockSize))
+		if !bytes.Equal(got, want) {
+			t.Errorf("unexpected header protection key, want %s", tt.hp)
+		}
+	}
+}
+
+func TestFlowTableQUICHandshake(t *testing.T) {
+	payload := tlsHandshakeTestPayload(t, tls.VersionTLS13)
+	clientHello := quicTestHandshakeMessage(payload.SourceData)
+	serverHello := quicTestHandshakeMessage(payload.DestinationData)
+	half := len(clientHello) / 2
+	originalDCID := []byte{0x83, 0x94, 0xc8, 0xf0, 0x3e, 0x51, 0x57, 0x08}
+	retryDCID := []byte{0x0a, 0x0b, 0x0c, 0x0d}
+	clientCID := []byte{0xc1, 0xc2}
+	serverCID := []byte{0x51, 0x52, 0x53}
+	ack := []byte{0x02, 0x00, 0x00, 0x00, 0x00}
+
+	tests := []struct {
+		name    string
+		version uint32
+		retry   bool
+		// helloAt is when the whole ClientHello was first seen.
+		helloAt time.Duration
+	}{
+		{"version 1", quicVersion1, false, 3 * time.Millisecond},
+		{"version 2 after a retry", quicVersion2, true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table := NewFlowTable(time.Minute, 0, 0, 0)
+			start := time.Now()
+			client := func(data []byte, at time.Duration) {
+				table.Add(udpDatagramTestPacket(t, "10.0.0.1", "10.0.0.2", 50000, 443, data, start.Add(at)))
+			}
+			server := func(data []byte, at time.Duration) {
+				table.Add(udpDatagramTestPacket(t, "10.0.0.2", "10.0.0.1", 443, 50000, data, start.Add(at)))
+			}
+
+			keyDCID := originalDCID
+			if tt.retry {
+				client(quicTestInitial(t, tt.version, false, originalDCID, originalDCID, clientCID, 0, quicTestCryptoFrame(0, clientHello)), 0)
+				retry := []byte{quicTestPacketType(tt.version, 3)}
+				retry = binary.BigEndian.AppendUint32(retry, tt.version)
+				retry = append(append(retry, byte(len(clientCID))), clientCID...)
+				retry = append(append(retry, byte(len(retryDCID))), retryDCID...)
+				server(append(retry, make([]byte, 32)...), time.Millisecond)
+				keyDCID = retryDCID
+			}
+			// The ClientHello comes in two packets, the second half first.
+			client(quicTestInitial(t, tt.version, false, keyDCID, keyDCID, clientCID, 1, quicTestCryptoFrame(half, clientHello[half:])), 2*time.Millisecond)
+			client(quicTestInitial(t, tt.version, false, keyDCID, keyDCID, clientCID, 2, quicTestCryptoFrame(0, clientHello[:half])), 3*time.Millisecond)
+			// The server Initial is coalesced with a Handshake packet.
+			handshake := []byte{quicTestPacketType(tt.version, 2)}
+			handshake = binary.BigEndian.AppendUint32(handshake, tt.version)
+			handshake = append(append(handshake, byte(len(clientCID))), clientCID...)
+			handshake = append(append(handshake, byte(len(serverCID))), serverCID...)
+			handshake = append(handshake, 20)
+			handshake = append(handshake, make([]byte, 20)...)
+			initial := quicTestInitial(t, tt.version, true, keyDCID, clientCID, serverCID, 0, ack, quicTestCryptoFrame(0, serverHello))
+			server(append(initial, handshake...), 4*time.Millisecond)
+
+			flows := table.Flush()
+			if len(flows) != 1 {
+				t.Fatalf("expected 1 flow, got %+v", flows)
+			}
+			flow := flows[0]
+			if flow.QUICVersion != quicVersionName(tt.version) || flow.QUICOriginalDCID != hex.EncodeToString(keyDCID) {
+				t.Errorf("unexpected version or original ID %+v", flow)
+			}
+			if flow.QUICClientCID != "c1c2" || flow.QUICServerCID != "515253" {
+				t.Errorf("unexpected connection IDs %+v", flow)
+			}
+			session := flow.TLS
+			if session == nil {
+				t.Fatal("expected a TLS session")
+			}
+			if session.ServerName != "www.example.com" || session.ClientIP != "10.0.0.1" || session.ServerPort != 443 || !session.Time.Equal(start.Add(tt.helloAt)) {
+				t.Errorf("unexpected session %+v", session)
+			}
+			if strings.Join(session.ALPN, ",") != "h2,http/1.1" || session.Version != "TLS 1.3" || session.CipherSuite == "" {
+				t.Errorf("unexpected handshake %+v", session)
+			}
+			if !strings.HasPrefix(session.JA4, "q13d") {
+				t.Errorf("expected a QUIC JA4, got %q", session.JA4)
+			}
+		})
+	}
+}
+
+func TestFlowTableQUICIgnoresOtherUDP(t *testing.T) {
+	table := NewFlowTable(time.Minute, 0, 0, 0)
+	start := time.Now()
+	// Looks like a long header without decrypting.
+	garbage := append([]byte{0xc0, 0, 0, 0, 1, 4, 1, 2, 3, 4, 0, 0, 0x40, 0x40}, make([]byte, 64)...)
+	for i := range quicMaxAttempts + 2 {
+		table.Add(udpDatagramTestPacket(t, "10.0.0.1", "10.0.0.2", 4000, 443, garbage, start.Add(time.Duration(i)*time.Millisecond)))
+	}
+	table.Add(udpTestPacket(t, "10.0.0.1", "10.0.0.53", 5353, 53, start))
+
+	for _, flow := range table.Flush() {
+		if flow.QUICVersion != "" || flow.QUICClientCID != "" || flow.TLS != nil {
+			t.Errorf("expected no QUIC on %+v", flow)
+		}
+	}
+}
@@ -0,0 +1,484 @@
+package pkg
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// TLS record and handshake message types, and the extensions read from
+// the hellos.
+const (
+	tlsRecordHandshake = 22
+
+	tlsClientHelloType = 1
+	tlsServerHelloType = 2
+	tlsCertificateType = 11
+
+	tlsExtensionServerName          = 0
+	tlsExtensionSupportedGroups     = 10
+	tlsExtensionPointFormats        = 11
+	tlsExtensionSignatureAlgorithms = 13
+	tlsExtensionALPN                = 16
+	tlsExtensionSupportedVersions   = 43
+)
+
+// ParseTLSSession reads the handshake at the start of a reassembled TCP
+// flow. The client is the side that starts with a ClientHello. The server
+// fields stay empty when the ServerHello was not captured, and the
+// certificates are only visible up to TLS 1.2.
+func ParseTLSSession(flow Flow, payload FlowPayload) *TLSSession {
+	clientIsSource := true
+	clientMessages := tlsHandshakeMessages(payload.SourceData)
+	serverData := payload.DestinationData
+	if len(clientMessages) == 0 || clientMessages[0].typ != tlsClientHelloType {
+		clientIsSource = false
+		clientMessages = tlsHandshakeMessages(payload.DestinationData)
+		serverData = payload.SourceData
+		if len(clientMessages) == 0 || clientMessages[0].typ != tlsClientHelloType {
+			return nil
+		}
+	}
+	client, ok := parseTLSClientHello(clientMessages[0].body)
+	if !ok {
+		return nil
+	}
+	session := newTLSSession(flow, client, 't')
+	if at := newStreamTimes(payload.Segments, clientIsSource).at(0); !at.IsZero() {
+		session.Time = at
+	}
+	if !clientIsSource {
+		session.ClientIP, session.ServerIP = session.ServerIP, session.ClientIP
+		session.ClientPort, session.ServerPort = session.ServerPort, session.ClientPort
+	}
+
+	for _, message := range tlsHandshakeMessages(serverData) {
+		switch message.typ {
+		case tlsServerHelloType:
+			if server, ok := parseTLSServerHello(message.body); ok {
+				session.setServerHello(server)
+			}
+		case tlsCertificateType:
+			session.Certificates = parseTLSCertificates(message.body)
+		}
+	}
+	return session
+}
+
+// newTLSSession fills a session from the ClientHello of a flow, transport
+// being that of the JA4 fingerprint.
+func newTLSSession(flow Flow, client *tlsClientHello, transport byte) *TLSSession {
+	session := &TLSSession{
+		FlowID:        flow.ID,
+		Time:          flow.StartedAt,
+		DeviceID:      flow.DeviceID,
+		ClientIP:      flow.SourceIP,
+		ClientPort:    portOrZero(flow.SourcePort),
+		ServerIP:      flow.DestinationIP,
+		ServerPort:    portOrZero(flow.DestinationPort),
+		ServerName:    client.serverName,
+		ALPN:          client.alpn,
+		ClientVersion: tls.VersionName(client.highestVersion()),
+		JA4:           client.ja4(transport),
+	}
+	for _, suite := range withoutGREASE(client.cipherSuites) {
+		session.CipherSuites = append(session.CipherSuites, tls.CipherSuiteName(suite))
+	}
+	session.JA3, session.JA3Hash = client.ja3()
+	return session
+}
+
+func (s *TLSSession) setServerHello(server *tlsServerHello) {
+	s.Version = tls.VersionName(server.selectedVersion())
+	s.CipherSuite = tls.CipherSuiteName(server.cipherSuite)
+	s.SelectedALPN = server.alpn
+	s.JA3S, s.JA3SHash = server.ja3s()
+}
+
+// tlsClientHello holds what a ClientHello offers, in the order sent.
+type tlsClientHello struct {
+	version             uint16
+	cipherSuites        []uint16
+	extensions          []uint16
+	serverName          string
+	alpn                []string
+	supportedVersions   []uint16
+	groups              []uint16
+	pointFormats        []uint8
+	signatureAlgorithms []uint16
+}
+
+// tlsServerHello holds what a ServerHello selects.
+type tlsServerHello struct {
+	version          uint16
+	cipherSuite      uint16
+	extensions       []uint16
+	supportedVersion uint16
+	alpn             string
+}
+
+type tlsHandshakeMessage struct {
+	typ  uint8
+	body []byte
+}
+
+// tlsHandshakeMessages returns the handshake messages at the start of one
+// side of a TCP stream, joining those split across records. It stops at
+// the first record of another type, after which TLS 1.3 is encrypted.
+func tlsHandshakeMessages(data []byte) []tlsHandshakeMessage {
+	var handshake []byte
+	records := cryptobyte.String(data)
+	for !records.Empty() {
+		var typ uint8
+		var version uint16
+		var fragment cryptobyte.String
+		if !records.ReadUint8(&typ) || !records.ReadUint16(&version) || !records.ReadUint16LengthPrefixed(&fragment) {
+			break
+		}
+		if typ != tlsRecordHandshake || version>>8 != 3 {
+			break
+		}
+		handshake = append(handshake, fragment...)
+	}
+	return splitTLSHandshake(handshake)
+}
+
+// splitTLSHandshake splits handshake data into its messages, dropping the
+// last one when it is incomplete.
+func splitTLSHandshake(data []byte) []tlsHandshakeMessage {
+	var messages []tlsHandshakeMessage
+	handshake := cryptobyte.String(data)
+	for !handshake.Empty() {
+		var message tlsHandshakeMessage
+		var body cryptobyte.String
+		if !handshake.ReadUint8(&message.typ) || !handshake.ReadUint24LengthPrefixed(&body) {
+			break
+		}
+		message.body = body
+		messages = append(messages, message)
+	}
+	return messages
+}
+
+func parseTLSClientHello(body []byte) (*tlsClientHello, bool) {
+	hello := &tlsClientHello{}
+	s := cryptobyte.String(body)
+	var sessionID, ciphers, compression cryptobyte.String
+	if !s.ReadUint16(&hello.version) || !s.Skip(32) ||
+		!s.ReadUint8LengthPrefixed(&sessionID) ||
+		!s.ReadUint16LengthPrefixed(&ciphers) ||
+		!s.ReadUint8LengthPrefixed(&compression) {
+		return nil, false
+	}
+	for !ciphers.Empty() {
+		var suite uint16
+		if !ciphers.ReadUint16(&suite) {
+			return nil, false
+		}
+		hello.cipherSuites = append(hello.cipherSuites, suite)
+	}
+	if s.Empty() {
+		return hello, true
+	}
+	var extensions cryptobyte.String
+	if !s.ReadUint16LengthPrefixed(&extensions) {
+		return nil, false
+	}
+	for !extensions.Empty() {
+		var typ uint16
+		var data cryptobyte.String
+		if !extensions.ReadUint16(&typ) || !extensions.ReadUint16LengthPrefixed(&data) {
+			return nil, false
+		}
+		hello.extensions = append(hello.extensions, typ)
+		switch typ {
+		case tlsExtensionServerName:
+			var names cryptobyte.String
+			if !data.ReadUint16LengthPrefixed(&names) {
+				continue
+			}
+			for !names.Empty() {
+				var nameType uint8
+				var name cryptobyte.String
+				if !names.ReadUint8(&nameType) || !names.ReadUint16LengthPrefixed(&name) {
+					break
+				}
+				if nameType == 0 {
+					hello.serverName = normalizeDNSName(string(name))
+					break
+				}
+			}
+		case tlsExtensionALPN:
+			var protocols cryptobyte.String
+			if !data.ReadUint16LengthPrefixed(&protocols) {
+				continue
+			}
+			for !protocols.Empty() {
+				var protocol cryptobyte.String
+				if !protocols.ReadUint8LengthPrefixed(&protocol) {
+					break
+				}
+				hello.alpn = append(hello.alpn, string(protocol))
+			}
+		case tlsExtensionSupportedVersions:
+			var versions cryptobyte.String
+			if data.ReadUint8LengthPrefixed(&versions) {
+				hello.supportedVersions = readUint16s(versions)
+			}
+		case tlsExtensionSupportedGroups:
+			var groups cryptobyte.String
+			if data.ReadUint16LengthPrefixed(&groups) {
+				hello.groups = readUint16s(groups)
+			}
+		case tlsExtensionPointFormats:
+			var formats cryptobyte.String
+			if data.ReadUint8LengthPrefixed(&formats) {
+				hello.pointFormats = formats
+			}
+		case tlsExtensionSignatureAlgorithms:
+			var algorithms cryptobyte.String
+			if data.ReadUint16LengthPrefixed(&algorithms) {
+				hello.signatureAlgorithms = readUint16s(algorithms)
+			}
+		}
+	}
+	return hello, true
+}
+
+func parseTLSServerHello(body []byte) (*tlsServerHello, bool) {
+	hello := &tlsServerHello{}
+	s := cryptobyte.String(body)
+	var sessionID cryptobyte.String
+	var compression uint8
+	if !s.ReadUint16(&hello.version) || !s.Skip(32) ||
+		!s.ReadUint8LengthPrefixed(&sessionID) ||
+		!s.ReadUint16(&hello.cipherSuite) ||
+		!s.ReadUint8(&compression) {
+		return nil, false
+	}
+	if s.Empty() {
+		return hello, true
+	}
+	var extensions cryptobyte.String
+	if !s.ReadUint16LengthPrefixed(&extensions) {
+		return nil, false
+	}
+	for !extensions.Empty() {
+		var typ uint16
+		var data cryptobyte.String
+		if !extensions.ReadUint16(&typ) || !extensions.ReadUint16LengthPrefixed(&data) {
+			return nil, false
+		}
+		hello.extensions = append(hello.extensions, typ)
+		switch typ {
+		case tlsExtensionSupportedVersions:
+			data.ReadUint16(&hello.supportedVersion)
+		case tlsExtensionALPN:
+			var protocols, protocol cryptobyte.String
+			if data.ReadUint16LengthPrefixed(&protocols) && protocols.ReadUint8LengthPrefixed(&protocol) {
+				hello.alpn = string(protocol)
+			}
+		}
+	}
+	return hello, true
+}
+
+// parseTLSCertificates reads the chain of a TLS 1.2 Certificate message,
+// skipping the certificates that do not parse.
+func parseTLSCertificates(body []byte) []TLSCertificate {
+	var certificates []TLSCertificate
+	s := cryptobyte.String(body)
+	var chain cryptobyte.String
+	if !s.ReadUint24LengthPrefixed(&chain) {
+		return nil
+	}
+	for !chain.Empty() {
+		var der cryptobyte.String
+		if !chain.ReadUint24LengthPrefixed(&der) {
+			break
+		}
+		certificate, err := x509.ParseCertificate(der)
+		if err != nil {
+			continue
+		}
+		fingerprint := sha256.Sum256(der)
+		certificates = append(certificates, TLSCertificate{
+			Subject:      certificate.Subject.String(),
+			Issuer:       certificate.Issuer.String(),
+			SerialNumber: certificate.SerialNumber.Text(16),
+			NotBefore:    certificate.NotBefore,
+			NotAfter:     certificate.NotAfter,
+			DNSNames:     certificate.DNSNames,
+			SHA256:       hex.EncodeToString(fingerprint[:]),
+		})
+	}
+	return certificates
+}
+
+func readUint16s(s cryptobyte.String) []uint16 {
+	var values []uint16
+	for !s.Empty() {
+		var value uint16
+		if !s.ReadUint16(&value) {
+			break
+		}
+		values = append(values, value)
+	}
+	return values
+}
+
+// isGREASE tells the values reserved by RFC 8701, which fingerprints
+// leave out since clients pick them at random.
+func isGREASE(value uint16) bool {
+	return value&0x0f0f == 0x0a0a && value>>8 == value&0xff
+}
+
+func withoutGREASE(values []uint16) []uint16 {
+	return slices.DeleteFunc(slices.Clone(values), isGREASE)
+}
+
+// highestVersion is the highest version offered, from the supported
+// versions extension when there is one.
+func (h *tlsClientHello) highestVersion() uint16 {
+	highest := h.version
+	if versions := withoutGREASE(h.supportedVersions); len(versions) > 0 {
+		highest = slices.Max(versions)
+	}
+	return highest
+}
+
+// selectedVersion is the version selected, which TLS 1.3 tells in the
+// supported versions extension.
+func (h *tlsServerHello) selectedVersion() uint16 {
+	if h.supportedVersion != 0 {
+		return h.supportedVersion
+	}
+	return h.version
+}
+
+// ja3 returns the JA3 string of a ClientHello and its MD5 hash.
+func (h *tlsClientHello) ja3() (string, string) {
+	formats := make([]uint16, len(h.pointFormats))
+	for i, format := range h.pointFormats {
+		formats[i] = uint16(format)
+	}
+	fields := []string{
+		strconv.Itoa(int(h.version)),
+		joinDecimal(withoutGREASE(h.cipherSuites)),
+		joinDecimal(withoutGREASE(h.extensions)),
+		joinDecimal(withoutGREASE(h.groups)),
+		joinDecimal(formats),
+	}
+	ja3 := strings.Join(fields, ",")
+	hash := md5.Sum([]byte(ja3))
+	return ja3, hex.EncodeToString(hash[:])
+}
+
+// ja3s returns the JA3S string of a ServerHello and its MD5 hash.
+func (h *tlsServerHello) ja3s() (string, string) {
+	fields := []string{
+		strconv.Itoa(int(h.version)),
+		strconv.Itoa(int(h.cipherSuite)),
+		joinDecimal(withoutGREASE(h.extensions)),
+	}
+	ja3s := strings.Join(fields, ",")
+	hash := md5.Sum([]byte(ja3s))
+	return ja3s, hex.EncodeToString(hash[:])
+}
+
+// ja4 returns the JA4 fingerprint of a ClientHello sent over TCP ('t') or
+// QUIC ('q').
+func (h *tlsClientHello) ja4(transport byte) string {
+	ciphers := withoutGREASE(h.cipherSuites)
+	extensions := withoutGREASE(h.extensions)
+	sni := 'i'
+	if h.serverName != "" {
+		sni = 'd'
+	}
+	alpn := "00"
+	if len(h.alpn) > 0 && h.alpn[0] != "" {
+		alpn = ja4ALPN(h.alpn[0])
+	}
+	a := fmt.Sprintf("%c%s%c%02d%02d%s", transport, ja4Version(h.highestVersion()), sni, min(len(ciphers), 99), min(len(extensions), 99), alpn)
+
+	slices.Sort(ciphers)
+	b := ja4Hash(joinHex(ciphers))
+	// The name and the protocols already count in the first part.
+	extensions = slices.DeleteFunc(extensions, func(extension uint16) bool {
+		return extension == tlsExtensionServerName || extension == tlsExtensionALPN
+	})
+	slices.Sort(extensions)
+	c := joinHex(extensions)
+	if len(h.signatureAlgorithms) > 0 {
+		c += "_" + joinHex(h.signatureAlgorithms)
+	}
+	if len(extensions) == 0 {
+		c = ""
+	}
+	return a + "_" + b + "_" + ja4Hash(c)
+}
+
+func ja4Version(version uint16) string {
+	switch version {
+	case tls.VersionTLS13:
+		return "13"
+	case tls.VersionTLS12:
+		return "12"
+	case tls.VersionTLS11:
+		return "11"
+	case tls.VersionTLS10:
+		return "10"
+	case 0x0300:
+		return "s3"
+	}
+	return "00"
+}
+
+// ja4ALPN is the first and last character of the protocol, or of its hex
+// form when either is not alphanumeric.
+func ja4ALPN(protocol string) string {
+	first, last := protocol[0], protocol[len(protocol)-1]
+	if !isAlphanumeric(first) || !isAlphanumeric(last) {
+		encoded := hex.EncodeToString([]byte(protocol))
+		first, last = encoded[0], encoded[len(encoded)-1]
+	}
+	return string([]byte{first, last})
+}
+
+func isAlphanumeric(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// ja4Hash is the first 12 hex characters of the SHA-256 of a list, zeros
+// for an empty one.
+func ja4Hash(list string) string {
+	if list == "" {
+		return "000000000000"
+	}
+	hash := sha256.Sum256([]byte(list))
+	return hex.EncodeToString(hash[:])[:12]
+}
+
+func joinDecimal(values []uint16) string {
+	items := make([]string, len(values))
+	for i, value := range values {
+		items[i] = strconv.Itoa(int(value))
+	}
+	return strings.Join(items, "-")
+}
+
+func joinHex(values []uint16) string {
+	items := make([]string, len(values))
+	for i, value := range values {
+		items[i] = fmt.Sprintf("%04x", value)
+	}
+	return strings.Join(items, ",")
+}
@@ -0,0 +1,189 @@
+package pkg
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingConn keeps what one side of a connection wrote.
+type recordingConn struct {
+	net.Conn
+	mu      sync.Mutex
+	written bytes.Buffer
+}
+
+func (c *recordingConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	c.written.Write(b)
+	c.mu.Unlock()
+	return c.Conn.Write(b)
+}
+
+func (c *recordingConn) Written() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return bytes.Clone(c.written.Bytes())
+}
+
+func testCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(0x2a),
+		Subject:      pkix.Name{CommonName: "www.example.com"},
+		Issuer:       pkix.Name{CommonName: "www.example.com"},
+		DNSNames:     []string{"www.example.com", "example.com"},
+		NotBefore:    time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:     time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// tlsHandshakeTestPayload runs a handshake of the crypto/tls client and
+// server and returns the payload of the flow, the client being the source.
+func tlsHandshakeTestPayload(t *testing.T, maxVersion uint16) FlowPayload {
+	t.Helper()
+	clientEnd, serverEnd := net.Pipe()
+	client := &recordingConn{Conn: clientEnd}
+	server := &recordingConn{Conn: serverEnd}
+	certificate := testCertificate(t)
+	done := make(chan error, 1)
+	go func() {
+		conn := tls.Server(server, &tls.Config{
+			Certificates: []tls.Certificate{certificate},
+			MaxVersion:   maxVersion,
+			NextProtos:   []string{"h2", "http/1.1"},
+		})
+		done <- conn.Handshake()
+	}()
+	conn := tls.Client(client, &tls.Config{ServerName: "WWW.Example.com", InsecureSkipVerify: true, NextProtos: []string{"h2", "http/1.1"}})
+	if err := conn.Handshake(); err != nil {
+		t.Fatalf("client handshake failed: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("server handshake failed: %v", err)
+	}
+	// Without close_notify, which nobody reads.
+	clientEnd.Close()
+	serverEnd.Close()
+
+	source, destination := client.Written(), server.Written()
+	return FlowPayload{
+		SourceData:      source,
+		DestinationData: destination,
+		Segments:        []StreamSegment{{FromSource: true, Length: len(source)}, {Length: len(destination)}},
+	}
+}
+
+func TestParseTLSSessionTLS12(t *testing.T) {
+	start := time.Now()
+	session := ParseTLSSession(httpTestFlow(start), tlsHandshakeTestPayload(t, tls.VersionTLS12))
+	if session == nil {
+		t.Fatal("expected a session")
+	}
+	if session.FlowID != 3 || session.ClientIP != "10.0.0.1" || session.ServerPort != 80 || !session.Time.Equal(start) {
+		t.Errorf("unexpected endpoints %+v", session)
+	}
+	if session.ServerName != "www.example.com" || strings.Join(session.ALPN, ",") != "h2,http/1.1" || session.SelectedALPN != "h2" {
+		t.Errorf("unexpected name and protocols %+v", session)
+	}
+	if session.ClientVersion != "TLS 1.3" || session.Version != "TLS 1.2" || !strings.HasPrefix(session.CipherSuite, "TLS_ECDHE_ECDSA_") {
+		t.Errorf("unexpected versions %+v", session)
+	}
+	if len(session.CipherSuites) == 0 || !strings.HasPrefix(session.JA3, "771,") || len(session.JA3Hash) != 32 {
+		t.Errorf("unexpected offer %+v", session)
+	}
+	if !strings.HasPrefix(session.JA3S, "771,") || len(session.JA3SHash) != 32 {
+		t.Errorf("unexpected JA3S %q", session.JA3S)
+	}
+	if !strings.HasPrefix(session.JA4, "t13d") || !strings.Contains(session.JA4, "h2_") || len(session.JA4) != 36 {
+		t.Errorf("unexpected JA4 %q", session.JA4)
+	}
+	if len(session.Certificates) != 1 {
+		t.Fatalf("expected the certificate, got %+v", session.Certificates)
+	}
+	certificate := session.Certificates[0]
+	if certificate.Subject != "CN=www.example.com" || certificate.SerialNumber != "2a" || len(certificate.DNSNames) != 2 || certificate.NotAfter.Year() != 2027 {
+		t.Errorf("unexpected certificate %+v", certificate)
+	}
+}
+
+func TestParseTLSSessionTLS13(t *testing.T) {
+	payload := tlsHandshakeTestPayload(t, tls.VersionTLS13)
+	// Picked up in the middle, the server is the source.
+	payload.SourceData, payload.DestinationData = payload.DestinationData, payload.SourceData
+
+	session := ParseTLSSession(httpTestFlow(time.Now()), payload)
+	if session == nil {
+		t.Fatal("expected a session")
+	}
+	if session.ClientIP != "10.0.0.2" || session.ServerIP != "10.0.0.1" {
+		t.Errorf("expected the sides turned around, got %+v", session)
+	}
+	if session.Version != "TLS 1.3" || session.CipherSuite == "" || session.SelectedALPN != "" {
+		t.Errorf("unexpected selection %+v", session)
+	}
+	if len(session.Certificates) != 0 {
+		t.Errorf("expected the certificates encrypted, got %+v", session.Certificates)
+	}
+
+	if session := ParseTLSSession(httpTestFlow(time.Now()), httpTestPayload(time.Now(), "GET / HTTP/1.1\r\n\r\n")); session != nil {
+		t.Errorf("expected no session from HTTP, got %+v", session)
+	}
+}
+
+func TestTLSFingerprints(t *testing.T) {
+	hello := &tlsClientHello{
+		version:             tls.VersionTLS12,
+		cipherSuites:        []uint16{0x0a0a, 0x1302, 0x1301, 0xc02b},
+		extensions:          []uint16{0x1a1a, tlsExtensionServerName, tlsExtensionSupportedVersions, tlsExtensionALPN, tlsExtensionSignatureAlgorithms, tlsExtensionSupportedGroups},
+		serverName:          "example.com",
+		alpn:                []string{"http/1.1"},
+		supportedVersions:   []uint16{0x2a2a, tls.VersionTLS13, tls.VersionTLS12},
+		groups:              []uint16{0x3a3a, 29, 23},
+		pointFormats:        []uint8{0},
+		signatureAlgorithms: []uint16{0x0403, 0x0804},
+	}
+
+	ja3, ja3Hash := hello.ja3()
+	if ja3 != "771,4866-4865-49195,0-43-16-13-10,29-23,0" || len(ja3Hash) != 32 {
+		t.Errorf("unexpected JA3 %q %q", ja3, ja3Hash)
+	}
+	ciphers := sha256.Sum256([]byte("1301,1302,c02b"))
+	extensions := sha256.Sum256([]byte("000a,000d,002b_0403,0804"))
+	want := "t13d0305h1_" + hex.EncodeToString(ciphers[:])[:12] + "_" + hex.EncodeToString(extensions[:])[:12]
+	if got := hello.ja4('t'); got != want {
+		t.Errorf("expected JA4 %s, got %s", want, got)
+	}
+
+	// A protocol that does not start with a letter or digit is taken in hex.
+	bare := &tlsClientHello{version: tls.VersionTLS10, alpn: []string{"\x00x"}}
+	if got := bare.ja4('q'); got != "q10i000008_000000000000_000000000000" {
+		t.Errorf("unexpected JA4 %s", got)
+	}
+
+	server := &tlsServerHello{version: tls.VersionTLS12, cipherSuite: 0x1301, extensions: []uint16{tlsExtensionSupportedVersions, 0x0033}, supportedVersion: tls.VersionTLS13}
+	if ja3s, _ := server.ja3s(); ja3s != "771,4865,43-51" || server.selectedVersion() != tls.VersionTLS13 {
+		t.Errorf("unexpected JA3S %q", ja3s)
+	}
+}
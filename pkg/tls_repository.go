@@ -0,0 +1,121 @@
+package pkg
+
+import (
+	"net"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// TLSSession is the handshake of a flow as seen in the clear: what the
+// ClientHello offered, what the ServerHello selected and the certificates
+// the server sent before TLS 1.3. Time is when the ClientHello was sent.
+type TLSSession struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	FlowID      uint      `gorm:"not null;uniqueIndex" json:"flow_id"`
+	Time        time.Time `gorm:"not null;index" json:"time"`
+	DeviceID    string    `gorm:"not null" json:"device"`
+	ClientIP    string    `gorm:"not null" json:"client_ip"`
+	ClientIPKey string    `gorm:"index" json:"-"`
+	ClientPort  int       `json:"client_port"`
+	ServerIP    string    `gorm:"not null" json:"server_ip"`
+	ServerIPKey string    `gorm:"index" json:"-"`
+	ServerPort  int       `json:"server_port"`
+
+	// ServerName is the SNI, lower case, ReversedServerName has its labels
+	// in reverse order for suffix searches.
+	ServerName         string   `json:"server_name"`
+	ReversedServerName string   `gorm:"index" json:"-"`
+	ALPN               []string `gorm:"serializer:json" json:"alpn"`
+	SelectedALPN       string   `json:"selected_alpn"`
+	// ClientVersion is the highest version offered, Version the one
+	// selected.
+	ClientVersion string   `json:"client_version"`
+	Version       string   `gorm:"index" json:"version"`
+	CipherSuites  []string `gorm:"serializer:json" json:"cipher_suites"`
+	CipherSuite   string   `json:"cipher_suite"`
+
+	JA3      string `gorm:"column:ja3" json:"ja3"`
+	JA3Hash  string `gorm:"column:ja3_hash;index" json:"ja3_hash"`
+	JA3S     string `gorm:"column:ja3s" json:"ja3s"`
+	JA3SHash string `gorm:"column:ja3s_hash;index" json:"ja3s_hash"`
+	JA4      string `gorm:"column:ja4;index" json:"ja4"`
+
+	Certificates []TLSCertificate `gorm:"serializer:json" json:"certificates"`
+}
+
+type TLSCertificate struct {
+	Subject      string    `json:"subject"`
+	Issuer       string    `json:"issuer"`
+	SerialNumber string    `json:"serial_number"`
+	NotBefore    time.Time `json:"not_before"`
+	NotAfter     time.Time `json:"not_after"`
+	DNSNames     []string  `json:"dns_names"`
+	SHA256       string    `json:"sha256"`
+}
+
+// TLSFilter selects the sessions returned by GetTLSSessions, newest first.
+// ServerName matches the name and its subdomains, IPs the client or the
+// server. JA3 and JA3S match the hashes.
+type TLSFilter struct {
+	Limit      int
+	From       time.Time
+	To         time.Time
+	FlowID     uint
+	ServerName string
+	JA3        []string
+	JA3S       []string
+	JA4        []string
+	Versions   []string
+	IPs        []*net.IPNet
+}
+
+type TLSRepository interface {
+	GetTLSSessions(filter TLSFilter) ([]TLSSession, error)
+}
+
+// The sessions are stored along with their flow by SaveFlows.
+type SqlLiteTLSRepository struct {
+	db *gorm.DB
+}
+
+func (r *SqlLiteTLSRepository) GetTLSSessions(filter TLSFilter) ([]TLSSession, error) {
+	sessions := make([]TLSSession, 0)
+	query := r.db.Model(&TLSSession{})
+	query = timeRange(query, "time", filter.From, filter.To)
+	if filter.FlowID != 0 {
+		query = query.Where("flow_id = ?", filter.FlowID)
+	}
+	if filter.ServerName != "" {
+		query = query.Where(nameCondition(query, filter.ServerName, "reversed_server_name"))
+	}
+	if len(filter.JA3) > 0 {
+		query = query.Where("ja3_hash IN ?", filter.JA3)
+	}
+	if len(filter.JA3S) > 0 {
+		query = query.Where("ja3s_hash IN ?", filter.JA3S)
+	}
+	if len(filter.JA4) > 0 {
+		query = query.Where("ja4 IN ?", filter.JA4)
+	}
+	if len(filter.Versions) > 0 {
+		query = query.Where("version IN ?", filter.Versions)
+	}
+	if len(filter.IPs) > 0 {
+		query = query.Where(ipCondition(query, filter.IPs, "client").Or(ipCondition(query, filter.IPs, "server")))
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+	result := query.Order("time desc").Order("id desc").Find(&sessions)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return sessions, nil
+}
+
+func NewSqlLiteTLSRepository(db *gorm.DB) TLSRepository {
+	db.AutoMigrate(&TLSSession{})
+
+	return &SqlLiteTLSRepository{db: db}
+}
@@ -0,0 +1,75 @@
+package pkg
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestTLSRepositoryGetTLSSessions(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open test database: %v", err)
+	}
+	flows := NewSqlLiteFlowRepository(db)
+	repo := NewSqlLiteTLSRepository(db)
+
+	start := time.Now().Add(-time.Hour)
+	stored := []Flow{
+		{DeviceID: "eth0", Protocol: ProtocolTCP, SourceIP: "10.0.0.1", DestinationIP: "10.0.1.1", StartedAt: start, EndedAt: start, EndReason: FlowEndFIN,
+			TLS: &TLSSession{Time: start, DeviceID: "eth0", ClientIP: "10.0.0.1", ServerIP: "10.0.1.1", ServerName: "www.example.com", Version: "TLS 1.3", JA3Hash: "aaa", JA4: "t13d_a"}},
+		{DeviceID: "eth0", Protocol: ProtocolTCP, SourceIP: "10.0.0.2", DestinationIP: "10.0.2.1", StartedAt: start.Add(time.Minute), EndedAt: start, EndReason: FlowEndFIN,
+			TLS: &TLSSession{Time: start.Add(time.Minute), DeviceID: "eth0", ClientIP: "10.0.0.2", ServerIP: "10.0.2.1", ServerName: "badexample.com", Version: "TLS 1.2", JA3Hash: "bbb", JA3SHash: "ccc", JA4: "t12d_b"}},
+		{DeviceID: "eth0", Protocol: ProtocolTCP, SourceIP: "10.0.0.3", DestinationIP: "10.0.2.1", StartedAt: start.Add(2 * time.Minute), EndedAt: start, EndReason: FlowEndFIN},
+	}
+	if err := flows.SaveFlows(stored); err != nil {
+		t.Fatalf("failed to save flows: %v", err)
+	}
+
+	_, servers, _ := net.ParseCIDR("10.0.2.0/24")
+	tests := []struct {
+		name   string
+		filter TLSFilter
+		want   []string
+	}{
+		{"all newest first", TLSFilter{}, []string{"badexample.com", "www.example.com"}},
+		{"server name suffix", TLSFilter{ServerName: "example.com"}, []string{"www.example.com"}},
+		{"flow", TLSFilter{FlowID: stored[1].ID}, []string{"badexample.com"}},
+		{"fingerprints", TLSFilter{JA3: []string{"aaa", "bbb"}, JA3S: []string{"ccc"}}, []string{"badexample.com"}},
+		{"ja4 and version", TLSFilter{JA4: []string{"t13d_a"}, Versions: []string{"TLS 1.3"}}, []string{"www.example.com"}},
+		{"server network", TLSFilter{IPs: []*net.IPNet{servers}}, []string{"badexample.com"}},
+		{"time range", TLSFilter{From: start, To: start.Add(time.Minute)}, []string{"www.example.com"}},
+		{"limit", TLSFilter{Limit: 1}, []string{"badexample.com"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := repo.GetTLSSessions(tt.filter)
+			if err != nil {
+				t.Fatalf("GetTLSSessions returned error: %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("expected %v, got %d sessions", tt.want, len(got))
+			}
+			for i, session := range got {
+				if session.ServerName != tt.want[i] {
+					t.Fatalf("expected %v, got %s at %d", tt.want, session.ServerName, i)
+				}
+			}
+		})
+	}
+
+	listed, err := flows.GetFlows(FlowFilter{})
+	if err != nil {
+		t.Fatalf("GetFlows returned error: %v", err)
+	}
+	if len(listed) != 3 || listed[0].TLS != nil || listed[1].TLS == nil || listed[1].TLS.ServerName != "badexample.com" {
+		t.Errorf("expected the flows with their TLS session, got %+v", listed)
+	}
+	flow, err := flows.GetFlow(stored[0].ID)
+	if err != nil || flow.TLS == nil || flow.TLS.JA4 != "t13d_a" {
+		t.Errorf("expected the flow with its TLS session, got %+v (%v)", flow, err)
+	}
+}